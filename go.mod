@@ -1,6 +1,6 @@
 module github.com/G-Node/libgin
 
-go 1.12
+go 1.15
 
 require (
 	github.com/gogs/git-module v1.0.0
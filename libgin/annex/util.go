@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/G-Node/libgin/libgin/gig"
 	"github.com/gogs/git-module"
 )
 
@@ -53,19 +54,23 @@ func Upgrade(dir string) ([]byte, error) {
 	return cmd.RunInDir(dir)
 }
 
+// IsBare reads the core.bare setting of the repository from its git
+// config files, without running git. Work trees whose .git is a file,
+// like linked work trees and submodules, are supported.
 func IsBare(repo *git.Repository) (bool, error) {
-	outb, err := git.NewCommand("config", "core.bare").RunInDir(repo.Path())
-	out := string(bytes.TrimSpace(outb))
+	r, err := gig.OpenRepository(repo.Path())
 	if err != nil {
 		return false, err
 	}
-	if out == "true" {
-		return true, nil
+	defer r.Close()
+	config, err := r.Config()
+	if err != nil {
+		return false, err
 	}
-	if out == "false" {
-		return false, nil
+	if _, ok := config.Get("core.bare"); !ok {
+		return false, fmt.Errorf("core.bare is not set in %s", r.Path)
 	}
-	return false, fmt.Errorf("unexpected output: %s", out)
+	return r.IsBare()
 }
 
 // ContentLocation returns the location of the content file for a given annex key.
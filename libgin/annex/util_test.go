@@ -0,0 +1,47 @@
+package annex
+
+import (
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/gogs/git-module"
+)
+
+func Test_IsBare(t *testing.T) {
+	base := t.TempDir()
+	run := func(dir string, args ...string) {
+		args = append([]string{"-c", "user.name=A U Thor", "-c", "user.email=author@example.com"}, args...)
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+	}
+
+	run(base, "init", "-q", "--bare", "bare.git")
+	run(base, "init", "-q", "work")
+	run(filepath.Join(base, "work"), "commit", "-q", "--allow-empty", "-m", "initial")
+	run(filepath.Join(base, "work"), "worktree", "add", "-q", filepath.Join(base, "linked"))
+
+	tests := []struct {
+		path string
+		bare bool
+	}{
+		{"bare.git", true},
+		{"work", false},
+		{"linked", false},
+	}
+
+	for _, tt := range tests {
+		repo, err := git.Open(filepath.Join(base, tt.path))
+		if err != nil {
+			t.Fatalf("%s: could not open repository: %v", tt.path, err)
+		}
+
+		bare, err := IsBare(repo)
+		if err != nil || bare != tt.bare {
+			t.Fatalf("%s: expected bare to be %v, got %v, %v", tt.path, tt.bare, bare, err)
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -402,3 +403,68 @@ func TestMakeZip(t *testing.T) {
 		t.Fatalf("Zip does not include correct number of elements: %v/%v\n%v", len(includedCounter), len(incl), includedCounter)
 	}
 }
+
+func TestSubmodule(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("[W] git not found. Skipping test")
+	}
+
+	repopath, err := ioutil.TempDir("", "libgintestsubmodule")
+	if err != nil {
+		t.Fatalf("failed creating directory for repository: %s", err.Error())
+	}
+	defer os.RemoveAll(repopath)
+
+	// a commit with a file and a gitlink to a commit that is not in the repository
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"commit", "-q", "--allow-empty", "-m", "empty"},
+		{"update-index", "--add", "--cacheinfo", "160000,1234567890123456789012345678901234567890,mod"},
+		{"commit", "-q", "-m", "submodule"},
+	} {
+		args = append([]string{"-c", "user.name=A U Thor", "-c", "user.email=author@example.com"}, args...)
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repopath
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s failed: %s\n%s", strings.Join(args, " "), err.Error(), out)
+		}
+	}
+
+	repo, err := git.Open(repopath)
+	if err != nil {
+		t.Fatalf("failed to open repository: %s", err.Error())
+	}
+
+	head, err := repo.CatFileCommit("HEAD")
+	if err != nil {
+		t.Fatalf("failed to get HEAD: %s", err.Error())
+	}
+
+	zipfile := filepath.Join(repopath, "repo.zip")
+	if err := NewZipWriter(repo, head).Write(zipfile); err != nil {
+		t.Fatalf("error creating zip file: %s", err.Error())
+	}
+
+	expath := filepath.Join(repopath, "zip")
+	if err := unzip(zipfile, expath); err != nil {
+		t.Fatalf("failed to extract created archive: %s", err.Error())
+	}
+
+	if fi, err := os.Stat(filepath.Join(expath, "mod")); err != nil || !fi.IsDir() {
+		t.Fatalf("expected directory for submodule in zip file: %v", err)
+	}
+
+	tarfile := filepath.Join(repopath, "repo.tar.gz")
+	if err := NewTarWriter(repo, head).Write(tarfile); err != nil {
+		t.Fatalf("error creating tar file: %s", err.Error())
+	}
+
+	expath = filepath.Join(repopath, "tar")
+	if err := untar(tarfile, expath); err != nil {
+		t.Fatalf("failed to extract created archive: %s", err.Error())
+	}
+
+	if fi, err := os.Stat(filepath.Join(expath, "mod")); err != nil || !fi.IsDir() {
+		t.Fatalf("expected directory for submodule in tar file: %v", err)
+	}
+}
@@ -111,7 +111,19 @@ func (a *TarWriter) addTree(tree *git.Tree, path string) error {
 	entries, _ := tree.Entries()
 	for _, te := range entries {
 		path := filepath.Join(path, te.Name())
-		if te.IsTree() {
+		if te.IsCommit() {
+			// submodules are in other repositories; like git-archive,
+			// only add an empty directory
+			header := tar.Header{
+				Name:     path + "/",
+				ModTime:  time.Now(), // TODO: use commit time
+				Typeflag: tar.TypeDir,
+				Mode:     0770,
+			}
+			if err := a.writer.WriteHeader(&header); err != nil {
+				return err
+			}
+		} else if te.IsTree() {
 			header := tar.Header{
 				Name:     path + "/",
 				ModTime:  time.Now(), // TODO: use commit time
@@ -92,7 +92,13 @@ func (a *ZipWriter) addTree(tree *git.Tree, parent string) error {
 	entries, _ := tree.Entries()
 	for _, te := range entries {
 		path := filepath.Join(parent, te.Name())
-		if te.IsTree() {
+		if te.IsCommit() {
+			// submodules are in other repositories; like git-archive,
+			// only add an empty directory
+			if _, err := a.writer.Create(path + "/"); err != nil {
+				return err
+			}
+		} else if te.IsTree() {
 			if _, err := a.writer.Create(path + "/"); err != nil {
 				return err
 			}
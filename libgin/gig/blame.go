@@ -0,0 +1,250 @@
+package gig
+
+import (
+	"container/heap"
+	"fmt"
+	"os"
+	"strings"
+)
+
+//BlameLine holds the origin of one line of a file: the commit
+//that last changed it, its author and the path and (1-based)
+//line number the line had in that commit.
+type BlameLine struct {
+	Commit   SHA1
+	Author   Signature
+	OrigPath string
+	OrigLine int
+	Content  string
+}
+
+//blameSuspect is a commit together with the lines of the final
+//file that might originate from it, i.e. that are unchanged
+//from the commit's version of the file to the final one.
+type blameSuspect struct {
+	commit *Commit
+	path   string
+	blob   SHA1
+
+	//final line index -> line number in this commit's version
+	lines map[int]int
+}
+
+//Blame attributes each line of the file at path in the given
+//commit to the commit that last changed it. Like "git blame",
+//whole-file renames are followed and merges pass the blame to
+//each parent in turn.
+func (repo *Repository) Blame(commit SHA1, path string) ([]BlameLine, error) {
+	path = strings.Trim(path, "/")
+
+	c, err := repo.openCommit(commit)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := repo.treeEntryAt(c.Tree, path)
+	if err != nil {
+		return nil, err
+	} else if entry == nil {
+		return nil, &os.PathError{Op: "find object", Path: path, Err: os.ErrNotExist}
+	} else if entry.Mode == ModeGitlink {
+		return nil, &os.PathError{Op: "find object", Path: path, Err: fmt.Errorf("%w at commit %s", ErrSubmodule, entry.ID)}
+	} else if entry.Type != ObjBlob {
+		err := &ObjectTypeError{ID: entry.ID, Type: entry.Type, Expected: ObjBlob}
+		return nil, &os.PathError{Op: "blame", Path: path, Err: err}
+	}
+
+	data, err := repo.readBlob(entry.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := splitLines(data)
+	result := make([]BlameLine, len(lines))
+	for i, l := range lines {
+		result[i].Content = l
+	}
+
+	start := &blameSuspect{commit: c, path: path, blob: entry.ID, lines: make(map[int]int)}
+	for i := range lines {
+		start.lines[i] = i + 1
+	}
+
+	b := &blamer{
+		repo:     repo,
+		result:   result,
+		suspects: map[SHA1]*blameSuspect{commit: start},
+		content:  make(map[SHA1][]string),
+	}
+
+	b.push(commit, c)
+	for b.queue.Len() > 0 {
+		id := heap.Pop(&b.queue).(queueItem).node.ID
+
+		suspect := b.suspects[id]
+		delete(b.suspects, id)
+
+		err = b.passBlame(id, suspect)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+//blamer holds the state of a blame run. Suspects are processed
+//newest first, so that they have received all lines from their
+//children by the time they are processed.
+type blamer struct {
+	repo     *Repository
+	result   []BlameLine
+	suspects map[SHA1]*blameSuspect
+	content  map[SHA1][]string
+
+	queue nodeQueue
+	seq   int
+}
+
+func (b *blamer) push(id SHA1, c *Commit) {
+	heap.Push(&b.queue, queueItem{&CommitNode{ID: id, Date: c.Committer.Date}, b.seq})
+	b.seq++
+}
+
+func (b *blamer) lines(blob SHA1) ([]string, error) {
+	if lines, ok := b.content[blob]; ok {
+		return lines, nil
+	}
+
+	data, err := b.repo.readBlob(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := splitLines(data)
+	b.content[blob] = lines
+	return lines, nil
+}
+
+//passBlame passes the lines of the suspect that are unchanged in
+//its parents on to them and blames the remaining ones on it.
+func (b *blamer) passBlame(id SHA1, suspect *blameSuspect) error {
+	type parentFile struct {
+		id     SHA1
+		commit *Commit
+		path   string
+		blob   SHA1
+	}
+
+	pids, err := b.repo.commitParents(id, suspect.commit.Parent)
+	if err != nil {
+		return err
+	}
+
+	var parents []parentFile
+	for _, pid := range pids {
+		pc, err := b.repo.openCommit(pid)
+		if err != nil {
+			return err
+		}
+
+		p, blob, err := b.findParentFile(suspect, pc)
+		if err != nil {
+			return err
+		} else if p == "" {
+			continue
+		}
+
+		//identical content, the parent takes all the blame
+		if blob == suspect.blob {
+			b.passLines(pid, pc, p, blob, suspect.lines)
+			return nil
+		}
+
+		parents = append(parents, parentFile{pid, pc, p, blob})
+	}
+
+	for _, pf := range parents {
+		if len(suspect.lines) == 0 {
+			break
+		}
+
+		own, err := b.lines(suspect.blob)
+		if err != nil {
+			return err
+		}
+
+		old, err := b.lines(pf.blob)
+		if err != nil {
+			return err
+		}
+
+		//map this version's line numbers to the parent's
+		mapping := make(map[int]int)
+		for _, l := range diffScript(old, own) {
+			if l.Op == LineContext {
+				mapping[l.NewNumber] = l.OldNumber
+			}
+		}
+
+		passed := make(map[int]int)
+		for final, n := range suspect.lines {
+			if o, ok := mapping[n]; ok {
+				passed[final] = o
+				delete(suspect.lines, final)
+			}
+		}
+
+		if len(passed) > 0 {
+			b.passLines(pf.id, pf.commit, pf.path, pf.blob, passed)
+		}
+	}
+
+	for final, n := range suspect.lines {
+		b.result[final].Commit = id
+		b.result[final].Author = suspect.commit.Author
+		b.result[final].OrigPath = suspect.path
+		b.result[final].OrigLine = n
+	}
+
+	return nil
+}
+
+//passLines adds the lines to the suspect for the given commit.
+func (b *blamer) passLines(id SHA1, c *Commit, path string, blob SHA1, lines map[int]int) {
+	suspect, ok := b.suspects[id]
+	if !ok {
+		suspect = &blameSuspect{commit: c, path: path, blob: blob, lines: make(map[int]int)}
+		b.suspects[id] = suspect
+		b.push(id, c)
+	}
+
+	for final, n := range lines {
+		suspect.lines[final] = n
+	}
+}
+
+//findParentFile finds the file in the parent that corresponds
+//to the suspect's file, i.e. the one at the same path or the one
+//it was renamed from. Returns an empty path if there is none.
+func (b *blamer) findParentFile(suspect *blameSuspect, parent *Commit) (string, SHA1, error) {
+	entry, err := b.repo.treeEntryAt(parent.Tree, suspect.path)
+	if err != nil {
+		return "", SHA1{}, err
+	} else if entry != nil && (modeKind(entry.Mode) == ModeBlob || entry.Mode == ModeSymlink) {
+		return suspect.path, entry.ID, nil
+	}
+
+	changes, err := b.repo.DiffTree(parent.Tree, suspect.commit.Tree, &DiffOptions{DetectRenames: true})
+	if err != nil {
+		return "", SHA1{}, err
+	}
+
+	for _, c := range changes {
+		if c.Type == ChangeRenamed && c.NewPath == suspect.path {
+			return c.OldPath, c.OldID, nil
+		}
+	}
+
+	return "", SHA1{}, nil
+}
@@ -0,0 +1,119 @@
+package gig
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+//gitBlame returns commit id and original line number for each
+//line of the file, as reported by "git blame --porcelain".
+func gitBlame(t *testing.T, dir, rev, path string) []string {
+	t.Helper()
+
+	out := gitCmd(t, dir, "blame", "--porcelain", rev, "--", path)
+
+	var res []string
+	s := bufio.NewScanner(strings.NewReader(out))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) >= 3 && len(fields[0]) == 40 {
+			if _, err := strconv.Atoi(fields[1]); err == nil {
+				res = append(res, fields[0]+" "+fields[1])
+			}
+		}
+	}
+
+	return res
+}
+
+func TestBlame(t *testing.T) {
+	repo, wt := mkTestRepo(t)
+
+	n := 0
+	commit := func(name, content string) {
+		p := filepath.Join(wt, name)
+		if err := ioutil.WriteFile(p, []byte(content), 0666); err != nil {
+			t.Fatalf("could not write file: %v", err)
+		}
+
+		gitCmd(t, wt, "add", "-A")
+		gitAt(t, wt, logBaseTime+n*3600, "commit", "-q", "-m", "blame "+strconv.Itoa(n))
+		n++
+	}
+
+	meta := "title: test\nauthors:\n  - A\n  - B\nlicense: CC-BY\nkeywords:\n  - one\n  - two\n"
+	commit("datacite.yml", meta)
+	commit("datacite.yml", strings.Replace(meta, "  - B\n", "  - B\n  - C\n", 1))
+
+	gitCmd(t, wt, "checkout", "-q", "-b", "side")
+	commit("datacite.yml", strings.Replace(meta, "  - B\n", "  - B\n  - C\n", 1)+"  - three\n")
+
+	gitCmd(t, wt, "checkout", "-q", "master")
+	commit("datacite.yml", strings.Replace(strings.Replace(meta, "  - B\n", "  - B\n  - C\n", 1), "CC-BY", "CC0", 1))
+	gitAt(t, wt, logBaseTime+n*3600, "merge", "-q", "--no-ff", "-m", "merge side", "side")
+	n++
+
+	gitCmd(t, wt, "mv", "datacite.yml", "meta.yml")
+	gitAt(t, wt, logBaseTime+n*3600, "commit", "-q", "-m", "rename")
+	n++
+
+	data, err := ioutil.ReadFile(filepath.Join(wt, "meta.yml"))
+	if err != nil {
+		t.Fatalf("could not read file: %v", err)
+	}
+	commit("meta.yml", "# header\n"+strings.Replace(string(data), "title: test", "title: Test", 1))
+	gitCmd(t, wt, "push", "-q", "bare", "master")
+
+	head, err := ParseSHA1(gitCmd(t, repo.Path, "rev-parse", "master"))
+	if err != nil {
+		t.Fatalf("could not parse id: %v", err)
+	}
+
+	for _, path := range []string{"meta.yml", "README.md", "data/sub/c.txt"} {
+		expected := gitBlame(t, repo.Path, "master", path)
+
+		lines, err := repo.Blame(head, path)
+		if err != nil {
+			t.Fatalf("blame failed: %v", err)
+		}
+
+		if len(lines) != len(expected) {
+			t.Fatalf("%s: expected %d lines, got %d", path, len(expected), len(lines))
+		}
+
+		for i, l := range lines {
+			if actual := l.Commit.String() + " " + strconv.Itoa(l.OrigLine); actual != expected[i] {
+				t.Fatalf("%s, line %d: expected %q, got %q", path, i+1, expected[i], actual)
+			}
+
+			if l.Author.Name != "A U Thor" {
+				t.Fatalf("unexpected author: %v", l.Author)
+			}
+		}
+	}
+
+	lines, err := repo.Blame(head, "meta.yml")
+	if err != nil {
+		t.Fatalf("blame failed: %v", err)
+	}
+
+	if lines[1].Content != "title: Test\n" || lines[2].OrigPath != "datacite.yml" {
+		t.Fatalf("unexpected blame lines: %+v, %+v", lines[1], lines[2])
+	}
+
+	var terr *ObjectTypeError
+	if _, err := repo.Blame(head, "data"); !errors.As(err, &terr) || terr.Type != ObjTree {
+		t.Fatalf("expected ObjectTypeError when blaming a directory, got %v", err)
+	}
+
+	var perr *os.PathError
+	if _, err := repo.Blame(head, "missing.txt"); !errors.Is(err, os.ErrNotExist) || !errors.As(err, &perr) || perr.Path != "missing.txt" {
+		t.Fatalf("expected os.ErrNotExist when blaming a missing file, got %v", err)
+	}
+}
@@ -0,0 +1,518 @@
+package gig
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Resources:
+//  https://github.com/git/git/blob/master/Documentation/technical/commit-graph.txt
+//  https://github.com/git/git/blob/master/Documentation/gitformat-commit-graph.txt
+
+//GenerationNumberInfinity is the generation number of commits
+//that are not contained in the commit-graph.
+const GenerationNumberInfinity = ^uint32(0)
+
+const (
+	graphParentNone  = 0x70000000
+	graphEdgeExtra   = 0x80000000
+	graphEdgeLast    = 0x80000000
+	graphHeaderSize  = 8
+	graphCDATEntryOp = 16 //size of a CDAT entry without the tree id
+)
+
+//CommitNode holds the information of a commit that is needed
+//to walk the commit history: its parents, its tree, the commit
+//date and its generation number (topological level).
+type CommitNode struct {
+	ID         SHA1
+	Tree       SHA1
+	Parent     []SHA1
+	Generation uint32
+	Date       time.Time
+}
+
+//CommitGraph represents the commit-graph of a repository, which
+//can consist of a single file or a chain of files. It gives fast
+//access to the history information of commits (see CommitNode)
+//without having to inflate and parse the commit objects.
+type CommitGraph struct {
+	files []*commitGraphFile
+}
+
+type commitGraphFile struct {
+	data   []byte
+	name   string
+	format ObjectFormat
+
+	fo   FanOut
+	oidl chunk
+	cdat chunk
+	edge chunk
+
+	//number of commits in all base graphs,
+	//i.e. the global position of the first
+	//commit in this file
+	base uint32
+}
+
+//OpenCommitGraph opens a single commit-graph file at path.
+func OpenCommitGraph(path string) (*CommitGraph, error) {
+	f, err := readCommitGraphFile(path, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CommitGraph{files: []*commitGraphFile{f}}, nil
+}
+
+//openCommitGraphChain opens the commit-graph chain, that is
+//described by the chain file at path. The chain file lists the
+//hashes of the graph files, starting with the base-most one.
+func openCommitGraphChain(path string) (*CommitGraph, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(path)
+	cg := &CommitGraph{}
+
+	var base uint32
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		l := strings.TrimSpace(s.Text())
+		if l == "" {
+			continue
+		}
+
+		fn := filepath.Join(dir, fmt.Sprintf("graph-%s.graph", l))
+		f, err := readCommitGraphFile(fn, base)
+		if err != nil {
+			return nil, err
+		}
+
+		cg.files = append(cg.files, f)
+		base += uint32(f.count())
+	}
+
+	if len(cg.files) == 0 {
+		return nil, fmt.Errorf("git: empty commit-graph chain")
+	}
+
+	return cg, nil
+}
+
+func readCommitGraphFile(path string, base uint32) (*commitGraphFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("git: could not read commit-graph: %v", err)
+	}
+
+	//header format:
+	//[sig{4}][version{1}][hash version{1}][#chunks{1}][#base graphs{1}]
+	if len(data) < graphHeaderSize || !bytes.Equal(data[:4], []byte("CGPH")) {
+		return nil, fmt.Errorf("git: commit-graph signature error")
+	}
+
+	if version := data[4]; version != 1 {
+		return nil, fmt.Errorf("git: unsupported commit-graph version: %d", version)
+	}
+
+	f := &commitGraphFile{data: data, name: path, base: base}
+	switch hv := data[5]; hv {
+	case 1:
+		f.format = FormatSHA1
+	case 2:
+		f.format = FormatSHA256
+	default:
+		return nil, fmt.Errorf("git: unsupported commit-graph hash version: %d", hv)
+	}
+
+	chunks, err := readChunkTable(bytes.NewReader(data), graphHeaderSize, int(data[6]))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range []string{"OIDF", "OIDL", "CDAT"} {
+		c, ok := chunks[id]
+		if !ok {
+			return nil, fmt.Errorf("git: commit-graph is missing the %s chunk", id)
+		} else if c.end > int64(len(data)) {
+			return nil, fmt.Errorf("git: commit-graph chunk %s is truncated", id)
+		}
+	}
+
+	oidf := chunks["OIDF"]
+	if oidf.end-oidf.start < 256*4 {
+		return nil, fmt.Errorf("git: commit-graph fanout is truncated")
+	}
+
+	for i := range f.fo {
+		f.fo[i] = binary.BigEndian.Uint32(data[oidf.start+int64(i)*4:])
+	}
+
+	f.oidl = chunks["OIDL"]
+	f.cdat = chunks["CDAT"]
+	f.edge = chunks["EDGE"]
+
+	n, hs := int64(f.count()), int64(f.format.Size())
+	if f.oidl.end-f.oidl.start < n*hs || f.cdat.end-f.cdat.start < n*(hs+graphCDATEntryOp) {
+		return nil, fmt.Errorf("git: commit-graph chunks are truncated")
+	}
+
+	if f.edge.end > int64(len(data)) {
+		return nil, fmt.Errorf("git: commit-graph chunk EDGE is truncated")
+	}
+
+	return f, nil
+}
+
+func (f *commitGraphFile) count() int {
+	return int(f.fo[255])
+}
+
+func (f *commitGraphFile) oid(pos int) SHA1 {
+	hs := int64(f.format.Size())
+	start := f.oidl.start + int64(pos)*hs
+	return f.format.ID(f.data[start : start+hs])
+}
+
+func (f *commitGraphFile) find(target SHA1) (int, bool) {
+	if target.format != f.format {
+		return 0, false
+	}
+
+	//see PackIndex.findSHA1; search interval is (s, e]
+	s, e := f.fo.Bounds(target.hash[0])
+
+	for s < e {
+		midpoint := s + (e-s+1)/2
+		sha := f.oid(midpoint - 1)
+
+		switch target.compare(sha) {
+		case -1:
+			e = midpoint - 1
+		case +1:
+			s = midpoint
+		default:
+			return midpoint - 1, true
+		}
+	}
+
+	return 0, false
+}
+
+//Count returns the number of commits in the commit-graph.
+func (cg *CommitGraph) Count() int {
+	last := cg.files[len(cg.files)-1]
+	return int(last.base) + last.count()
+}
+
+//lookup returns the file and the local position for the
+//given global position of a commit.
+func (cg *CommitGraph) lookup(pos uint32) (*commitGraphFile, int, error) {
+	for i := len(cg.files) - 1; i >= 0; i-- {
+		f := cg.files[i]
+		if pos >= f.base {
+			if local := int(pos - f.base); local < f.count() {
+				return f, local, nil
+			}
+			break
+		}
+	}
+
+	return nil, 0, fmt.Errorf("git: invalid commit-graph position: %d", pos)
+}
+
+//find returns the global position of the commit with the given id.
+func (cg *CommitGraph) find(id SHA1) (uint32, bool) {
+	for i := len(cg.files) - 1; i >= 0; i-- {
+		f := cg.files[i]
+		if pos, ok := f.find(id); ok {
+			return f.base + uint32(pos), true
+		}
+	}
+
+	return 0, false
+}
+
+//Contains checks if the commit with the given id is contained
+//in the commit-graph.
+func (cg *CommitGraph) Contains(id SHA1) bool {
+	_, ok := cg.find(id)
+	return ok
+}
+
+//Node returns the CommitNode of the commit with the given id,
+//or an error if it is not contained in the commit-graph.
+func (cg *CommitGraph) Node(id SHA1) (*CommitNode, error) {
+	pos, ok := cg.find(id)
+	if !ok {
+		return nil, fmt.Errorf("git: commit %s not in commit-graph", id)
+	}
+
+	return cg.node(pos)
+}
+
+func (cg *CommitGraph) oid(pos uint32) (SHA1, error) {
+	f, local, err := cg.lookup(pos)
+	if err != nil {
+		return SHA1{}, err
+	}
+
+	return f.oid(local), nil
+}
+
+func (cg *CommitGraph) node(pos uint32) (*CommitNode, error) {
+	f, local, err := cg.lookup(pos)
+	if err != nil {
+		return nil, err
+	}
+
+	node := &CommitNode{ID: f.oid(local)}
+
+	//CDAT entry format:
+	//[tree{20|32}][parent1{4}][parent2{4}][generation{30 bit}, time{34 bit}]
+	hs := f.format.Size()
+	start := f.cdat.start + int64(local)*int64(hs+graphCDATEntryOp)
+	entry := f.data[start : start+int64(hs+graphCDATEntryOp)]
+
+	node.Tree = f.format.ID(entry[:hs])
+	p1 := binary.BigEndian.Uint32(entry[hs:])
+	p2 := binary.BigEndian.Uint32(entry[hs+4:])
+	hi := binary.BigEndian.Uint32(entry[hs+8:])
+	lo := binary.BigEndian.Uint32(entry[hs+12:])
+
+	node.Generation = hi >> 2
+	node.Date = time.Unix(int64(hi&0x3)<<32|int64(lo), 0)
+
+	var parents []uint32
+	if p1 != graphParentNone {
+		parents = append(parents, p1)
+	}
+
+	switch {
+	case p2 == graphParentNone:
+	case p2&graphEdgeExtra == 0:
+		parents = append(parents, p2)
+	default:
+		//octopus merge, the other parents are
+		//stored in the extra edge list
+		for i := int64(p2 &^ graphEdgeExtra); ; i++ {
+			off := f.edge.start + i*4
+			if off+4 > f.edge.end {
+				return nil, fmt.Errorf("git: commit-graph edge list is truncated")
+			}
+
+			e := binary.BigEndian.Uint32(f.data[off:])
+			parents = append(parents, e&^graphEdgeLast)
+
+			if e&graphEdgeLast != 0 {
+				break
+			}
+		}
+	}
+
+	for _, p := range parents {
+		id, err := cg.oid(p)
+		if err != nil {
+			return nil, err
+		}
+		node.Parent = append(node.Parent, id)
+	}
+
+	return node, nil
+}
+
+//commitGraphPaths returns the paths of the single commit-graph
+//file and of the commit-graph chain file.
+func (repo *Repository) commitGraphPaths() (string, string) {
+	info := filepath.Join(repo.Path, "objects", "info")
+	return filepath.Join(info, "commit-graph"),
+		filepath.Join(info, "commit-graphs", "commit-graph-chain")
+}
+
+//CommitGraph returns the commit-graph of the repository, which is
+//loaded on first use and reloaded when it changes on disk. If the
+//repository does not have a commit-graph, nil is returned.
+func (repo *Repository) CommitGraph() (*CommitGraph, error) {
+	single, chain := repo.commitGraphPaths()
+
+	var stamp time.Time
+	var path string
+	if fi, err := os.Stat(chain); err == nil {
+		stamp, path = fi.ModTime(), chain
+	} else if fi, err := os.Stat(single); err == nil {
+		stamp, path = fi.ModTime(), single
+	} else {
+		return nil, nil
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if repo.graph != nil && repo.graphPath == path && repo.graphStamp.Equal(stamp) {
+		return repo.graph, nil
+	}
+
+	var cg *CommitGraph
+	var err error
+	if path == chain {
+		cg, err = openCommitGraphChain(path)
+	} else {
+		cg, err = OpenCommitGraph(path)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	repo.graph, repo.graphPath, repo.graphStamp = cg, path, stamp
+	return cg, nil
+}
+
+//OpenCommitNode returns the CommitNode for the commit with the
+//given id. If the commit is contained in the commit-graph, the
+//information is taken from there, otherwise the commit object is
+//opened and parsed. In the latter case the generation number is
+//GenerationNumberInfinity. Shallow commits have no parents.
+func (repo *Repository) OpenCommitNode(id SHA1) (*CommitNode, error) {
+	cg, err := repo.CommitGraph()
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.openCommitNode(cg, id)
+}
+
+func (repo *Repository) openCommitNode(cg *CommitGraph, id SHA1) (*CommitNode, error) {
+	node, err := repo.readCommitNode(cg, id)
+	if err != nil {
+		return nil, err
+	}
+
+	//shallow commits are the roots of the history
+	node.Parent, err = repo.commitParents(id, node.Parent)
+	if err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+func (repo *Repository) readCommitNode(cg *CommitGraph, id SHA1) (*CommitNode, error) {
+	if cg != nil {
+		if pos, ok := cg.find(id); ok {
+			return cg.node(pos)
+		}
+	}
+
+	obj, err := repo.OpenObject(id)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	commit, ok := obj.(*Commit)
+	if !ok {
+		return nil, &ObjectTypeError{ID: id, Type: obj.Type(), Expected: ObjCommit}
+	}
+
+	node := &CommitNode{
+		ID:         id,
+		Tree:       commit.Tree,
+		Parent:     commit.Parent,
+		Generation: GenerationNumberInfinity,
+		Date:       commit.Committer.Date,
+	}
+
+	return node, nil
+}
+
+//WalkAncestors walks the history starting at the commit start,
+//and calls goOn for every commit reachable from it exactly once.
+//If goOn returns false, the parents of that commit are not
+//visited (unless they are reachable via another path). The
+//commit-graph is used, if present, so commit objects need not
+//be inflated.
+func (repo *Repository) WalkAncestors(start SHA1, goOn func(*CommitNode) bool) error {
+	cg, err := repo.CommitGraph()
+	if err != nil {
+		return err
+	}
+
+	seen := map[SHA1]bool{start: true}
+	stack := []SHA1{start}
+
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		node, err := repo.openCommitNode(cg, id)
+		if err != nil {
+			return err
+		}
+
+		if !goOn(node) {
+			continue
+		}
+
+		for _, p := range node.Parent {
+			if !seen[p] {
+				seen[p] = true
+				stack = append(stack, p)
+			}
+		}
+	}
+
+	return nil
+}
+
+//hasGeneration checks if node has a valid generation number;
+//very old commit-graph files store zero for all commits.
+func hasGeneration(node *CommitNode) bool {
+	return node.Generation != 0 && node.Generation != GenerationNumberInfinity
+}
+
+//IsAncestor checks if the commit ancestor is reachable from the
+//commit descendant. A commit is considered an ancestor of itself.
+//Generation numbers from the commit-graph are used, if present,
+//to cut the search short.
+func (repo *Repository) IsAncestor(ancestor, descendant SHA1) (bool, error) {
+	cg, err := repo.CommitGraph()
+	if err != nil {
+		return false, err
+	}
+
+	target, err := repo.openCommitNode(cg, ancestor)
+	if err != nil {
+		return false, err
+	}
+
+	found := false
+	err = repo.WalkAncestors(descendant, func(node *CommitNode) bool {
+		if found || node.ID == ancestor {
+			found = true
+			return false
+		}
+
+		//all descendants of the target have a higher
+		//generation number than the target itself
+		if hasGeneration(node) && hasGeneration(target) &&
+			node.Generation <= target.Generation {
+			return false
+		}
+
+		return true
+	})
+
+	return found, err
+}
@@ -0,0 +1,164 @@
+package gig
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+//mkMergeHistory adds branches, a merge and an octopus
+//merge to the non-bare repository at wt and pushes the
+//result to the bare repository.
+func mkMergeHistory(t *testing.T, wt string) {
+	t.Helper()
+
+	for _, b := range []string{"b1", "b2", "b3"} {
+		gitCmd(t, wt, "checkout", "-q", "-b", b, "master")
+		p := filepath.Join(wt, b+".txt")
+		if err := ioutil.WriteFile(p, []byte(b+"\n"), 0666); err != nil {
+			t.Fatalf("could not write file: %v", err)
+		}
+		gitCmd(t, wt, "add", "-A")
+		gitCmd(t, wt, "commit", "-q", "-m", "branch "+b)
+	}
+
+	gitCmd(t, wt, "checkout", "-q", "master")
+	gitCmd(t, wt, "merge", "-q", "--no-ff", "-m", "merge b1", "b1")
+	gitCmd(t, wt, "merge", "-q", "--no-ff", "-m", "octopus", "b2", "b3")
+	gitCmd(t, wt, "push", "-q", "bare", "master")
+}
+
+func checkCommitGraph(t *testing.T, repo *Repository) {
+	t.Helper()
+
+	cg, err := repo.CommitGraph()
+	if err != nil || cg == nil {
+		t.Fatalf("could not load commit-graph: %v", err)
+	}
+
+	out := gitCmd(t, repo.Path, "log", "--format=%H %T %ct %P", "master")
+	lines := strings.Split(out, "\n")
+
+	if cg.Count() != len(lines) {
+		t.Fatalf("expected %d commits in commit-graph, got %d", len(lines), cg.Count())
+	}
+
+	for _, l := range lines {
+		fields := strings.Fields(l)
+		id, _ := ParseSHA1(fields[0])
+
+		node, err := cg.Node(id)
+		if err != nil {
+			t.Fatalf("could not get node for %s: %v", id, err)
+		}
+
+		if node.Tree.String() != fields[1] {
+			t.Errorf("%s: tree mismatch %s != %s", id, node.Tree, fields[1])
+		}
+
+		ts, _ := strconv.ParseInt(fields[2], 10, 64)
+		if node.Date.Unix() != ts {
+			t.Errorf("%s: date mismatch %d != %d", id, node.Date.Unix(), ts)
+		}
+
+		parents := fmt.Sprint(fields[3:])
+		if got := fmt.Sprint(node.Parent); got != parents {
+			t.Errorf("%s: parents mismatch %s != %s", id, got, parents)
+		}
+
+		for _, p := range node.Parent {
+			pn, err := cg.Node(p)
+			if err != nil {
+				t.Fatalf("could not get node for %s: %v", p, err)
+			}
+
+			if pn.Generation >= node.Generation {
+				t.Errorf("%s: generation %d not larger than parent's %d",
+					id, node.Generation, pn.Generation)
+			}
+		}
+	}
+}
+
+func TestCommitGraph(t *testing.T) {
+	repo, wt := mkTestRepo(t)
+	mkMergeHistory(t, wt)
+
+	if cg, err := repo.CommitGraph(); cg != nil || err != nil {
+		t.Fatalf("expected no commit-graph, got %v (%v)", cg, err)
+	}
+
+	gitCmd(t, repo.Path, "commit-graph", "write", "--reachable")
+	checkCommitGraph(t, repo)
+
+	//add another layer to get a commit-graph chain
+	if err := ioutil.WriteFile(filepath.Join(wt, "chain.txt"), []byte("chain\n"), 0666); err != nil {
+		t.Fatalf("could not write file: %v", err)
+	}
+	gitCmd(t, wt, "add", "-A")
+	gitCmd(t, wt, "commit", "-q", "-m", "chain")
+	gitCmd(t, wt, "push", "-q", "bare", "master")
+	gitCmd(t, repo.Path, "commit-graph", "write", "--reachable", "--split=no-merge")
+
+	checkCommitGraph(t, repo)
+	if cg, _ := repo.CommitGraph(); len(cg.files) < 2 {
+		t.Fatalf("expected a commit-graph chain, got %d file(s)", len(cg.files))
+	}
+}
+
+func TestIsAncestor(t *testing.T) {
+	repo, wt := mkTestRepo(t)
+	mkMergeHistory(t, wt)
+
+	check := func() {
+		all := strings.Split(gitCmd(t, repo.Path, "rev-list", "--all"), "\n")
+		b3, _ := ParseSHA1(gitCmd(t, wt, "rev-parse", "b3"))
+
+		for _, l := range all {
+			id, _ := ParseSHA1(l)
+			for _, other := range []SHA1{b3, id} {
+				isAnc, err := repo.IsAncestor(id, other)
+				if err != nil {
+					t.Fatalf("IsAncestor(%s, %s) failed: %v", id, other, err)
+				}
+
+				out := gitCmd(t, repo.Path, "rev-list", other.String())
+				want := strings.Contains(out, id.String())
+
+				if isAnc != want {
+					t.Errorf("IsAncestor(%s, %s) => %v, expected %v", id, other, isAnc, want)
+				}
+			}
+		}
+	}
+
+	check()
+	gitCmd(t, repo.Path, "commit-graph", "write", "--reachable")
+	check()
+}
+
+func TestWalkRefCommitGraph(t *testing.T) {
+	repo, wt := mkTestRepo(t)
+	mkMergeHistory(t, wt)
+	gitCmd(t, repo.Path, "commit-graph", "write", "--reachable")
+
+	commits, err := repo.WalkRef("master", func(SHA1) bool { return true })
+	if err != nil {
+		t.Fatalf("could not walk master: %v", err)
+	}
+
+	all := strings.Split(gitCmd(t, repo.Path, "rev-list", "master"), "\n")
+	if len(commits) != len(all) {
+		t.Fatalf("expected %d commits, got %d", len(all), len(commits))
+	}
+
+	for _, l := range all {
+		id, _ := ParseSHA1(l)
+		if c := commits[id]; c == nil || c.Message == "" {
+			t.Fatalf("commit %s missing from walk", id)
+		}
+	}
+}
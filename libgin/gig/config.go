@@ -0,0 +1,697 @@
+package gig
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//maxConfigIncludeDepth is the maximal nesting of included
+//config files, the same limit that git uses.
+const maxConfigIncludeDepth = 10
+
+//Config holds the values of one or more git config files, in the
+//order in which they were read, see git-config(1). Keys are of the
+//form "section.name" or "section.subsection.name"; section and name
+//are case-insensitive, the subsection is case-sensitive.
+type Config struct {
+	entries []configEntry
+
+	//noIncludes disables includes, for files that are not
+	//under the control of the user, like .gitmodules
+	noIncludes bool
+}
+
+type configEntry struct {
+	key   string
+	value string
+
+	//implicit is set for keys without "=", which are
+	//boolean true
+	implicit bool
+}
+
+//ReadConfig reads the config file at path, including the files it
+//includes via "include.path". Conditional includes ("includeIf") are
+//only resolved by Repository.Config.
+func ReadConfig(path string) (*Config, error) {
+	c := &Config{}
+	err := c.readFile(path, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+//normalizeConfigKey lower-cases the section and the name of the key,
+//but not the subsection.
+func normalizeConfigKey(key string) string {
+	first, last := strings.IndexByte(key, '.'), strings.LastIndexByte(key, '.')
+	if first == -1 {
+		return strings.ToLower(key)
+	}
+	return strings.ToLower(key[:first]) + key[first:last] + strings.ToLower(key[last:])
+}
+
+//Get returns the last value of the key. Keys without value,
+//which are boolean true, return an empty string.
+func (c *Config) Get(key string) (string, bool) {
+	e, ok := c.last(key)
+	return e.value, ok
+}
+
+//GetAll returns all values of the (multi-valued) key, in the
+//order in which they were read.
+func (c *Config) GetAll(key string) []string {
+	key = normalizeConfigKey(key)
+
+	var values []string
+	for _, e := range c.entries {
+		if e.key == key {
+			values = append(values, e.value)
+		}
+	}
+	return values
+}
+
+func (c *Config) last(key string) (configEntry, bool) {
+	key = normalizeConfigKey(key)
+	for i := len(c.entries) - 1; i >= 0; i-- {
+		if c.entries[i].key == key {
+			return c.entries[i], true
+		}
+	}
+	return configEntry{}, false
+}
+
+//Bool returns the boolean value of the key, or def if the key is
+//not set. True values are "true", "yes", "on", non-zero numbers
+//and keys without value; false values are "false", "no", "off",
+//zero and the empty string.
+func (c *Config) Bool(key string, def bool) (bool, error) {
+	e, ok := c.last(key)
+	if !ok {
+		return def, nil
+	} else if e.implicit {
+		return true, nil
+	}
+
+	switch strings.ToLower(e.value) {
+	case "true", "yes", "on":
+		return true, nil
+	case "false", "no", "off", "":
+		return false, nil
+	}
+
+	n, err := parseConfigInt(e.value)
+	if err != nil {
+		return false, fmt.Errorf("git: bad boolean config value %q for %s", e.value, key)
+	}
+	return n != 0, nil
+}
+
+//Int returns the integer value of the key, or def if the key is not
+//set. The value may have one of the (case-insensitive) suffixes "k",
+//"m" or "g", which multiply it by 1024, 1024² or 1024³.
+func (c *Config) Int(key string, def int64) (int64, error) {
+	e, ok := c.last(key)
+	if !ok {
+		return def, nil
+	}
+
+	n, err := parseConfigInt(e.value)
+	if err != nil {
+		return 0, fmt.Errorf("git: bad numeric config value %q for %s", e.value, key)
+	}
+	return n, nil
+}
+
+func parseConfigInt(value string) (int64, error) {
+	factor := int64(1)
+	if value != "" {
+		switch value[len(value)-1] {
+		case 'k', 'K':
+			factor = 1 << 10
+		case 'm', 'M':
+			factor = 1 << 20
+		case 'g', 'G':
+			factor = 1 << 30
+		}
+	}
+
+	if factor != 1 {
+		value = value[:len(value)-1]
+	}
+
+	n, err := strconv.ParseInt(value, 0, 64)
+	if err != nil {
+		return 0, err
+	} else if n > math.MaxInt64/factor || n < math.MinInt64/factor {
+		return 0, fmt.Errorf("value out of range")
+	}
+
+	return n * factor, nil
+}
+
+//Subsections returns the names of the subsections of section,
+//e.g. the names of the remotes for "remote", in the order in
+//which they first appear.
+func (c *Config) Subsections(section string) []string {
+	prefix := strings.ToLower(section) + "."
+	seen := make(map[string]bool)
+
+	var names []string
+	for _, e := range c.entries {
+		if !strings.HasPrefix(e.key, prefix) {
+			continue
+		}
+
+		last := strings.LastIndexByte(e.key, '.')
+		if last < len(prefix) {
+			continue
+		}
+
+		name := e.key[len(prefix):last]
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+//readFile parses the config file at path and appends its values.
+//Missing files are ignored. Conditional includes are resolved for
+//the repository at gitDir, if it is not empty.
+func (c *Config) readFile(path, gitDir string, depth int) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return c.parse(string(data), path, gitDir, depth)
+}
+
+//parse parses the contents of a config file, see the "CONFIGURATION
+//FILE" section of git-config(1).
+func (c *Config) parse(data, path, gitDir string, depth int) error {
+	var section string
+	line := 1
+
+	bad := func() error {
+		return fmt.Errorf("git: bad config line %d in %s", line, path)
+	}
+
+	for pos := 0; pos < len(data); {
+		ch := data[pos]
+		switch {
+		case ch == '\n':
+			line++
+			pos++
+			continue
+		case ch == ' ' || ch == '\t' || ch == '\r':
+			pos++
+			continue
+		case ch == '#' || ch == ';':
+			for pos < len(data) && data[pos] != '\n' {
+				pos++
+			}
+			continue
+		case ch == '[':
+			name, n, ok := parseConfigSection(data[pos+1:])
+			if !ok {
+				return bad()
+			}
+			section = name
+			pos += n + 1
+			continue
+		case !isConfigKeyChar(ch) || ch == '-' || (ch >= '0' && ch <= '9'):
+			return bad()
+		}
+
+		if section == "" {
+			return bad()
+		}
+
+		start := pos
+		for pos < len(data) && isConfigKeyChar(data[pos]) {
+			pos++
+		}
+		name := strings.ToLower(data[start:pos])
+
+		for pos < len(data) && (data[pos] == ' ' || data[pos] == '\t') {
+			pos++
+		}
+
+		e := configEntry{key: section + "." + name}
+		switch {
+		case pos == len(data) || data[pos] == '\n' || data[pos] == '\r' || data[pos] == '#' || data[pos] == ';':
+			e.implicit = true
+		case data[pos] == '=':
+			value, n, lines, ok := parseConfigValue(data[pos+1:])
+			if !ok {
+				return bad()
+			}
+			e.value = value
+			pos += n + 1
+			line += lines
+		default:
+			return bad()
+		}
+
+		c.entries = append(c.entries, e)
+
+		if include, ok := c.includePath(e, path, gitDir); ok {
+			if depth >= maxConfigIncludeDepth {
+				return fmt.Errorf("git: exceeded maximum config include depth in %s", path)
+			}
+
+			err := c.readFile(include, gitDir, depth+1)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func isConfigKeyChar(ch byte) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '-'
+}
+
+//parseConfigSection parses a section header after the opening
+//bracket, i.e. `section]`, `section "subsection"]` or the deprecated
+//`section.subsection]`. Returns the section as it is used in keys
+//and the number of bytes consumed.
+func parseConfigSection(data string) (string, int, bool) {
+	pos := 0
+	for pos < len(data) && (isConfigKeyChar(data[pos]) || data[pos] == '.') {
+		pos++
+	}
+
+	name := strings.ToLower(data[:pos])
+	if name == "" || pos == len(data) {
+		return "", 0, false
+	} else if data[pos] == ']' {
+		return name, pos + 1, true
+	} else if data[pos] != ' ' && data[pos] != '\t' {
+		return "", 0, false
+	}
+
+	for pos < len(data) && (data[pos] == ' ' || data[pos] == '\t') {
+		pos++
+	}
+
+	if pos == len(data) || data[pos] != '"' || strings.Contains(name, ".") {
+		return "", 0, false
+	}
+
+	var sub strings.Builder
+	for pos++; pos < len(data); pos++ {
+		switch ch := data[pos]; ch {
+		case '\n':
+			return "", 0, false
+		case '"':
+			if pos+1 < len(data) && data[pos+1] == ']' {
+				return name + "." + sub.String(), pos + 2, true
+			}
+			return "", 0, false
+		case '\\':
+			pos++
+			if pos == len(data) || data[pos] == '\n' {
+				return "", 0, false
+			}
+			sub.WriteByte(data[pos])
+		default:
+			sub.WriteByte(ch)
+		}
+	}
+
+	return "", 0, false
+}
+
+//parseConfigValue parses the value after the "=" up to the end of
+//the line: quotes are removed, escape sequences are resolved, comments
+//and leading and trailing whitespace are stripped and lines that end
+//with a backslash are continued. Returns the value, the number of
+//bytes and continued lines consumed.
+func parseConfigValue(data string) (string, int, int, bool) {
+	var b strings.Builder
+	quoted := false
+	spaces := 0
+	lines := 0
+	comment := false
+
+	pos := 0
+	for ; pos < len(data); pos++ {
+		ch := data[pos]
+		if ch == '\n' {
+			if quoted {
+				return "", 0, 0, false
+			}
+			break
+		} else if comment {
+			continue
+		}
+
+		switch {
+		case (ch == ' ' || ch == '\t' || ch == '\r') && !quoted:
+			if b.Len() > 0 {
+				spaces++
+			}
+			continue
+		case (ch == '#' || ch == ';') && !quoted:
+			comment = true
+			continue
+		}
+
+		for ; spaces > 0; spaces-- {
+			b.WriteByte(' ')
+		}
+
+		switch ch {
+		case '"':
+			quoted = !quoted
+		case '\\':
+			pos++
+			if pos == len(data) {
+				return "", 0, 0, false
+			}
+
+			switch data[pos] {
+			case '\n':
+				lines++
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'b':
+				b.WriteByte('\b')
+			case '"', '\\':
+				b.WriteByte(data[pos])
+			default:
+				return "", 0, 0, false
+			}
+		default:
+			b.WriteByte(ch)
+		}
+	}
+
+	if quoted {
+		return "", 0, 0, false
+	}
+
+	return b.String(), pos, lines, true
+}
+
+//includePath checks if the entry includes another config file and
+//returns its path, which is relative to the including file.
+func (c *Config) includePath(e configEntry, path, gitDir string) (string, bool) {
+	if c.noIncludes {
+		return "", false
+	}
+
+	var cond string
+	switch {
+	case e.key == "include.path":
+	case strings.HasPrefix(e.key, "includeif.") && strings.HasSuffix(e.key, ".path"):
+		cond = e.key[len("includeif.") : len(e.key)-len(".path")]
+		if !includeCondition(cond, path, gitDir) {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+
+	if e.implicit || e.value == "" {
+		return "", false
+	}
+
+	include := expandHome(e.value)
+	if !filepath.IsAbs(include) {
+		include = filepath.Join(filepath.Dir(path), include)
+	}
+
+	return include, true
+}
+
+//includeCondition evaluates the condition of an "includeIf"
+//section: "gitdir:", "gitdir/i:" and "onbranch:" are supported.
+func includeCondition(cond, path, gitDir string) bool {
+	if gitDir == "" {
+		return false
+	}
+
+	kind, pattern := split2(cond, ":")
+	switch kind {
+	case "gitdir", "gitdir/i":
+		if strings.HasPrefix(pattern, "./") {
+			pattern = filepath.ToSlash(filepath.Dir(path)) + pattern[1:]
+		}
+
+		pattern = expandHome(pattern)
+		if !strings.HasPrefix(pattern, "/") {
+			pattern = "**/" + pattern
+		}
+
+		candidates := []string{gitDir}
+		if real, err := filepath.EvalSymlinks(gitDir); err == nil && real != gitDir {
+			candidates = append(candidates, real)
+		}
+
+		for _, dir := range candidates {
+			if matchConfigPattern(pattern, filepath.ToSlash(dir), kind == "gitdir/i") {
+				return true
+			}
+		}
+	case "onbranch":
+		head, err := ioutil.ReadFile(filepath.Join(gitDir, "HEAD"))
+		if err != nil {
+			return false
+		}
+
+		branch := strings.TrimSpace(string(head))
+		if !strings.HasPrefix(branch, "ref: refs/heads/") {
+			return false
+		}
+
+		return matchConfigPattern(pattern, strings.TrimPrefix(branch, "ref: refs/heads/"), false)
+	}
+
+	return false
+}
+
+//matchConfigPattern matches the name against a glob pattern, where
+//"*" does not match "/", "**/" matches any number of directories and
+//a trailing "/" matches everything below the directory.
+func matchConfigPattern(pattern, name string, fold bool) bool {
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+
+	re, err := globRegexp(pattern, fold)
+	return err == nil && re.MatchString(name)
+}
+
+//globRegexp translates a glob pattern, as used by gitignore(5)
+//and conditional includes, into a regular expression.
+func globRegexp(pattern string, fold bool) (*regexp.Regexp, error) {
+	var re strings.Builder
+	if fold {
+		re.WriteString("(?i)")
+	}
+	re.WriteString("^")
+
+	for i := 0; i < len(pattern); i++ {
+		switch ch := pattern[i]; {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			re.WriteString("(.*/)?")
+			i += 2
+		case pattern[i:] == "/**":
+			re.WriteString("(/.*)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case ch == '\\' && i+1 < len(pattern):
+			re.WriteString(regexp.QuoteMeta(pattern[i+1 : i+2]))
+			i++
+		case ch == '*':
+			re.WriteString("[^/]*")
+		case ch == '?':
+			re.WriteString("[^/]")
+		case ch == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end == -1 {
+				re.WriteString(`\[`)
+				continue
+			}
+
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i += end + 1
+		default:
+			re.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	re.WriteString("$")
+
+	return regexp.Compile(re.String())
+}
+
+//expandHome replaces a leading "~/" with the home directory.
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+
+	return filepath.Join(home, path[2:])
+}
+
+//configFiles returns the paths of the system and the global config
+//files, in the order git reads them; the environment variables
+//GIT_CONFIG_NOSYSTEM, GIT_CONFIG_SYSTEM and GIT_CONFIG_GLOBAL are
+//honored.
+func configFiles() []string {
+	var files []string
+
+	if nosystem, _ := strconv.ParseBool(os.Getenv("GIT_CONFIG_NOSYSTEM")); !nosystem {
+		system := os.Getenv("GIT_CONFIG_SYSTEM")
+		if system == "" {
+			system = "/etc/gitconfig"
+		}
+		files = append(files, system)
+	}
+
+	if global, ok := os.LookupEnv("GIT_CONFIG_GLOBAL"); ok {
+		if global != "" {
+			files = append(files, global)
+		}
+		return files
+	}
+
+	xdg := os.Getenv("XDG_CONFIG_HOME")
+	if xdg == "" {
+		xdg = expandHome("~/.config")
+	}
+	files = append(files, filepath.Join(xdg, "git", "config"))
+
+	if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, filepath.Join(home, ".gitconfig"))
+	}
+
+	return files
+}
+
+//Config reads the system, the global and the repository config
+//files, in that order, so that later values take precedence. Missing
+//files are not an error.
+func (repo *Repository) Config() (*Config, error) {
+	c := &Config{}
+	files := append(configFiles(), filepath.Join(repo.Path, "config"))
+	for _, f := range files {
+		err := c.readFile(f, repo.Path, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+//readConfig reads only the config file of the repository, which is
+//used for settings that git only honors there, like "extensions.*".
+func (repo *Repository) readConfig() (*Config, error) {
+	c := &Config{}
+	err := c.readFile(filepath.Join(repo.Path, "config"), repo.Path, 0)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+//IsBare checks the "core.bare" setting of the repository. If it is
+//not set, a repository is bare unless it is the ".git" directory of
+//a work tree.
+func (repo *Repository) IsBare() (bool, error) {
+	config, err := repo.Config()
+	if err != nil {
+		return false, err
+	}
+	return config.Bool("core.bare", filepath.Base(repo.Path) != ".git")
+}
+
+//Remote is a remote repository, as configured in the
+//"remote.<name>" section.
+type Remote struct {
+	Name     string
+	URLs     []string
+	PushURLs []string
+	Fetch    []string
+}
+
+//Remotes returns the configured remotes, in the order
+//in which they appear in the config.
+func (repo *Repository) Remotes() ([]Remote, error) {
+	config, err := repo.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	var remotes []Remote
+	for _, name := range config.Subsections("remote") {
+		remotes = append(remotes, Remote{
+			Name:     name,
+			URLs:     config.GetAll("remote." + name + ".url"),
+			PushURLs: config.GetAll("remote." + name + ".pushurl"),
+			Fetch:    config.GetAll("remote." + name + ".fetch"),
+		})
+	}
+
+	return remotes, nil
+}
+
+//AnnexConfig are the git-annex settings of a repository.
+type AnnexConfig struct {
+	UUID    string
+	Version int64
+}
+
+//Annex returns the git-annex settings of the repository, i.e.
+//"annex.uuid" and "annex.version"; nil if the repository is not
+//a git-annex repository.
+func (repo *Repository) Annex() (*AnnexConfig, error) {
+	config, err := repo.readConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	uuid, hasUUID := config.Get("annex.uuid")
+	_, hasVersion := config.Get("annex.version")
+	if !hasUUID && !hasVersion {
+		return nil, nil
+	}
+
+	version, err := config.Int("annex.version", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AnnexConfig{UUID: uuid, Version: version}, nil
+}
@@ -0,0 +1,232 @@
+package gig
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testConfig = `# a comment
+[core]
+	bare = true ; trailing comment
+	filemode
+	Compression = 9
+[remote "Origin"]
+	url = https://example.com/a.git
+	url = "git@example.com:a.git"
+	fetch = +refs/heads/*:refs/remotes/origin/*
+[remote "up \"stream\""] url = /path/to/upstream
+[pack]
+	windowMemory = 10m
+	bigFileThreshold = 1G
+	threads = 0x10
+	depth = -2k
+[user]
+	name = "A \"quoted\"  name"   # trailing comment
+	multi = one \
+two
+	tab = a\tb "; not a comment"
+	empty =
+[Section.Sub]
+	key = value
+[include]
+	path = included.cfg
+[core]
+	bare = false
+`
+
+func writeConfig(t *testing.T, dir, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+		t.Fatalf("could not write config: %v", err)
+	}
+	return path
+}
+
+func TestReadConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfig(t, dir, "config", testConfig)
+	writeConfig(t, dir, "included.cfg", "[core]\n\tcompression = 1\n[include]\n\tpath = nested.cfg\n")
+	writeConfig(t, dir, "nested.cfg", "[user]\n\tmulti = three\n")
+
+	c, err := ReadConfig(path)
+	if err != nil {
+		t.Fatalf("could not read config: %v", err)
+	}
+
+	values := map[string]string{
+		"core.bare":              "false",
+		"CORE.Compression":       "1",
+		"core.filemode":          "",
+		"remote.Origin.URL":      "git@example.com:a.git",
+		`remote.up "stream".url`: "/path/to/upstream",
+		"user.name":              `A "quoted"  name`,
+		"user.tab":               "a\tb ; not a comment",
+		"user.empty":             "",
+		"user.multi":             "three",
+		"section.sub.key":        "value",
+	}
+
+	for key, expected := range values {
+		value, ok := c.Get(key)
+		if !ok || value != expected {
+			t.Fatalf("%s: expected %q, got %q (%v)", key, expected, value, ok)
+		}
+
+		//git itself agrees
+		if out := gitCmd(t, dir, "config", "--file", path, "--includes", "--get", key); out != strings.TrimSpace(expected) {
+			t.Fatalf("%s: git says %q, got %q", key, out, value)
+		}
+	}
+
+	if _, ok := c.Get("remote.origin.url"); ok {
+		t.Fatalf("expected subsections to be case-sensitive")
+	}
+
+	if all := c.GetAll("user.multi"); !reflect.DeepEqual(all, []string{"one two", "three"}) {
+		t.Fatalf("unexpected values for user.multi: %q", all)
+	}
+
+	if subs := c.Subsections("remote"); !reflect.DeepEqual(subs, []string{"Origin", `up "stream"`}) {
+		t.Fatalf("unexpected remotes: %q", subs)
+	}
+
+	bools := map[string]bool{"core.bare": false, "core.filemode": true, "user.empty": false, "pack.threads": true, "core.missing": true}
+	for key, expected := range bools {
+		if b, err := c.Bool(key, true); err != nil || b != expected {
+			t.Fatalf("%s: expected %v, got %v (%v)", key, expected, b, err)
+		}
+	}
+
+	ints := map[string]int64{"pack.windowMemory": 10 << 20, "pack.bigFileThreshold": 1 << 30, "pack.threads": 16, "pack.depth": -2048, "core.missing": 42}
+	for key, expected := range ints {
+		if n, err := c.Int(key, 42); err != nil || n != expected {
+			t.Fatalf("%s: expected %d, got %d (%v)", key, expected, n, err)
+		}
+	}
+
+	if _, err := c.Int("user.name", 0); err == nil {
+		t.Fatalf("expected error for non-numeric value")
+	}
+
+	if _, err := c.Bool("user.tab", false); err == nil {
+		t.Fatalf("expected error for non-boolean value")
+	}
+
+	for _, bad := range []string{"[core\n", "key = 1\n", "[a]\nk = \"open\n", "[a]\nk = \\q\n", "[a]\n1k = v\n", "[a \"b]\n"} {
+		path := writeConfig(t, dir, "bad", bad)
+		if _, err := ReadConfig(path); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+
+	//a file that includes itself
+	path = writeConfig(t, dir, "loop", "[include]\n\tpath = loop\n")
+	if _, err := ReadConfig(path); err == nil {
+		t.Fatalf("expected error for recursive include")
+	}
+}
+
+func TestRepositoryConfig(t *testing.T) {
+	repo, wt := mkTestRepo(t)
+
+	dir := t.TempDir()
+	global := writeConfig(t, dir, "global", `[user]
+	name = Global
+[includeIf "gitdir:`+repo.Path+`"]
+	path = gitdir.cfg
+[includeIf "gitdir/i:`+strings.ToUpper(repo.Path)+`/"]
+	path = gitdir-i.cfg
+[includeIf "gitdir:/nonexistent/"]
+	path = other.cfg
+[includeIf "onbranch:ma*"]
+	path = branch.cfg
+`)
+	writeConfig(t, dir, "gitdir.cfg", "[test]\n\tgitdir = yes\n")
+	writeConfig(t, dir, "gitdir-i.cfg", "[test]\n\tgitdiri = yes\n")
+	writeConfig(t, dir, "other.cfg", "[test]\n\tother = yes\n")
+	writeConfig(t, dir, "branch.cfg", "[test]\n\tbranch = yes\n")
+
+	setenv(t, "GIT_CONFIG_NOSYSTEM", "1")
+	setenv(t, "GIT_CONFIG_GLOBAL", global)
+
+	gitCmd(t, repo.Path, "config", "user.name", "Local")
+
+	c, err := repo.Config()
+	if err != nil {
+		t.Fatalf("could not read config: %v", err)
+	}
+
+	if name, _ := c.Get("user.name"); name != "Local" {
+		t.Fatalf("expected the repository config to take precedence, got %q", name)
+	}
+
+	if all := c.GetAll("user.name"); !reflect.DeepEqual(all, []string{"Global", "Local"}) {
+		t.Fatalf("unexpected values for user.name: %q", all)
+	}
+
+	for key, expected := range map[string]bool{"test.gitdir": true, "test.gitdiri": true, "test.other": false, "test.branch": true} {
+		if b, err := c.Bool(key, false); err != nil || b != expected {
+			t.Fatalf("%s: expected %v, got %v (%v)", key, expected, b, err)
+		}
+	}
+
+	if bare, err := repo.IsBare(); err != nil || !bare {
+		t.Fatalf("expected bare repository: %v", err)
+	}
+
+	//without core.bare, the .git directory of a work tree is not bare
+	gitCmd(t, wt, "config", "--unset", "core.bare")
+	if bare, err := (&Repository{Path: filepath.Join(wt, ".git")}).IsBare(); err != nil || bare {
+		t.Fatalf("expected non-bare repository: %v", err)
+	}
+
+	for path, expected := range map[string]bool{repo.Path: true, wt: false, filepath.Join(wt, ".git"): false, dir: false} {
+		if IsBareRepository(path) != expected {
+			t.Fatalf("%s: expected IsBareRepository to be %v", path, expected)
+		}
+	}
+
+	remotes, err := repo.Remotes()
+	if err != nil {
+		t.Fatalf("could not read remotes: %v", err)
+	}
+
+	if len(remotes) != 1 || remotes[0].Name != "origin" || !reflect.DeepEqual(remotes[0].URLs, []string{wt}) {
+		t.Fatalf("unexpected remotes: %+v", remotes)
+	}
+
+	annex, err := repo.Annex()
+	if err != nil || annex != nil {
+		t.Fatalf("expected no annex config, got %v (%v)", annex, err)
+	}
+
+	gitCmd(t, repo.Path, "config", "annex.uuid", "5d4d0a2f-0a71-4c4b-9b76-1c6a3b1d7f54")
+	gitCmd(t, repo.Path, "config", "annex.version", "8")
+
+	annex, err = repo.Annex()
+	if err != nil || annex == nil || annex.UUID != "5d4d0a2f-0a71-4c4b-9b76-1c6a3b1d7f54" || annex.Version != 8 {
+		t.Fatalf("unexpected annex config %+v (%v)", annex, err)
+	}
+}
+
+//setenv sets the environment variable for the rest of the
+//test; the old value is restored when the test is done.
+func setenv(t *testing.T, key, value string) {
+	t.Helper()
+
+	old, had := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if had {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
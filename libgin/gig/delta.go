@@ -39,7 +39,7 @@ func readByte(r io.Reader) (byte, error) {
 	return b[0], nil
 }
 
-func parseDelta(obj gitObject) (*Delta, error) {
+func parseDelta(obj gitObject) (_ *Delta, err error) {
 	delta := Delta{gitObject: obj}
 
 	//all delta objects come from a PackFile and
@@ -47,15 +47,21 @@ func parseDelta(obj gitObject) (*Delta, error) {
 	source := delta.source.(*packReader)
 	delta.pf = source.fd
 
-	var err error
-	if obj.otype == ObjRefDelta {
-		_, err = source.Read(delta.BaseRef[:])
-		//TODO: check n?
+	defer func() {
+		if err != nil {
+			source.Close()
+		}
+	}()
 
+	if obj.otype == ObjRefDelta {
+		buf := make([]byte, delta.pf.Format.Size())
+		_, err = io.ReadFull(source, buf)
 		if err != nil {
 			return nil, err
 		}
 
+		delta.BaseRef = delta.pf.Format.ID(buf)
+
 	} else {
 		off, err := readVarint(source)
 		if err != nil {
@@ -271,98 +277,458 @@ func (d *Delta) WriteTo(w io.Writer) (int64, error) {
 	return 0, fmt.Errorf("WriteTo not implemented for Delta")
 }
 
-type deltaChain struct {
-	baseObj gitObject
-	baseOff int64
+//deltaReader applies a delta onto the base data while it is
+//being read, so the target never has to be fully in memory.
+type deltaReader struct {
+	delta *Delta
+	base  []byte
 
-	links []Delta
+	op     DeltaOp
+	remain int64
+	n      int64
+	err    error
 }
 
-func (c *deltaChain) Len() int {
-	return len(c.links)
+func newDeltaReader(d *Delta, base []byte) (*deltaReader, error) {
+	if d.SizeSource != int64(len(base)) {
+		return nil, fmt.Errorf("git: delta base size mismatch")
+	}
+
+	return &deltaReader{delta: d, base: base}, nil
 }
 
-type objectSource interface {
-	openRawObject(id SHA1) (gitObject, error)
+func (r *deltaReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	for r.remain == 0 {
+		if !r.delta.NextOp() {
+			r.err = r.delta.Err()
+			if r.err == nil && r.n != r.delta.SizeTarget {
+				r.err = fmt.Errorf("git: size mismatch while patching delta object")
+			} else if r.err == nil {
+				r.err = io.EOF
+			}
+			return 0, r.err
+		}
+
+		r.op = r.delta.Op()
+		if r.op.Op == DeltaOpCopy && r.op.Offset+r.op.Size > int64(len(r.base)) {
+			r.err = fmt.Errorf("git: delta copy out of bounds")
+			return 0, r.err
+		}
+
+		r.remain = r.op.Size
+	}
+
+	if int64(len(p)) > r.remain {
+		p = p[:r.remain]
+	}
+
+	var n int
+	if r.op.Op == DeltaOpCopy {
+		n = copy(p, r.base[r.op.Offset:])
+		r.op.Offset += int64(n)
+	} else {
+		n, r.err = io.ReadFull(r.delta.source, p)
+		if r.err == io.EOF {
+			r.err = io.ErrUnexpectedEOF
+		}
+	}
+
+	r.remain -= int64(n)
+	r.n += int64(n)
+	if r.n > r.delta.SizeTarget {
+		r.err = fmt.Errorf("git: size mismatch while patching delta object")
+		return 0, r.err
+	}
+
+	return n, r.err
 }
 
-func buildDeltaChain(d *Delta, s objectSource) (*deltaChain, error) {
-	var chain deltaChain
-	var err error
+func (r *deltaReader) Close() error {
+	return r.delta.source.Close()
+}
+
+//applyDelta applies the delta to the base and returns the result,
+//which may not be larger than limit. The delta is closed.
+func applyDelta(d *Delta, base []byte, limit int64) ([]byte, error) {
+	defer d.Close()
+
+	if d.SizeTarget > limit {
+		return nil, fmt.Errorf("%w: delta target of %d bytes", ErrObjectTooLarge, d.SizeTarget)
+	} else if d.SizeTarget > int64(^uint(0)>>1) {
+		return nil, fmt.Errorf("git: target to large for delta unpatching")
+	}
+
+	r, err := newDeltaReader(d, base)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := readSized(r, d.SizeTarget)
+	if err != nil {
+		return nil, err
+	}
+
+	//make sure the delta is complete
+	var buf [1]byte
+	if n, err := r.Read(buf[:]); n != 0 || err != io.EOF {
+		if err == nil || err == io.EOF {
+			err = fmt.Errorf("git: size mismatch while patching delta object")
+		}
+		return nil, err
+	}
+
+	return data, nil
+}
+
+//readObjectData reads all data of the (standard) object, which
+//may not be larger than limit.
+func readObjectData(obj gitObject, limit int64) ([]byte, error) {
+	defer obj.source.Close()
+
+	if obj.size > limit {
+		return nil, fmt.Errorf("%w: object of %d bytes", ErrObjectTooLarge, obj.size)
+	}
+
+	data, err := readSized(obj.source, obj.size)
+	if err != nil {
+		return nil, fmt.Errorf("git: could not read object data: %v", err)
+	}
+
+	return data, nil
+}
+
+//readSized reads exactly size bytes from r. The buffer grows with
+//the data that is actually read, instead of being allocated for the
+//declared size upfront, so that bogus sizes in object or delta
+//headers cannot exhaust the memory.
+func readSized(r io.Reader, size int64) ([]byte, error) {
+	var buf bytes.Buffer
+	if size < readSizedChunk {
+		buf.Grow(int(size))
+	} else {
+		buf.Grow(readSizedChunk)
+	}
+
+	n, err := io.Copy(&buf, io.LimitReader(r, size))
+	if err != nil {
+		return nil, err
+	} else if n != size {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return buf.Bytes(), nil
+}
+
+//readSizedChunk is the amount of memory readSized allocates
+//at most before any data has been read.
+const readSizedChunk = 1 << 20
+
+//DefaultMaxObjectSize is the default maximum size (in bytes) of
+//objects that are read into memory as a whole.
+const DefaultMaxObjectSize = 512 << 20
+
+//maxObjectSize returns the maximum size of objects that are
+//read into memory as a whole.
+func (repo *Repository) maxObjectSize() int64 {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if repo.maxObject == 0 {
+		return DefaultMaxObjectSize
+	}
+	return repo.maxObject
+}
+
+//SetMaxObjectSize sets the maximum size (in bytes) of objects that
+//are read into memory as a whole, i.e. delta bases, the results of
+//applying deltas while indexing packs (see IndexPack) and the like,
+//similar to git's core.bigFileThreshold. Larger objects yield an
+//error that wraps ErrObjectTooLarge; objects that are read via
+//OpenObject are streamed and only limited by the size of their
+//delta bases. Zero restores the default.
+func (repo *Repository) SetMaxObjectSize(limit int64) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.maxObject = limit
+}
+
+//resolveDelta returns the object that is represented by the delta.
+//All bases are resolved in memory (and cached), the delta itself is
+//applied while reading the returned object. On errors, the delta
+//is closed.
+func (repo *Repository) resolveDelta(d *Delta) (Object, error) {
+	otype, base, err := repo.deltaBase(d)
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
 
-	for err == nil {
+	r, err := newDeltaReader(d, base)
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+
+	return parseObject(gitObject{otype: otype, size: d.SizeTarget, source: r, format: d.format})
+}
+
+//deltaBase returns the type and the data of the base of the delta.
+func (repo *Repository) deltaBase(d *Delta) (ObjectType, []byte, error) {
+	cache := repo.deltaCache()
+	limit := repo.maxObjectSize()
+
+	var chain []*Delta
+	var keys []deltaCacheKey
+
+	//the deltas of the chain hold their pack readers until they
+	//are closed; applyDelta closes them, unless it is not reached
+	defer func() {
+		for _, d := range chain {
+			d.Close()
+		}
+	}()
+
+	var otype ObjectType
+	var data []byte
 
-		chain.links = append(chain.links, *d)
+	for {
+		pf, off := d.pf, d.BaseOff
 
-		var obj gitObject
 		if d.otype == ObjRefDelta {
-			obj, err = s.openRawObject(d.BaseRef)
-		} else {
-			obj, err = d.pf.readRawObject(d.BaseOff)
+			var found bool
+			var err error
+			pf, off, found, err = repo.packSet().find(d.BaseRef)
+			if err != nil {
+				return 0, nil, err
+			} else if found {
+				defer pf.release()
+			}
+
+			if !found {
+				//base outside of packs, i.e. a loose object
+				obj, err := repo.openRawObject(d.BaseRef)
+				if err != nil {
+					return 0, nil, err
+				} else if !IsStandardObject(obj.otype) {
+					obj.Close()
+					return 0, nil, fmt.Errorf("git: unexpected object type in delta chain")
+				}
+
+				otype = obj.otype
+				data, err = readObjectData(obj, limit)
+				if err != nil {
+					return 0, nil, err
+				}
+				break
+			}
 		}
 
-		if err != nil {
+		key := deltaCacheKey{pf.Name(), off}
+		if t, cached, ok := cache.get(key); ok {
+			otype, data = t, cached
 			break
 		}
 
+		obj, err := pf.readRawObject(off)
+		if err != nil {
+			return 0, nil, err
+		}
+
 		if IsStandardObject(obj.otype) {
-			chain.baseObj = obj
-			chain.baseOff = d.BaseOff
+			otype = obj.otype
+			data, err = readObjectData(obj, limit)
+			if err != nil {
+				return 0, nil, err
+			}
+
+			cache.add(key, otype, data)
 			break
 		} else if !IsDeltaObject(obj.otype) {
-			err = fmt.Errorf("git: unexpected object type in delta chain")
-			break
+			obj.Close()
+			return 0, nil, fmt.Errorf("git: unexpected object type in delta chain")
 		}
 
 		d, err = parseDelta(obj)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		chain = append(chain, d)
+		keys = append(keys, key)
 	}
 
-	if err != nil {
-		//cleanup
-		return nil, err
+	for i := len(chain) - 1; i >= 0; i-- {
+		var err error
+		data, err = applyDelta(chain[i], data, limit)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		cache.add(keys[i], otype, data)
 	}
 
-	return &chain, nil
+	return otype, data, nil
 }
 
-func (c *deltaChain) resolve() (Object, error) {
+//deltaBlockSize is the size of the blocks of the source data
+//that are indexed when creating deltas.
+const deltaBlockSize = 16
 
-	ibuf := bytes.NewBuffer(make([]byte, 0, c.baseObj.Size()))
-	n, err := io.Copy(ibuf, c.baseObj.source)
-	if err != nil {
-		return nil, err
+//deltaIndex is an index of the blocks of source data, used
+//to create deltas against that source (see diff).
+type deltaIndex struct {
+	source []byte
+	blocks map[uint32][]int
+}
+
+//deltaMaxCandidates is the maximal number of source offsets
+//that are kept for blocks with the same hash.
+const deltaMaxCandidates = 64
+
+func deltaBlockHash(data []byte) uint32 {
+	//FNV-1a
+	h := uint32(2166136261)
+	for _, b := range data[:deltaBlockSize] {
+		h ^= uint32(b)
+		h *= 16777619
 	}
+	return h
+}
 
-	if n != c.baseObj.Size() {
-		return nil, io.ErrUnexpectedEOF
+func newDeltaIndex(source []byte) *deltaIndex {
+	idx := &deltaIndex{source: source, blocks: make(map[uint32][]int)}
+
+	for i := 0; i+deltaBlockSize <= len(source); i += deltaBlockSize {
+		h := deltaBlockHash(source[i:])
+		if offs := idx.blocks[h]; len(offs) < deltaMaxCandidates {
+			idx.blocks[h] = append(offs, i)
+		}
 	}
 
-	obuf := bytes.NewBuffer(make([]byte, 0, c.baseObj.Size()))
+	return idx
+}
+
+func appendVarSize(buf []byte, size int64) []byte {
+	for size >= 0x80 {
+		buf = append(buf, byte(size)|0x80)
+		size >>= 7
+	}
+	return append(buf, byte(size))
+}
 
-	for i := len(c.links); i > 0; i-- {
-		lk := c.links[i-1]
+//deltaMaxCopy is the maximal size of data that is copied
+//by a single copy operation (see DeltaOpCopy).
+const deltaMaxCopy = 0x10000
 
-		if lk.SizeTarget > int64(^uint(0)>>1) {
-			return nil, fmt.Errorf("git: target to large for delta unpatching")
+func appendDeltaCopy(buf []byte, offset, size int) []byte {
+	for size > 0 {
+		n := size
+		if n > deltaMaxCopy {
+			n = deltaMaxCopy
 		}
 
-		obuf.Grow(int(lk.SizeTarget))
-		obuf.Truncate(0)
+		op := byte(0x80)
+		var args []byte
+		for i := uint(0); i < 4; i++ {
+			if b := byte(offset >> (i * 8)); b != 0 {
+				op |= 1 << i
+				args = append(args, b)
+			}
+		}
 
-		err = lk.Patch(bytes.NewReader(ibuf.Bytes()), obuf)
+		//a size of 0x10000 is encoded as zero
+		s := n
+		if s == deltaMaxCopy {
+			s = 0
+		}
 
-		if err != nil {
-			return nil, err
+		for i := uint(0); i < 3; i++ {
+			if b := byte(s >> (i * 8)); b != 0 {
+				op |= 1 << (4 + i)
+				args = append(args, b)
+			}
 		}
 
-		if lk.SizeTarget != int64(obuf.Len()) {
-			return nil, fmt.Errorf("git: size mismatch while patching delta object")
+		buf = append(append(buf, op), args...)
+		offset += n
+		size -= n
+	}
+
+	return buf
+}
+
+func appendDeltaInsert(buf []byte, data []byte) []byte {
+	for len(data) > 0 {
+		n := len(data)
+		if n > 0x7F {
+			n = 0x7F
 		}
 
-		obuf, ibuf = ibuf, obuf
+		buf = append(buf, byte(n))
+		buf = append(buf, data[:n]...)
+		data = data[n:]
+	}
+
+	return buf
+}
+
+//diff creates the delta data that transforms the indexed source
+//into target. If the delta would be larger than maxSize, nil is
+//returned.
+func (idx *deltaIndex) diff(target []byte, maxSize int) []byte {
+	src := idx.source
+
+	delta := appendVarSize(nil, int64(len(src)))
+	delta = appendVarSize(delta, int64(len(target)))
+
+	pending := 0 //start of the not yet emitted insert data
+	i := 0
+	for i+deltaBlockSize <= len(target) {
+		bestOff, bestLen := 0, 0
+
+		for _, off := range idx.blocks[deltaBlockHash(target[i:])] {
+			n := 0
+			for off+n < len(src) && i+n < len(target) && src[off+n] == target[i+n] {
+				n++
+			}
+
+			if n > bestLen {
+				bestOff, bestLen = off, n
+			}
+		}
+
+		if bestLen < deltaBlockSize {
+			i++
+			continue
+		}
+
+		//extend the match backwards into the pending data
+		for bestOff > 0 && i > pending && src[bestOff-1] == target[i-1] {
+			bestOff--
+			bestLen++
+			i--
+		}
+
+		delta = appendDeltaInsert(delta, target[pending:i])
+		delta = appendDeltaCopy(delta, bestOff, bestLen)
+
+		i += bestLen
+		pending = i
+
+		if len(delta) > maxSize {
+			return nil
+		}
+	}
+
+	delta = appendDeltaInsert(delta, target[pending:])
+	if len(delta) > maxSize {
+		return nil
 	}
 
-	//ibuf is holding the data
-	obj := gitObject{c.baseObj.otype, int64(ibuf.Len()), ioutil.NopCloser(ibuf)}
-	return parseObject(obj)
+	return delta
 }
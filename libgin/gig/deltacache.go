@@ -0,0 +1,130 @@
+package gig
+
+import (
+	"container/list"
+	"sync"
+)
+
+//DefaultDeltaBaseCacheLimit is the default memory budget (in bytes)
+//of the cache for delta bases, like git's core.deltaBaseCacheLimit.
+const DefaultDeltaBaseCacheLimit = 96 << 20
+
+//deltaCacheKey identifies an object by its location in a pack.
+type deltaCacheKey struct {
+	pack   string
+	offset int64
+}
+
+type deltaCacheEntry struct {
+	key   deltaCacheKey
+	otype ObjectType
+	data  []byte
+}
+
+//deltaBaseCache is a LRU cache of resolved objects from pack files
+//that serve as delta bases, bounded by the total size of the data.
+//The cached data is shared and must not be modified.
+type deltaBaseCache struct {
+	mu      sync.Mutex
+	limit   int64
+	size    int64
+	lru     *list.List
+	entries map[deltaCacheKey]*list.Element
+}
+
+func newDeltaBaseCache(limit int64) *deltaBaseCache {
+	return &deltaBaseCache{
+		limit:   limit,
+		lru:     list.New(),
+		entries: make(map[deltaCacheKey]*list.Element),
+	}
+}
+
+func (c *deltaBaseCache) get(key deltaCacheKey) (ObjectType, []byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return 0, nil, false
+	}
+
+	c.lru.MoveToFront(el)
+	e := el.Value.(*deltaCacheEntry)
+	return e.otype, e.data, true
+}
+
+func (c *deltaBaseCache) add(key deltaCacheKey, otype ObjectType, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if int64(len(data)) > c.limit {
+		return
+	}
+
+	if el, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&deltaCacheEntry{key, otype, data})
+	c.size += int64(len(data))
+	c.evict()
+}
+
+//getLimit returns the memory budget.
+func (c *deltaBaseCache) getLimit() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.limit
+}
+
+//setLimit changes the memory budget, evicting entries if needed.
+func (c *deltaBaseCache) setLimit(limit int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.limit = limit
+	c.evict()
+}
+
+//clear removes all entries.
+func (c *deltaBaseCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lru.Init()
+	c.entries = make(map[deltaCacheKey]*list.Element)
+	c.size = 0
+}
+
+func (c *deltaBaseCache) evict() {
+	for c.size > c.limit {
+		el := c.lru.Back()
+		e := el.Value.(*deltaCacheEntry)
+
+		c.lru.Remove(el)
+		delete(c.entries, e.key)
+		c.size -= int64(len(e.data))
+	}
+}
+
+//deltaCache returns the delta base cache of the
+//repository, which is created on first use.
+func (repo *Repository) deltaCache() *deltaBaseCache {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if repo.deltaBases == nil {
+		repo.deltaBases = newDeltaBaseCache(DefaultDeltaBaseCacheLimit)
+	}
+
+	return repo.deltaBases
+}
+
+//SetDeltaBaseCacheLimit sets the memory budget (in bytes) for the
+//cache of delta base objects. Zero disables the cache.
+func (repo *Repository) SetDeltaBaseCacheLimit(limit int64) {
+	repo.deltaCache().setLimit(limit)
+}
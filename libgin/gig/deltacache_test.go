@@ -0,0 +1,132 @@
+package gig
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDeltaBaseCache(t *testing.T) {
+	c := newDeltaBaseCache(10)
+	key := func(i int) deltaCacheKey { return deltaCacheKey{"pack", int64(i)} }
+
+	c.add(key(1), ObjBlob, []byte("aaaa"))
+	c.add(key(2), ObjBlob, []byte("bbbb"))
+
+	//access 1, so that 2 is the least recently used
+	if _, data, ok := c.get(key(1)); !ok || string(data) != "aaaa" {
+		t.Fatalf("expected cached entry, got %q, %v", data, ok)
+	}
+
+	c.add(key(3), ObjTree, []byte("cccc"))
+	if _, _, ok := c.get(key(2)); ok {
+		t.Fatal("least recently used entry should have been evicted")
+	}
+
+	if otype, _, ok := c.get(key(3)); !ok || otype != ObjTree {
+		t.Fatalf("expected cached tree entry, got %v, %v", otype, ok)
+	}
+
+	c.add(key(4), ObjBlob, []byte("too large to be cached"))
+	if _, _, ok := c.get(key(4)); ok || c.size != 8 {
+		t.Fatalf("entries larger than the limit must not be cached (size: %d)", c.size)
+	}
+
+	c.setLimit(0)
+	if c.size != 0 || c.lru.Len() != 0 || len(c.entries) != 0 {
+		t.Fatalf("expected empty cache, size: %d", c.size)
+	}
+}
+
+func TestDeepDeltaChains(t *testing.T) {
+	repo, wt := mkTestRepo(t)
+
+	//many small changes to a large file give long delta chains
+	var lines []string
+	for i := 0; i < 2000; i++ {
+		lines = append(lines, fmt.Sprintf("line %d of a large file", i))
+	}
+
+	for i := 0; i < 30; i++ {
+		lines[i*50] = fmt.Sprintf("changed in revision %d", i)
+		data := strings.Join(lines, "\n")
+		if err := ioutil.WriteFile(filepath.Join(wt, "large.txt"), []byte(data), 0666); err != nil {
+			t.Fatalf("could not write file: %v", err)
+		}
+		gitCmd(t, wt, "add", "-A")
+		gitCmd(t, wt, "commit", "-q", "-m", fmt.Sprintf("revision %d", i))
+	}
+
+	gitCmd(t, wt, "push", "-q", "bare", "master")
+	gitCmd(t, repo.Path, "repack", "-q", "-a", "-d", "-f", "--depth=50", "--window=50")
+
+	idx, err := filepath.Glob(filepath.Join(repo.Path, "objects", "pack", "*.idx"))
+	if err != nil || len(idx) != 1 {
+		t.Fatalf("expected exactly one pack index, got %v (%v)", idx, err)
+	}
+
+	stats := gitCmd(t, repo.Path, "verify-pack", "-s", idx[0])
+	if !strings.Contains(stats, "chain length = 10:") {
+		t.Fatalf("expected deep delta chains, got:\n%s", stats)
+	}
+
+	ids := listObjects(t, repo)
+
+	for _, limit := range []int64{DefaultDeltaBaseCacheLimit, 1 << 16, 0} {
+		r := &Repository{Path: repo.Path}
+		r.SetDeltaBaseCacheLimit(limit)
+		checkOpenAll(t, r, ids)
+
+		cache := r.deltaCache()
+		if cache.size > limit {
+			t.Fatalf("cache exceeds its limit: %d > %d", cache.size, limit)
+		}
+
+		if limit == DefaultDeltaBaseCacheLimit && cache.lru.Len() == 0 {
+			t.Fatal("expected delta bases to be cached")
+		}
+
+		r.packSet().close()
+	}
+
+	//deltified objects are patched while being read
+	id, err := ParseSHA1(gitCmd(t, repo.Path, "rev-parse", "master~5:large.txt"))
+	if err != nil {
+		t.Fatalf("could not parse id: %v", err)
+	}
+
+	//failures in the chain close all of its deltas
+	r := &Repository{Path: repo.Path}
+	r.SetMaxObjectSize(1 << 10)
+	if _, err := r.OpenObject(id); !errors.Is(err, ErrObjectTooLarge) {
+		t.Fatalf("expected ErrObjectTooLarge, got %v", err)
+	}
+
+	if pf := r.packSet().packs[0].pf; pf == nil || pf.readers != 0 {
+		t.Fatalf("expected no readers of the pack file, got %+v", pf)
+	}
+	r.packSet().close()
+
+	obj, err := repo.OpenObject(id)
+	if err != nil {
+		t.Fatalf("could not open blob: %v", err)
+	}
+	defer obj.Close()
+
+	if _, ok := obj.(*Blob).source.(*deltaReader); !ok {
+		t.Fatalf("expected deltified blob, got source %T", obj.(*Blob).source)
+	}
+
+	data, err := ioutil.ReadAll(iotest.OneByteReader(obj.(*Blob)))
+	if err != nil {
+		t.Fatalf("could not read blob: %v", err)
+	}
+
+	if expected := gitCmd(t, repo.Path, "cat-file", "blob", id.String()); string(data) != expected {
+		t.Fatal("blob content differs from git's")
+	}
+}
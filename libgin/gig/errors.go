@@ -0,0 +1,166 @@
+package gig
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+//The errors returned by this package wrap these errors where they
+//apply, so that they can be detected via errors.Is.
+var (
+	//ErrObjectNotFound is returned if an object is not present.
+	ErrObjectNotFound = errors.New("git: object not found")
+
+	//ErrRefNotFound is returned if a ref does not exist.
+	ErrRefNotFound = errors.New("git: ref not found")
+
+	//ErrNotRepository is returned by OpenRepository if the
+	//path is neither a git directory nor a work tree.
+	ErrNotRepository = errors.New("git: not a git repository")
+
+	//ErrNotBareRepository is the same as ErrNotRepository, from
+	//when OpenRepository only opened bare repositories.
+	ErrNotBareRepository = ErrNotRepository
+
+	//ErrSubmodule is returned if a path refers to a submodule,
+	//or to a file in it, since its objects are in a different
+	//repository.
+	ErrSubmodule = errors.New("git: path is in a submodule")
+
+	//ErrCorruptObject is matched by all CorruptObjectErrors.
+	ErrCorruptObject = errors.New("git: corrupt object")
+
+	//ErrObjectTooLarge is returned if an object that has to be read
+	//into memory exceeds the maximum size (see SetMaxObjectSize).
+	ErrObjectTooLarge = errors.New("git: object too large")
+
+	//ErrInvalidRefName is returned for invalid ref names.
+	ErrInvalidRefName = errors.New("git: invalid ref name")
+
+	//ErrRefLocked is returned if a ref cannot be updated, since
+	//it is locked by another process.
+	ErrRefLocked = errors.New("git: ref is locked")
+
+	//ErrStaleRef is returned if a ref cannot be updated, since it
+	//does not have the expected value (see RefUpdate.Old).
+	ErrStaleRef = errors.New("git: ref does not have the expected value")
+
+	//ErrNotSigned is returned if a commit or tag without
+	//signature is verified.
+	ErrNotSigned = errors.New("git: object is not signed")
+
+	//ErrUnknownSigningKey is returned if a signature was made
+	//by a key that is not in the key ring.
+	ErrUnknownSigningKey = errors.New("git: signing key not in key ring")
+
+	//ErrBadSignature is returned if a signature does not match.
+	ErrBadSignature = errors.New("git: bad signature")
+
+	//ErrExpiredSignature is returned if a signature matches,
+	//but has expired.
+	ErrExpiredSignature = errors.New("git: signature has expired")
+
+	//ErrExpiredKey is returned if a signature matches, but the
+	//key that made it (or its primary key) has expired.
+	ErrExpiredKey = errors.New("git: signing key has expired")
+
+	//ErrRevokedKey is returned if a signature matches, but the
+	//key that made it (or its primary key) has been revoked.
+	ErrRevokedKey = errors.New("git: signing key has been revoked")
+)
+
+//AmbiguousRefError is returned if a short ref name matches more
+//than one ref.
+type AmbiguousRefError struct {
+	Name string
+
+	//Candidates are the full names of the matching refs.
+	Candidates []string
+}
+
+func (e *AmbiguousRefError) Error() string {
+	return fmt.Sprintf("git: ambiguous ref name %q, multiple matches: %s",
+		e.Name, strings.Join(e.Candidates, ", "))
+}
+
+//AmbiguousObjectError is returned if an abbreviated object id
+//matches more than one object.
+type AmbiguousObjectError struct {
+	Prefix     string
+	Candidates []SHA1
+}
+
+func (e *AmbiguousObjectError) Error() string {
+	ids := make([]string, len(e.Candidates))
+	for i, id := range e.Candidates {
+		ids[i] = id.String()
+	}
+
+	return fmt.Sprintf("git: short object id %s is ambiguous, candidates: %s",
+		e.Prefix, strings.Join(ids, ", "))
+}
+
+//ObjectTypeError is returned if an object does not have the
+//expected type, e.g. a commit id was expected, but a tree was
+//found. The ID is zero if it is not known.
+type ObjectTypeError struct {
+	ID       SHA1
+	Type     ObjectType
+	Expected ObjectType
+}
+
+func (e *ObjectTypeError) Error() string {
+	if e.ID.IsZero() {
+		return fmt.Sprintf("git: object is a %s, not a %s", e.Type, e.Expected)
+	}
+	return fmt.Sprintf("git: object %s is a %s, not a %s", e.ID, e.Type, e.Expected)
+}
+
+//CorruptObjectError is returned if an object is present, but
+//cannot be read. Pack is the path of the pack the object is stored
+//in and Offset its offset in the pack; for loose objects Pack is
+//empty and Offset is -1.
+type CorruptObjectError struct {
+	ID     SHA1
+	Pack   string
+	Offset int64
+	Err    error
+}
+
+func (e *CorruptObjectError) Error() string {
+	if e.Pack == "" {
+		return fmt.Sprintf("git: corrupt loose object %s: %v", e.ID, e.Err)
+	}
+	return fmt.Sprintf("git: corrupt object %s in pack %s at offset %d: %v", e.ID, e.Pack, e.Offset, e.Err)
+}
+
+//Unwrap returns the underlying error.
+func (e *CorruptObjectError) Unwrap() error {
+	return e.Err
+}
+
+//Is makes all CorruptObjectErrors match ErrCorruptObject.
+func (e *CorruptObjectError) Is(target error) bool {
+	return target == ErrCorruptObject
+}
+
+//corruptObject wraps err, which occurred while reading the
+//object, in a CorruptObjectError, unless it is one already.
+func (repo *Repository) corruptObject(id SHA1, err error) error {
+	var cerr *CorruptObjectError
+	if errors.As(err, &cerr) {
+		return err
+	}
+
+	corrupt := &CorruptObjectError{ID: id, Offset: -1, Err: err}
+	if _, serr := os.Stat(repo.looseObjectPath(id)); serr != nil {
+		if pf, off, found, ferr := repo.packSet().find(id); ferr == nil && found {
+			corrupt.Pack, corrupt.Offset = pf.Name(), off
+			pf.release()
+		}
+	}
+
+	return corrupt
+}
@@ -0,0 +1,149 @@
+package gig
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+//revParse returns the id of the object rev refers to.
+func revParse(t *testing.T, repo *Repository, rev string) SHA1 {
+	t.Helper()
+
+	id, err := ParseSHA1(gitCmd(t, repo.Path, "rev-parse", rev))
+	if err != nil {
+		t.Fatalf("could not parse id: %v", err)
+	}
+	return id
+}
+
+func TestErrorsRefs(t *testing.T) {
+	repo, _ := mkTestRepo(t)
+
+	_, err := OpenRepository(filepath.Dir(repo.Path))
+	if !errors.Is(err, ErrNotBareRepository) {
+		t.Fatalf("expected ErrNotBareRepository, got %v", err)
+	}
+
+	_, err = repo.OpenRef("nonexistent")
+	if !errors.Is(err, ErrRefNotFound) {
+		t.Fatalf("expected ErrRefNotFound, got %v", err)
+	}
+
+	gitCmd(t, repo.Path, "branch", "a/dup", "master")
+	gitCmd(t, repo.Path, "branch", "b/dup", "master")
+
+	var aerr *AmbiguousRefError
+	_, err = repo.OpenRef("dup")
+	if !errors.As(err, &aerr) {
+		t.Fatalf("expected AmbiguousRefError, got %v", err)
+	}
+
+	expected := []string{"refs/heads/a/dup", "refs/heads/b/dup"}
+	if aerr.Name != "dup" || !reflect.DeepEqual(aerr.Candidates, expected) {
+		t.Fatalf("unexpected ambiguous ref error: %+v", aerr)
+	}
+
+	if err := CheckRefName("refs/heads/a..b"); !errors.Is(err, ErrInvalidRefName) {
+		t.Fatalf("expected ErrInvalidRefName, got %v", err)
+	}
+
+	var zero SHA1
+	head := revParse(t, repo, "master")
+	err = repo.UpdateRef("refs/heads/master", head, &zero, Signature{}, "")
+	if !errors.Is(err, ErrStaleRef) {
+		t.Fatalf("expected ErrStaleRef, got %v", err)
+	}
+
+	lock := filepath.Join(repo.Path, "refs", "heads", "master.lock")
+	if err := ioutil.WriteFile(lock, nil, 0666); err != nil {
+		t.Fatalf("could not create lock file: %v", err)
+	}
+
+	err = repo.UpdateRef("refs/heads/master", head, nil, Signature{}, "")
+	if !errors.Is(err, ErrRefLocked) {
+		t.Fatalf("expected ErrRefLocked, got %v", err)
+	}
+}
+
+func TestErrorsObjects(t *testing.T) {
+	repo, _ := mkTestRepo(t)
+
+	missing, _ := ParseSHA1("0123456789012345678901234567890123456789")
+	_, err := repo.OpenObject(missing)
+	if !errors.Is(err, ErrObjectNotFound) {
+		t.Fatalf("expected ErrObjectNotFound, got %v", err)
+	}
+
+	commit, err := repo.OpenObject(revParse(t, repo, "master"))
+	if err != nil {
+		t.Fatalf("could not open commit: %v", err)
+	}
+	defer commit.Close()
+
+	_, err = repo.ObjectForPath(commit, "data/missing.txt")
+	if !os.IsNotExist(err) || !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected not exist error, got %v", err)
+	}
+
+	var terr *ObjectTypeError
+	_, err = repo.ObjectForPath(commit, "README.md/foo")
+	if !errors.As(err, &terr) || terr.Type != ObjBlob || terr.Expected != ObjTree {
+		t.Fatalf("expected ObjectTypeError, got %v", err)
+	}
+
+	readme := revParse(t, repo, "master:README.md")
+	if terr.ID != readme {
+		t.Fatalf("expected type error for %s, got %s", readme, terr.ID)
+	}
+
+	_, err = repo.Readlink(revParse(t, repo, "master^{tree}"))
+	if !errors.As(err, &terr) || terr.Expected != ObjBlob {
+		t.Fatalf("expected ObjectTypeError, got %v", err)
+	}
+}
+
+func TestErrorsCorruptObject(t *testing.T) {
+	repo, _ := mkTestRepo(t)
+
+	id := revParse(t, repo, "master:README.md")
+	pf, off, found, err := repo.packSet().find(id)
+	if err != nil || !found {
+		t.Fatalf("could not find object in packs: %v", err)
+	}
+
+	//damage the compressed data after the object header
+	name := pf.Name()
+	pf.release()
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatalf("could not read pack: %v", err)
+	}
+
+	for i := off + 2; i < off+12; i++ {
+		data[i] ^= 0xff
+	}
+
+	os.Chmod(name, 0666)
+	if err := ioutil.WriteFile(name, data, 0666); err != nil {
+		t.Fatalf("could not write pack: %v", err)
+	}
+
+	repo, err = OpenRepository(repo.Path)
+	if err != nil {
+		t.Fatalf("could not open repository: %v", err)
+	}
+
+	_, err = repo.OpenObject(id)
+	var cerr *CorruptObjectError
+	if !errors.Is(err, ErrCorruptObject) || !errors.As(err, &cerr) {
+		t.Fatalf("expected CorruptObjectError, got %v", err)
+	}
+
+	if cerr.ID != id || cerr.Pack != name || cerr.Offset != off {
+		t.Fatalf("unexpected corrupt object error: %+v", cerr)
+	}
+}
@@ -0,0 +1,149 @@
+package gig
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+//ignorePattern is a single pattern of a gitignore(5) file.
+type ignorePattern struct {
+	//base is the directory of the file the pattern is from,
+	//relative to the work tree, with a trailing slash unless
+	//it is the top-level directory.
+	base string
+	re   *regexp.Regexp
+
+	negate  bool
+	dirOnly bool
+
+	//anchored patterns, i.e. those containing a slash, match the
+	//path relative to base; all others only match the base name.
+	anchored bool
+}
+
+//parseIgnorePattern parses a line of a gitignore file; returns
+//false for blank lines and comments.
+func parseIgnorePattern(line, base string) (ignorePattern, bool) {
+	p := ignorePattern{base: base}
+
+	line = strings.TrimSuffix(line, "\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return p, false
+	}
+
+	//trailing spaces are ignored, unless they are escaped
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+
+	if strings.Contains(line, "/") {
+		p.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+
+	if line == "" {
+		return p, false
+	}
+
+	re, err := globRegexp(line, false)
+	if err != nil {
+		return p, false
+	}
+	p.re = re
+
+	return p, true
+}
+
+//readIgnoreFile reads the patterns of the gitignore file at path,
+//which are relative to base. A missing file has no patterns.
+func readIgnoreFile(path, base string) ([]ignorePattern, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var patterns []ignorePattern
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if p, ok := parseIgnorePattern(scanner.Text(), base); ok {
+			patterns = append(patterns, p)
+		}
+	}
+
+	return patterns, scanner.Err()
+}
+
+//match reports whether the pattern matches the path, which is
+//relative to the work tree.
+func (p *ignorePattern) match(name string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	} else if !strings.HasPrefix(name, p.base) {
+		return false
+	}
+
+	name = name[len(p.base):]
+	if !p.anchored {
+		name = path.Base(name)
+	}
+
+	return p.re.MatchString(name)
+}
+
+//isIgnored reports whether the path (relative to the work tree)
+//is ignored by the patterns, of which the last matching one wins.
+func isIgnored(patterns []ignorePattern, name string, isDir bool) bool {
+	for i := len(patterns) - 1; i >= 0; i-- {
+		if patterns[i].match(name, isDir) {
+			return !patterns[i].negate
+		}
+	}
+
+	return false
+}
+
+//excludePatterns returns the patterns that apply to the whole work
+//tree, i.e. those of core.excludesFile (which defaults to
+//$XDG_CONFIG_HOME/git/ignore) and of info/exclude, in that order.
+//The patterns of the .gitignore files take precedence over them.
+func (repo *Repository) excludePatterns(config *Config) ([]ignorePattern, error) {
+	file, ok := config.Get("core.excludesFile")
+	if !ok {
+		xdg := os.Getenv("XDG_CONFIG_HOME")
+		if xdg == "" {
+			xdg = expandHome("~/.config")
+		}
+		file = filepath.Join(xdg, "git", "ignore")
+	}
+
+	patterns, err := readIgnoreFile(expandHome(file), "")
+	if err != nil {
+		return nil, err
+	}
+
+	exclude, err := readIgnoreFile(filepath.Join(repo.Path, "info", "exclude"), "")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(patterns, exclude...), nil
+}
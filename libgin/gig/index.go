@@ -0,0 +1,336 @@
+package gig
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Index is the index file ("staging area") of a repository
+//with a work tree, see gitformat-index(5).
+type Index struct {
+	Version uint32
+
+	//Entries are sorted by name and stage.
+	Entries []IndexEntry
+
+	//Tree is the cached tree of the index (the "TREE" extension),
+	//nil if the index does not have one.
+	Tree *IndexTree
+}
+
+//IndexEntry is a file in the index, or a directory in sparse
+//indices. The stat data is used to detect changed files.
+type IndexEntry struct {
+	Name string
+	ID   SHA1
+	Mode os.FileMode
+	Size uint32
+
+	CTime time.Time
+	MTime time.Time
+	Dev   uint32
+	Ino   uint32
+	UID   uint32
+	GID   uint32
+
+	//Stage is the merge stage, 1 to 3 for conflicts
+	//and 0 otherwise.
+	Stage int
+
+	AssumeValid  bool
+	SkipWorktree bool
+	IntentToAdd  bool
+}
+
+//IndexTree is a tree of the cache of tree objects in the index.
+type IndexTree struct {
+	//Name is the name of the directory, empty for the root.
+	Name string
+
+	//Entries is the number of index entries below the tree;
+	//-1 if the tree is invalid, i.e. ID is not set.
+	Entries int
+
+	ID       SHA1
+	Subtrees []*IndexTree
+}
+
+//The flags of index entries.
+const (
+	indexAssumeValid  = 0x8000
+	indexExtended     = 0x4000
+	indexStageMask    = 0x3000
+	indexNameMask     = 0x0FFF
+	indexSkipWorktree = 0x4000
+	indexIntentToAdd  = 0x2000
+)
+
+//ReadIndex reads the index of the work tree.
+func (repo *Repository) ReadIndex() (*Index, error) {
+	data, err := ioutil.ReadFile(filepath.Join(repo.gitDir(), "index"))
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := parseIndex(data, repo.ObjectFormat())
+	if err != nil {
+		return nil, fmt.Errorf("git: invalid index: %v", err)
+	}
+
+	return idx, nil
+}
+
+//Entry returns the entry for the path, or the one of the lowest
+//stage if there are conflicts; nil if there is none.
+func (idx *Index) Entry(name string) *IndexEntry {
+	i := sort.Search(len(idx.Entries), func(i int) bool {
+		return idx.Entries[i].Name >= name
+	})
+
+	if i < len(idx.Entries) && idx.Entries[i].Name == name {
+		return &idx.Entries[i]
+	}
+
+	return nil
+}
+
+//parseIndex parses the index file data, versions 2 to 4.
+func parseIndex(data []byte, format ObjectFormat) (*Index, error) {
+	hs := format.Size()
+	if len(data) < 12+hs {
+		return nil, fmt.Errorf("file too short")
+	} else if string(data[:4]) != "DIRC" {
+		return nil, fmt.Errorf("bad signature")
+	}
+
+	//the checksum is zero if index.skipHash is set
+	sum := data[len(data)-hs:]
+	data = data[:len(data)-hs]
+	if !bytes.Equal(sum, make([]byte, hs)) {
+		h := format.New()
+		h.Write(data)
+		if !bytes.Equal(h.Sum(nil), sum) {
+			return nil, fmt.Errorf("checksum mismatch")
+		}
+	}
+
+	idx := &Index{Version: binary.BigEndian.Uint32(data[4:])}
+	if idx.Version < 2 || idx.Version > 4 {
+		return nil, fmt.Errorf("unsupported version %d", idx.Version)
+	}
+
+	count := binary.BigEndian.Uint32(data[8:])
+	pos := 12
+
+	var prev string
+	for i := uint32(0); i < count; i++ {
+		e, n, err := parseIndexEntry(data[pos:], idx.Version, prev, format)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %v", i, err)
+		}
+
+		idx.Entries = append(idx.Entries, e)
+		prev = e.Name
+		pos += n
+	}
+
+	err := idx.parseExtensions(data, pos, format)
+	if err != nil {
+		return nil, err
+	}
+
+	return idx, nil
+}
+
+//parseIndexEntry parses the entry at the start of data and returns
+//it together with its size. In version 4, the name is prefix
+//compressed relative to the name of the previous entry.
+func parseIndexEntry(data []byte, version uint32, prev string, format ObjectFormat) (IndexEntry, int, error) {
+	var e IndexEntry
+
+	hs := format.Size()
+	pos := 40 + hs + 2
+	if len(data) < pos {
+		return e, 0, fmt.Errorf("unexpected end of data")
+	}
+
+	u32 := func(off int) uint32 {
+		return binary.BigEndian.Uint32(data[off:])
+	}
+
+	e.CTime = time.Unix(int64(u32(0)), int64(u32(4)))
+	e.MTime = time.Unix(int64(u32(8)), int64(u32(12)))
+	e.Dev, e.Ino = u32(16), u32(20)
+	e.Mode = os.FileMode(u32(24))
+	e.UID, e.GID = u32(28), u32(32)
+	e.Size = u32(36)
+	e.ID = format.ID(data[40 : 40+hs])
+
+	flags := binary.BigEndian.Uint16(data[40+hs:])
+	e.AssumeValid = flags&indexAssumeValid != 0
+	e.Stage = int(flags&indexStageMask) >> 12
+
+	if flags&indexExtended != 0 {
+		if version < 3 {
+			return e, 0, fmt.Errorf("extended flags in version %d", version)
+		} else if len(data) < pos+2 {
+			return e, 0, fmt.Errorf("unexpected end of data")
+		}
+
+		extended := binary.BigEndian.Uint16(data[pos:])
+		e.SkipWorktree = extended&indexSkipWorktree != 0
+		e.IntentToAdd = extended&indexIntentToAdd != 0
+		pos += 2
+	}
+
+	if version == 4 {
+		r := bytes.NewReader(data[pos:])
+		strip, err := readVarint(r)
+		if err != nil {
+			return e, 0, err
+		} else if strip > int64(len(prev)) {
+			return e, 0, fmt.Errorf("invalid name prefix")
+		}
+		pos = len(data) - r.Len()
+
+		end := bytes.IndexByte(data[pos:], 0)
+		if end == -1 {
+			return e, 0, fmt.Errorf("unterminated name")
+		}
+
+		e.Name = prev[:len(prev)-int(strip)] + string(data[pos:pos+end])
+		return e, pos + end + 1, nil
+	}
+
+	//the name length is in the flags, unless it is too long
+	n := int(flags & indexNameMask)
+	if n == indexNameMask {
+		n = bytes.IndexByte(data[pos:], 0)
+	}
+
+	if n < 0 || pos+n >= len(data) || data[pos+n] != 0 {
+		return e, 0, fmt.Errorf("invalid name")
+	}
+
+	e.Name = string(data[pos : pos+n])
+
+	//entries are padded with 1-8 NULs to a multiple of 8 bytes
+	size := (pos + n + 8) &^ 7
+	if size > len(data) {
+		return e, 0, fmt.Errorf("unexpected end of data")
+	}
+
+	return e, size, nil
+}
+
+//parseExtensions parses the extensions after the entries. Unknown
+//optional extensions, i.e. those whose signature starts with an
+//upper-case letter, are ignored.
+func (idx *Index) parseExtensions(data []byte, pos int, format ObjectFormat) error {
+	start := pos
+	headers := format.New()
+
+	for pos < len(data) {
+		if len(data)-pos < 8 {
+			return fmt.Errorf("truncated extension header")
+		}
+
+		sig := string(data[pos : pos+4])
+		size := int(binary.BigEndian.Uint32(data[pos+4:]))
+		if size < 0 || size > len(data)-pos-8 {
+			return fmt.Errorf("extension %q: invalid size", sig)
+		}
+		ext := data[pos+8 : pos+8+size]
+
+		switch {
+		case sig == "TREE":
+			tree, rest, err := parseIndexTree(ext, format)
+			if err != nil {
+				return fmt.Errorf("extension %q: %v", sig, err)
+			} else if len(rest) != 0 {
+				return fmt.Errorf("extension %q: unexpected data", sig)
+			}
+			idx.Tree = tree
+		case sig == "EOIE":
+			//end of index entries: the offset of the extensions
+			//and the hash of the preceding extension headers
+			hs := format.Size()
+			if size != 4+hs || int(binary.BigEndian.Uint32(ext)) != start {
+				return fmt.Errorf("extension %q: invalid offset", sig)
+			} else if !bytes.Equal(headers.Sum(nil), ext[4:]) {
+				return fmt.Errorf("extension %q: checksum mismatch", sig)
+			}
+		case sig == "sdir":
+			//sparse directory entries, which are regular entries
+		case sig[0] < 'A' || sig[0] > 'Z':
+			return fmt.Errorf("unsupported extension %q", sig)
+		}
+
+		headers.Write(data[pos : pos+8])
+		pos += 8 + size
+	}
+
+	return nil
+}
+
+//parseIndexTree parses one entry of the "TREE" extension and
+//its subtrees; returns the remaining data.
+func parseIndexTree(data []byte, format ObjectFormat) (*IndexTree, []byte, error) {
+	nul := bytes.IndexByte(data, 0)
+	if nul == -1 {
+		return nil, nil, fmt.Errorf("unterminated path")
+	}
+
+	t := &IndexTree{Name: string(data[:nul])}
+	data = data[nul+1:]
+
+	nl := bytes.IndexByte(data, '\n')
+	if nl == -1 {
+		return nil, nil, fmt.Errorf("unterminated counts")
+	}
+
+	counts := strings.Fields(string(data[:nl]))
+	data = data[nl+1:]
+	if len(counts) != 2 {
+		return nil, nil, fmt.Errorf("invalid counts")
+	}
+
+	var err error
+	t.Entries, err = strconv.Atoi(counts[0])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	subtrees, err := strconv.Atoi(counts[1])
+	if err != nil || subtrees < 0 {
+		return nil, nil, fmt.Errorf("invalid number of subtrees")
+	}
+
+	if t.Entries >= 0 {
+		hs := format.Size()
+		if len(data) < hs {
+			return nil, nil, fmt.Errorf("truncated id")
+		}
+		t.ID = format.ID(data[:hs])
+		data = data[hs:]
+	}
+
+	for i := 0; i < subtrees; i++ {
+		var sub *IndexTree
+		sub, data, err = parseIndexTree(data, format)
+		if err != nil {
+			return nil, nil, err
+		}
+		t.Subtrees = append(t.Subtrees, sub)
+	}
+
+	return t, data, nil
+}
@@ -0,0 +1,214 @@
+package gig
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenWorkTree(t *testing.T) {
+	bare, wt := mkTestRepo(t)
+	gitdir := filepath.Join(wt, ".git")
+
+	for _, path := range []string{wt, gitdir} {
+		repo, err := OpenRepository(path)
+		if err != nil {
+			t.Fatalf("%s: could not open repository: %v", path, err)
+		}
+
+		if repo.Path != gitdir || repo.WorkTree != wt || repo.GitDir != "" {
+			t.Fatalf("%s: unexpected repository %+v", path, repo)
+		}
+
+		head, err := repo.ResolveRevision("HEAD")
+		if err != nil || head != revParse(t, bare, "master") {
+			t.Fatalf("%s: unexpected HEAD %s: %v", path, head, err)
+		}
+	}
+
+	//a linked work tree, with a ".git" file
+	linked := filepath.Join(filepath.Dir(wt), "linked")
+	gitCmd(t, wt, "worktree", "add", "-q", "-b", "other", linked, "master~1")
+	gitCmd(t, linked, "commit", "-q", "--allow-empty", "-m", "on other")
+
+	repo, err := OpenRepository(linked)
+	if err != nil {
+		t.Fatalf("could not open linked work tree: %v", err)
+	}
+
+	if repo.Path != gitdir || repo.WorkTree != linked || repo.GitDir != filepath.Join(gitdir, "worktrees", "linked") {
+		t.Fatalf("unexpected linked repository %+v", repo)
+	}
+
+	for rev, expected := range map[string]string{"HEAD": "other", "master": "master", "HEAD~1": "master~1"} {
+		id, err := repo.ResolveRevision(rev)
+		if err != nil || id.String() != gitCmd(t, linked, "rev-parse", expected) {
+			t.Fatalf("%s: unexpected id %s: %v", rev, id, err)
+		}
+	}
+
+	//updating HEAD of the linked work tree leaves the main one alone
+	id := revParse(t, bare, "master")
+	if err := repo.UpdateRef("HEAD", id, nil, Signature{}, ""); err != nil {
+		t.Fatalf("could not update HEAD: %v", err)
+	}
+
+	if head := gitCmd(t, linked, "rev-parse", "other"); head != id.String() {
+		t.Fatalf("expected other to be updated to %s, got %s", id, head)
+	}
+
+	if head := gitCmd(t, wt, "symbolic-ref", "HEAD"); head != "refs/heads/master" {
+		t.Fatalf("unexpected HEAD of the main work tree: %s", head)
+	}
+
+	//discovery from a subdirectory
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("could not get working directory: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+
+	if err := os.Chdir(filepath.Join(wt, "data", "sub")); err != nil {
+		t.Fatalf("could not change directory: %v", err)
+	}
+
+	repo, err = DiscoverRepository()
+	if err != nil || repo.Path != gitdir || repo.WorkTree != wt {
+		t.Fatalf("unexpected discovered repository %+v: %v", repo, err)
+	}
+
+	_, err = OpenRepository(filepath.Join(wt, "data"))
+	if !errors.Is(err, ErrNotRepository) {
+		t.Fatalf("expected ErrNotRepository, got %v", err)
+	}
+}
+
+func TestReadIndex(t *testing.T) {
+	_, wt := mkTestRepo(t)
+
+	//all kinds of entries: modified, new, intent-to-add and
+	//skip-worktree, assume-unchanged and long names
+	files := map[string]string{"new.txt": "new\n", "data/a.txt": "changed\n", "ita.txt": "ita\n"}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(wt, filepath.FromSlash(name)), []byte(content), 0666); err != nil {
+			t.Fatalf("could not write file: %v", err)
+		}
+	}
+
+	gitCmd(t, wt, "add", "new.txt", "data/a.txt")
+	blob := gitCmd(t, wt, "rev-parse", "HEAD:README.md")
+	long := strings.Repeat("long-name/", 500) + "file.txt"
+	gitCmd(t, wt, "update-index", "--add", "--cacheinfo", "100644,"+blob+","+long)
+	gitCmd(t, wt, "add", "-N", "ita.txt")
+	gitCmd(t, wt, "update-index", "--skip-worktree", "data/b.txt")
+	gitCmd(t, wt, "update-index", "--assume-unchanged", "README.md")
+
+	repo, err := OpenRepository(wt)
+	if err != nil {
+		t.Fatalf("could not open repository: %v", err)
+	}
+
+	check := func(version uint32) {
+		t.Helper()
+
+		idx, err := repo.ReadIndex()
+		if err != nil {
+			t.Fatalf("v%d: could not read index: %v", version, err)
+		}
+
+		if idx.Version != version {
+			t.Fatalf("expected version %d, got %d", version, idx.Version)
+		}
+
+		var lines []string
+		for _, e := range idx.Entries {
+			lines = append(lines, fmt.Sprintf("%o %s %d\t%s", uint32(e.Mode), e.ID, e.Stage, e.Name))
+		}
+
+		if out := gitCmd(t, wt, "ls-files", "--stage"); strings.Join(lines, "\n") != out {
+			t.Fatalf("v%d: unexpected entries:\n%s\nexpected:\n%s", version, strings.Join(lines, "\n"), out)
+		}
+
+		flags := map[string][3]bool{"ita.txt": {false, false, true}, "data/b.txt": {false, true, false}, "README.md": {true, false, false}, "new.txt": {}}
+		for name, f := range flags {
+			e := idx.Entry(name)
+			if e == nil || e.AssumeValid != f[0] || e.SkipWorktree != f[1] || e.IntentToAdd != f[2] {
+				t.Fatalf("v%d: unexpected flags for %s: %+v", version, name, e)
+			}
+		}
+
+		e := idx.Entry("data/a.txt")
+		fi, err := os.Stat(filepath.Join(wt, "data", "a.txt"))
+		if err != nil {
+			t.Fatalf("could not stat file: %v", err)
+		}
+
+		if e == nil || int64(e.Size) != fi.Size() || e.MTime.Unix() != fi.ModTime().Unix() {
+			t.Fatalf("v%d: unexpected stat data for data/a.txt: %+v", version, e)
+		}
+
+		if idx.Entry("missing.txt") != nil {
+			t.Fatalf("v%d: unexpected entry for missing file", version)
+		}
+	}
+
+	//the extended flags need version 3, which git picks itself
+	check(3)
+
+	gitCmd(t, wt, "-c", "index.recordEndOfIndexEntries=true", "update-index", "--index-version", "4")
+	check(4)
+
+	//the cached trees
+	gitCmd(t, wt, "rm", "-q", "--cached", "ita.txt", long)
+	gitCmd(t, wt, "update-index", "--no-skip-worktree", "data/b.txt")
+	gitCmd(t, wt, "commit", "-q", "-m", "index")
+	gitCmd(t, wt, "-c", "index.recordEndOfIndexEntries=true", "update-index", "--index-version", "2")
+
+	idx, err := repo.ReadIndex()
+	if err != nil {
+		t.Fatalf("could not read index: %v", err)
+	}
+
+	if idx.Version != 2 {
+		t.Fatalf("expected version 2, got %d", idx.Version)
+	}
+
+	if idx.Tree == nil || idx.Tree.ID != revParse(t, repo, "HEAD^{tree}") || idx.Tree.Entries != len(idx.Entries) {
+		t.Fatalf("unexpected cached tree %+v", idx.Tree)
+	}
+
+	for _, sub := range idx.Tree.Subtrees {
+		if sub.ID != revParse(t, repo, "HEAD:"+sub.Name) {
+			t.Fatalf("unexpected cached tree for %s: %s", sub.Name, sub.ID)
+		}
+	}
+
+	//a merge conflict
+	gitCmd(t, wt, "checkout", "-q", "-b", "conflict", "HEAD~1")
+	if err := ioutil.WriteFile(filepath.Join(wt, "new.txt"), []byte("conflict\n"), 0666); err != nil {
+		t.Fatalf("could not write file: %v", err)
+	}
+	gitCmd(t, wt, "add", "new.txt")
+	gitCmd(t, wt, "commit", "-q", "-m", "conflict")
+	gitTry(wt, "merge", "-q", "master")
+
+	idx, err = repo.ReadIndex()
+	if err != nil {
+		t.Fatalf("could not read index: %v", err)
+	}
+
+	var stages []int
+	for _, e := range idx.Entries {
+		if e.Name == "new.txt" {
+			stages = append(stages, e.Stage)
+		}
+	}
+
+	if fmt.Sprint(stages) != "[2 3]" || idx.Entry("new.txt").Stage != 2 {
+		t.Fatalf("unexpected stages for new.txt: %v", stages)
+	}
+}
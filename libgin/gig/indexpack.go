@@ -0,0 +1,516 @@
+package gig
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+//IndexPack reads a pack from r (until EOF), as it is sent by git
+//clients when pushing, and stores it in the repository together
+//with its index. Thin packs, i.e. packs with deltas against objects
+//that are not part of the pack but of the repository, are completed
+//by appending the missing bases. Returns the checksum of the stored
+//pack; nothing is stored for an empty pack.
+//
+//Packs larger than "receive.maxInputSize" (if set) are rejected,
+//as are delta objects and their bases that are larger than the
+//maximum object size (see SetMaxObjectSize).
+func (repo *Repository) IndexPack(r io.Reader) (SHA1, error) {
+	var sum SHA1
+
+	config, err := repo.Config()
+	if err != nil {
+		return sum, err
+	}
+
+	maxSize, err := config.Int("receive.maxInputSize", 0)
+	if err != nil {
+		return sum, err
+	}
+
+	dir := filepath.Join(repo.Path, "objects", "pack")
+	err = os.MkdirAll(dir, 0777)
+	if err != nil {
+		return sum, err
+	}
+
+	packTmp, err := ioutil.TempFile(dir, "tmp_pack_")
+	if err != nil {
+		return sum, err
+	}
+	defer os.Remove(packTmp.Name())
+	defer packTmp.Close()
+
+	if maxSize > 0 {
+		r = io.LimitReader(r, maxSize+1)
+	}
+
+	size, err := io.Copy(packTmp, r)
+	if err != nil {
+		return sum, err
+	} else if maxSize > 0 && size > maxSize {
+		return sum, fmt.Errorf("git: pack exceeds maximum allowed size (%d bytes)", maxSize)
+	}
+
+	ix := &packIndexer{repo: repo, format: repo.ObjectFormat(), f: packTmp, size: size, limit: repo.maxObjectSize()}
+	err = ix.scan()
+	if err != nil {
+		return sum, err
+	} else if len(ix.entries) == 0 {
+		return sum, nil
+	}
+
+	err = ix.resolveDeltas()
+	if err != nil {
+		return sum, err
+	}
+
+	if len(ix.external) > 0 {
+		err = ix.fixThin()
+		if err != nil {
+			return sum, err
+		}
+	}
+
+	idxTmp, err := ioutil.TempFile(dir, "tmp_idx_")
+	if err != nil {
+		return sum, err
+	}
+	defer os.Remove(idxTmp.Name())
+	defer idxTmp.Close()
+
+	entries := make([]packIndexEntry, len(ix.entries))
+	for i, e := range ix.entries {
+		entries[i] = e.packIndexEntry
+	}
+
+	err = writePackIndex(idxTmp, entries, ix.sum)
+	if err != nil {
+		return sum, err
+	}
+
+	return ix.sum, repo.installPack(ix.sum, packTmp, idxTmp)
+}
+
+//indexEntry is an object of a pack that is being indexed.
+type indexEntry struct {
+	packIndexEntry
+
+	otype   ObjectType
+	end     int64
+	baseOff int64
+	baseRef SHA1
+
+	resolved bool
+}
+
+//packIndexer computes the ids and CRC32s of all objects in a pack.
+type packIndexer struct {
+	repo   *Repository
+	format ObjectFormat
+	f      *os.File
+	size   int64
+	limit  int64
+	sum    SHA1
+
+	entries  []*indexEntry
+	byOffset map[int64]*indexEntry
+	byID     map[SHA1]*indexEntry
+
+	bases    *deltaBaseCache
+	external map[SHA1]bool
+}
+
+//offsetReader keeps track of the offset in the pack while
+//reading. It implements io.ByteReader, so that the zlib reader
+//does not consume any data beyond the end of the compressed data.
+type offsetReader struct {
+	r   *bufio.Reader
+	off int64
+}
+
+func (or *offsetReader) Read(p []byte) (int, error) {
+	n, err := or.r.Read(p)
+	or.off += int64(n)
+	return n, err
+}
+
+func (or *offsetReader) ReadByte() (byte, error) {
+	b, err := or.r.ReadByte()
+	if err == nil {
+		or.off++
+	}
+	return b, err
+}
+
+//scan checks the header and the checksum of the pack and reads the
+//headers of all objects. The ids of all non-delta objects are computed.
+func (ix *packIndexer) scan() error {
+	var header PackHeader
+	hs := int64(ix.format.Size())
+	if ix.size < int64(binary.Size(header))+hs {
+		return fmt.Errorf("git: pack too short (%d bytes)", ix.size)
+	}
+
+	h := ix.format.New()
+	sum := make([]byte, hs)
+	_, err := io.Copy(h, io.NewSectionReader(ix.f, 0, ix.size-hs))
+	if err == nil {
+		_, err = ix.f.ReadAt(sum, ix.size-hs)
+	}
+
+	if err != nil {
+		return err
+	} else if !bytes.Equal(h.Sum(nil), sum) {
+		return fmt.Errorf("git: pack checksum mismatch")
+	}
+	ix.sum = ix.format.ID(sum)
+
+	or := &offsetReader{r: bufio.NewReader(io.NewSectionReader(ix.f, 0, ix.size-hs))}
+	err = binary.Read(or, binary.BigEndian, &header)
+	if err != nil {
+		return err
+	}
+
+	if string(header.Sig[:]) != "PACK" {
+		return fmt.Errorf("git: packfile signature error")
+	} else if header.Version != 2 && header.Version != 3 {
+		return fmt.Errorf("git: unsupported packfile version")
+	}
+
+	ix.byOffset = make(map[int64]*indexEntry, header.Objects)
+	ix.byID = make(map[SHA1]*indexEntry, header.Objects)
+
+	for i := uint32(0); i < header.Objects; i++ {
+		e, err := ix.scanEntry(or)
+		if err != nil {
+			return fmt.Errorf("git: invalid pack entry at offset %d: %v", or.off, err)
+		}
+
+		ix.entries = append(ix.entries, e)
+		ix.byOffset[e.Offset] = e
+		if e.resolved {
+			ix.byID[e.ID] = e
+		}
+	}
+
+	if or.off != ix.size-hs {
+		return fmt.Errorf("git: unexpected data after the last object of the pack")
+	}
+
+	for _, e := range ix.entries {
+		crc := crc32.NewIEEE()
+		_, err = io.Copy(crc, io.NewSectionReader(ix.f, e.Offset, e.end-e.Offset))
+		if err != nil {
+			return err
+		}
+
+		e.CRC32 = crc.Sum32()
+	}
+
+	return nil
+}
+
+func (ix *packIndexer) scanEntry(or *offsetReader) (*indexEntry, error) {
+	e := &indexEntry{packIndexEntry: packIndexEntry{Offset: or.off}}
+
+	b, err := or.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	e.otype = ObjectType((b & 0x70) >> 4)
+	size := int64(b & 0xF)
+	if b&0x80 != 0 {
+		s, err := readVarSize(or, 4)
+		if err != nil {
+			return nil, err
+		}
+		size += s
+	}
+
+	switch e.otype {
+	case ObjOFSDelta:
+		off, err := readVarint(or)
+		if err != nil {
+			return nil, err
+		}
+
+		e.baseOff = e.Offset - off
+		if off <= 0 || e.baseOff < 0 {
+			return nil, fmt.Errorf("invalid delta base offset")
+		}
+	case ObjRefDelta:
+		base := make([]byte, ix.format.Size())
+		_, err = io.ReadFull(or, base)
+		if err != nil {
+			return nil, err
+		}
+		e.baseRef = ix.format.ID(base)
+	case ObjCommit, ObjTree, ObjBlob, ObjTag:
+	default:
+		return nil, fmt.Errorf("unknown object type %d", e.otype)
+	}
+
+	zr, err := zlib.NewReader(or)
+	if err != nil {
+		return nil, err
+	}
+
+	if IsStandardObject(e.otype) {
+		e.ID, err = hashObjectData(ix.format, e.otype, size, zr)
+		e.resolved = true
+	} else if n, cerr := io.Copy(ioutil.Discard, zr); cerr != nil {
+		err = cerr
+	} else if n != size {
+		err = fmt.Errorf("size mismatch (%d != %d)", n, size)
+	}
+
+	if err == nil {
+		err = zr.Close()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	e.end = or.off
+	return e, nil
+}
+
+//resolveDeltas computes the ids of all delta objects. Ref-deltas can
+//refer to objects in the pack that are deltas themselves, therefore
+//the objects are processed until no more progress is made.
+func (ix *packIndexer) resolveDeltas() error {
+	pf := &PackFile{File: ix.f, Version: 2, ObjCount: uint32(len(ix.entries)), Format: ix.format}
+	ix.bases = newDeltaBaseCache(ix.repo.deltaCache().getLimit())
+	ix.external = make(map[SHA1]bool)
+
+	for progress := true; progress; {
+		progress = false
+		pending := 0
+
+		for _, e := range ix.entries {
+			if e.resolved {
+				continue
+			}
+
+			otype, data, ok, err := ix.resolve(pf, e)
+			if err != nil {
+				return err
+			} else if !ok {
+				pending++
+				continue
+			}
+
+			e.ID, err = hashObjectData(ix.format, otype, int64(len(data)), bytes.NewReader(data))
+			if err != nil {
+				return err
+			}
+
+			e.resolved = true
+			ix.byID[e.ID] = e
+			progress = true
+		}
+
+		if pending == 0 {
+			return nil
+		}
+	}
+
+	for _, e := range ix.entries {
+		if !e.resolved {
+			return fmt.Errorf("git: delta base %s of object at offset %d not found", e.baseRef, e.Offset)
+		}
+	}
+
+	return nil
+}
+
+//resolve returns the type and data of the (delta) object. If the
+//delta chain contains a ref-delta to an object that is not known
+//(yet), ok is false.
+func (ix *packIndexer) resolve(pf *PackFile, e *indexEntry) (otype ObjectType, data []byte, ok bool, err error) {
+	key := func(e *indexEntry) deltaCacheKey {
+		return deltaCacheKey{"", e.Offset}
+	}
+
+	var chain []*indexEntry
+	for cur := e; ; {
+		var cached bool
+		if otype, data, cached = ix.bases.get(key(cur)); cached {
+			break
+		}
+
+		if IsStandardObject(cur.otype) {
+			obj, err := pf.readRawObject(cur.Offset)
+			if err != nil {
+				return 0, nil, false, err
+			}
+
+			otype = obj.otype
+			data, err = readObjectData(obj, ix.limit)
+			if err != nil {
+				return 0, nil, false, err
+			}
+
+			ix.bases.add(key(cur), otype, data)
+			break
+		}
+
+		chain = append(chain, cur)
+		if len(chain) > len(ix.entries) {
+			return 0, nil, false, fmt.Errorf("git: delta chain loop in pack")
+		}
+
+		if cur.otype == ObjOFSDelta {
+			cur = ix.byOffset[cur.baseOff]
+			if cur == nil {
+				return 0, nil, false, fmt.Errorf("git: invalid delta base offset %d", chain[len(chain)-1].baseOff)
+			}
+			continue
+		}
+
+		if base, inPack := ix.byID[cur.baseRef]; inPack {
+			cur = base
+			continue
+		}
+
+		//ref-delta against an object in the repository
+		has, err := ix.repo.HasObject(cur.baseRef)
+		if err != nil || !has {
+			return 0, nil, false, err
+		}
+
+		otype, data, err = ix.repo.readRawData(cur.baseRef)
+		if err != nil {
+			return 0, nil, false, err
+		}
+		ix.external[cur.baseRef] = true
+		break
+	}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		obj, err := pf.readRawObject(chain[i].Offset)
+		if err != nil {
+			return 0, nil, false, err
+		}
+
+		d, err := parseDelta(obj)
+		if err != nil {
+			return 0, nil, false, err
+		}
+
+		data, err = applyDelta(d, data, ix.limit)
+		if err != nil {
+			return 0, nil, false, err
+		}
+
+		ix.bases.add(key(chain[i]), otype, data)
+	}
+
+	return otype, data, true, nil
+}
+
+//readRawData returns the type and the (inflated) data of an object.
+func (repo *Repository) readRawData(id SHA1) (ObjectType, []byte, error) {
+	obj, err := repo.openRawObject(id)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	limit := repo.maxObjectSize()
+	if IsStandardObject(obj.otype) {
+		data, err := readObjectData(obj, limit)
+		return obj.otype, data, err
+	} else if !IsDeltaObject(obj.otype) {
+		return 0, nil, fmt.Errorf("git: unknown object type %d", obj.otype)
+	}
+
+	d, err := parseDelta(obj)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	otype, base, err := repo.deltaBase(d)
+	if err != nil {
+		d.Close()
+		return 0, nil, err
+	}
+
+	data, err := applyDelta(d, base, limit)
+	return otype, data, err
+}
+
+//fixThin completes a thin pack by appending the external delta
+//bases as (non-delta) objects. The object count in the header and
+//the trailing checksum are updated accordingly.
+func (ix *packIndexer) fixThin() error {
+	off := ix.size - int64(ix.format.Size())
+	err := ix.f.Truncate(off)
+	if err != nil {
+		return err
+	}
+
+	_, err = ix.f.Seek(off, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(ix.f)
+	for id := range ix.external {
+		otype, data, err := ix.repo.readRawData(id)
+		if err != nil {
+			return err
+		}
+
+		crc := crc32.NewIEEE()
+		cw := &countingWriter{w: io.MultiWriter(w, crc)}
+		cw.Write(packEntryHeader(otype, int64(len(data))))
+
+		zw := zlib.NewWriter(cw)
+		_, err = zw.Write(data)
+		if err == nil {
+			err = zw.Close()
+		}
+
+		if err != nil {
+			return err
+		}
+
+		e := &indexEntry{packIndexEntry: packIndexEntry{id, off, crc.Sum32()}, otype: otype, resolved: true}
+		ix.entries = append(ix.entries, e)
+		off += cw.n
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	var count [4]byte
+	binary.BigEndian.PutUint32(count[:], uint32(len(ix.entries)))
+	_, err = ix.f.WriteAt(count[:], 8)
+	if err != nil {
+		return err
+	}
+
+	h := ix.format.New()
+	_, err = io.Copy(h, io.NewSectionReader(ix.f, 0, off))
+	if err != nil {
+		return err
+	}
+
+	ix.sum = ix.format.ID(h.Sum(nil))
+	_, err = ix.f.WriteAt(ix.sum.Bytes(), off)
+	ix.size = off + int64(ix.format.Size())
+	return err
+}
@@ -0,0 +1,171 @@
+package gig
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//packObjects runs "git pack-objects --stdout --revs" with the
+//given revisions and returns the pack.
+func packObjects(t *testing.T, repo *Repository, revs string, args ...string) []byte {
+	t.Helper()
+
+	args = append([]string{"pack-objects", "-q", "--stdout", "--revs"}, args...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = repo.Path
+	cmd.Stdin = strings.NewReader(revs)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git %s failed: %v\n%s", strings.Join(args, " "), err, stderr.String())
+	}
+
+	return out
+}
+
+func TestIndexPack(t *testing.T) {
+	src, _ := mkTestRepo(t)
+	ids := listObjects(t, src)
+
+	indexPack := func(dst *Repository, pack []byte) SHA1 {
+		t.Helper()
+
+		sum, err := dst.IndexPack(bytes.NewReader(pack))
+		if err != nil {
+			t.Fatalf("IndexPack failed: %v", err)
+		}
+
+		idx := filepath.Join(dst.Path, "objects", "pack", "pack-"+sum.String()+".idx")
+		gitCmd(t, dst.Path, "verify-pack", idx)
+		return sum
+	}
+
+	for _, args := range [][]string{nil, {"--delta-base-offset"}} {
+		dst, err := InitBareRepository(filepath.Join(t.TempDir(), "dst.git"))
+		if err != nil {
+			t.Fatalf("could not create repository: %v", err)
+		}
+
+		pack := packObjects(t, src, "master\n", args...)
+		sum := indexPack(dst, pack)
+		if !bytes.HasSuffix(pack, sum.Bytes()) {
+			t.Fatalf("%v: expected pack checksum %x, got %s", args, pack[len(pack)-20:], sum)
+		}
+
+		checkOpenAll(t, dst, ids)
+	}
+
+	//a thin pack on top of the history without the last commit
+	dst, err := InitBareRepository(filepath.Join(t.TempDir(), "thin.git"))
+	if err != nil {
+		t.Fatalf("could not create repository: %v", err)
+	}
+
+	indexPack(dst, packObjects(t, src, "master~1\n"))
+
+	thin := packObjects(t, src, "master\n^master~1\n", "--thin")
+	sum := indexPack(dst, thin)
+
+	pi, err := PackIndexOpen(filepath.Join(dst.Path, "objects", "pack", "pack-"+sum.String()+".idx"))
+	if err != nil {
+		t.Fatalf("could not open index: %v", err)
+	}
+	defer pi.Close()
+
+	if sent := int(binary.BigEndian.Uint32(thin[8:12])); pi.Count() <= sent {
+		t.Fatalf("expected thin pack with %d objects to be completed, got %d objects", sent, pi.Count())
+	}
+
+	checkOpenAll(t, &Repository{Path: dst.Path}, ids)
+
+	//empty and corrupt packs
+	if sum, err := dst.IndexPack(bytes.NewReader(packObjects(t, src, "master\n^master\n"))); err != nil || sum != (SHA1{}) {
+		t.Fatalf("expected empty pack to be ignored, got %s, %v", sum, err)
+	}
+
+	corrupt := packObjects(t, src, "master\n")
+	corrupt[len(corrupt)/2] ^= 0xFF
+	if _, err := dst.IndexPack(bytes.NewReader(corrupt)); err == nil {
+		t.Fatal("expected error for corrupt pack")
+	}
+
+	packs, _ := filepath.Glob(filepath.Join(dst.Path, "objects", "pack", "*"))
+	if len(packs) != 4 {
+		t.Fatalf("expected exactly two packs with indices, got %v", packs)
+	}
+}
+
+//rawPackEntry encodes a pack entry with the given header and data.
+func rawPackEntry(header []byte, data []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write(header)
+
+	zw := zlib.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+
+	return buf.Bytes()
+}
+
+func TestIndexPackLimits(t *testing.T) {
+	dst, err := InitBareRepository(filepath.Join(t.TempDir(), "dst.git"))
+	if err != nil {
+		t.Fatalf("could not create repository: %v", err)
+	}
+
+	//a small base and an ofs-delta against it with a huge target size
+	var pack bytes.Buffer
+	pack.WriteString("PACK")
+	binary.Write(&pack, binary.BigEndian, []uint32{2, 2})
+
+	base := int64(pack.Len())
+	pack.Write(rawPackEntry(packEntryHeader(ObjBlob, 6), []byte("hello\n")))
+
+	delta := appendVarSize(nil, 6)
+	delta = appendVarSize(delta, 1<<46)
+	delta = appendDeltaInsert(delta, []byte("x"))
+
+	header := append(packEntryHeader(ObjOFSDelta, int64(len(delta))), encodeOfsDeltaOffset(int64(pack.Len())-base)...)
+	pack.Write(rawPackEntry(header, delta))
+
+	sum := sha1.Sum(pack.Bytes())
+	pack.Write(sum[:])
+
+	if _, err := dst.IndexPack(bytes.NewReader(pack.Bytes())); !errors.Is(err, ErrObjectTooLarge) {
+		t.Fatalf("expected ErrObjectTooLarge, got %v", err)
+	}
+
+	//the same with a target size below the limit, but no data
+	dst.SetMaxObjectSize(1 << 62)
+	if _, err := dst.IndexPack(bytes.NewReader(pack.Bytes())); err == nil || errors.Is(err, ErrObjectTooLarge) {
+		t.Fatalf("expected size mismatch, got %v", err)
+	}
+	dst.SetMaxObjectSize(0)
+
+	//bases are limited as well
+	dst.SetMaxObjectSize(5)
+	if _, err := dst.IndexPack(bytes.NewReader(pack.Bytes())); !errors.Is(err, ErrObjectTooLarge) {
+		t.Fatalf("expected ErrObjectTooLarge for the base, got %v", err)
+	}
+	dst.SetMaxObjectSize(0)
+
+	//receive.maxInputSize
+	gitCmd(t, dst.Path, "config", "receive.maxInputSize", "32")
+	if _, err := dst.IndexPack(bytes.NewReader(pack.Bytes())); err == nil || !strings.Contains(err.Error(), "maximum allowed size") {
+		t.Fatalf("expected error for pack exceeding receive.maxInputSize, got %v", err)
+	}
+
+	packs, _ := filepath.Glob(filepath.Join(dst.Path, "objects", "pack", "*"))
+	if len(packs) != 0 {
+		t.Fatalf("expected no packs, got %v", packs)
+	}
+}
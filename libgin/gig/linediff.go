@@ -0,0 +1,385 @@
+package gig
+
+import (
+	"bytes"
+	"strings"
+)
+
+//LineOp is the kind of a line in a diff hunk.
+type LineOp int
+
+//LineOp values.
+const (
+	LineContext LineOp = iota
+	LineAdded
+	LineDeleted
+)
+
+//Prefix returns the character that precedes lines
+//of this kind in a unified diff.
+func (op LineOp) Prefix() byte {
+	switch op {
+	case LineAdded:
+		return '+'
+	case LineDeleted:
+		return '-'
+	}
+	return ' '
+}
+
+//Line is a single line of a diff hunk. Content includes the
+//trailing newline, unless it is the last line of a file that
+//does not end in one. OldNumber and NewNumber are the (1-based)
+//line numbers in the old and new file, zero if not applicable.
+type Line struct {
+	Op        LineOp
+	Content   string
+	OldNumber int
+	NewNumber int
+}
+
+//Hunk is a group of changed lines, together with surrounding
+//context. Section is the "function name" line git shows in the
+//hunk header, i.e. the closest preceding line in the old file
+//that starts with a letter, '_' or '$'.
+type Hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	Section  string
+	Lines    []Line
+}
+
+//binaryCheckSize is the number of bytes that are
+//checked for NUL bytes to detect binary content.
+const binaryCheckSize = 8000
+
+//IsBinary reports whether the data is considered binary,
+//which, like git, is the case if one of the first 8000
+//bytes is a NUL byte.
+func IsBinary(data []byte) bool {
+	if len(data) > binaryCheckSize {
+		data = data[:binaryCheckSize]
+	}
+	return bytes.IndexByte(data, 0) != -1
+}
+
+//splitLines splits data into lines, keeping the newlines.
+func splitLines(data []byte) []string {
+	var lines []string
+	for len(data) > 0 {
+		pos := bytes.IndexByte(data, '\n')
+		if pos == -1 {
+			pos = len(data) - 1
+		}
+		lines = append(lines, string(data[:pos+1]))
+		data = data[pos+1:]
+	}
+	return lines
+}
+
+//DiffText computes the differences between the lines of a and b
+//using the Myers algorithm and returns them as hunks with (up to)
+//context lines of context.
+func DiffText(a, b []byte, context int) []Hunk {
+	if context < 0 {
+		context = 0
+	}
+
+	al, bl := splitLines(a), splitLines(b)
+	return makeHunks(diffScript(al, bl), al, context)
+}
+
+//diffScript computes the edit script between the lines al and bl,
+//i.e. all lines of both, marked as context, added or deleted.
+func diffScript(al, bl []string) []Line {
+	//map lines to integers for faster comparison
+	ids := make(map[string]int)
+	intern := func(lines []string) []int {
+		res := make([]int, len(lines))
+		for i, l := range lines {
+			id, ok := ids[l]
+			if !ok {
+				id = len(ids)
+				ids[l] = id
+			}
+			res[i] = id
+		}
+		return res
+	}
+
+	d := &lineDiff{a: intern(al), b: intern(bl)}
+	d.deleted = make([]bool, len(d.a))
+	d.added = make([]bool, len(d.b))
+	d.compare(0, len(d.a), 0, len(d.b))
+
+	var script []Line
+	i, j := 0, 0
+	for i < len(al) || j < len(bl) {
+		switch {
+		case i < len(al) && d.deleted[i]:
+			script = append(script, Line{LineDeleted, al[i], i + 1, 0})
+			i++
+		case j < len(bl) && d.added[j]:
+			script = append(script, Line{LineAdded, bl[j], 0, j + 1})
+			j++
+		default:
+			script = append(script, Line{LineContext, al[i], i + 1, j + 1})
+			i++
+			j++
+		}
+	}
+
+	return script
+}
+
+//makeHunks groups the changes in the script into hunks. Changes
+//that are at most 2*context lines apart end up in the same hunk.
+func makeHunks(script []Line, old []string, context int) []Hunk {
+	var hunks []Hunk
+
+	//number of old and new lines before each script position
+	oldBefore := make([]int, len(script)+1)
+	newBefore := make([]int, len(script)+1)
+	for k, l := range script {
+		oldBefore[k+1], newBefore[k+1] = oldBefore[k], newBefore[k]
+		if l.Op != LineAdded {
+			oldBefore[k+1]++
+		}
+		if l.Op != LineDeleted {
+			newBefore[k+1]++
+		}
+	}
+
+	for pos := 0; pos < len(script); {
+		//find the next change
+		for pos < len(script) && script[pos].Op == LineContext {
+			pos++
+		}
+		if pos == len(script) {
+			break
+		}
+
+		start := pos - context
+		if start < 0 {
+			start = 0
+		}
+
+		//extend the hunk while the next change is close enough
+		end := pos
+		for {
+			for end < len(script) && script[end].Op != LineContext {
+				end++
+			}
+
+			next := end
+			for next < len(script) && script[next].Op == LineContext {
+				next++
+			}
+
+			if next == len(script) || next-end > 2*context {
+				break
+			}
+			end = next
+		}
+
+		end += context
+		if end > len(script) {
+			end = len(script)
+		}
+
+		hunks = append(hunks, newHunk(script[start:end], old, oldBefore[start], newBefore[start]))
+		pos = end
+	}
+
+	return hunks
+}
+
+//newHunk creates a hunk from the lines; oldBefore and newBefore
+//are the numbers of lines in the old and new file before it.
+func newHunk(lines []Line, old []string, oldBefore, newBefore int) Hunk {
+	h := Hunk{Lines: lines}
+
+	for _, l := range lines {
+		if l.Op != LineAdded {
+			h.OldLines++
+		}
+		if l.Op != LineDeleted {
+			h.NewLines++
+		}
+	}
+
+	//for empty ranges, the start is the preceding line
+	h.OldStart, h.NewStart = oldBefore, newBefore
+	if h.OldLines > 0 {
+		h.OldStart++
+	}
+	if h.NewLines > 0 {
+		h.NewStart++
+	}
+
+	h.Section = sectionLine(old, oldBefore)
+	return h
+}
+
+//sectionLine finds the last line before old[before] that starts
+//with a letter, '_' or '$', like git's default "funcname" pattern.
+func sectionLine(old []string, before int) string {
+	if before > len(old) {
+		before = len(old)
+	}
+
+	for i := before - 1; i >= 0; i-- {
+		l := old[i]
+		c := l[0]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '$' {
+			if len(l) > 80 {
+				l = l[:80]
+			}
+			return strings.TrimRight(l, " \t\r\n\v\f")
+		}
+	}
+	return ""
+}
+
+//lineDiff holds the state of the Myers algorithm: the (interned)
+//lines of both files and which of them are deleted or added.
+type lineDiff struct {
+	a, b    []int
+	deleted []bool
+	added   []bool
+}
+
+//compare finds the differences between a[aLo:aHi] and b[bLo:bHi]
+//using the linear space variant of the Myers algorithm.
+func (d *lineDiff) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		aLo++
+		bLo++
+	}
+
+	for aLo < aHi && bLo < bHi && d.a[aHi-1] == d.b[bHi-1] {
+		aHi--
+		bHi--
+	}
+
+	if aLo == aHi || bLo == bHi {
+		d.markChanged(aLo, aHi, bLo, bHi)
+		return
+	}
+
+	x, y, ok := d.bisect(aLo, aHi, bLo, bHi)
+	if !ok || (x == aLo && y == bLo) || (x == aHi && y == bHi) {
+		d.markChanged(aLo, aHi, bLo, bHi)
+		return
+	}
+
+	d.compare(aLo, x, bLo, y)
+	d.compare(x, aHi, y, bHi)
+}
+
+func (d *lineDiff) markChanged(aLo, aHi, bLo, bHi int) {
+	for i := aLo; i < aHi; i++ {
+		d.deleted[i] = true
+	}
+	for j := bLo; j < bHi; j++ {
+		d.added[j] = true
+	}
+}
+
+//bisect finds the "middle snake" of the shortest edit script
+//between a[aLo:aHi] and b[bLo:bHi] by searching forward and
+//backward at the same time, and returns the point where the
+//two searches meet.
+func (d *lineDiff) bisect(aLo, aHi, bLo, bHi int) (int, int, bool) {
+	a, b := d.a[aLo:aHi], d.b[bLo:bHi]
+	n, m := len(a), len(b)
+
+	maxD := (n + m + 1) / 2
+	off := maxD
+	size := 2*maxD + 2
+
+	v1 := make([]int, size)
+	v2 := make([]int, size)
+	for i := range v1 {
+		v1[i], v2[i] = -1, -1
+	}
+	v1[off+1], v2[off+1] = 0, 0
+
+	delta := n - m
+	//if the delta is odd, the forward path
+	//checks for overlaps, else the backward one
+	front := delta%2 != 0
+
+	k1start, k1end, k2start, k2end := 0, 0, 0, 0
+	for step := 0; step < maxD; step++ {
+		for k1 := -step + k1start; k1 <= step-k1end; k1 += 2 {
+			k1off := off + k1
+
+			var x1 int
+			if k1 == -step || (k1 != step && v1[k1off-1] < v1[k1off+1]) {
+				x1 = v1[k1off+1]
+			} else {
+				x1 = v1[k1off-1] + 1
+			}
+
+			y1 := x1 - k1
+			for x1 < n && y1 < m && a[x1] == b[y1] {
+				x1++
+				y1++
+			}
+			v1[k1off] = x1
+
+			switch {
+			case x1 > n:
+				k1end += 2
+			case y1 > m:
+				k1start += 2
+			case front:
+				k2off := off + delta - k1
+				if k2off >= 0 && k2off < size && v2[k2off] != -1 {
+					if x1 >= n-v2[k2off] {
+						return aLo + x1, bLo + y1, true
+					}
+				}
+			}
+		}
+
+		for k2 := -step + k2start; k2 <= step-k2end; k2 += 2 {
+			k2off := off + k2
+
+			var x2 int
+			if k2 == -step || (k2 != step && v2[k2off-1] < v2[k2off+1]) {
+				x2 = v2[k2off+1]
+			} else {
+				x2 = v2[k2off-1] + 1
+			}
+
+			y2 := x2 - k2
+			for x2 < n && y2 < m && a[n-x2-1] == b[m-y2-1] {
+				x2++
+				y2++
+			}
+			v2[k2off] = x2
+
+			switch {
+			case x2 > n:
+				k2end += 2
+			case y2 > m:
+				k2start += 2
+			case !front:
+				k1off := off + delta - k2
+				if k1off >= 0 && k1off < size && v1[k1off] != -1 {
+					x1 := v1[k1off]
+					y1 := off + x1 - k1off
+					if x1 >= n-x2 {
+						return aLo + x1, bLo + y1, true
+					}
+				}
+			}
+		}
+	}
+
+	return 0, 0, false
+}
@@ -0,0 +1,438 @@
+package gig
+
+import (
+	"container/heap"
+	"strings"
+	"time"
+)
+
+//LogOrder is the order in which commits are returned by the
+//commit log iterator.
+type LogOrder int
+
+//LogOrder values.
+const (
+	//LogDateOrder returns commits with newer commit dates
+	//first, like "git log" does by default.
+	LogDateOrder LogOrder = iota
+
+	//LogTopoOrder returns no parent before all its children
+	//and avoids intermixing multiple lines of history, like
+	//"git log --topo-order". The whole history has to be
+	//walked before the first commit is returned.
+	LogTopoOrder
+)
+
+//LogOptions control which commits are returned by the commit
+//log iterator and in which order.
+type LogOptions struct {
+	Order LogOrder
+
+	//Path limits the commits to those that changed the file
+	//or directory at Path. Like in "git log -- <path>", merges
+	//that did not change the path with respect to one of their
+	//parents are not shown and only that parent is followed.
+	Path string
+
+	//Since and Until limit the commits by their commit date,
+	//if non-zero. The history is not walked past commits that
+	//are older than Since.
+	Since time.Time
+	Until time.Time
+
+	//Skip is the number of (matching) commits to skip, Limit
+	//the maximal number of commits to return, if non-zero.
+	Skip  int
+	Limit int
+}
+
+//CommitIter iterates over the commit history. Use Next() to
+//advance to the next commit and Commit() to access it; Err()
+//returns the error that stopped the iteration, if any.
+type CommitIter struct {
+	repo *Repository
+	opts LogOptions
+	walk *logWalker
+
+	topo []*logNode
+
+	count  int
+	id     SHA1
+	commit *Commit
+	err    error
+	done   bool
+}
+
+//Log returns an iterator over the history starting at the given
+//commits. If opts is nil, all commits are returned in date order.
+func (repo *Repository) Log(opts *LogOptions, start ...SHA1) *CommitIter {
+	it := &CommitIter{repo: repo}
+	if opts != nil {
+		it.opts = *opts
+	}
+
+	it.opts.Path = strings.Trim(it.opts.Path, "/")
+
+	cg, err := repo.CommitGraph()
+	if err != nil {
+		it.err = err
+		return it
+	}
+
+	it.walk = &logWalker{
+		repo:  repo,
+		cg:    cg,
+		path:  it.opts.Path,
+		since: it.opts.Since,
+		seen:  make(map[SHA1]bool),
+		paths: make(map[SHA1]pathState),
+	}
+
+	for _, id := range start {
+		err = it.walk.push(id)
+		if err != nil {
+			it.err = err
+			return it
+		}
+	}
+
+	if it.opts.Order == LogTopoOrder {
+		it.topo, it.err = it.walk.sortTopo()
+	}
+
+	return it
+}
+
+//Next advances the iterator to the next commit, returns false
+//if there are no more commits or an error occurred.
+func (it *CommitIter) Next() bool {
+	if it.err != nil || it.done {
+		return false
+	}
+
+	if it.opts.Limit > 0 && it.count >= it.opts.Limit {
+		it.done = true
+		return false
+	}
+
+	for {
+		node, err := it.nextNode()
+		if err != nil {
+			it.err = err
+			return false
+		} else if node == nil {
+			it.done = true
+			return false
+		}
+
+		if !node.show || (!it.opts.Until.IsZero() && node.Date.After(it.opts.Until)) {
+			continue
+		}
+
+		if it.opts.Skip > 0 {
+			it.opts.Skip--
+			continue
+		}
+
+		it.commit, err = it.repo.openCommit(node.ID)
+		if err != nil {
+			it.err = err
+			return false
+		}
+
+		it.id = node.ID
+		it.count++
+		return true
+	}
+}
+
+func (it *CommitIter) nextNode() (*logNode, error) {
+	if it.opts.Order != LogTopoOrder {
+		return it.walk.next()
+	}
+
+	if len(it.topo) == 0 {
+		return nil, nil
+	}
+
+	node := it.topo[0]
+	it.topo = it.topo[1:]
+	return node, nil
+}
+
+//ID returns the id of the current commit.
+func (it *CommitIter) ID() SHA1 {
+	return it.id
+}
+
+//Commit returns the current commit.
+func (it *CommitIter) Commit() *Commit {
+	return it.commit
+}
+
+//Err returns the error that stopped the iteration, if any.
+func (it *CommitIter) Err() error {
+	return it.err
+}
+
+//openCommit opens and fully reads the commit with the given id.
+func (repo *Repository) openCommit(id SHA1) (*Commit, error) {
+	obj, err := repo.OpenObject(id)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	commit, ok := obj.(*Commit)
+	if !ok {
+		return nil, &ObjectTypeError{ID: id, Type: obj.Type(), Expected: ObjCommit}
+	}
+
+	return commit, nil
+}
+
+//logNode is a commit in the (simplified) history: parents only
+//contains the parents that are followed and show tells if the
+//commit matches the path filter.
+type logNode struct {
+	*CommitNode
+	parents []SHA1
+	show    bool
+}
+
+//pathState is the state of the filtered path in a tree.
+type pathState struct {
+	entry  TreeEntry
+	exists bool
+}
+
+//logWalker walks the history in commit date order (newest first),
+//simplifying it with respect to the path filter on the way.
+type logWalker struct {
+	repo  *Repository
+	cg    *CommitGraph
+	path  string
+	since time.Time
+
+	queue nodeQueue
+	seq   int
+	seen  map[SHA1]bool
+	paths map[SHA1]pathState
+}
+
+func (w *logWalker) push(id SHA1) error {
+	if w.seen[id] {
+		return nil
+	}
+	w.seen[id] = true
+
+	node, err := w.repo.openCommitNode(w.cg, id)
+	if err != nil {
+		return err
+	}
+
+	heap.Push(&w.queue, queueItem{node, w.seq})
+	w.seq++
+	return nil
+}
+
+//next returns the next commit of the walk, nil at the end.
+func (w *logWalker) next() (*logNode, error) {
+	for w.queue.Len() > 0 {
+		node := heap.Pop(&w.queue).(queueItem).node
+		if !w.since.IsZero() && node.Date.Before(w.since) {
+			continue
+		}
+
+		ln := &logNode{CommitNode: node, parents: node.Parent, show: true}
+		if w.path != "" {
+			err := w.simplify(ln)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, p := range ln.parents {
+			err := w.push(p)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return ln, nil
+	}
+
+	return nil, nil
+}
+
+//simplify determines if the commit changed the filtered path
+//with respect to its parents. If it did not, with respect to
+//one of them, only that parent is followed.
+func (w *logWalker) simplify(ln *logNode) error {
+	own, err := w.pathState(ln.Tree)
+	if err != nil {
+		return err
+	}
+
+	if len(ln.Parent) == 0 {
+		ln.show = own.exists
+		return nil
+	}
+
+	for _, p := range ln.Parent {
+		node, err := w.repo.openCommitNode(w.cg, p)
+		if err != nil {
+			return err
+		}
+
+		ps, err := w.pathState(node.Tree)
+		if err != nil {
+			return err
+		}
+
+		if ps == own {
+			ln.parents = []SHA1{p}
+			ln.show = false
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (w *logWalker) pathState(tree SHA1) (pathState, error) {
+	if state, ok := w.paths[tree]; ok {
+		return state, nil
+	}
+
+	entry, err := w.repo.treeEntryAt(tree, w.path)
+	if err != nil {
+		return pathState{}, err
+	}
+
+	var state pathState
+	if entry != nil {
+		state = pathState{*entry, true}
+	}
+
+	w.paths[tree] = state
+	return state, nil
+}
+
+//sortTopo walks the whole history and sorts it topologically.
+func (w *logWalker) sortTopo() ([]*logNode, error) {
+	var nodes []*logNode
+	indegree := make(map[SHA1]int)
+
+	for {
+		node, err := w.next()
+		if err != nil {
+			return nil, err
+		} else if node == nil {
+			break
+		}
+
+		nodes = append(nodes, node)
+		indegree[node.ID] = 1
+	}
+
+	byID := make(map[SHA1]*logNode, len(nodes))
+	for _, node := range nodes {
+		byID[node.ID] = node
+		for _, p := range node.parents {
+			if indegree[p] > 0 {
+				indegree[p]++
+			}
+		}
+	}
+
+	//tips are processed in the order of the walk,
+	//so they are pushed to the stack in reverse
+	var stack []*logNode
+	for i := len(nodes) - 1; i >= 0; i-- {
+		if indegree[nodes[i].ID] == 1 {
+			stack = append(stack, nodes[i])
+		}
+	}
+
+	res := make([]*logNode, 0, len(nodes))
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		for _, p := range node.parents {
+			if indegree[p] == 0 {
+				continue
+			}
+
+			indegree[p]--
+			if indegree[p] == 1 {
+				stack = append(stack, byID[p])
+			}
+		}
+
+		res = append(res, node)
+	}
+
+	return res, nil
+}
+
+//treeEntryAt returns the entry at path p (slash separated) in the
+//tree with the given id, or nil if there is no such entry. For the
+//empty path, an entry for the tree itself is returned.
+func (repo *Repository) treeEntryAt(tree SHA1, p string) (*TreeEntry, error) {
+	entry := &TreeEntry{Mode: ModeTree, Type: ObjTree, ID: tree}
+	if p == "" {
+		return entry, nil
+	}
+
+	for _, name := range strings.Split(p, "/") {
+		if entry.Mode != ModeTree {
+			return nil, nil
+		}
+
+		entries, err := repo.readTreeEntries(entry.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		e, ok := entries[name]
+		if !ok {
+			return nil, nil
+		}
+		entry = &e
+	}
+
+	return entry, nil
+}
+
+type queueItem struct {
+	node *CommitNode
+	seq  int
+}
+
+//nodeQueue is a priority queue of commits, newest first; commits
+//with the same date are returned in the order they were added.
+type nodeQueue []queueItem
+
+func (q nodeQueue) Len() int { return len(q) }
+
+func (q nodeQueue) Less(i, j int) bool {
+	if !q[i].node.Date.Equal(q[j].node.Date) {
+		return q[i].node.Date.After(q[j].node.Date)
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q nodeQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *nodeQueue) Push(x interface{}) {
+	*q = append(*q, x.(queueItem))
+}
+
+func (q *nodeQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
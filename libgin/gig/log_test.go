@@ -0,0 +1,150 @@
+package gig
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+//logBaseTime is the (unix) commit date of the first commit
+//made by mkLogHistory, later commits are an hour apart.
+const logBaseTime = 1500000000
+
+//mkLogHistory creates two interleaved lines of history that are
+//merged, with commits at distinct dates, and pushes them to the
+//bare repository.
+func mkLogHistory(t *testing.T, wt string) {
+	t.Helper()
+
+	n := 0
+	commit := func(file, content string) {
+		p := filepath.Join(wt, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+			t.Fatalf("could not create dir: %v", err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0666); err != nil {
+			t.Fatalf("could not write file: %v", err)
+		}
+
+		gitCmd(t, wt, "add", "-A")
+		gitAt(t, wt, logBaseTime+n*3600, "commit", "-q", "-m", fmt.Sprintf("log commit %d", n))
+		n++
+	}
+
+	gitCmd(t, wt, "checkout", "-q", "-b", "side", "master")
+	commit("side/one.txt", "one\n")
+
+	gitCmd(t, wt, "checkout", "-q", "master")
+	commit("data/a.txt", "changed on master\n")
+
+	gitCmd(t, wt, "checkout", "-q", "side")
+	commit("data/b.txt", "changed on side\n")
+	commit("side/one.txt", "two\n")
+
+	gitCmd(t, wt, "checkout", "-q", "master")
+	commit("README.md", "changed on master\n")
+	gitAt(t, wt, logBaseTime+n*3600, "merge", "-q", "--no-ff", "-m", "merge side", "side")
+	n++
+	commit("data/a.txt", "changed again\n")
+
+	gitCmd(t, wt, "push", "-q", "bare", "master", "side")
+}
+
+//gitAt runs git like gitCmd, but with the given commit date.
+func gitAt(t *testing.T, dir string, date int, args ...string) string {
+	t.Helper()
+
+	args = append([]string{"-c", "user.name=A U Thor", "-c", "user.email=author@example.com"}, args...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("GIT_AUTHOR_DATE=%d +0200", date),
+		fmt.Sprintf("GIT_COMMITTER_DATE=%d +0200", date))
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s failed: %v\n%s", strings.Join(args, " "), err, out)
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+func logIDs(t *testing.T, it *CommitIter) string {
+	t.Helper()
+
+	var ids []string
+	for it.Next() {
+		if it.Commit() == nil {
+			t.Fatal("iterator returned nil commit")
+		}
+		ids = append(ids, it.ID().String())
+	}
+
+	if err := it.Err(); err != nil {
+		t.Fatalf("log failed: %v", err)
+	}
+
+	return strings.Join(ids, "\n")
+}
+
+func TestLog(t *testing.T) {
+	repo, wt := mkTestRepo(t)
+	mkLogHistory(t, wt)
+
+	head, err := ParseSHA1(gitCmd(t, repo.Path, "rev-parse", "master"))
+	if err != nil {
+		t.Fatalf("could not parse id: %v", err)
+	}
+
+	since := time.Unix(logBaseTime+2*3600, 0)
+	until := time.Unix(logBaseTime+4*3600, 0)
+
+	tests := []struct {
+		opts LogOptions
+		args []string
+	}{
+		{LogOptions{}, nil},
+		{LogOptions{Order: LogTopoOrder}, []string{"--topo-order"}},
+		{LogOptions{Skip: 2, Limit: 3}, []string{"--skip=2", "-n3"}},
+		{LogOptions{Order: LogTopoOrder, Skip: 1, Limit: 4}, []string{"--topo-order", "--skip=1", "-n4"}},
+		{LogOptions{Since: since}, []string{fmt.Sprintf("--since=%d", since.Unix())}},
+		{LogOptions{Until: until}, []string{fmt.Sprintf("--until=%d", until.Unix())}},
+		{LogOptions{Path: "data/a.txt"}, []string{"--", "data/a.txt"}},
+		{LogOptions{Path: "data"}, []string{"--", "data"}},
+		{LogOptions{Path: "side/"}, []string{"--", "side/"}},
+		{LogOptions{Path: "data/b.txt", Order: LogTopoOrder}, []string{"--topo-order", "--", "data/b.txt"}},
+		{LogOptions{Path: "data", Limit: 2}, []string{"-n2", "--", "data"}},
+		{LogOptions{Path: "does/not/exist"}, []string{"--", "does/not/exist"}},
+	}
+
+	for _, tt := range tests {
+		opts := tt.opts
+		args := append([]string{"log", "--format=%H", "master"}, tt.args...)
+		expected := gitCmd(t, repo.Path, args...)
+
+		if actual := logIDs(t, repo.Log(&opts, head)); actual != expected {
+			t.Fatalf("git %v: expected:\n%s\ngot:\n%s", tt.args, expected, actual)
+		}
+	}
+
+	//multiple starting points
+	side, err := ParseSHA1(gitCmd(t, repo.Path, "rev-parse", "side"))
+	if err != nil {
+		t.Fatalf("could not parse id: %v", err)
+	}
+
+	parent, err := ParseSHA1(gitCmd(t, repo.Path, "rev-parse", "master~1"))
+	if err != nil {
+		t.Fatalf("could not parse id: %v", err)
+	}
+
+	expected := gitCmd(t, repo.Path, "log", "--format=%H", "side", "master~1")
+	if actual := logIDs(t, repo.Log(nil, side, parent)); actual != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, actual)
+	}
+}
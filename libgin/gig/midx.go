@@ -0,0 +1,241 @@
+package gig
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Resources:
+//  https://github.com/git/git/blob/master/Documentation/technical/multi-pack-index.txt
+//  https://github.com/git/git/blob/master/Documentation/gitformat-pack.txt
+
+//MultiPackIndex represents the multi-pack-index file, that
+//indexes the objects of multiple pack files at once.
+type MultiPackIndex struct {
+	*os.File
+
+	Version     uint8
+	HashVersion uint8
+	FO          FanOut
+
+	//Format is the object format, given by HashVersion.
+	Format ObjectFormat
+
+	//PackNames are the names of the pack indices
+	//(i.e. "pack-<sha1>.idx") that are covered.
+	PackNames []string
+
+	oidl chunk
+	ooff chunk
+	loff chunk
+}
+
+//MultiPackIndexOpen opens the multi-pack-index file at path.
+func MultiPackIndexOpen(path string) (*MultiPackIndex, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("git: could not read multi-pack-index: %v", err)
+	}
+
+	midx, err := readMultiPackIndex(fd)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+
+	return midx, nil
+}
+
+func readMultiPackIndex(fd *os.File) (*MultiPackIndex, error) {
+	//header format:
+	//[sig{4}][version{1}][hash version{1}][#chunks{1}][#base files{1}][#packs{4}]
+	var header [12]byte
+	_, err := fd.ReadAt(header[:], 0)
+	if err != nil {
+		return nil, fmt.Errorf("git: could not read multi-pack-index: %v", err)
+	}
+
+	if !bytes.Equal(header[:4], []byte("MIDX")) {
+		return nil, fmt.Errorf("git: multi-pack-index signature error")
+	}
+
+	midx := &MultiPackIndex{File: fd, Version: header[4], HashVersion: header[5]}
+	if midx.HashVersion == 2 {
+		midx.Format = FormatSHA256
+	}
+
+	if midx.Version != 1 {
+		return nil, fmt.Errorf("git: unsupported multi-pack-index version: %d", midx.Version)
+	} else if midx.HashVersion != 1 && midx.HashVersion != 2 {
+		return nil, fmt.Errorf("git: unsupported multi-pack-index hash version: %d", midx.HashVersion)
+	} else if header[7] != 0 {
+		return nil, fmt.Errorf("git: incremental multi-pack-index not supported")
+	}
+
+	nchunks := int(header[6])
+	npacks := int(binary.BigEndian.Uint32(header[8:]))
+
+	chunks, err := readChunkTable(fd, 12, nchunks)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range []string{"PNAM", "OIDF", "OIDL", "OOFF"} {
+		if _, ok := chunks[id]; !ok {
+			return nil, fmt.Errorf("git: multi-pack-index is missing the %s chunk", id)
+		}
+	}
+
+	pnam := chunks["PNAM"]
+	names := make([]byte, pnam.end-pnam.start)
+	_, err = fd.ReadAt(names, pnam.start)
+	if err != nil {
+		return nil, fmt.Errorf("git: io error: %v", err)
+	}
+
+	for _, name := range strings.Split(string(names), "\x00") {
+		if name != "" {
+			midx.PackNames = append(midx.PackNames, name)
+		}
+	}
+
+	if len(midx.PackNames) != npacks {
+		return nil, fmt.Errorf("git: multi-pack-index pack name count mismatch")
+	}
+
+	var fo [256 * 4]byte
+	_, err = fd.ReadAt(fo[:], chunks["OIDF"].start)
+	if err != nil {
+		return nil, fmt.Errorf("git: io error: %v", err)
+	}
+
+	for i := range midx.FO {
+		midx.FO[i] = binary.BigEndian.Uint32(fo[i*4:])
+	}
+
+	midx.oidl = chunks["OIDL"]
+	midx.ooff = chunks["OOFF"]
+	midx.loff = chunks["LOFF"]
+
+	n := int64(midx.Count())
+	if midx.oidl.end-midx.oidl.start < n*int64(midx.Format.Size()) || midx.ooff.end-midx.ooff.start < n*8 {
+		return nil, fmt.Errorf("git: multi-pack-index chunks are truncated")
+	}
+
+	return midx, nil
+}
+
+//Count returns the number of objects in the index.
+func (midx *MultiPackIndex) Count() int {
+	return int(midx.FO[255])
+}
+
+//ReadSHA1 reads the SHA1 stored at position pos.
+func (midx *MultiPackIndex) ReadSHA1(chksum *SHA1, pos int) error {
+	hs := midx.Format.Size()
+
+	var buf [32]byte
+	_, err := midx.ReadAt(buf[:hs], midx.oidl.start+int64(pos*hs))
+	if err != nil {
+		return err
+	}
+
+	*chksum = midx.Format.ID(buf[:hs])
+	return nil
+}
+
+//ReadOffset returns the index of the pack (in PackNames) that
+//contains the object at position pos and its offset in that pack.
+func (midx *MultiPackIndex) ReadOffset(pos int) (int, int64, error) {
+	var buf [8]byte
+	_, err := midx.ReadAt(buf[:], midx.ooff.start+int64(pos)*8)
+	if err != nil {
+		return -1, -1, fmt.Errorf("git: io error: %v", err)
+	}
+
+	pack := int(binary.BigEndian.Uint32(buf[:4]))
+	offset := binary.BigEndian.Uint32(buf[4:])
+
+	if pack >= len(midx.PackNames) {
+		return -1, -1, fmt.Errorf("git: invalid pack id in multi-pack-index")
+	}
+
+	if offset&(1<<31) == 0 {
+		return pack, int64(offset), nil
+	}
+
+	idx := int64(offset &^ (1 << 31))
+	start := midx.loff.start + idx*8
+	if start+8 > midx.loff.end {
+		return -1, -1, fmt.Errorf("git: invalid large offset in multi-pack-index")
+	}
+
+	_, err = midx.ReadAt(buf[:], start)
+	if err != nil {
+		return -1, -1, fmt.Errorf("git: io error: %v", err)
+	}
+
+	large := binary.BigEndian.Uint64(buf[:])
+	if large > 1<<63-1 {
+		return -1, -1, fmt.Errorf("git: pack offset overflow")
+	}
+
+	return pack, int64(large), nil
+}
+
+func (midx *MultiPackIndex) findSHA1(target SHA1) (int, bool, error) {
+	//see PackIndex.findSHA1; search interval is (s, e]
+	s, e := midx.FO.Bounds(target.hash[0])
+	if target.format != midx.Format {
+		e = s
+	}
+
+	for s < e {
+		midpoint := s + (e-s+1)/2
+
+		var sha SHA1
+		err := midx.ReadSHA1(&sha, midpoint-1)
+		if err != nil {
+			return 0, false, fmt.Errorf("git: io error: %v", err)
+		}
+
+		switch target.compare(sha) {
+		case -1:
+			e = midpoint - 1
+		case +1:
+			s = midpoint
+		default:
+			return midpoint - 1, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
+
+//findPrefix returns the ids in the index that start with the
+//given (lower-case) hex prefix, see matchPrefix.
+func (midx *MultiPackIndex) findPrefix(prefix string) ([]SHA1, error) {
+	return matchPrefix(midx.ReadSHA1, midx.FO, prefix)
+}
+
+//FindOffset looks up the object with the id target and, if found,
+//returns the name of the pack index of the pack file that contains
+//the object and the offset of the object in that pack file.
+func (midx *MultiPackIndex) FindOffset(target SHA1) (string, int64, error) {
+	pos, found, err := midx.findSHA1(target)
+	if err != nil {
+		return "", 0, err
+	} else if !found {
+		return "", 0, fmt.Errorf("git: sha1 not found in multi-pack-index")
+	}
+
+	pack, off, err := midx.ReadOffset(pos)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return midx.PackNames[pack], off, nil
+}
@@ -0,0 +1,62 @@
+package gig
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestMultiPackIndex(t *testing.T) {
+	repo, wt := mkTestRepo(t)
+	defer repo.packSet().close()
+
+	//create a second pack
+	if err := ioutil.WriteFile(filepath.Join(wt, "midx.txt"), []byte("midx\n"), 0666); err != nil {
+		t.Fatalf("could not write file: %v", err)
+	}
+	gitCmd(t, wt, "add", "-A")
+	gitCmd(t, wt, "commit", "-q", "-m", "midx")
+	gitCmd(t, wt, "push", "-q", "bare", "master")
+	gitCmd(t, repo.Path, "repack", "-q", "-d")
+	gitCmd(t, repo.Path, "multi-pack-index", "write")
+
+	midx, err := MultiPackIndexOpen(filepath.Join(repo.Path, "objects", "pack", "multi-pack-index"))
+	if err != nil {
+		t.Fatalf("could not open multi-pack-index: %v", err)
+	}
+	defer midx.Close()
+
+	if len(midx.PackNames) != 2 {
+		t.Fatalf("expected multi-pack-index to cover 2 packs, got %v", midx.PackNames)
+	}
+
+	ids := listObjects(t, repo)
+	if midx.Count() != len(ids) {
+		t.Fatalf("expected %d objects in multi-pack-index, got %d", len(ids), midx.Count())
+	}
+
+	for _, id := range ids {
+		name, off, err := midx.FindOffset(id)
+		if err != nil {
+			t.Fatalf("could not find %s in multi-pack-index: %v", id, err)
+		}
+
+		idx, err := PackIndexOpen(filepath.Join(repo.Path, "objects", "pack", name))
+		if err != nil {
+			t.Fatalf("could not open pack index %s: %v", name, err)
+		}
+
+		poff, err := idx.FindOffset(id)
+		idx.Close()
+
+		if err != nil || poff != off {
+			t.Fatalf("offset mismatch for %s: %d != %d (%v)", id, off, poff, err)
+		}
+	}
+
+	checkOpenAll(t, repo, ids)
+
+	if ps := repo.packSet(); ps.midx == nil {
+		t.Fatalf("multi-pack-index was not used")
+	}
+}
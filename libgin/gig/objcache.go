@@ -0,0 +1,159 @@
+package gig
+
+import (
+	"bytes"
+	"container/list"
+	"io/ioutil"
+	"sync"
+)
+
+//DefaultObjectCacheLimit is the default memory budget (in bytes)
+//of the cache for commits and trees.
+const DefaultObjectCacheLimit = 32 << 20
+
+type objectCacheEntry struct {
+	id   SHA1
+	size int64
+
+	//commit is a parsed commit without source, tree
+	//the data of a tree
+	commit *Commit
+	tree   []byte
+}
+
+//objectCache is a LRU cache of commits and trees, which are read
+//over and over again during history walks and path lookups. It is
+//bounded by the total size of the objects. Commits are cached in
+//parsed form, trees as their data; each lookup returns a new object
+//that can be used (and closed) independently.
+type objectCache struct {
+	mu      sync.Mutex
+	limit   int64
+	size    int64
+	lru     *list.List
+	entries map[SHA1]*list.Element
+}
+
+func newObjectCache(limit int64) *objectCache {
+	return &objectCache{
+		limit:   limit,
+		lru:     list.New(),
+		entries: make(map[SHA1]*list.Element),
+	}
+}
+
+func (c *objectCache) get(id SHA1) (Object, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+
+	c.lru.MoveToFront(el)
+	e := el.Value.(*objectCacheEntry)
+
+	if e.commit != nil {
+		commit := *e.commit
+		commit.Parent = append([]SHA1(nil), e.commit.Parent...)
+		return &commit, true
+	}
+
+	source := ioutil.NopCloser(bytes.NewReader(e.tree))
+	return &Tree{gitObject: gitObject{otype: ObjTree, size: e.size, source: source, format: id.Format()}}, true
+}
+
+//add adds the object, if it is a commit or a tree that fits into
+//the cache, and returns an object to be used instead of it. Trees
+//are read completely for that.
+func (c *objectCache) add(id SHA1, obj Object) (Object, error) {
+	c.mu.Lock()
+	limit := c.limit
+	c.mu.Unlock()
+
+	e := &objectCacheEntry{id: id, size: obj.Size()}
+	if limit <= 0 || e.size > limit {
+		return obj, nil
+	}
+
+	switch o := obj.(type) {
+	case *Commit:
+		commit := *o
+		commit.gitObject.source = nil
+		commit.Parent = append([]SHA1(nil), o.Parent...)
+		e.commit = &commit
+	case *Tree:
+		data, err := ioutil.ReadAll(o.source)
+		o.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		e.tree = data
+		e.size = int64(len(data))
+		obj = &Tree{gitObject: gitObject{otype: ObjTree, size: e.size, source: ioutil.NopCloser(bytes.NewReader(data)), format: o.format}}
+	default:
+		return obj, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[id]; !ok {
+		c.entries[id] = c.lru.PushFront(e)
+		c.size += e.size
+		c.evict()
+	}
+
+	return obj, nil
+}
+
+//setLimit changes the memory budget, evicting entries if needed.
+func (c *objectCache) setLimit(limit int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.limit = limit
+	c.evict()
+}
+
+//clear removes all entries.
+func (c *objectCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lru.Init()
+	c.entries = make(map[SHA1]*list.Element)
+	c.size = 0
+}
+
+func (c *objectCache) evict() {
+	for c.size > c.limit {
+		el := c.lru.Back()
+		e := el.Value.(*objectCacheEntry)
+
+		c.lru.Remove(el)
+		delete(c.entries, e.id)
+		c.size -= e.size
+	}
+}
+
+//objectCache returns the cache of commits and trees of
+//the repository, which is created on first use.
+func (repo *Repository) objectCache() *objectCache {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if repo.objects == nil {
+		repo.objects = newObjectCache(DefaultObjectCacheLimit)
+	}
+
+	return repo.objects
+}
+
+//SetObjectCacheLimit sets the memory budget (in bytes) for the
+//cache of commits and trees. Zero disables the cache.
+func (repo *Repository) SetObjectCacheLimit(limit int64) {
+	repo.objectCache().setLimit(limit)
+}
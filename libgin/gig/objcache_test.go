@@ -0,0 +1,136 @@
+package gig
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestObjectCache(t *testing.T) {
+	repo, wt := mkTestRepo(t)
+	gitCmd(t, repo.Path, "repack", "-q", "-a", "-d")
+
+	head := revParse(t, repo, "master")
+	tree := revParse(t, repo, "master^{tree}")
+
+	readTree := func() string {
+		t.Helper()
+
+		obj, err := repo.OpenObject(tree)
+		if err != nil {
+			t.Fatalf("could not open tree: %v", err)
+		}
+		defer obj.Close()
+
+		var entries []string
+		tr := obj.(*Tree)
+		for tr.Next() {
+			entries = append(entries, tr.Entry().Name)
+		}
+		if err := tr.Err(); err != nil {
+			t.Fatalf("could not read tree: %v", err)
+		}
+
+		return fmt.Sprint(entries)
+	}
+
+	//cached objects are independent of each other
+	first, second := readTree(), readTree()
+	if first != second || first != "[README.md data]" {
+		t.Fatalf("unexpected tree entries %s and %s", first, second)
+	}
+
+	if _, ok := repo.objectCache().get(tree); !ok {
+		t.Fatalf("expected tree to be cached")
+	}
+
+	obj, err := repo.OpenObject(head)
+	if err != nil {
+		t.Fatalf("could not open commit: %v", err)
+	}
+	obj.Close()
+
+	commit := obj.(*Commit)
+	commit.Parent[0] = SHA1{}
+
+	obj, err = repo.OpenObject(head)
+	if err != nil {
+		t.Fatalf("could not open commit: %v", err)
+	}
+	obj.Close()
+
+	if cached := obj.(*Commit); cached.Parent[0] != revParse(t, repo, "master~1") || cached.Message != "commit 2\n" {
+		t.Fatalf("unexpected cached commit %+v", cached)
+	}
+
+	//the cache is bounded
+	repo.SetObjectCacheLimit(1)
+	if cache := repo.objectCache(); len(cache.entries) != 0 || cache.size != 0 {
+		t.Fatalf("expected empty cache, got %d entries", len(cache.entries))
+	}
+
+	if readTree() != first {
+		t.Fatalf("unexpected tree entries")
+	} else if _, ok := repo.objectCache().get(tree); ok {
+		t.Fatalf("expected tree not to be cached")
+	}
+	repo.SetObjectCacheLimit(DefaultObjectCacheLimit)
+
+	//concurrent use
+	log := gitCmd(t, wt, "log", "--format=%H %T", "master")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var lines []string
+			for id := head; !id.IsZero(); {
+				obj, err := repo.OpenObject(id)
+				if err != nil {
+					errs <- err
+					return
+				}
+				obj.Close()
+
+				c := obj.(*Commit)
+				lines = append(lines, fmt.Sprintf("%s %s", id, c.Tree))
+
+				if _, err := repo.ObjectForPath(c, "data/sub/c.txt"); err != nil {
+					errs <- err
+					return
+				}
+
+				id = SHA1{}
+				if len(c.Parent) > 0 {
+					id = c.Parent[0]
+				}
+			}
+
+			if history := strings.Join(lines, "\n"); history != log {
+				errs <- fmt.Errorf("unexpected history:\n%s", history)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatalf("concurrent use failed: %v", err)
+	}
+
+	//closing releases the packs, but the repository remains usable
+	if err := repo.Close(); err != nil {
+		t.Fatalf("could not close repository: %v", err)
+	} else if repo.packs != nil || len(repo.objects.entries) != 0 {
+		t.Fatalf("expected packs and caches to be released")
+	}
+
+	if readTree() != first {
+		t.Fatalf("unexpected tree entries after closing")
+	}
+	repo.Close()
+}
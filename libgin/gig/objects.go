@@ -1,36 +1,133 @@
 package gig
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
+	"io/ioutil"
 	"os"
 	"strings"
 	"time"
 )
 
-//SHA1 is the object identifying checksum of
-// the object data
-type SHA1 [20]byte
+//ObjectFormat is the hash function that is used for object
+//ids, either SHA-1 or SHA-256 (see "extensions.objectFormat"
+//in git-config(1)).
+type ObjectFormat byte
 
-func (oid SHA1) String() string {
-	return hex.EncodeToString(oid[:])
+//The supported object formats, SHA-1 is the default.
+const (
+	FormatSHA1 ObjectFormat = iota
+	FormatSHA256
+)
+
+//ParseObjectFormat parses the name of an object format,
+//i.e. "sha1" or "sha256".
+func ParseObjectFormat(s string) (ObjectFormat, error) {
+	switch strings.ToLower(s) {
+	case "sha1":
+		return FormatSHA1, nil
+	case "sha256":
+		return FormatSHA256, nil
+	}
+
+	return FormatSHA1, fmt.Errorf("git: unknown object format %q", s)
 }
 
-//ParseSHA1 expects a string with a hex encoded sha1.
-//It will trim the string of newline and space before
-//parsing.
-func ParseSHA1(input string) (sha SHA1, err error) {
+func (f ObjectFormat) String() string {
+	if f == FormatSHA256 {
+		return "sha256"
+	}
+	return "sha1"
+}
+
+//Size returns the size of object ids in bytes.
+func (f ObjectFormat) Size() int {
+	if f == FormatSHA256 {
+		return sha256.Size
+	}
+	return sha1.Size
+}
+
+//New returns a new hash for the object format.
+func (f ObjectFormat) New() hash.Hash {
+	if f == FormatSHA256 {
+		return sha256.New()
+	}
+	return sha1.New()
+}
+
+//ID returns the object id for the (binary) checksum, which must
+//be of the size of the object format.
+func (f ObjectFormat) ID(sum []byte) ObjectID {
+	id := ObjectID{format: f}
+	copy(id.hash[:f.Size()], sum)
+	return id
+}
+
+//ObjectID is the id of an object, i.e. the checksum of the object
+//data, which is a SHA-1 or SHA-256 hash, depending on the object
+//format of the repository. The zero value is the null SHA-1 id.
+type ObjectID struct {
+	hash   [sha256.Size]byte
+	format ObjectFormat
+}
+
+//SHA1 is the object identifying checksum of the object data.
+//It is an alias for ObjectID, which can be a SHA-256 hash.
+type SHA1 = ObjectID
+
+//Format returns the object format, i.e. the hash function, of the id.
+func (oid ObjectID) Format() ObjectFormat {
+	return oid.format
+}
+
+//Bytes returns the binary checksum.
+func (oid ObjectID) Bytes() []byte {
+	return oid.hash[:oid.format.Size()]
+}
+
+//IsZero checks if the id is the null id (of any format).
+func (oid ObjectID) IsZero() bool {
+	return oid.hash == [sha256.Size]byte{}
+}
+
+func (oid ObjectID) String() string {
+	return hex.EncodeToString(oid.Bytes())
+}
+
+//compare compares the ids bytewise, like bytes.Compare.
+func (oid ObjectID) compare(other ObjectID) int {
+	return bytes.Compare(oid.hash[:], other.hash[:])
+}
+
+//ParseObjectID parses a hex encoded SHA-1 or SHA-256 id, the format
+//is determined by the length. Leading and trailing newlines and spaces
+//are ignored.
+func ParseObjectID(input string) (ObjectID, error) {
 	data, err := hex.DecodeString(strings.Trim(input, " \n"))
 	if err != nil {
-		return
-	} else if len(data) != 20 {
-		err = fmt.Errorf("git: sha1 must be 20 bytes")
-		return
+		return ObjectID{}, err
 	}
 
-	copy(sha[:], data)
-	return
+	switch len(data) {
+	case sha1.Size:
+		return FormatSHA1.ID(data), nil
+	case sha256.Size:
+		return FormatSHA256.ID(data), nil
+	}
+
+	return ObjectID{}, fmt.Errorf("git: object id must be 20 (sha1) or 32 (sha256) bytes")
+}
+
+//ParseSHA1 expects a string with a hex encoded object id,
+//see ParseObjectID, which it is the same as.
+func ParseSHA1(input string) (sha SHA1, err error) {
+	return ParseObjectID(input)
 }
 
 //Signature is a combination of who (Name, Email) and when (Date, Offset).
@@ -44,7 +141,36 @@ type Signature struct {
 }
 
 func (s Signature) String() string {
-	return fmt.Sprintf("%s <%s> %d %s", s.Name, s.Email, s.Date.Unix(), s.Offset)
+	return fmt.Sprintf("%s <%s> %d %s", s.Name, s.Email, s.Date.Unix(), s.tzOffset())
+}
+
+//tzOffset returns the time zone offset in the "+hhmm" format. If
+//Offset was created by parseSignature its name already is in that
+//format, otherwise the offset is computed from the location.
+func (s Signature) tzOffset() string {
+	loc := s.Offset
+	if loc == nil {
+		loc = s.Date.Location()
+	}
+
+	name, off := s.Date.In(loc).Zone()
+	if len(name) == 5 && (name[0] == '+' || name[0] == '-') {
+		return name
+	}
+
+	sign := '+'
+	if off < 0 {
+		sign = '-'
+		off = -off
+	}
+
+	return fmt.Sprintf("%c%02d%02d", sign, off/3600, (off/60)%60)
+}
+
+//NewSignature returns a Signature for name and email, with the
+//given date; the location of the date is used as the Offset.
+func NewSignature(name, email string, date time.Time) Signature {
+	return Signature{Name: name, Email: email, Date: date, Offset: date.Location()}
 }
 
 //ObjectType is to the git object type
@@ -131,6 +257,10 @@ type gitObject struct {
 	size  int64
 
 	source io.ReadCloser
+
+	//format is the object format of the ids in the
+	//object, e.g. the ones of the entries of trees
+	format ObjectFormat
 }
 
 func (o *gitObject) Type() ObjectType {
@@ -173,6 +303,15 @@ type Tree struct {
 	err   error
 }
 
+//The file modes of tree entries, as used by git.
+const (
+	ModeTree    = os.FileMode(040000)
+	ModeBlob    = os.FileMode(0100644)
+	ModeExec    = os.FileMode(0100755)
+	ModeSymlink = os.FileMode(0120000)
+	ModeGitlink = os.FileMode(0160000)
+)
+
 //TreeEntry holds information about a single
 //entry in the git Tree object.
 type TreeEntry struct {
@@ -188,7 +327,7 @@ type TreeEntry struct {
 //if there was an error while advacing. Use Err()
 //to resolve between the to conditions.
 func (tree *Tree) Next() bool {
-	tree.entry, tree.err = parseTreeEntry(tree.source)
+	tree.entry, tree.err = parseTreeEntry(tree.source, tree.format)
 	return tree.err == nil
 }
 
@@ -216,6 +355,17 @@ func (b *Blob) Read(data []byte) (n int, err error) {
 	return
 }
 
+//NewBlob returns a new Blob object with the given size, whose
+//data is read from r.
+func NewBlob(r io.Reader, size int64) *Blob {
+	rc, ok := r.(io.ReadCloser)
+	if !ok {
+		rc = ioutil.NopCloser(r)
+	}
+
+	return &Blob{gitObject{otype: ObjBlob, size: size, source: rc}}
+}
+
 func MakeAnnexBlob(fp *os.File, size int64) *Blob {
 	return &Blob{gitObject{otype: ObjBlob, size: size, source: fp}}
 }
@@ -0,0 +1,161 @@
+package gig
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseObjectID(t *testing.T) {
+	for _, s := range []string{
+		"e69de29bb2d1d6434b8b29ae775ad8c2e48c5391",
+		"473a0f4c3be8a93681a267e3b1e9a7dcda1185436fe141f7749120a303721813",
+	} {
+		id, err := ParseObjectID(s)
+		if err != nil {
+			t.Fatalf("could not parse %s: %v", s, err)
+		}
+
+		if id.String() != s || len(id.Bytes())*2 != len(s) || id.IsZero() {
+			t.Fatalf("unexpected id %s for %s", id, s)
+		}
+	}
+
+	zero, err := ParseObjectID(strings.Repeat("0", 64))
+	if err != nil || !zero.IsZero() || zero.Format() != FormatSHA256 {
+		t.Fatalf("unexpected zero id %s: %v", zero, err)
+	}
+
+	for _, s := range []string{"", "e69de29b", strings.Repeat("a", 41), strings.Repeat("x", 40)} {
+		if _, err := ParseObjectID(s); err == nil {
+			t.Fatalf("expected error for %q", s)
+		}
+	}
+}
+
+func TestObjectFormatSHA256(t *testing.T) {
+	repo, wt := mkTestRepo(t, "--object-format=sha256")
+	mkLogHistory(t, wt)
+
+	if repo.ObjectFormat() != FormatSHA256 {
+		t.Fatalf("expected sha256 repository, got %s", repo.ObjectFormat())
+	}
+
+	gitCmd(t, repo.Path, "tag", "-a", "-m", "annotated", "v1", "master")
+	revs := []string{"master", "v1", "v1^{}", "v1~2", "v1^^2", "v1:data/a.txt", "v1^{tree}"}
+	check := func() {
+		t.Helper()
+
+		ids := listObjects(t, repo)
+		checkOpenAll(t, repo, ids)
+
+		for _, rev := range revs {
+			id, err := repo.ResolveRevision(rev)
+			if err != nil {
+				t.Fatalf("%s: could not resolve revision: %v", rev, err)
+			}
+
+			if expected := revParse(t, repo, rev); id != expected {
+				t.Fatalf("%s: expected %s, got %s", rev, expected, id)
+			}
+		}
+
+		head := revParse(t, repo, "master")
+		it := repo.ReachableObjects([]SHA1{head}, nil)
+		n := 0
+		for it.Next() {
+			n++
+		}
+
+		if err := it.Err(); err != nil {
+			t.Fatalf("could not list reachable objects: %v", err)
+		}
+
+		out := gitCmd(t, repo.Path, "rev-list", "--objects", "master")
+		if expected := len(strings.Split(out, "\n")); n != expected {
+			t.Fatalf("expected %d reachable objects, got %d", expected, n)
+		}
+
+		report, err := repo.Verify()
+		if err != nil || !report.OK() {
+			t.Fatalf("unexpected verify report (%v):\n%s", err, report)
+		}
+	}
+
+	check()
+
+	//packed, with a commit-graph and a multi-pack-index
+	gitCmd(t, repo.Path, "repack", "-q", "-a", "-d")
+	gitCmd(t, repo.Path, "commit-graph", "write", "--reachable")
+	gitCmd(t, repo.Path, "multi-pack-index", "write")
+	repo, err := OpenRepository(repo.Path)
+	if err != nil {
+		t.Fatalf("could not open repository: %v", err)
+	}
+	check()
+
+	//new objects, loose and packed
+	blob := writeBlob(t, repo, "sha256 content\n")
+	tb := NewTreeBuilder(repo)
+	if err = tb.Load(revParse(t, repo, "master^{tree}")); err != nil {
+		t.Fatalf("could not load tree: %v", err)
+	}
+
+	if err = tb.Add("new/file.txt", ModeBlob, blob); err != nil {
+		t.Fatalf("could not add file: %v", err)
+	}
+
+	tree, err := tb.Write()
+	if err != nil {
+		t.Fatalf("could not write tree: %v", err)
+	}
+
+	head := revParse(t, repo, "master")
+	who := NewSignature("GIN", "gin@example.com", time.Unix(1600000000, 0))
+	cid, err := repo.CreateCommit(tree, []SHA1{head}, who, who, "Add file\n")
+	if err != nil {
+		t.Fatalf("could not create commit: %v", err)
+	}
+
+	if err = repo.UpdateRef("refs/heads/master", cid, &head, who, "commit: Add file"); err != nil {
+		t.Fatalf("could not update master: %v", err)
+	}
+
+	if cid != revParse(t, repo, "master") || blob != revParse(t, repo, "master:new/file.txt") {
+		t.Fatalf("unexpected ids for new objects")
+	}
+
+	var objs []Object
+	for _, id := range []SHA1{blob, tree, cid} {
+		obj, err := repo.OpenObject(id)
+		if err != nil {
+			t.Fatalf("could not open object: %v", err)
+		}
+		objs = append(objs, obj)
+	}
+
+	sum, err := repo.WritePack(objs, 10)
+	if err != nil {
+		t.Fatalf("could not write pack: %v", err)
+	}
+
+	gitCmd(t, repo.Path, "verify-pack", filepath.Join(repo.Path, "objects", "pack", "pack-"+sum.String()+".idx"))
+	gitCmd(t, repo.Path, "fsck", "--strict", "--no-dangling")
+	check()
+
+	//the packs that git sends are indexed with sha256 ids
+	dst := filepath.Join(t.TempDir(), "dst.git")
+	gitCmd(t, repo.Path, "init", "-q", "--bare", "--object-format=sha256", dst)
+	other, err := OpenRepository(dst)
+	if err != nil {
+		t.Fatalf("could not open repository: %v", err)
+	}
+
+	pack := packObjects(t, repo, "master\n")
+	if _, err = other.IndexPack(bytes.NewReader(pack)); err != nil {
+		t.Fatalf("could not index pack: %v", err)
+	}
+	checkOpenAll(t, other, listObjects(t, other))
+}
@@ -0,0 +1,737 @@
+package gig
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha1" //registers the hash functions used
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Resources:
+//  https://www.rfc-editor.org/rfc/rfc4880 (OpenPGP Message Format)
+//  https://www.rfc-editor.org/rfc/rfc6637 (ECC in OpenPGP)
+
+//OpenPGP packet tags
+const (
+	pgpTagSignature = 2
+	pgpTagPublicKey = 6
+	pgpTagUserID    = 13
+	pgpTagSubkey    = 14
+)
+
+//OpenPGP public key algorithms
+const (
+	pgpRSA        = 1
+	pgpRSAEncrypt = 2
+	pgpRSASign    = 3
+	pgpECDSA      = 19
+	pgpEdDSA      = 22
+)
+
+//OpenPGP signature types
+const (
+	pgpSigBinary           = 0x00
+	pgpSigCertGeneric      = 0x10 //up to 0x13, certifications of a user id
+	pgpSigCertPositive     = 0x13
+	pgpSigSubkeyBinding    = 0x18
+	pgpSigDirectKey        = 0x1f
+	pgpSigKeyRevocation    = 0x20
+	pgpSigSubkeyRevocation = 0x28
+)
+
+//OpenPGP signature subpacket types
+const (
+	pgpSubCreated           = 2
+	pgpSubExpires           = 3
+	pgpSubKeyExpires        = 9
+	pgpSubIssuer            = 16
+	pgpSubIssuerFingerprint = 33
+)
+
+//PublicKey is an OpenPGP (v4) public key or subkey.
+type PublicKey struct {
+	//Fingerprint is the upper-case hex encoded fingerprint and
+	//KeyID its last 16 digits.
+	Fingerprint string
+	KeyID       string
+	Created     time.Time
+
+	//UserIDs are the user ids of a primary key, e.g.
+	//"A U Thor <author@example.com>".
+	UserIDs []string
+
+	//Primary is the primary key of a subkey, nil for primary keys.
+	Primary *PublicKey
+
+	//Expires is the time the key expires, as set by its latest
+	//self-signature; zero if it does not expire. Revoked is set if
+	//the key ring contains a revocation of the key by the primary key.
+	Expires time.Time
+	Revoked bool
+
+	algo   byte
+	key    crypto.PublicKey
+	packet []byte //the body of the key packet
+
+	//selfSigned is the creation time of the latest self-signature
+	selfSigned time.Time
+}
+
+//KeyRing is a set of trusted public keys, against which signatures
+//are verified. Subkeys are trusted if they are part of the key ring;
+//their binding signatures are not checked. The expiration and the
+//revocation of keys are taken from the signatures made by the primary
+//key (see PublicKey.Expires), signatures by other keys are ignored.
+type KeyRing struct {
+	Keys []*PublicKey
+}
+
+//ReadKeyRing reads public keys, binary or ASCII armored, as exported
+//via "gpg --export [--armor]". Keys of versions other than 4 are
+//skipped; keys with unsupported algorithms are included, but cannot
+//be used to verify signatures.
+func ReadKeyRing(r io.Reader) (*KeyRing, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Contains(data, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----")) {
+		data, err = decodeArmor(data, "PGP PUBLIC KEY BLOCK")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	kr := &KeyRing{}
+	var primary, current *PublicKey
+	var uid []byte
+	for len(data) > 0 {
+		tag, body, rest, err := nextPacket(data)
+		if err != nil {
+			return nil, err
+		}
+		data = rest
+
+		switch tag {
+		case pgpTagPublicKey, pgpTagSubkey:
+			current, uid = nil, nil
+			key, err := parsePublicKey(body)
+			if err != nil {
+				return nil, err
+			} else if key == nil {
+				if tag == pgpTagPublicKey {
+					primary = nil
+				}
+				continue
+			}
+
+			if tag == pgpTagPublicKey {
+				primary = key
+			} else if key.Primary = primary; primary == nil {
+				continue
+			}
+			current = key
+			kr.Keys = append(kr.Keys, key)
+
+		case pgpTagUserID:
+			if primary != nil {
+				primary.UserIDs = append(primary.UserIDs, string(body))
+			}
+			uid = body
+
+		case pgpTagSignature:
+			if current != nil {
+				current.applySelfSignature(body, uid)
+			}
+		}
+	}
+
+	return kr, nil
+}
+
+//KeyByID returns the key (or subkey) with the given key id or
+//fingerprint (hex encoded, case-insensitive), or nil if it is not
+//part of the key ring.
+func (kr *KeyRing) KeyByID(id string) *PublicKey {
+	id = strings.ToUpper(id)
+	for _, key := range kr.Keys {
+		if key.KeyID == id || key.Fingerprint == id {
+			return key
+		}
+	}
+	return nil
+}
+
+//PGPSignature is a verified OpenPGP signature.
+type PGPSignature struct {
+	//Key is the key that made the signature, which can be a subkey.
+	Key     *PublicKey
+	Created time.Time
+}
+
+//Verify checks the detached, ASCII armored signature of data against
+//the keys in the key ring. The error wraps ErrUnknownSigningKey if the
+//key is not in the key ring, and ErrBadSignature if the signature does
+//not match the data. Matching signatures fail with ErrExpiredSignature,
+//ErrExpiredKey or ErrRevokedKey if the signature or the key (or its
+//primary key) is no longer valid.
+func (kr *KeyRing) Verify(data []byte, signature string) (*PGPSignature, error) {
+	raw, err := decodeArmor([]byte(signature), "PGP SIGNATURE")
+	if err != nil {
+		return nil, err
+	}
+
+	tag, body, _, err := nextPacket(raw)
+	if err != nil {
+		return nil, err
+	} else if tag != pgpTagSignature {
+		return nil, fmt.Errorf("git: expected signature packet, found packet type %d", tag)
+	}
+
+	sig, err := parsePGPSignature(body)
+	if err != nil {
+		return nil, err
+	} else if sig.sigType != pgpSigBinary {
+		return nil, fmt.Errorf("git: unsupported signature type %#x", sig.sigType)
+	}
+
+	key := kr.KeyByID(sig.issuer)
+	if key == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSigningKey, sig.issuer)
+	} else if key.algo != sig.algo {
+		return nil, fmt.Errorf("%w: made with algorithm %d, key %s has algorithm %d", ErrBadSignature, sig.algo, key.KeyID, key.algo)
+	} else if key.algo == pgpRSAEncrypt {
+		return nil, fmt.Errorf("%w: key %s is not a signing key", ErrBadSignature, key.KeyID)
+	}
+
+	if !key.verify(sig, sig.digest(data)) {
+		return nil, fmt.Errorf("%w: by key %s", ErrBadSignature, key.KeyID)
+	}
+
+	now := time.Now()
+	if sig.lifetime != 0 && now.After(sig.created.Add(sig.lifetime)) {
+		return nil, fmt.Errorf("%w: by key %s, at %s", ErrExpiredSignature, key.KeyID, sig.created.Add(sig.lifetime))
+	}
+
+	for k := key; k != nil; k = k.Primary {
+		if k.Revoked {
+			return nil, fmt.Errorf("%w: %s", ErrRevokedKey, k.KeyID)
+		} else if !k.Expires.IsZero() && now.After(k.Expires) {
+			return nil, fmt.Errorf("%w: %s, at %s", ErrExpiredKey, k.KeyID, k.Expires)
+		}
+	}
+
+	return &PGPSignature{Key: key, Created: sig.created}, nil
+}
+
+//applySelfSignature applies a signature that follows the packet of
+//the key, or the user id uid of a primary key, in a key ring if it
+//is made by the primary key: revocations, and the expiration time
+//of the latest certification or binding signature. Other signatures,
+//and the ones that cannot be parsed or verified, are ignored.
+func (key *PublicKey) applySelfSignature(body, uid []byte) {
+	sig, err := parsePGPSignature(body)
+	if err != nil {
+		return
+	}
+
+	primary := key
+	if key.Primary != nil {
+		primary = key.Primary
+	}
+
+	if sig.issuer != primary.KeyID && sig.issuer != primary.Fingerprint {
+		return
+	}
+
+	//the signed data are the primary key and what the signature is about
+	signed := [][]byte{keyPacketHeader(primary.packet), primary.packet}
+	switch t := sig.sigType; {
+	case key.Primary == nil && (t == pgpSigDirectKey || t == pgpSigKeyRevocation):
+	case key.Primary == nil && t >= pgpSigCertGeneric && t <= pgpSigCertPositive && uid != nil:
+		header := []byte{0xb4, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(header[1:], uint32(len(uid)))
+		signed = append(signed, header, uid)
+	case key.Primary != nil && (t == pgpSigSubkeyBinding || t == pgpSigSubkeyRevocation):
+		signed = append(signed, keyPacketHeader(key.packet), key.packet)
+	default:
+		return
+	}
+
+	if sig.algo != primary.algo || !primary.verify(sig, sig.digest(signed...)) {
+		return
+	}
+
+	switch {
+	case sig.sigType == pgpSigKeyRevocation || sig.sigType == pgpSigSubkeyRevocation:
+		key.Revoked = true
+	case !sig.created.Before(key.selfSigned):
+		key.selfSigned = sig.created
+		key.Expires = time.Time{}
+		if sig.keyLifetime != 0 {
+			key.Expires = key.Created.Add(sig.keyLifetime)
+		}
+	}
+}
+
+//keyPacketHeader returns the header with which key packets are
+//hashed, for fingerprints and signatures.
+func keyPacketHeader(body []byte) []byte {
+	return []byte{0x99, byte(len(body) >> 8), byte(len(body))}
+}
+
+//verify checks the signature values against the digest.
+func (key *PublicKey) verify(sig *pgpSignature, digest []byte) bool {
+	//RSA keys with the encrypt-only algorithm must not sign
+	if key.algo == pgpRSAEncrypt || !bytes.Equal(digest[:2], sig.left16) {
+		return false
+	}
+
+	switch pub := key.key.(type) {
+	case *rsa.PublicKey:
+		if len(sig.mpis) != 1 || len(sig.mpis[0]) > pub.Size() {
+			return false
+		}
+		s := leftPad(sig.mpis[0], pub.Size())
+		return rsa.VerifyPKCS1v15(pub, sig.hash, digest, s) == nil
+
+	case *ecdsa.PublicKey:
+		if len(sig.mpis) != 2 {
+			return false
+		}
+		r, s := new(big.Int).SetBytes(sig.mpis[0]), new(big.Int).SetBytes(sig.mpis[1])
+		return ecdsa.Verify(pub, digest, r, s)
+
+	case ed25519.PublicKey:
+		if len(sig.mpis) != 2 || len(sig.mpis[0]) > 32 || len(sig.mpis[1]) > 32 {
+			return false
+		}
+		value := append(leftPad(sig.mpis[0], 32), leftPad(sig.mpis[1], 32)...)
+		return ed25519.Verify(pub, digest, value)
+	}
+
+	return false
+}
+
+func leftPad(b []byte, n int) []byte {
+	if len(b) >= n {
+		return b
+	}
+	return append(make([]byte, n-len(b)), b...)
+}
+
+//curves maps the OIDs of the supported ECDSA curves
+//to their implementation.
+var curves = map[string]elliptic.Curve{
+	"2a8648ce3d030107": elliptic.P256(),
+	"2b81040022":       elliptic.P384(),
+	"2b81040023":       elliptic.P521(),
+}
+
+//oidEd25519 is the OID of the Ed25519 curve for EdDSA keys.
+const oidEd25519 = "2b06010401da470f01"
+
+//parsePublicKey parses the body of a public key or subkey packet.
+//Returns nil (and no error) for keys of unsupported versions.
+func parsePublicKey(body []byte) (*PublicKey, error) {
+	if len(body) < 6 {
+		return nil, fmt.Errorf("git: public key packet is truncated")
+	} else if body[0] != 4 {
+		return nil, nil
+	}
+
+	fp := crypto.SHA1.New()
+	fp.Write(keyPacketHeader(body))
+	fp.Write(body)
+	fingerprint := strings.ToUpper(hex.EncodeToString(fp.Sum(nil)))
+
+	key := &PublicKey{
+		Fingerprint: fingerprint,
+		KeyID:       fingerprint[24:],
+		Created:     time.Unix(int64(binary.BigEndian.Uint32(body[1:5])), 0),
+		algo:        body[5],
+		packet:      body,
+	}
+
+	r := bytes.NewReader(body[6:])
+	var err error
+	switch key.algo {
+	case pgpRSA, pgpRSAEncrypt, pgpRSASign:
+		var n, e []byte
+		if n, err = readMPI(r); err == nil {
+			e, err = readMPI(r)
+		}
+
+		exp := new(big.Int).SetBytes(e)
+		if err == nil && (len(e) == 0 || !exp.IsInt64() || exp.Int64() > 1<<31-1) {
+			err = fmt.Errorf("invalid RSA exponent")
+		} else if err == nil {
+			key.key = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}
+		}
+
+	case pgpECDSA, pgpEdDSA:
+		var oid, point []byte
+		if oid, err = readOID(r); err == nil {
+			point, err = readMPI(r)
+		}
+
+		if err != nil {
+			break
+		}
+
+		curve, ok := curves[hex.EncodeToString(oid)]
+		switch {
+		case key.algo == pgpECDSA && ok:
+			x, y := elliptic.Unmarshal(curve, point)
+			if x == nil {
+				return nil, fmt.Errorf("git: invalid ECDSA public key %s", key.KeyID)
+			}
+			key.key = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		case key.algo == pgpEdDSA && hex.EncodeToString(oid) == oidEd25519:
+			if len(point) != 33 || point[0] != 0x40 {
+				return nil, fmt.Errorf("git: invalid EdDSA public key %s", key.KeyID)
+			}
+			key.key = ed25519.PublicKey(point[1:])
+		}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("git: invalid public key packet: %v", err)
+	}
+
+	return key, nil
+}
+
+//pgpSignature is a parsed (v4) signature packet.
+type pgpSignature struct {
+	sigType byte
+	algo    byte
+	hash    crypto.Hash
+	hashed  []byte //the hashed part of the packet
+	left16  []byte
+	mpis    [][]byte
+	issuer  string //fingerprint or key id
+	created time.Time
+
+	//lifetime is the validity period of the signature, keyLifetime
+	//the one of the key (in self-signatures); zero if unlimited
+	lifetime    time.Duration
+	keyLifetime time.Duration
+}
+
+//pgpHashes are the supported hash algorithms.
+var pgpHashes = map[byte]crypto.Hash{
+	2:  crypto.SHA1,
+	8:  crypto.SHA256,
+	9:  crypto.SHA384,
+	10: crypto.SHA512,
+	11: crypto.SHA224,
+}
+
+func parsePGPSignature(body []byte) (*pgpSignature, error) {
+	if len(body) < 6 {
+		return nil, fmt.Errorf("git: signature packet is truncated")
+	} else if body[0] != 4 {
+		return nil, fmt.Errorf("git: unsupported signature version %d", body[0])
+	}
+
+	sig := &pgpSignature{sigType: body[1], algo: body[2]}
+
+	var ok bool
+	if sig.hash, ok = pgpHashes[body[3]]; !ok || !sig.hash.Available() {
+		return nil, fmt.Errorf("git: unsupported signature hash algorithm %d", body[3])
+	}
+
+	n := int(binary.BigEndian.Uint16(body[4:6]))
+	if len(body) < 6+n+2 {
+		return nil, fmt.Errorf("git: signature packet is truncated")
+	}
+	sig.hashed = body[:6+n]
+	hashedSub := body[6 : 6+n]
+
+	rest := body[6+n:]
+	m := int(binary.BigEndian.Uint16(rest[:2]))
+	if len(rest) < 2+m+2 {
+		return nil, fmt.Errorf("git: signature packet is truncated")
+	}
+	unhashedSub := rest[2 : 2+m]
+	sig.left16 = rest[2+m : 4+m]
+
+	//the issuer fingerprint is preferred over the key id,
+	//and hashed subpackets over unhashed ones; the times
+	//are only taken from hashed ones
+	var keyID, fingerprint string
+	for i, subs := range [][]byte{unhashedSub, hashedSub} {
+		hashed := i == 1
+		err := forEachSubpacket(subs, func(typ byte, critical bool, data []byte) error {
+			switch {
+			case typ == pgpSubCreated && len(data) == 4 && hashed:
+				sig.created = time.Unix(int64(binary.BigEndian.Uint32(data)), 0)
+			case typ == pgpSubExpires && len(data) == 4 && hashed:
+				sig.lifetime = time.Duration(binary.BigEndian.Uint32(data)) * time.Second
+			case typ == pgpSubKeyExpires && len(data) == 4 && hashed:
+				sig.keyLifetime = time.Duration(binary.BigEndian.Uint32(data)) * time.Second
+			case typ == pgpSubIssuer && len(data) == 8:
+				keyID = strings.ToUpper(hex.EncodeToString(data))
+			case typ == pgpSubIssuerFingerprint && len(data) == 21 && data[0] == 4:
+				fingerprint = strings.ToUpper(hex.EncodeToString(data[1:]))
+			case critical:
+				//the signature must not be used if a subpacket
+				//marked as critical is not understood
+				return fmt.Errorf("git: unsupported critical signature subpacket %d", typ)
+			}
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if sig.issuer = fingerprint; sig.issuer == "" {
+		sig.issuer = keyID
+	}
+
+	if sig.issuer == "" {
+		return nil, fmt.Errorf("git: signature has no issuer")
+	}
+
+	r := bytes.NewReader(rest[4+m:])
+	for r.Len() > 0 {
+		mpi, err := readMPI(r)
+		if err != nil {
+			return nil, fmt.Errorf("git: invalid signature packet: %v", err)
+		}
+		sig.mpis = append(sig.mpis, mpi)
+	}
+
+	return sig, nil
+}
+
+//digest hashes the signed data, which is given in parts, and the
+//hashed part of the signature packet with its trailer.
+func (sig *pgpSignature) digest(parts ...[]byte) []byte {
+	h := sig.hash.New()
+	for _, p := range parts {
+		h.Write(p)
+	}
+
+	h.Write(sig.hashed)
+	trailer := []byte{4, 0xff, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(trailer[2:], uint32(len(sig.hashed)))
+	h.Write(trailer)
+	return h.Sum(nil)
+}
+
+//forEachSubpacket calls fn for each signature subpacket, with the
+//critical bit split off the type; errors of fn are returned.
+func forEachSubpacket(data []byte, fn func(typ byte, critical bool, data []byte) error) error {
+	for len(data) > 0 {
+		n, hdr := 0, 0
+		switch o := int(data[0]); {
+		case o < 192:
+			n, hdr = o, 1
+		case o < 255 && len(data) > 1:
+			n, hdr = (o-192)<<8+int(data[1])+192, 2
+		case o == 255 && len(data) > 4:
+			n, hdr = int(binary.BigEndian.Uint32(data[1:5])), 5
+		default:
+			return fmt.Errorf("git: invalid signature subpacket")
+		}
+
+		if n < 1 || len(data) < hdr+n {
+			return fmt.Errorf("git: invalid signature subpacket")
+		}
+
+		if err := fn(data[hdr]&0x7f, data[hdr]&0x80 != 0, data[hdr+1:hdr+n]); err != nil {
+			return err
+		}
+		data = data[hdr+n:]
+	}
+
+	return nil
+}
+
+//nextPacket splits off the first OpenPGP packet of data and
+//returns its tag and body. Partial body lengths are supported.
+func nextPacket(data []byte) (tag byte, body, rest []byte, err error) {
+	if len(data) < 2 || data[0]&0x80 == 0 {
+		return 0, nil, nil, fmt.Errorf("git: invalid OpenPGP packet header")
+	}
+
+	truncated := fmt.Errorf("git: OpenPGP packet is truncated")
+
+	if data[0]&0x40 == 0 {
+		//old format packet
+		tag = (data[0] >> 2) & 0xf
+		var n, hdr int
+		switch data[0] & 3 {
+		case 0:
+			n, hdr = int(data[1]), 2
+		case 1:
+			if len(data) < 3 {
+				return 0, nil, nil, truncated
+			}
+			n, hdr = int(binary.BigEndian.Uint16(data[1:3])), 3
+		case 2:
+			if len(data) < 5 {
+				return 0, nil, nil, truncated
+			}
+			n, hdr = int(binary.BigEndian.Uint32(data[1:5])), 5
+		case 3:
+			n, hdr = len(data)-1, 1
+		}
+
+		if n < 0 || len(data) < hdr+n {
+			return 0, nil, nil, truncated
+		}
+		return tag, data[hdr : hdr+n], data[hdr+n:], nil
+	}
+
+	tag = data[0] & 0x3f
+	data = data[1:]
+	for {
+		if len(data) < 1 {
+			return 0, nil, nil, truncated
+		}
+
+		var n, hdr int
+		partial := false
+		switch o := int(data[0]); {
+		case o < 192:
+			n, hdr = o, 1
+		case o < 224 && len(data) > 1:
+			n, hdr = (o-192)<<8+int(data[1])+192, 2
+		case o == 255 && len(data) > 4:
+			n, hdr = int(binary.BigEndian.Uint32(data[1:5])), 5
+		case o >= 224 && o < 255:
+			n, hdr, partial = 1<<uint(o&0x1f), 1, true
+		default:
+			return 0, nil, nil, truncated
+		}
+
+		if n < 0 || len(data) < hdr+n {
+			return 0, nil, nil, truncated
+		}
+
+		body = append(body, data[hdr:hdr+n]...)
+		data = data[hdr+n:]
+		if !partial {
+			return tag, body, data, nil
+		}
+	}
+}
+
+//readMPI reads a multiprecision integer, i.e. the length in
+//bits followed by the big-endian value.
+func readMPI(r *bytes.Reader) ([]byte, error) {
+	var bits uint16
+	if err := binary.Read(r, binary.BigEndian, &bits); err != nil {
+		return nil, err
+	}
+
+	value := make([]byte, (int(bits)+7)/8)
+	_, err := io.ReadFull(r, value)
+	return value, err
+}
+
+//readOID reads a length-prefixed curve OID.
+func readOID(r *bytes.Reader) ([]byte, error) {
+	n, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	} else if n == 0 || n == 0xff {
+		return nil, fmt.Errorf("invalid curve OID")
+	}
+
+	oid := make([]byte, n)
+	_, err = io.ReadFull(r, oid)
+	return oid, err
+}
+
+//decodeArmor decodes the first ASCII armored block of the given
+//type, e.g. "PGP SIGNATURE", and checks its CRC-24 checksum.
+func decodeArmor(data []byte, blockType string) ([]byte, error) {
+	begin := "-----BEGIN " + blockType + "-----"
+	end := "-----END " + blockType + "-----"
+
+	var b64 strings.Builder
+	var checksum string
+	inBlock, inHeaders := false, false
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case !inBlock:
+			inBlock, inHeaders = line == begin, true
+		case line == end:
+			return decodeArmorData(b64.String(), checksum, blockType)
+		case inHeaders:
+			//armor headers, like "Version: ...", end with an empty line;
+			//the empty line is missing if there are no headers.
+			if line == "" || !strings.Contains(line, ": ") {
+				inHeaders = false
+				b64.WriteString(line)
+			}
+		case strings.HasPrefix(line, "="):
+			checksum = line[1:]
+		default:
+			b64.WriteString(line)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, fmt.Errorf("git: no %s block found", blockType)
+}
+
+func decodeArmorData(b64, checksum, blockType string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return nil, fmt.Errorf("git: invalid %s block: %v", blockType, err)
+	}
+
+	if checksum != "" {
+		sum, err := base64.StdEncoding.DecodeString(checksum)
+		crc := crc24(data)
+		if err != nil || len(sum) != 3 || !bytes.Equal(sum, []byte{byte(crc >> 16), byte(crc >> 8), byte(crc)}) {
+			return nil, fmt.Errorf("git: checksum mismatch in %s block", blockType)
+		}
+	}
+
+	return data, nil
+}
+
+//crc24 computes the checksum of ASCII armored data.
+func crc24(data []byte) uint32 {
+	crc := uint32(0xb704ce)
+	for _, b := range data {
+		crc ^= uint32(b) << 16
+		for i := 0; i < 8; i++ {
+			crc <<= 1
+			if crc&0x1000000 != 0 {
+				crc ^= 0x1864cfb
+			}
+		}
+	}
+	return crc & 0xffffff
+}
@@ -3,9 +3,11 @@ package gig
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 )
 
 // Resources:
@@ -46,6 +48,9 @@ type PackIndex struct {
 	Version uint32
 	FO      FanOut
 
+	//Format is the object format of the ids in the index.
+	Format ObjectFormat
+
 	shaBase int64
 }
 
@@ -57,11 +62,61 @@ type PackFile struct {
 
 	Version  uint32
 	ObjCount uint32
+
+	//Format is the object format of the repository,
+	//needed to read the base ids of ref-delta objects.
+	Format ObjectFormat
+
+	//readers is the number of open objects read from the
+	//pack file, which is closed once they are all closed
+	//if it is retired (see retire).
+	mu      sync.Mutex
+	readers int
+	retired bool
+}
+
+//acquire registers a reader of the pack file.
+func (pf *PackFile) acquire() {
+	pf.mu.Lock()
+	pf.readers++
+	pf.mu.Unlock()
+}
+
+//release unregisters a reader and closes the pack
+//file if it was the last one of a retired pack file.
+func (pf *PackFile) release() {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+
+	pf.readers--
+	if pf.retired && pf.readers == 0 {
+		pf.File.Close()
+	}
+}
+
+//retire closes the pack file as soon as there are no
+//more readers, i.e. right away if there are none.
+func (pf *PackFile) retire() {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+
+	pf.retired = true
+	if pf.readers == 0 {
+		pf.File.Close()
+	}
 }
 
 //PackIndexOpen opens the git pack file with the given
 //path. The ".idx" if missing will be appended.
+//The index is assumed to contain SHA-1 ids, see
+//Repository.ObjectFormat for other object formats.
 func PackIndexOpen(path string) (*PackIndex, error) {
+	return openPackIndex(path, FormatSHA1)
+}
+
+//openPackIndex opens the pack index at path, which
+//contains ids of the given object format.
+func openPackIndex(path string, format ObjectFormat) (*PackIndex, error) {
 
 	if !strings.HasSuffix(path, ".idx") {
 		path += ".idx"
@@ -73,7 +128,7 @@ func PackIndexOpen(path string) (*PackIndex, error) {
 		return nil, fmt.Errorf("git: could not read pack index: %v", err)
 	}
 
-	idx := &PackIndex{File: fd, Version: 1}
+	idx := &PackIndex{File: fd, Version: 1, Format: format}
 
 	var peek [4]byte
 	err = binary.Read(fd, binary.BigEndian, &peek)
@@ -108,50 +163,108 @@ func PackIndexOpen(path string) (*PackIndex, error) {
 	return idx, nil
 }
 
+//Count returns the number of objects in the index.
+func (pi *PackIndex) Count() int {
+	return int(pi.FO[255])
+}
+
 //ReadSHA1 reads the SHA1 stared at position pos (in the FanOut table).
 func (pi *PackIndex) ReadSHA1(chksum *SHA1, pos int) error {
-	if version := pi.Version; version != 2 {
-		return fmt.Errorf("git: v%d version support incomplete", version)
+	var start int64
+	hs := int64(pi.Format.Size())
+
+	switch pi.Version {
+	case 1:
+		//FanOut[256*4] + n * (offset[4] + id[20|32]) + offset[4]
+		start = pi.shaBase + int64(pos)*(hs+4) + 4
+	case 2:
+		start = pi.shaBase + int64(pos)*hs
+	default:
+		return fmt.Errorf("git: unsupported pack index version: %d", pi.Version)
 	}
 
-	start := pi.shaBase
-	_, err := pi.ReadAt(chksum[0:20], start+int64(pos)*int64(20))
+	var buf [32]byte
+	_, err := pi.ReadAt(buf[:hs], start)
 	if err != nil {
 		return err
 	}
 
+	*chksum = pi.Format.ID(buf[:hs])
 	return nil
 }
 
 //ReadOffset returns the offset in the pack file of the object
 //at position pos in the FanOut table.
 func (pi *PackIndex) ReadOffset(pos int) (int64, error) {
-	if version := pi.Version; version != 2 {
-		return -1, fmt.Errorf("git: v%d version incomplete", version)
-	}
+	var buf [8]byte
+	hs := int64(pi.Format.Size())
+
+	switch pi.Version {
+	case 1:
+		//FanOut[256*4] + n * (offset[4] + id[20|32])
+		start := pi.shaBase + int64(pos)*(hs+4)
+		_, err := pi.ReadAt(buf[:4], start)
+		if err != nil {
+			return -1, fmt.Errorf("git: io error: %v", err)
+		}
 
-	//header[2*4] + FanOut[256*4] + n * (sha1[20]+crc[4])
-	start := int64(2*4+256*4) + int64(pi.FO[255]*24) + int64(pos*4)
+		return int64(binary.BigEndian.Uint32(buf[:4])), nil
+	case 2:
+	default:
+		return -1, fmt.Errorf("git: unsupported pack index version: %d", pi.Version)
+	}
 
-	var offset uint32
+	//header[2*4] + FanOut[256*4] + n * (id[20|32]+crc[4])
+	n := int64(pi.Count())
+	start := pi.shaBase + n*(hs+4) + int64(pos)*4
 
-	_, err := pi.Seek(start, 0)
+	_, err := pi.ReadAt(buf[:4], start)
 	if err != nil {
 		return -1, fmt.Errorf("git: io error: %v", err)
 	}
 
-	err = binary.Read(pi, binary.BigEndian, &offset)
-	if err != nil {
-		return -1, err
-	}
+	offset := binary.BigEndian.Uint32(buf[:4])
 
 	//see if msb is set, if so this is an
 	// offset into the 64b_offset table
-	if val := uint32(1<<31) & offset; val != 0 {
-		return -1, fmt.Errorf("git: > 31 bit offests not implemented. Meh")
+	if offset&(1<<31) == 0 {
+		return int64(offset), nil
+	}
+
+	//... + n * offset[4] + idx * offset64[8]
+	idx := int64(offset &^ (1 << 31))
+	start = pi.shaBase + n*(hs+8) + idx*8
+
+	_, err = pi.ReadAt(buf[:], start)
+	if err != nil {
+		return -1, fmt.Errorf("git: io error: %v", err)
+	}
+
+	large := binary.BigEndian.Uint64(buf[:])
+	if large > 1<<63-1 {
+		return -1, fmt.Errorf("git: pack offset overflow")
+	}
+
+	return int64(large), nil
+}
+
+//ReadCRC32 returns the CRC32 checksum of the packed (compressed)
+//object data at position pos in the FanOut table. Only version 2
+//indices store checksums.
+func (pi *PackIndex) ReadCRC32(pos int) (uint32, error) {
+	if pi.Version != 2 {
+		return 0, fmt.Errorf("git: v%d pack index has no crc32 table", pi.Version)
 	}
 
-	return int64(offset), nil
+	var buf [4]byte
+	start := pi.shaBase + int64(pi.Count())*int64(pi.Format.Size()) + int64(pos)*4
+
+	_, err := pi.ReadAt(buf[:], start)
+	if err != nil {
+		return 0, fmt.Errorf("git: io error: %v", err)
+	}
+
+	return binary.BigEndian.Uint32(buf[:]), nil
 }
 
 func (pi *PackIndex) findSHA1(target SHA1) (int, error) {
@@ -160,7 +273,10 @@ func (pi *PackIndex) findSHA1(target SHA1) (int, error) {
 	//where s is the index before interval and
 	//e is the index of the last element in it
 	//-> search interval is: (s | 1, 2, ... e]
-	s, e := pi.FO.Bounds(target[0])
+	s, e := pi.FO.Bounds(target.hash[0])
+	if target.format != pi.Format {
+		e = s
+	}
 
 	//invariant: object is, if present, in the interval, (s, e]
 	for s < e {
@@ -172,7 +288,7 @@ func (pi *PackIndex) findSHA1(target SHA1) (int, error) {
 			return 0, fmt.Errorf("git: io error: %v", err)
 		}
 
-		switch bytes.Compare(target[:], sha[:]) {
+		switch target.compare(sha) {
 		case -1: // target < sha1, new interval (s, m-1]
 			e = midpoint - 1
 		case +1: //taget > sha1, new interval (m, e]
@@ -185,6 +301,54 @@ func (pi *PackIndex) findSHA1(target SHA1) (int, error) {
 	return 0, fmt.Errorf("git: sha1 not found in index")
 }
 
+//findPrefix returns the ids in the index that start with the
+//given (lower-case) hex prefix, see matchPrefix.
+func (pi *PackIndex) findPrefix(prefix string) ([]SHA1, error) {
+	return matchPrefix(pi.ReadSHA1, pi.FO, prefix)
+}
+
+//matchPrefix returns the ids that start with the hex prefix, which
+//must be at least two characters long, from a sorted list of ids
+//with the given fanout table; read reads the id at a position.
+func matchPrefix(read func(*SHA1, int) error, fo FanOut, prefix string) ([]SHA1, error) {
+	low, err := hex.DecodeString((prefix + strings.Repeat("0", 64))[:64])
+	if err != nil {
+		return nil, fmt.Errorf("git: invalid object id prefix %q", prefix)
+	}
+
+	//find the first id that is not less than low in [s, e)
+	s, e := fo.Bounds(low[0])
+	for s < e {
+		midpoint := s + (e-s)/2
+
+		var sha SHA1
+		if err := read(&sha, midpoint); err != nil {
+			return nil, fmt.Errorf("git: io error: %v", err)
+		}
+
+		if bytes.Compare(sha.hash[:], low) < 0 {
+			s = midpoint + 1
+		} else {
+			e = midpoint
+		}
+	}
+
+	var ids []SHA1
+	for _, end := fo.Bounds(low[0]); s < end; s++ {
+		var sha SHA1
+		if err := read(&sha, s); err != nil {
+			return nil, fmt.Errorf("git: io error: %v", err)
+		}
+
+		if !strings.HasPrefix(sha.String(), prefix) {
+			break
+		}
+		ids = append(ids, sha)
+	}
+
+	return ids, nil
+}
+
 //FindOffset tries to find  object with the id target and if
 //if found returns the offset of the object in the pack file.
 //Returns an error that can be detected by os.IsNotExist if
@@ -212,6 +376,7 @@ func (pi *PackIndex) OpenPackFile() (*PackFile, error) {
 		return nil, err
 	}
 
+	pf.Format = pi.Format
 	return pf, nil
 }
 
@@ -256,7 +421,7 @@ func (pi *PackIndex) OpenObject(id SHA1) (Object, error) {
 
 //OpenPackFile opens the git pack file at the given path
 //It will check the pack file header and version.
-//Currently only version 2 is supported.
+//Version 2 and 3 (which is identical to 2) are supported.
 //NB: This is low-level API and should most likely
 //not be used directly.
 func OpenPackFile(path string) (*PackFile, error) {
@@ -269,14 +434,17 @@ func OpenPackFile(path string) (*PackFile, error) {
 	var header PackHeader
 	err = binary.Read(osfd, binary.BigEndian, &header)
 	if err != nil {
+		osfd.Close()
 		return nil, fmt.Errorf("git: could not read header: %v", err)
 	}
 
 	if string(header.Sig[:]) != "PACK" {
+		osfd.Close()
 		return nil, fmt.Errorf("git: packfile signature error")
 	}
 
-	if header.Version != 2 {
+	if header.Version != 2 && header.Version != 3 {
+		osfd.Close()
 		return nil, fmt.Errorf("git: unsupported packfile version")
 	}
 
@@ -287,8 +455,16 @@ func OpenPackFile(path string) (*PackFile, error) {
 	return fd, nil
 }
 
-func (pf *PackFile) readRawObject(offset int64) (gitObject, error) {
+//readRawObject reads the header of the object at offset. The
+//source of the returned object is a reader of the pack file, that
+//is registered until the object is closed (see acquire).
+func (pf *PackFile) readRawObject(offset int64) (obj gitObject, err error) {
 	r := newPackReader(pf, offset)
+	defer func() {
+		if err != nil {
+			r.Close()
+		}
+	}()
 
 	b, err := r.ReadByte()
 	if err != nil {
@@ -312,7 +488,7 @@ func (pf *PackFile) readRawObject(offset int64) (gitObject, error) {
 
 		size += s
 	}
-	obj := gitObject{otype, size, r}
+	obj = gitObject{otype: otype, size: size, source: r, format: pf.Format}
 
 	if IsStandardObject(otype) {
 		err = obj.wrapSourceWithDeflate()
@@ -355,12 +531,14 @@ func (pf *PackFile) OpenObject(offset int64) (Object, error) {
 }
 
 type packReader struct {
-	fd    *PackFile
-	start int64
-	off   int64
+	fd     *PackFile
+	start  int64
+	off    int64
+	closed bool
 }
 
 func newPackReader(fd *PackFile, offset int64) *packReader {
+	fd.acquire()
 	return &packReader{fd: fd, start: offset, off: offset}
 }
 
@@ -378,5 +556,9 @@ func (p *packReader) ReadByte() (c byte, err error) {
 }
 
 func (p *packReader) Close() (err error) {
-	return //noop
+	if !p.closed {
+		p.closed = true
+		p.fd.release()
+	}
+	return
 }
@@ -77,13 +77,7 @@ func TestPackBasic(t *testing.T) {
 				if IsDeltaObject(obj.Type()) {
 					//t.Logf("checking delta obj: %q", oid)
 					delta := obj.(*Delta)
-					chain, err := buildDeltaChain(delta, repo)
-
-					if err != nil {
-						t.Fatalf("building delta chain failed for %q: %v", oid, err)
-					}
-
-					obj, err = chain.resolve()
+					obj, err = repo.resolveDelta(delta)
 
 					if err != nil {
 						t.Fatalf("resolving delta chain failed for %q: %v", oid, err)
@@ -99,9 +93,7 @@ func TestPackBasic(t *testing.T) {
 					t.Fatalf("Object.WriteTo(%q) => failed!: %v ", oid, err)
 				}
 
-				hid := h.Sum(nil)
-				var cid SHA1
-				copy(cid[:], hid)
+				cid := FormatSHA1.ID(h.Sum(nil))
 
 				if cid != oid {
 					t.Logf("[E] object proof:\n%s---EOF---\n", b.String())
@@ -0,0 +1,330 @@
+package gig
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+//packSet is the set of pack indices of a repository. Indices and
+//their pack files are opened once and then kept open, so looking
+//up objects does not require scanning the pack directory or
+//re-opening files. If a multi-pack-index is present, it is used
+//for the packs it covers. It is safe for concurrent use.
+type packSet struct {
+	dir    string
+	format ObjectFormat
+
+	//mu is held for reading during lookups, so that indices
+	//are only closed when no lookup is using them
+	mu       sync.RWMutex
+	packs    []*pack
+	midx     *MultiPackIndex
+	midxStat os.FileInfo
+	byName   map[string]*pack
+	inMidx   map[*pack]bool
+	scanned  bool
+}
+
+//pack is one entry of the packSet, i.e. an open pack
+//index with its lazily opened pack file.
+type pack struct {
+	idx   *PackIndex
+	mtime time.Time
+
+	once sync.Once
+	pf   *PackFile
+	err  error
+}
+
+func (p *pack) packFile() (*PackFile, error) {
+	p.once.Do(func() {
+		p.pf, p.err = p.idx.OpenPackFile()
+	})
+	return p.pf, p.err
+}
+
+//Close closes the index right away and the pack file
+//once the objects read from it are closed.
+func (p *pack) Close() error {
+	err := p.idx.Close()
+	if p.pf != nil {
+		p.pf.retire()
+	}
+	return err
+}
+
+func newPackSet(dir string, format ObjectFormat) *packSet {
+	return &packSet{dir: dir, format: format}
+}
+
+//find looks for the object with the given id in all packs and
+//returns the pack file and the offset of the object in it. If
+//the object is not found, the pack directory is checked for new
+//packs, which are then searched as well. The pack file is
+//registered as being read (see PackFile.acquire) and must be
+//released by the caller if the object was found.
+func (ps *packSet) find(id SHA1) (*PackFile, int64, bool, error) {
+	err := ps.refresh(false)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		pf, off, found, err := ps.lookup(id)
+		if err != nil || found {
+			return pf, off, found, err
+		}
+
+		if attempt == 0 {
+			changed, err := ps.update()
+			if err != nil || !changed {
+				return nil, 0, false, err
+			}
+		}
+	}
+
+	return nil, 0, false, nil
+}
+
+//lookup looks for the object in the current packs.
+func (ps *packSet) lookup(id SHA1) (*PackFile, int64, bool, error) {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	found := func(p *pack, off int64) (*PackFile, int64, bool, error) {
+		pf, err := p.packFile()
+		if err != nil {
+			return nil, 0, false, err
+		}
+
+		pf.acquire()
+		return pf, off, true, nil
+	}
+
+	if ps.midx != nil {
+		name, off, err := ps.midx.FindOffset(id)
+		if p := ps.byName[name]; err == nil && p != nil {
+			return found(p, off)
+		}
+	}
+
+	for _, p := range ps.packs {
+		if ps.inMidx[p] {
+			continue
+		}
+
+		off, err := p.idx.FindOffset(id)
+		if err == nil {
+			return found(p, off)
+		}
+	}
+
+	return nil, 0, false, nil
+}
+
+//findPrefix returns the ids of the objects in all packs that
+//start with the given hex prefix. The pack directory is always
+//checked for new packs first. Ids can be contained more than once.
+func (ps *packSet) findPrefix(prefix string) ([]SHA1, error) {
+	if err := ps.refresh(false); err != nil {
+		return nil, err
+	} else if _, err := ps.update(); err != nil {
+		return nil, err
+	}
+
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	var ids []SHA1
+	if ps.midx != nil {
+		found, err := ps.midx.findPrefix(prefix)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, found...)
+	}
+
+	for _, p := range ps.packs {
+		if ps.inMidx[p] {
+			continue
+		}
+
+		found, err := p.idx.findPrefix(prefix)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, found...)
+	}
+
+	return ids, nil
+}
+
+//refresh scans the pack directory if it has not been scanned
+//before, or unconditionally if force is true.
+func (ps *packSet) refresh(force bool) error {
+	ps.mu.RLock()
+	scanned := ps.scanned
+	ps.mu.RUnlock()
+
+	if scanned && !force {
+		return nil
+	}
+
+	_, err := ps.update()
+	return err
+}
+
+//update re-scans the pack directory and compares the indices with
+//the known ones; the modification time of the directory is not used,
+//as its resolution is too coarse on some file systems to notice packs
+//that are added right after a scan. Indices that are new are
+//opened, the ones that have vanished are closed; their pack files are
+//closed once the objects read from them are closed. The
+//multi-pack-index is re-opened if it has changed. Returns
+//true if the set of packs has changed.
+func (ps *packSet) update() (bool, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	_, err := os.Stat(ps.dir)
+	if os.IsNotExist(err) {
+		ps.scanned = true
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(ps.dir, "*.idx"))
+	if err != nil {
+		return false, err
+	}
+
+	known := make(map[string]*pack, len(ps.packs))
+	for _, p := range ps.packs {
+		known[p.idx.Name()] = p
+	}
+
+	changed := false
+	var packs []*pack
+	for _, f := range files {
+		if p, ok := known[f]; ok {
+			packs = append(packs, p)
+			delete(known, f)
+			continue
+		}
+
+		fi, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+
+		idx, err := openPackIndex(f, ps.format)
+		if err != nil {
+			//most likely a pack that is just being written
+			continue
+		}
+
+		packs = append(packs, &pack{idx: idx, mtime: fi.ModTime()})
+		changed = true
+	}
+
+	for _, p := range known {
+		p.Close()
+		changed = true
+	}
+
+	midxChanged, err := ps.updateMidx()
+	if err != nil {
+		return false, err
+	}
+	changed = changed || midxChanged
+
+	byName := make(map[string]*pack, len(packs))
+	for _, p := range packs {
+		byName[filepath.Base(p.idx.Name())] = p
+	}
+
+	inMidx := make(map[*pack]bool)
+	if ps.midx != nil {
+		for _, name := range ps.midx.PackNames {
+			if p, ok := byName[name]; ok {
+				inMidx[p] = true
+			}
+		}
+	}
+
+	//newer packs are more likely to contain the objects
+	//we are looking for, so they are searched first
+	sort.SliceStable(packs, func(i, j int) bool {
+		return packs[i].mtime.After(packs[j].mtime)
+	})
+
+	ps.packs = packs
+	ps.byName = byName
+	ps.inMidx = inMidx
+	ps.scanned = true
+
+	return changed, nil
+}
+
+//updateMidx re-opens the multi-pack-index if the file has been
+//replaced or removed since it was opened; the old one is closed.
+//Returns true if it has changed.
+func (ps *packSet) updateMidx() (bool, error) {
+	path := filepath.Join(ps.dir, "multi-pack-index")
+
+	fi, err := os.Stat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	if old := ps.midxStat; old != nil && fi != nil && os.SameFile(old, fi) &&
+		old.Size() == fi.Size() && old.ModTime().Equal(fi.ModTime()) {
+		return false, nil
+	} else if old == nil && fi == nil {
+		return false, nil
+	}
+
+	changed := ps.midx != nil
+	if ps.midx != nil {
+		ps.midx.Close()
+		ps.midx, ps.midxStat = nil, nil
+	}
+
+	if fi == nil {
+		return changed, nil
+	}
+
+	midx, err := MultiPackIndexOpen(path)
+	if err != nil {
+		//most likely one that is just being written
+		return changed, nil
+	}
+
+	ps.midx, ps.midxStat = midx, fi
+	return true, nil
+}
+
+//close closes all open index and pack files.
+func (ps *packSet) close() {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	for _, p := range ps.packs {
+		p.Close()
+	}
+
+	if ps.midx != nil {
+		ps.midx.Close()
+	}
+
+	ps.packs = nil
+	ps.midx = nil
+	ps.byName = nil
+	ps.midxStat = nil
+	ps.inMidx = nil
+	ps.scanned = false
+}
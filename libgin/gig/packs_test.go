@@ -0,0 +1,338 @@
+package gig
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+//gitCmd runs git with the given arguments in dir and returns
+//the trimmed output. The test fails if git fails.
+func gitCmd(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+
+	args = append([]string{
+		"-c", "user.name=A U Thor",
+		"-c", "user.email=author@example.com",
+		"-c", "init.defaultBranch=master",
+		"-c", "gc.auto=0",
+	}, args...)
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_DATE=1462210432 +0200",
+		"GIT_COMMITTER_DATE=1462210432 +0200")
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s failed: %v\n%s", strings.Join(args, " "), err, out)
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+//mkTestRepo creates a bare repository with a few commits
+//and returns it together with the path of a non-bare clone,
+//which can be used to create more commits. The initArgs are
+//passed to "git init", e.g. to select the object format.
+func mkTestRepo(t *testing.T, initArgs ...string) (*Repository, string) {
+	t.Helper()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("[W] git not found. Skipping test")
+	}
+
+	base, err := ioutil.TempDir("", "gig-test")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(base) })
+
+	wt := filepath.Join(base, "work")
+	gitCmd(t, base, append(append([]string{"init", "-q"}, initArgs...), wt)...)
+
+	files := []string{"README.md", "data/a.txt", "data/b.txt", "data/sub/c.txt"}
+	for i := 0; i < 3; i++ {
+		for k, f := range files {
+			p := filepath.Join(wt, filepath.FromSlash(f))
+			if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+				t.Fatalf("could not create dir: %v", err)
+			}
+
+			lines := strings.Repeat(fmt.Sprintf("line of file %d\n", k), 20)
+			data := fmt.Sprintf("%s\nrevision %d\n", lines, i)
+			if err := ioutil.WriteFile(p, []byte(data), 0666); err != nil {
+				t.Fatalf("could not write file: %v", err)
+			}
+		}
+
+		gitCmd(t, wt, "add", "-A")
+		gitCmd(t, wt, "commit", "-q", "-m", fmt.Sprintf("commit %d", i))
+	}
+
+	bare := filepath.Join(base, "repo.git")
+	gitCmd(t, base, "clone", "-q", "--bare", "--no-local", wt, bare)
+	gitCmd(t, wt, "remote", "add", "bare", bare)
+
+	repo, err := OpenRepository(bare)
+	if err != nil {
+		t.Fatalf("could not open repository: %v", err)
+	}
+
+	return repo, wt
+}
+
+//listObjects returns the ids of all objects in the repository.
+func listObjects(t *testing.T, repo *Repository) []SHA1 {
+	t.Helper()
+
+	out := gitCmd(t, repo.Path, "cat-file", "--batch-all-objects", "--batch-check=%(objectname)")
+
+	var ids []SHA1
+	for _, l := range strings.Split(out, "\n") {
+		id, err := ParseSHA1(l)
+		if err != nil {
+			t.Fatalf("could not parse object id %q: %v", l, err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+func checkOpenAll(t *testing.T, repo *Repository, ids []SHA1) {
+	t.Helper()
+
+	for _, id := range ids {
+		obj, err := repo.OpenObject(id)
+		if err != nil {
+			t.Fatalf("could not open object %s: %v", id, err)
+		}
+
+		h := id.Format().New()
+		_, err = obj.WriteTo(h)
+		obj.Close()
+
+		if err != nil {
+			t.Fatalf("could not read object %s: %v", id, err)
+		}
+
+		oid := id.Format().ID(h.Sum(nil))
+		if oid != id {
+			t.Fatalf("object %s hashes to %s", id, oid)
+		}
+	}
+}
+
+func TestPackIndexVersions(t *testing.T) {
+	repo, _ := mkTestRepo(t)
+	ids := listObjects(t, repo)
+
+	packs, err := filepath.Glob(filepath.Join(repo.Path, "objects", "pack", "*.pack"))
+	if err != nil || len(packs) != 1 {
+		t.Fatalf("expected exactly one pack file, got %v (%v)", packs, err)
+	}
+
+	tests := []struct {
+		name    string
+		version string
+		want    uint32
+	}{
+		{"v1", "1", 1},
+		{"v2", "2", 2},
+		{"v2-large-offsets", "2,0x1", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idxPath := strings.TrimSuffix(packs[0], ".pack") + ".idx"
+			os.Remove(idxPath)
+			gitCmd(t, repo.Path, "index-pack", "--index-version="+tt.version, packs[0])
+
+			idx, err := PackIndexOpen(idxPath)
+			if err != nil {
+				t.Fatalf("could not open index: %v", err)
+			}
+			defer idx.Close()
+
+			if idx.Version != tt.want {
+				t.Fatalf("expected index version %d, got %d", tt.want, idx.Version)
+			}
+
+			if idx.Count() != len(ids) {
+				t.Fatalf("expected %d objects in index, got %d", len(ids), idx.Count())
+			}
+
+			for _, id := range ids {
+				if _, err := idx.FindOffset(id); err != nil {
+					t.Fatalf("could not find %s in index: %v", id, err)
+				}
+			}
+
+			r := &Repository{Path: repo.Path}
+			defer r.packSet().close()
+			checkOpenAll(t, r, ids)
+		})
+	}
+}
+
+func TestPackSetConcurrent(t *testing.T) {
+	repo, wt := mkTestRepo(t)
+	defer repo.packSet().close()
+
+	ids := listObjects(t, repo)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, id := range ids {
+				obj, err := repo.OpenObject(id)
+				if err != nil {
+					t.Errorf("could not open object %s: %v", id, err)
+					return
+				}
+				obj.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	//new packs must be picked up without re-opening
+	//the repository
+	err := ioutil.WriteFile(filepath.Join(wt, "new.txt"), []byte("new file\n"), 0666)
+	if err != nil {
+		t.Fatalf("could not write file: %v", err)
+	}
+	gitCmd(t, wt, "add", "-A")
+	gitCmd(t, wt, "commit", "-q", "-m", "new commit")
+	gitCmd(t, wt, "push", "-q", "bare", "master")
+	gitCmd(t, repo.Path, "repack", "-q", "-a", "-d")
+
+	checkOpenAll(t, repo, listObjects(t, repo))
+
+	ps := repo.packSet()
+	ps.mu.RLock()
+	n := len(ps.packs)
+	ps.mu.RUnlock()
+
+	if n != 1 {
+		t.Fatalf("expected 1 pack after repack, got %d", n)
+	}
+
+	//also if the modification time of the directory is unchanged
+	dir := filepath.Join(repo.Path, "objects", "pack")
+	fi, err := os.Stat(dir)
+	if err != nil {
+		t.Fatalf("could not stat pack dir: %v", err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(wt, "newer.txt"), []byte("newer file\n"), 0666)
+	if err != nil {
+		t.Fatalf("could not write file: %v", err)
+	}
+	gitCmd(t, wt, "add", "-A")
+	gitCmd(t, wt, "commit", "-q", "-m", "newer commit")
+	gitCmd(t, wt, "push", "-q", "bare", "master")
+	gitCmd(t, repo.Path, "repack", "-q", "-d")
+
+	if err := os.Chtimes(dir, fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatalf("could not reset modification time: %v", err)
+	}
+
+	checkOpenAll(t, repo, listObjects(t, repo))
+}
+
+func TestPackSetRetire(t *testing.T) {
+	repo, wt := mkTestRepo(t)
+	ps := repo.packSet()
+	defer ps.close()
+
+	isClosed := func(f *os.File) bool {
+		_, err := f.Stat()
+		return errors.Is(err, os.ErrClosed)
+	}
+
+	update := func() {
+		t.Helper()
+		if _, err := ps.update(); err != nil {
+			t.Fatalf("could not update packs: %v", err)
+		}
+	}
+
+	commit := func(name string) {
+		t.Helper()
+		writeFiles(t, wt, map[string]string{name: name + "\n"})
+		gitCmd(t, wt, "add", "-A")
+		gitCmd(t, wt, "commit", "-q", "-m", name)
+		gitCmd(t, wt, "push", "-q", "bare", "master")
+		gitCmd(t, repo.Path, "repack", "-q", "-d")
+	}
+
+	//an object that is kept open while its pack vanishes
+	id := revParse(t, repo, "master:README.md")
+	obj, err := repo.OpenObject(id)
+	if err != nil {
+		t.Fatalf("could not open object: %v", err)
+	}
+
+	pf, _, found, err := ps.find(id)
+	if err != nil || !found {
+		t.Fatalf("could not find object: %v", err)
+	}
+	pf.release()
+
+	ps.mu.RLock()
+	idx := ps.packs[0].idx
+	ps.mu.RUnlock()
+
+	//the multi-pack-index is kept as long as it is not replaced
+	gitCmd(t, repo.Path, "multi-pack-index", "write")
+	update()
+
+	midx := ps.midx
+	if midx == nil {
+		t.Fatalf("multi-pack-index was not opened")
+	}
+
+	commit("retire1.txt")
+	update()
+	if ps.midx != midx || isClosed(midx.File) {
+		t.Fatalf("expected unchanged multi-pack-index to be kept")
+	}
+
+	gitCmd(t, repo.Path, "multi-pack-index", "write")
+	update()
+	if ps.midx == midx || !isClosed(midx.File) {
+		t.Fatalf("expected replaced multi-pack-index to be closed")
+	}
+
+	//vanished packs are closed once they are not read anymore
+	gitCmd(t, repo.Path, "repack", "-q", "-a", "-d")
+	update()
+	checkOpenAll(t, repo, listObjects(t, repo))
+
+	if !isClosed(idx.File) {
+		t.Fatalf("expected index of vanished pack to be closed")
+	} else if isClosed(pf.File) {
+		t.Fatalf("expected pack file to be kept open while it is read")
+	}
+
+	data, err := ioutil.ReadAll(obj.(*Blob))
+	if err != nil || string(data) != gitCmd(t, wt, "show", "master:README.md")+"\n" {
+		t.Fatalf("could not read object from vanished pack: %q, %v", data, err)
+	}
+
+	obj.Close()
+	if !isClosed(pf.File) {
+		t.Fatalf("expected pack file to be closed after the last object")
+	}
+}
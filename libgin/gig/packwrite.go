@@ -0,0 +1,361 @@
+package gig
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+//PackWriter writes objects in the git pack file format. The number
+//of objects must be known in advance, since it is part of the pack
+//header. After all objects have been written, Close() must be called
+//to write the trailing checksum. The corresponding (version 2) pack
+//index can then be written via WriteIndex().
+type PackWriter struct {
+	//DeltaWindow is the number of preceding objects of the same
+	//type that are tried as delta bases for each object. Zero,
+	//the default, disables delta compression.
+	DeltaWindow int
+
+	w      io.Writer
+	h      hash.Hash
+	format ObjectFormat
+	off    int64
+	count  uint32
+
+	entries []packIndexEntry
+	window  []*deltaCandidate
+
+	closed bool
+	sum    SHA1
+}
+
+//packIndexEntry holds the information needed for the
+//pack index for one object in the pack.
+type packIndexEntry struct {
+	ID     SHA1
+	Offset int64
+	CRC32  uint32
+}
+
+type deltaCandidate struct {
+	otype  ObjectType
+	offset int64
+	data   []byte
+	index  *deltaIndex
+}
+
+//NewPackWriter returns a PackWriter that will write count
+//SHA-1 objects to w. The pack header is written immediately.
+func NewPackWriter(w io.Writer, count uint32) (*PackWriter, error) {
+	return newPackWriter(w, count, FormatSHA1)
+}
+
+//newPackWriter is like NewPackWriter, but for packs of
+//repositories with the given object format.
+func newPackWriter(w io.Writer, count uint32, format ObjectFormat) (*PackWriter, error) {
+	pw := &PackWriter{h: format.New(), format: format, count: count}
+	pw.w = io.MultiWriter(w, pw.h)
+
+	header := PackHeader{Version: 2, Objects: count}
+	copy(header.Sig[:], "PACK")
+
+	err := binary.Write(pw.w, binary.BigEndian, &header)
+	if err != nil {
+		return nil, err
+	}
+
+	pw.off = int64(binary.Size(header))
+	return pw, nil
+}
+
+//packEntryHeader encodes the type and the (uncompressed) size
+//of a pack entry, see PackFile.readRawObject for the format.
+func packEntryHeader(otype ObjectType, size int64) []byte {
+	b := byte(otype)<<4 | byte(size&0xF)
+	size >>= 4
+
+	var buf []byte
+	for size != 0 {
+		buf = append(buf, b|0x80)
+		b = byte(size & 0x7F)
+		size >>= 7
+	}
+
+	return append(buf, b)
+}
+
+//encodeOfsDeltaOffset encodes the negative offset to the base
+//object of an ofs-delta object, see readVarint.
+func encodeOfsDeltaOffset(off int64) []byte {
+	var buf [10]byte
+	pos := len(buf) - 1
+
+	buf[pos] = byte(off & 0x7F)
+	for off >>= 7; off != 0; off >>= 7 {
+		off--
+		pos--
+		buf[pos] = 0x80 | byte(off&0x7F)
+	}
+
+	return buf[pos:]
+}
+
+//WriteObject writes the object to the pack and returns its id.
+//NB: The object's data is consumed in the process.
+func (pw *PackWriter) WriteObject(obj Object) (SHA1, error) {
+	var id SHA1
+
+	if pw.closed {
+		return id, fmt.Errorf("git: pack writer is closed")
+	} else if uint32(len(pw.entries)) >= pw.count {
+		return id, fmt.Errorf("git: too many objects for pack")
+	} else if !IsStandardObject(obj.Type()) {
+		return id, fmt.Errorf("git: cannot write %s objects into pack", obj.Type())
+	}
+
+	if pw.DeltaWindow > 0 {
+		return pw.writeWithDelta(obj)
+	}
+
+	//no delta compression, stream the data
+	otype, size := obj.Type(), obj.Size()
+	crc := crc32.NewIEEE()
+	out := io.MultiWriter(pw.w, crc)
+
+	header := packEntryHeader(otype, size)
+	_, err := out.Write(header)
+	if err != nil {
+		return id, err
+	}
+
+	cw := &countingWriter{w: out}
+	zw := zlib.NewWriter(cw)
+	h := pw.format.New()
+
+	n, err := obj.WriteTo(io.MultiWriter(h, &headerSkipper{w: zw}))
+	if err == nil {
+		err = zw.Close()
+	}
+
+	if err != nil {
+		return id, err
+	}
+
+	if n != int64(len(objectHeader(otype, size)))+size {
+		return id, fmt.Errorf("git: object size mismatch")
+	}
+
+	id = pw.format.ID(h.Sum(nil))
+	pw.entries = append(pw.entries, packIndexEntry{id, pw.off, crc.Sum32()})
+	pw.off += int64(len(header)) + cw.n
+
+	return id, nil
+}
+
+func (pw *PackWriter) writeWithDelta(obj Object) (SHA1, error) {
+	var id SHA1
+
+	var buf bytes.Buffer
+	_, err := obj.WriteTo(&buf)
+	if err != nil {
+		return id, err
+	}
+
+	h := pw.format.New()
+	h.Write(buf.Bytes())
+	id = pw.format.ID(h.Sum(nil))
+
+	raw := buf.Bytes()
+	pos := bytes.IndexByte(raw, 0)
+	if pos == -1 {
+		return id, fmt.Errorf("git: invalid object data")
+	}
+
+	data := raw[pos+1:]
+	if int64(len(data)) != obj.Size() {
+		return id, fmt.Errorf("git: object size mismatch")
+	}
+
+	//find the best delta base in the window
+	var best *deltaCandidate
+	var delta []byte
+	maxSize := len(data)/2 - 20
+
+	for _, c := range pw.window {
+		if c.otype != obj.Type() || maxSize <= 0 {
+			continue
+		}
+
+		if c.index == nil {
+			c.index = newDeltaIndex(c.data)
+		}
+
+		d := c.index.diff(data, maxSize)
+		if d != nil && (delta == nil || len(d) < len(delta)) {
+			best, delta = c, d
+		}
+	}
+
+	var header []byte
+	payload := data
+	if best != nil {
+		header = packEntryHeader(ObjOFSDelta, int64(len(delta)))
+		header = append(header, encodeOfsDeltaOffset(pw.off-best.offset)...)
+		payload = delta
+	} else {
+		header = packEntryHeader(obj.Type(), int64(len(data)))
+	}
+
+	crc := crc32.NewIEEE()
+	out := io.MultiWriter(pw.w, crc)
+
+	_, err = out.Write(header)
+	if err != nil {
+		return id, err
+	}
+
+	cw := &countingWriter{w: out}
+	zw := zlib.NewWriter(cw)
+	_, err = zw.Write(payload)
+	if err == nil {
+		err = zw.Close()
+	}
+
+	if err != nil {
+		return id, err
+	}
+
+	pw.entries = append(pw.entries, packIndexEntry{id, pw.off, crc.Sum32()})
+
+	pw.window = append(pw.window, &deltaCandidate{otype: obj.Type(), offset: pw.off, data: data})
+	if len(pw.window) > pw.DeltaWindow {
+		pw.window = pw.window[1:]
+	}
+
+	pw.off += int64(len(header)) + cw.n
+	return id, nil
+}
+
+//Close writes the trailing checksum of the pack. It
+//does not close the underlying writer.
+func (pw *PackWriter) Close() error {
+	if pw.closed {
+		return nil
+	}
+
+	if n := uint32(len(pw.entries)); n != pw.count {
+		return fmt.Errorf("git: pack header announced %d objects, %d written", pw.count, n)
+	}
+
+	pw.sum = pw.format.ID(pw.h.Sum(nil))
+	_, err := pw.w.Write(pw.sum.Bytes())
+	if err != nil {
+		return err
+	}
+
+	pw.closed = true
+	pw.window = nil
+	return nil
+}
+
+//Checksum returns the checksum of the pack, which is only
+//valid after Close() has been called.
+func (pw *PackWriter) Checksum() SHA1 {
+	return pw.sum
+}
+
+//WriteIndex writes the version 2 pack index for the pack. It
+//must only be called after Close().
+func (pw *PackWriter) WriteIndex(w io.Writer) error {
+	if !pw.closed {
+		return fmt.Errorf("git: pack must be closed before writing its index")
+	}
+
+	return writePackIndex(w, pw.entries, pw.sum)
+}
+
+//writePackIndex writes a version 2 pack index for the given
+//entries (which will be sorted) and the pack checksum.
+func writePackIndex(writer io.Writer, entries []packIndexEntry, packSum SHA1) error {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID.compare(entries[j].ID) < 0
+	})
+
+	h := packSum.Format().New()
+	bw := bufio.NewWriter(io.MultiWriter(writer, h))
+
+	var fo FanOut
+	for _, e := range entries {
+		fo[e.ID.hash[0]]++
+	}
+
+	for i := 1; i < len(fo); i++ {
+		fo[i] += fo[i-1]
+	}
+
+	var buf [8]byte
+	write32 := func(v uint32) {
+		binary.BigEndian.PutUint32(buf[:4], v)
+		bw.Write(buf[:4])
+	}
+
+	bw.WriteString("\377tOc")
+	write32(2)
+
+	for _, n := range fo {
+		write32(n)
+	}
+
+	for _, e := range entries {
+		bw.Write(e.ID.Bytes())
+	}
+
+	for _, e := range entries {
+		write32(e.CRC32)
+	}
+
+	var large []int64
+	for _, e := range entries {
+		if e.Offset < 1<<31 {
+			write32(uint32(e.Offset))
+			continue
+		}
+
+		write32(uint32(len(large)) | 1<<31)
+		large = append(large, e.Offset)
+	}
+
+	for _, off := range large {
+		binary.BigEndian.PutUint64(buf[:], uint64(off))
+		bw.Write(buf[:])
+	}
+
+	bw.Write(packSum.Bytes())
+
+	//all errors of bufio.Writer are sticky
+	err := bw.Flush()
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.Write(h.Sum(nil))
+	return err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(data []byte) (int, error) {
+	n, err := cw.w.Write(data)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,86 @@
+package gig
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDeltaDiff(t *testing.T) {
+	source := []byte(strings.Repeat("the quick brown fox jumps over the lazy dog\n", 50))
+	target := append([]byte("header\n"), source[:1000]...)
+	target = append(target, []byte("something in between\n")...)
+	target = append(target, source[1000:]...)
+
+	delta := newDeltaIndex(source).diff(target, len(target))
+	if delta == nil {
+		t.Fatalf("expected delta to be created")
+	} else if len(delta) > len(target)/4 {
+		t.Fatalf("delta is too large: %d for %d bytes", len(delta), len(target))
+	}
+
+	d := &Delta{gitObject: gitObject{otype: ObjOFSDelta, source: nopReadCloser{bytes.NewReader(delta)}}}
+
+	d.SizeSource, _ = readVarSize(d.source, 0)
+	d.SizeTarget, _ = readVarSize(d.source, 0)
+
+	var out bytes.Buffer
+	if err := d.Patch(bytes.NewReader(source), &out); err != nil {
+		t.Fatalf("could not apply delta: %v", err)
+	}
+
+	if !bytes.Equal(out.Bytes(), target) {
+		t.Fatalf("patched data differs from target")
+	}
+
+	unrelated := []byte(strings.Repeat("completely different data\n", 10))
+	if delta := newDeltaIndex(source).diff(unrelated, len(unrelated)/2); delta != nil {
+		t.Fatalf("unexpected delta for unrelated data")
+	}
+}
+
+func TestWritePack(t *testing.T) {
+	src, _ := mkTestRepo(t)
+	ids := listObjects(t, src)
+
+	for _, window := range []int{0, 10} {
+		t.Run(fmt.Sprintf("window-%d", window), func(t *testing.T) {
+			dst, err := InitBareRepository(filepath.Join(t.TempDir(), "dst.git"))
+			if err != nil {
+				t.Fatalf("could not create repository: %v", err)
+			}
+
+			var objs []Object
+			for _, id := range ids {
+				obj, err := src.OpenObject(id)
+				if err != nil {
+					t.Fatalf("could not open %s: %v", id, err)
+				}
+				objs = append(objs, obj)
+			}
+
+			sum, err := dst.WritePack(objs, window)
+			if err != nil {
+				t.Fatalf("could not write pack: %v", err)
+			}
+
+			idx := filepath.Join(dst.Path, "objects", "pack", fmt.Sprintf("pack-%s.idx", sum))
+			out := gitCmd(t, dst.Path, "verify-pack", "-v", idx)
+			if window > 0 && !strings.Contains(out, "chain length = 1") {
+				t.Errorf("expected delta objects in pack:\n%s", out)
+			}
+
+			checkOpenAll(t, dst, ids)
+		})
+	}
+}
+
+type nopReadCloser struct {
+	*bytes.Reader
+}
+
+func (nopReadCloser) Close() error {
+	return nil
+}
@@ -133,7 +133,7 @@ func openRawObject(path string) (gitObject, error) {
 		return gitObject{}, err
 	}
 
-	obj := gitObject{otype, size, r}
+	obj := gitObject{otype: otype, size: size, source: r}
 	obj.wrapSource(r)
 
 	return obj, nil
@@ -212,7 +212,7 @@ func parseTree(obj gitObject) (*Tree, error) {
 	return &tree, nil
 }
 
-func parseTreeEntry(r io.Reader) (*TreeEntry, error) {
+func parseTreeEntry(r io.Reader, format ObjectFormat) (*TreeEntry, error) {
 	//format is: [mode{ASCII, octal}][space][name][\0][SHA1]
 	entry := &TreeEntry{}
 
@@ -233,20 +233,26 @@ func parseTreeEntry(r io.Reader) (*TreeEntry, error) {
 	// info bits by 16
 	entry.Mode = os.FileMode(mode)
 
-	if entry.Mode == 040000 {
+	switch entry.Mode {
+	case ModeTree:
 		entry.Type = ObjTree
-	} else {
+	case ModeGitlink:
+		//a commit in the repository of the submodule
+		entry.Type = ObjCommit
+	default:
 		entry.Type = ObjBlob
 	}
 
 	entry.Name = name
 
-	n, err := r.Read(entry.ID[:])
+	id := make([]byte, format.Size())
+	_, err = io.ReadFull(r, id)
+	entry.ID = format.ID(id)
 
-	if err != nil && err != io.EOF {
-		return nil, err
-	} else if err == io.EOF && n != 20 {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
 		return nil, fmt.Errorf("git: unexpected EOF")
+	} else if err != nil {
+		return nil, err
 	}
 
 	return entry, nil
@@ -137,7 +137,7 @@ func TestParseTag(t *testing.T) {
 	t.Log("Test Parse Signed Tag")
 	tagReader := strings.NewReader(fakeSignedTagTxt)
 	tagRC := ioutil.NopCloser(tagReader)
-	fakeGitObject := gitObject{ObjTag, tagReader.Size(), tagRC}
+	fakeGitObject := gitObject{otype: ObjTag, size: tagReader.Size(), source: tagRC}
 	tag, err := parseTag(fakeGitObject)
 	if err != nil {
 		t.Log(err)
@@ -158,7 +158,7 @@ func TestParseTag(t *testing.T) {
 	t.Log("Test Parse Unsigned Tag")
 	tagReader = strings.NewReader(FakeUnsignedTagTxt)
 	tagRC = ioutil.NopCloser(tagReader)
-	fakeGitObject = gitObject{ObjTag, tagReader.Size(), tagRC}
+	fakeGitObject = gitObject{otype: ObjTag, size: tagReader.Size(), source: tagRC}
 	tag, err = parseTag(fakeGitObject)
 	if err != nil {
 		t.Log(err)
@@ -0,0 +1,242 @@
+package gig
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+//DefaultContextLines is the number of context lines
+//around changes, if not specified otherwise.
+const DefaultContextLines = 3
+
+//PatchOptions control how patches are generated.
+type PatchOptions struct {
+	DiffOptions
+
+	//Context is the number of context lines around changes;
+	//zero means DefaultContextLines, negative values none.
+	Context int
+}
+
+func (opts *PatchOptions) context() int {
+	if opts == nil || opts.Context == 0 {
+		return DefaultContextLines
+	} else if opts.Context < 0 {
+		return 0
+	}
+	return opts.Context
+}
+
+//FilePatch holds the line-level differences for one changed file.
+//If either side is binary, Binary is set and there are no hunks.
+type FilePatch struct {
+	Change
+	Binary bool
+	Hunks  []Hunk
+}
+
+//DiffBlobs computes the differences between the blobs oldID and
+//newID. The zero SHA1 can be used for a non-existing blob.
+func (repo *Repository) DiffBlobs(oldID, newID SHA1, opts *PatchOptions) (*FilePatch, error) {
+	c := Change{Type: ChangeModified, OldID: oldID, NewID: newID, OldMode: ModeBlob, NewMode: ModeBlob}
+	if oldID.IsZero() {
+		c.Type, c.OldMode = ChangeAdded, 0
+	} else if newID.IsZero() {
+		c.Type, c.NewMode = ChangeDeleted, 0
+	}
+
+	return repo.Patch(c, opts)
+}
+
+//Patch computes the line-level differences for a change, as returned
+//by DiffTree. Type changes are diffed like a modification; see
+//DiffTreePatch for git's behaviour.
+func (repo *Repository) Patch(c Change, opts *PatchOptions) (*FilePatch, error) {
+	fp := &FilePatch{Change: c}
+
+	oldData, err := repo.patchContent(c.OldID, c.OldMode, c.Type == ChangeAdded)
+	if err != nil {
+		return nil, err
+	}
+
+	newData, err := repo.patchContent(c.NewID, c.NewMode, c.Type == ChangeDeleted)
+	if err != nil {
+		return nil, err
+	}
+
+	if IsBinary(oldData) || IsBinary(newData) {
+		fp.Binary = c.OldID != c.NewID
+		return fp, nil
+	}
+
+	if c.OldID != c.NewID {
+		fp.Hunks = DiffText(oldData, newData, opts.context())
+	}
+
+	return fp, nil
+}
+
+//patchContent returns the content of the blob with the given id
+//for diffing. Submodules are shown by the commit they refer to.
+func (repo *Repository) patchContent(id SHA1, mode os.FileMode, absent bool) ([]byte, error) {
+	if absent {
+		return nil, nil
+	} else if mode == ModeGitlink {
+		return []byte(fmt.Sprintf("Subproject commit %s\n", id)), nil
+	}
+
+	return repo.readBlob(id)
+}
+
+//DiffTreePatch compares the trees oldTree and newTree and returns the
+//patches for all changed files. Like git, type changes are split into
+//a deletion and an addition.
+func (repo *Repository) DiffTreePatch(oldTree, newTree SHA1, opts *PatchOptions) ([]*FilePatch, error) {
+	var dopts *DiffOptions
+	if opts != nil {
+		dopts = &opts.DiffOptions
+	}
+
+	changes, err := repo.DiffTree(oldTree, newTree, dopts)
+	if err != nil {
+		return nil, err
+	}
+
+	var patches []*FilePatch
+	for _, c := range changes {
+		parts := []Change{c}
+		if c.Type == ChangeTypeChanged {
+			parts = []Change{
+				{Type: ChangeDeleted, OldPath: c.OldPath, OldMode: c.OldMode, OldID: c.OldID},
+				{Type: ChangeAdded, NewPath: c.NewPath, NewMode: c.NewMode, NewID: c.NewID},
+			}
+		}
+
+		for _, part := range parts {
+			fp, err := repo.Patch(part, opts)
+			if err != nil {
+				return nil, err
+			}
+			patches = append(patches, fp)
+		}
+	}
+
+	return patches, nil
+}
+
+//WritePatch writes the patches in the unified diff format of
+//"git diff". Abbreviated ids are used unless fullIndex is set.
+func WritePatch(w io.Writer, patches []*FilePatch, fullIndex bool) error {
+	bw := bufio.NewWriter(w)
+	for _, fp := range patches {
+		fp.write(bw, fullIndex)
+	}
+	return bw.Flush()
+}
+
+//String returns the patch in the unified diff format.
+func (fp *FilePatch) String() string {
+	var b strings.Builder
+	bw := bufio.NewWriter(&b)
+	fp.write(bw, false)
+	bw.Flush()
+	return b.String()
+}
+
+func (fp *FilePatch) write(w *bufio.Writer, fullIndex bool) {
+	c := fp.Change
+
+	oldPath, newPath := c.OldPath, c.NewPath
+	switch c.Type {
+	case ChangeAdded:
+		oldPath = newPath
+	case ChangeDeleted:
+		newPath = oldPath
+	}
+
+	fmt.Fprintf(w, "diff --git a/%s b/%s\n", oldPath, newPath)
+
+	switch {
+	case c.Type == ChangeAdded:
+		fmt.Fprintf(w, "new file mode %06o\n", uint32(c.NewMode))
+	case c.Type == ChangeDeleted:
+		fmt.Fprintf(w, "deleted file mode %06o\n", uint32(c.OldMode))
+	case c.OldMode != c.NewMode:
+		fmt.Fprintf(w, "old mode %06o\nnew mode %06o\n", uint32(c.OldMode), uint32(c.NewMode))
+	}
+
+	if c.Type == ChangeRenamed {
+		fmt.Fprintf(w, "similarity index %d%%\nrename from %s\nrename to %s\n", c.Similarity, c.OldPath, c.NewPath)
+	}
+
+	if c.OldID != c.NewID {
+		abbrev := func(id SHA1) string {
+			if fullIndex {
+				return id.String()
+			}
+			return id.String()[:7]
+		}
+
+		fmt.Fprintf(w, "index %s..%s", abbrev(c.OldID), abbrev(c.NewID))
+		if c.OldMode == c.NewMode {
+			fmt.Fprintf(w, " %06o", uint32(c.NewMode))
+		}
+		w.WriteString("\n")
+	}
+
+	oldName, newName := "a/"+oldPath, "b/"+newPath
+	if c.Type == ChangeAdded {
+		oldName = "/dev/null"
+	} else if c.Type == ChangeDeleted {
+		newName = "/dev/null"
+	}
+
+	if fp.Binary {
+		fmt.Fprintf(w, "Binary files %s and %s differ\n", oldName, newName)
+		return
+	}
+
+	if len(fp.Hunks) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", oldName, newName)
+	for _, h := range fp.Hunks {
+		h.write(w)
+	}
+}
+
+//String returns the hunk in the unified diff format.
+func (h *Hunk) String() string {
+	var b strings.Builder
+	bw := bufio.NewWriter(&b)
+	h.write(bw)
+	bw.Flush()
+	return b.String()
+}
+
+func (h *Hunk) write(w *bufio.Writer) {
+	hunkRange := func(start, count int) string {
+		if count == 1 {
+			return fmt.Sprintf("%d", start)
+		}
+		return fmt.Sprintf("%d,%d", start, count)
+	}
+
+	fmt.Fprintf(w, "@@ -%s +%s @@", hunkRange(h.OldStart, h.OldLines), hunkRange(h.NewStart, h.NewLines))
+	if h.Section != "" {
+		w.WriteString(" " + h.Section)
+	}
+	w.WriteString("\n")
+
+	for _, l := range h.Lines {
+		w.WriteByte(l.Op.Prefix())
+		w.WriteString(l.Content)
+		if !strings.HasSuffix(l.Content, "\n") {
+			w.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
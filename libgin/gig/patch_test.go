@@ -0,0 +1,211 @@
+package gig
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//gitDiffNoIndex runs "git diff --no-index" on a and b and returns
+//the hunks of the output, i.e. everything after the "+++" line.
+func gitDiffNoIndex(t *testing.T, a, b string, context int) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "gig-diff")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, data := range map[string]string{"a": a, "b": b} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0666); err != nil {
+			t.Fatalf("could not write file: %v", err)
+		}
+	}
+
+	cmd := exec.Command("git", "diff", "--no-index", "--no-color", fmt.Sprintf("-U%d", context), "a", "b")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil && cmd.ProcessState.ExitCode() != 1 {
+		t.Fatalf("git diff failed: %v", err)
+	}
+
+	if pos := bytes.Index(out, []byte("\n+++ ")); pos != -1 {
+		out = out[pos+1:]
+		return string(out[bytes.IndexByte(out, '\n')+1:])
+	}
+	return ""
+}
+
+func formatHunks(hunks []Hunk) string {
+	var b strings.Builder
+	for _, h := range hunks {
+		b.WriteString(h.String())
+	}
+	return b.String()
+}
+
+func TestDiffText(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("[W] git not found. Skipping test")
+	}
+
+	var lines []string
+	for i := 0; i < 30; i++ {
+		lines = append(lines, fmt.Sprintf("line %d\n", i))
+	}
+	text := strings.Join(lines, "")
+
+	edit := func(f func(l []string) []string) string {
+		l := append([]string(nil), lines...)
+		return strings.Join(f(l), "")
+	}
+
+	tests := []struct {
+		a, b string
+	}{
+		{"", ""},
+		{"", text},
+		{text, ""},
+		{text, text},
+		{text, edit(func(l []string) []string { l[0] = "changed\n"; return l })},
+		{text, edit(func(l []string) []string { l[29] = "changed\n"; return l })},
+		{text, edit(func(l []string) []string { return append(l[:10], l[11:]...) })},
+		{text, edit(func(l []string) []string {
+			l[3], l[25] = "changed 3\n", "changed 25\n"
+			return append(l[:15], append([]string{"new a\n", "new b\n"}, l[15:]...)...)
+		})},
+		{text, edit(func(l []string) []string { l[8], l[14] = "x\n", "y\n"; return l })},
+		{text, strings.TrimSuffix(text, "\n")},
+		{"a\nb\nc", "a\nb\nd"},
+		{"Section\n\tone\n\ttwo\n\tthree\n\tfour\n\tfive\n", "Section\n\tone\n\ttwo\n\tthree\n\tfour\n\tsix\n"},
+	}
+
+	for i, tt := range tests {
+		for _, context := range []int{0, 1, 3} {
+			expected := gitDiffNoIndex(t, tt.a, tt.b, context)
+			actual := formatHunks(DiffText([]byte(tt.a), []byte(tt.b), context))
+
+			if actual != expected {
+				t.Fatalf("test %d, context %d: expected:\n%s\ngot:\n%s", i, context, expected, actual)
+			}
+		}
+	}
+}
+
+func TestIsBinary(t *testing.T) {
+	if IsBinary([]byte("text\n")) || !IsBinary([]byte("te\x00xt")) {
+		t.Fatal("binary detection failed")
+	}
+
+	data := append(bytes.Repeat([]byte("a"), binaryCheckSize), 0)
+	if IsBinary(data) {
+		t.Fatal("NUL after the checked size should be ignored")
+	}
+}
+
+func TestDiffTreePatch(t *testing.T) {
+	repo, wt := mkTestRepo(t)
+	a, b := mkDiffCommit(t, repo, wt)
+
+	for _, renames := range []bool{false, true} {
+		opts := &PatchOptions{DiffOptions: DiffOptions{DetectRenames: renames}}
+		patches, err := repo.DiffTreePatch(a, b, opts)
+		if err != nil {
+			t.Fatalf("DiffTreePatch failed: %v", err)
+		}
+
+		var buf bytes.Buffer
+		if err := WritePatch(&buf, patches, true); err != nil {
+			t.Fatalf("WritePatch failed: %v", err)
+		}
+
+		if !renames {
+			expected := gitCmd(t, repo.Path, "diff", "--full-index", "--no-renames", a.String(), b.String())
+			if actual := strings.TrimSpace(buf.String()); actual != expected {
+				t.Fatalf("expected:\n%s\ngot:\n%s", expected, actual)
+			}
+		}
+
+		//the patch must apply to the old tree and yield the new one
+		gitCmd(t, wt, "checkout", "-q", "master~2")
+		patch := filepath.Join(wt, "..", "test.patch")
+		if err := ioutil.WriteFile(patch, buf.Bytes(), 0666); err != nil {
+			t.Fatalf("could not write patch: %v", err)
+		}
+
+		gitCmd(t, wt, "apply", "--index", patch)
+		if tree := gitCmd(t, wt, "write-tree"); tree != b.String() {
+			t.Fatalf("renames: %v, applying the patch yields tree %s, expected %s", renames, tree, b)
+		}
+
+		gitCmd(t, wt, "reset", "-q", "--hard")
+	}
+}
+
+func TestDiffBlobs(t *testing.T) {
+	repo, _ := mkTestRepo(t)
+
+	x := writeBlob(t, repo, "one\ntwo\nthree\n")
+	y := writeBlob(t, repo, "one\n2\nthree\n")
+	z := writeBlob(t, repo, "bin\x00ary")
+
+	fp, err := repo.DiffBlobs(x, y, &PatchOptions{Context: -1})
+	if err != nil {
+		t.Fatalf("DiffBlobs failed: %v", err)
+	}
+
+	if len(fp.Hunks) != 1 || fp.Hunks[0].String() != "@@ -2 +2 @@ one\n-two\n+2\n" {
+		t.Fatalf("unexpected hunks: %q", formatHunks(fp.Hunks))
+	}
+
+	fp, err = repo.DiffBlobs(x, z, nil)
+	if err != nil || !fp.Binary || len(fp.Hunks) != 0 {
+		t.Fatalf("expected binary patch, got %v, %v", fp, err)
+	}
+
+	fp, err = repo.DiffBlobs(SHA1{}, x, nil)
+	if err != nil || fp.Type != ChangeAdded || len(fp.Hunks) != 1 || fp.Hunks[0].NewLines != 3 {
+		t.Fatalf("unexpected patch for added blob: %v, %v", fp, err)
+	}
+}
+
+func TestDiffTextRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+	mk := func() []byte {
+		var b bytes.Buffer
+		for i, n := 0, rnd.Intn(60); i < n; i++ {
+			fmt.Fprintf(&b, "%d\n", rnd.Intn(8))
+		}
+		return b.Bytes()
+	}
+
+	for i := 0; i < 500; i++ {
+		a, b := mk(), mk()
+
+		//with all lines as context, the new lines of the
+		//single hunk must yield b, the old ones a
+		hunks := DiffText(a, b, 1000)
+		var old, new strings.Builder
+		for _, h := range hunks {
+			for _, l := range h.Lines {
+				if l.Op != LineAdded {
+					old.WriteString(l.Content)
+				}
+				if l.Op != LineDeleted {
+					new.WriteString(l.Content)
+				}
+			}
+		}
+
+		if len(hunks) > 1 || (len(hunks) == 1 && (old.String() != string(a) || new.String() != string(b))) {
+			t.Fatalf("diff of %q and %q is wrong: %s", a, b, formatHunks(hunks))
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package gig
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+//pktMaxData is the maximum payload of a single pkt-line.
+const pktMaxData = 65516
+
+//pktKind is the kind of a pkt-line: data or one
+//of the special packets.
+type pktKind int
+
+const (
+	pktData pktKind = iota
+	pktFlush
+	pktDelim
+	pktResponseEnd
+)
+
+//pktReader reads pkt-lines as used by the git protocols, see
+//gitprotocol-common(5). Each line is a 4 digit hex length, including
+//the 4 bytes of the length itself, followed by the data; the lengths
+//0000, 0001 and 0002 denote the flush, delim and response-end packets.
+type pktReader struct {
+	r   io.Reader
+	buf [pktMaxData + 4]byte
+}
+
+func newPktReader(r io.Reader) *pktReader {
+	return &pktReader{r: r}
+}
+
+//readPkt reads the next pkt-line. The returned data is only
+//valid until the next call.
+func (pr *pktReader) readPkt() (pktKind, []byte, error) {
+	_, err := io.ReadFull(pr.r, pr.buf[:4])
+	if err != nil {
+		return pktData, nil, err
+	}
+
+	n, err := strconv.ParseUint(string(pr.buf[:4]), 16, 16)
+	if err != nil {
+		return pktData, nil, fmt.Errorf("git: invalid pkt-line length %q", pr.buf[:4])
+	}
+
+	switch {
+	case n == 0:
+		return pktFlush, nil, nil
+	case n == 1:
+		return pktDelim, nil, nil
+	case n == 2:
+		return pktResponseEnd, nil, nil
+	case n < 4 || n > uint64(len(pr.buf)):
+		return pktData, nil, fmt.Errorf("git: invalid pkt-line length %d", n)
+	}
+
+	data := pr.buf[4:n]
+	_, err = io.ReadFull(pr.r, data)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return pktData, data, err
+}
+
+//readLine reads a data pkt-line and returns its content without
+//the trailing newline. Special packets are returned as kind only.
+func (pr *pktReader) readLine() (pktKind, string, error) {
+	kind, data, err := pr.readPkt()
+	if err != nil || kind != pktData {
+		return kind, "", err
+	}
+
+	if n := len(data); n > 0 && data[n-1] == '\n' {
+		data = data[:n-1]
+	}
+
+	return pktData, string(data), nil
+}
+
+//pktWriter writes pkt-lines. The first error is sticky, i.e.
+//once a write failed, all later writes are no-ops and return
+//the same error.
+type pktWriter struct {
+	w   io.Writer
+	err error
+}
+
+func newPktWriter(w io.Writer) *pktWriter {
+	return &pktWriter{w: w}
+}
+
+func (pw *pktWriter) write(data []byte) error {
+	if pw.err != nil {
+		return pw.err
+	}
+
+	if len(data) > pktMaxData {
+		pw.err = fmt.Errorf("git: pkt-line too long (%d bytes)", len(data))
+		return pw.err
+	}
+
+	_, pw.err = fmt.Fprintf(pw.w, "%04x", len(data)+4)
+	if pw.err == nil {
+		_, pw.err = pw.w.Write(data)
+	}
+
+	return pw.err
+}
+
+//writeLine writes a formatted line, terminated by a newline.
+func (pw *pktWriter) writeLine(format string, args ...interface{}) error {
+	return pw.write([]byte(fmt.Sprintf(format, args...) + "\n"))
+}
+
+func (pw *pktWriter) special(pkt string) error {
+	if pw.err == nil {
+		_, pw.err = io.WriteString(pw.w, pkt)
+	}
+	return pw.err
+}
+
+func (pw *pktWriter) flush() error {
+	return pw.special("0000")
+}
+
+func (pw *pktWriter) delim() error {
+	return pw.special("0001")
+}
+
+//sidebandWriter multiplexes data onto one band of the side-band
+//channel, splitting it into pkt-lines of at most max bytes of data.
+type sidebandWriter struct {
+	pw   *pktWriter
+	band byte
+	max  int
+}
+
+func (sw *sidebandWriter) Write(data []byte) (int, error) {
+	n := 0
+	buf := make([]byte, 0, sw.max+1)
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > sw.max {
+			chunk = chunk[:sw.max]
+		}
+
+		buf = append(append(buf[:0], sw.band), chunk...)
+		if err := sw.pw.write(buf); err != nil {
+			return n, err
+		}
+
+		n += len(chunk)
+		data = data[len(chunk):]
+	}
+
+	return n, nil
+}
@@ -0,0 +1,254 @@
+package gig
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+//ObjectFilter is a filter of a partial clone that omits blobs,
+//see "--filter" in git-rev-list(1). Only the "blob:none" and the
+//"blob:limit=<n>" filters are supported.
+type ObjectFilter struct {
+	//BlobLimit is the size from which on blobs are omitted;
+	//zero omits all blobs ("blob:none").
+	BlobLimit int64
+}
+
+//ParseObjectFilter parses a filter spec like "blob:none" or
+//"blob:limit=1m"; the limit can have a k, m or g suffix.
+func ParseObjectFilter(spec string) (*ObjectFilter, error) {
+	if spec == "blob:none" {
+		return &ObjectFilter{}, nil
+	} else if !strings.HasPrefix(spec, "blob:limit=") {
+		return nil, fmt.Errorf("git: unsupported object filter %q", spec)
+	}
+
+	limit, err := parseConfigInt(spec[11:])
+	if err != nil || limit < 0 {
+		return nil, fmt.Errorf("git: invalid blob limit in object filter %q", spec)
+	}
+
+	return &ObjectFilter{BlobLimit: limit}, nil
+}
+
+//String returns the filter spec.
+func (f *ObjectFilter) String() string {
+	if f.BlobLimit == 0 {
+		return "blob:none"
+	}
+	return fmt.Sprintf("blob:limit=%d", f.BlobLimit)
+}
+
+//OmitsBlob checks if a blob of the given size is omitted.
+func (f *ObjectFilter) OmitsBlob(size int64) bool {
+	return size >= f.BlobLimit
+}
+
+//PartialClone describes the promisor remote of a partial clone,
+//from which the objects that are not present can be fetched.
+type PartialClone struct {
+	//Remote is the name of the promisor remote; it is empty if
+	//the repository has promisor packs, but no promisor remote.
+	Remote string
+
+	//Filter is the filter the objects were omitted with, if any.
+	Filter *ObjectFilter
+
+	//Packs are the paths of the promisor packs, i.e. of the packs
+	//that were fetched from the promisor remote.
+	Packs []string
+}
+
+//PartialClone returns the partial clone settings of the repository,
+//which are taken from the "extensions.partialClone" or "remote.<name>.
+//promisor" and "remote.<name>.partialCloneFilter" settings and the
+//"*.promisor" files of the packs. Returns nil if the repository is
+//not a partial clone.
+func (repo *Repository) PartialClone() (*PartialClone, error) {
+	config, err := repo.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	pc := &PartialClone{}
+	if remote, ok := config.Get("extensions.partialClone"); ok {
+		pc.Remote = remote
+	} else {
+		for _, name := range config.Subsections("remote") {
+			promisor, err := config.Bool("remote."+name+".promisor", false)
+			if err != nil {
+				return nil, err
+			} else if promisor {
+				pc.Remote = name
+				break
+			}
+		}
+	}
+
+	if pc.Remote != "" {
+		if spec, ok := config.Get("remote." + pc.Remote + ".partialCloneFilter"); ok {
+			pc.Filter, err = ParseObjectFilter(spec)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	pc.Packs, err = filepath.Glob(filepath.Join(repo.Path, "objects", "pack", "pack-*.promisor"))
+	if err != nil {
+		return nil, err
+	}
+
+	for i, p := range pc.Packs {
+		pc.Packs[i] = strings.TrimSuffix(p, ".promisor") + ".pack"
+	}
+
+	if pc.Remote == "" && len(pc.Packs) == 0 {
+		return nil, nil
+	}
+
+	return pc, nil
+}
+
+//PromisedObjectError is returned for objects that are not present
+//in a partial clone, but promised by the promisor remote, i.e. they
+//were omitted when the repository was cloned or fetched.
+type PromisedObjectError struct {
+	ID     SHA1
+	Remote string
+	Filter *ObjectFilter
+}
+
+func (e *PromisedObjectError) Error() string {
+	msg := fmt.Sprintf("git: object %s is not present in partial clone", e.ID)
+	if e.Remote != "" {
+		msg += fmt.Sprintf(" (promised by remote %q", e.Remote)
+		if e.Filter != nil {
+			msg += fmt.Sprintf(", filter %s", e.Filter)
+		}
+		msg += ")"
+	}
+
+	return msg
+}
+
+//objectNotFound returns the error for an object that is not present,
+//which is a PromisedObjectError if the object is promised in a
+//partial clone (see promised).
+func (repo *Repository) objectNotFound(id SHA1) error {
+	if pc, err := repo.PartialClone(); err == nil && pc != nil {
+		if ok, err := repo.promised(pc, id); err == nil && ok {
+			return &PromisedObjectError{ID: id, Remote: pc.Remote, Filter: pc.Filter}
+		}
+	}
+
+	return fmt.Errorf("%w: %s", ErrObjectNotFound, id)
+}
+
+//promised checks if the absent object is promised by the promisor
+//remote. Like git, only objects that are referenced by objects in
+//the promisor packs are promised; if the objects were omitted with
+//a blob filter, they must also be referenced as blobs.
+func (repo *Repository) promised(pc *PartialClone, id SHA1) (bool, error) {
+	refs, err := repo.promisorRefs(pc)
+	if err != nil {
+		return false, err
+	}
+
+	otype, ok := refs[id]
+	if ok && pc.Filter != nil {
+		ok = otype == ObjBlob
+	}
+
+	return ok, nil
+}
+
+//promisorRefs returns the objects that are referenced by the objects
+//in the promisor packs, along with the type they are referenced as.
+//The result is kept until the promisor packs change.
+func (repo *Repository) promisorRefs(pc *PartialClone) (map[SHA1]ObjectType, error) {
+	key := strings.Join(pc.Packs, "\n")
+
+	repo.mu.Lock()
+	refs := repo.promisor
+	if repo.promisorKey != key {
+		refs = nil
+	}
+	repo.mu.Unlock()
+
+	if refs != nil {
+		return refs, nil
+	}
+
+	refs = make(map[SHA1]ObjectType)
+	for _, p := range pc.Packs {
+		pi, err := openPackIndex(strings.TrimSuffix(p, ".pack")+".idx", repo.ObjectFormat())
+		if err != nil {
+			return nil, err
+		}
+
+		err = repo.addPromisorRefs(pi, refs)
+		pi.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	repo.mu.Lock()
+	repo.promisor, repo.promisorKey = refs, key
+	repo.mu.Unlock()
+
+	return refs, nil
+}
+
+//addPromisorRefs adds the objects referenced by the objects
+//in the pack to refs.
+func (repo *Repository) addPromisorRefs(pi *PackIndex, refs map[SHA1]ObjectType) error {
+	for i := 0; i < pi.Count(); i++ {
+		var id SHA1
+		err := pi.ReadSHA1(&id, i)
+		if err != nil {
+			return err
+		}
+
+		obj, err := repo.OpenObject(id)
+		if err != nil {
+			return err
+		}
+
+		switch o := obj.(type) {
+		case *Commit:
+			refs[o.Tree] = ObjTree
+			for _, p := range o.Parent {
+				refs[p] = ObjCommit
+			}
+		case *Tree:
+			for o.Next() {
+				e := o.Entry()
+				switch {
+				case e.Mode == ModeGitlink:
+				case e.Mode == ModeTree:
+					refs[e.ID] = ObjTree
+				default:
+					refs[e.ID] = ObjBlob
+				}
+			}
+			err = o.Err()
+		case *Tag:
+			refs[o.Object] = o.ObjType
+		}
+
+		obj.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//Is makes PromisedObjectErrors match ErrObjectNotFound.
+func (e *PromisedObjectError) Is(target error) bool {
+	return target == ErrObjectNotFound
+}
@@ -0,0 +1,128 @@
+package gig
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseObjectFilter(t *testing.T) {
+	tests := []struct {
+		spec  string
+		limit int64
+		err   bool
+	}{
+		{"blob:none", 0, false},
+		{"blob:limit=100", 100, false},
+		{"blob:limit=2k", 2 << 10, false},
+		{"blob:limit=1M", 1 << 20, false},
+		{"blob:limit=3g", 3 << 30, false},
+		{"blob:limit=", 0, true},
+		{"blob:limit=-1", 0, true},
+		{"blob:limit=9007199254740992g", 0, true},
+		{"blob:limit=1x", 0, true},
+		{"tree:0", 0, true},
+	}
+
+	for _, tt := range tests {
+		f, err := ParseObjectFilter(tt.spec)
+		if tt.err {
+			if err == nil {
+				t.Fatalf("%s: expected error", tt.spec)
+			}
+			continue
+		}
+
+		if err != nil || f.BlobLimit != tt.limit {
+			t.Fatalf("%s: unexpected result %v, %v", tt.spec, f, err)
+		}
+
+		if back, err := ParseObjectFilter(f.String()); err != nil || *back != *f {
+			t.Fatalf("%s: filter does not round-trip via %q", tt.spec, f)
+		}
+	}
+
+	f := &ObjectFilter{BlobLimit: 10}
+	if f.OmitsBlob(9) || !f.OmitsBlob(10) {
+		t.Fatal("blobs from the limit on must be omitted")
+	}
+}
+
+func TestPartialClone(t *testing.T) {
+	src, _ := mkTestRepo(t)
+	gitCmd(t, src.Path, "config", "uploadpack.allowFilter", "true")
+
+	if pc, err := src.PartialClone(); err != nil || pc != nil {
+		t.Fatalf("expected no partial clone, got %v, %v", pc, err)
+	}
+
+	path := filepath.Join(filepath.Dir(src.Path), "partial.git")
+	gitCmd(t, filepath.Dir(path), "clone", "-q", "--bare", "--filter=blob:none", "file://"+src.Path, path)
+	repo := &Repository{Path: path}
+
+	pc, err := repo.PartialClone()
+	if err != nil {
+		t.Fatalf("could not read partial clone settings: %v", err)
+	}
+
+	if pc == nil || pc.Remote != "origin" || pc.Filter == nil || pc.Filter.BlobLimit != 0 || len(pc.Packs) != 1 {
+		t.Fatalf("unexpected partial clone settings: %+v", pc)
+	}
+
+	//the trees are present, the blobs are not
+	id, err := ParseSHA1(gitCmd(t, src.Path, "rev-parse", "master:README.md"))
+	if err != nil {
+		t.Fatalf("could not parse id: %v", err)
+	}
+
+	_, err = repo.OpenObject(id)
+	var promised *PromisedObjectError
+	if !errors.As(err, &promised) || promised.ID != id || promised.Remote != "origin" {
+		t.Fatalf("expected PromisedObjectError, got %v", err)
+	}
+
+	if !strings.Contains(err.Error(), "blob:none") {
+		t.Fatalf("expected filter in error message, got %q", err)
+	}
+
+	report, err := repo.Verify()
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	} else if !report.OK() || report.Promised == 0 {
+		t.Fatalf("expected healthy partial clone with promised objects, got:\n%s", report)
+	}
+
+	//only objects that the filter could have omitted are promised
+	if _, err := repo.OpenObject(FormatSHA1.ID([]byte{1})); !errors.Is(err, ErrObjectNotFound) || errors.As(err, &promised) {
+		t.Fatalf("expected ErrObjectNotFound for unknown object, got %v", err)
+	}
+
+	//a commit outside of the promisor packs with a missing tree
+	tree := FormatSHA1.ID([]byte{2})
+	file := filepath.Join(t.TempDir(), "commit")
+	data := "tree " + tree.String() + "\nauthor A <a@b> 0 +0000\ncommitter A <a@b> 0 +0000\n\nbroken\n"
+	if err := ioutil.WriteFile(file, []byte(data), 0666); err != nil {
+		t.Fatalf("could not write commit: %v", err)
+	}
+
+	commit := gitCmd(t, path, "hash-object", "-t", "commit", "-w", "--literally", file)
+	gitCmd(t, path, "update-ref", "refs/heads/broken", commit)
+
+	if _, err := repo.OpenObject(tree); !errors.Is(err, ErrObjectNotFound) || errors.As(err, &promised) {
+		t.Fatalf("expected ErrObjectNotFound for missing tree, got %v", err)
+	}
+
+	report, err = repo.Verify()
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	} else if len(report.Missing) != 1 || report.Missing[0].ID != tree || report.Promised == 0 {
+		t.Fatalf("expected missing tree, got:\n%s", report)
+	}
+
+	//objects that are absent from complete repositories are not promised
+	if _, err := src.OpenObject(FormatSHA1.ID([]byte{1})); err == nil || errors.As(err, &promised) {
+		t.Fatalf("expected generic error, got %v", err)
+	}
+}
@@ -0,0 +1,374 @@
+package gig
+
+import (
+	"container/heap"
+	"path"
+)
+
+//ReachableObject is an object found by ReachableObjects. Path is
+//the path of trees and blobs relative to the root tree of Commit,
+//the commit through which the object was first reached.
+type ReachableObject struct {
+	ID     SHA1
+	Type   ObjectType
+	Path   string
+	Commit SHA1
+}
+
+//ObjectIter iterates over the objects reachable from a set of
+//commits, see ReachableObjects.
+type ObjectIter struct {
+	repo *Repository
+
+	tags    []ReachableObject
+	commits []SHA1
+	seen    map[SHA1]bool
+
+	//trees and blobs of the current commit still to be visited
+	pending []ReachableObject
+
+	obj  ReachableObject
+	err  error
+	done bool
+}
+
+//ReachableObjects returns an iterator over all objects that are
+//reachable from the include commits but not from the exclude ones,
+//like "git rev-list --objects <include> --not <exclude>". Annotated
+//tags are peeled. Commits are returned newest first, each followed
+//by the trees and blobs it introduced. Every object is returned only
+//once and objects are read one at a time, so only the set of visited
+//ids is kept in memory. As with git, trees and blobs are excluded
+//if they are reachable from an excluded commit at the boundary of
+//the walk, i.e. one that is a parent of an included commit.
+func (repo *Repository) ReachableObjects(include, exclude []SHA1) *ObjectIter {
+	it := &ObjectIter{repo: repo, seen: make(map[SHA1]bool)}
+
+	var in, out []SHA1
+	for _, id := range include {
+		commit, err := it.peel(id, true)
+		if err != nil {
+			it.err = err
+			return it
+		}
+		in = append(in, commit)
+	}
+
+	for _, id := range exclude {
+		commit, err := it.peel(id, false)
+		if err != nil {
+			it.err = err
+			return it
+		}
+		out = append(out, commit)
+	}
+
+	commits, edges, err := repo.limitCommits(in, out)
+	if err != nil {
+		it.err = err
+		return it
+	}
+	it.commits = commits
+
+	//everything reachable from the boundary is already known
+	for _, id := range edges {
+		err = it.markUninteresting(id)
+		if err != nil {
+			it.err = err
+			return it
+		}
+	}
+
+	return it
+}
+
+//peel follows annotated tags to the commit they point to; the tags
+//are returned before any commit, if emit is set.
+func (it *ObjectIter) peel(id SHA1, emit bool) (SHA1, error) {
+	for {
+		obj, err := it.repo.OpenObject(id)
+		if err != nil {
+			return id, err
+		}
+		obj.Close()
+
+		switch o := obj.(type) {
+		case *Commit:
+			return id, nil
+		case *Tag:
+			if emit && !it.seen[id] {
+				it.seen[id] = true
+				it.tags = append(it.tags, ReachableObject{ID: id, Type: ObjTag})
+			}
+			id = o.Object
+		default:
+			return id, &ObjectTypeError{ID: id, Type: obj.Type(), Expected: ObjCommit}
+		}
+	}
+}
+
+//markUninteresting marks the objects of the commit's tree as seen.
+func (it *ObjectIter) markUninteresting(commit SHA1) error {
+	node, err := it.repo.OpenCommitNode(commit)
+	if err != nil {
+		return err
+	}
+
+	stack := []SHA1{node.Tree}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if it.seen[id] {
+			continue
+		}
+		it.seen[id] = true
+
+		entries, err := it.repo.readTreeEntries(id)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			switch {
+			case e.Mode == ModeTree:
+				stack = append(stack, e.ID)
+			case e.Mode != ModeGitlink:
+				it.seen[e.ID] = true
+			}
+		}
+	}
+
+	return nil
+}
+
+//Next advances the iterator to the next object, returns false
+//if there are no more objects or an error occurred.
+func (it *ObjectIter) Next() bool {
+	if it.err != nil || it.done {
+		return false
+	}
+
+	if len(it.tags) > 0 {
+		it.obj, it.tags = it.tags[0], it.tags[1:]
+		return true
+	}
+
+	for len(it.pending) > 0 {
+		obj := it.pending[len(it.pending)-1]
+		it.pending = it.pending[:len(it.pending)-1]
+
+		if it.seen[obj.ID] {
+			continue
+		}
+		it.seen[obj.ID] = true
+
+		if obj.Type == ObjTree {
+			it.err = it.pushTree(obj)
+			if it.err != nil {
+				return false
+			}
+		}
+
+		it.obj = obj
+		return true
+	}
+
+	for len(it.commits) > 0 {
+		id := it.commits[0]
+		it.commits = it.commits[1:]
+
+		if it.seen[id] {
+			continue
+		}
+		it.seen[id] = true
+
+		node, err := it.repo.OpenCommitNode(id)
+		if err != nil {
+			it.err = err
+			return false
+		}
+
+		it.pending = append(it.pending, ReachableObject{ID: node.Tree, Type: ObjTree, Commit: id})
+		it.obj = ReachableObject{ID: id, Type: ObjCommit, Commit: id}
+		return true
+	}
+
+	it.done = true
+	return false
+}
+
+//pushTree adds the entries of the tree to the pending objects,
+//in reverse order, so they are visited in order.
+func (it *ObjectIter) pushTree(tree ReachableObject) error {
+	obj, err := it.repo.OpenObject(tree.ID)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	t, ok := obj.(*Tree)
+	if !ok {
+		return &ObjectTypeError{ID: tree.ID, Type: obj.Type(), Expected: ObjTree}
+	}
+
+	var entries []ReachableObject
+	for t.Next() {
+		e := t.Entry()
+		if e.Mode == ModeGitlink || it.seen[e.ID] {
+			continue
+		}
+
+		otype := ObjBlob
+		if e.Mode == ModeTree {
+			otype = ObjTree
+		}
+
+		entries = append(entries, ReachableObject{e.ID, otype, path.Join(tree.Path, e.Name), tree.Commit})
+	}
+
+	if err := t.Err(); err != nil {
+		return err
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		it.pending = append(it.pending, entries[i])
+	}
+
+	return nil
+}
+
+//Object returns the current object.
+func (it *ObjectIter) Object() ReachableObject {
+	return it.obj
+}
+
+//Err returns the error that stopped the iteration, if any.
+func (it *ObjectIter) Err() error {
+	return it.err
+}
+
+//limitCommits returns the commits reachable from include but not
+//from exclude, newest first, and the excluded commits at the boundary
+//(including the exclude commits themselves). Commits are processed by
+//generation number, where available, otherwise by commit date, and
+//the walk stops as soon as only excluded commits are left.
+func (repo *Repository) limitCommits(include, exclude []SHA1) ([]SHA1, []SHA1, error) {
+	cg, err := repo.CommitGraph()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	uninteresting := make(map[SHA1]bool)
+	queued := make(map[SHA1]bool)
+	inQueue := make(map[SHA1]bool)
+	var queue generationQueue
+	interesting := 0
+
+	push := func(id SHA1, excluded bool) error {
+		if excluded && !uninteresting[id] {
+			uninteresting[id] = true
+			if inQueue[id] {
+				interesting--
+			}
+		}
+
+		if queued[id] {
+			return nil
+		}
+
+		node, err := repo.openCommitNode(cg, id)
+		if err != nil {
+			return err
+		}
+
+		queued[id] = true
+		inQueue[id] = true
+		heap.Push(&queue, queueItem{node, len(queued)})
+		if !uninteresting[id] {
+			interesting++
+		}
+		return nil
+	}
+
+	for _, id := range exclude {
+		if err := push(id, true); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, id := range include {
+		if err := push(id, false); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var commits []SHA1
+	var parents = make(map[SHA1][]SHA1)
+	for queue.Len() > 0 && interesting > 0 {
+		node := heap.Pop(&queue).(queueItem).node
+		delete(inQueue, node.ID)
+		excluded := uninteresting[node.ID]
+		if !excluded {
+			interesting--
+			commits = append(commits, node.ID)
+			parents[node.ID] = node.Parent
+		}
+
+		for _, p := range node.Parent {
+			if err := push(p, excluded); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	//commits can still be found to be excluded after they have
+	//been processed, if the commit dates are skewed
+	var res []SHA1
+	edges := append([]SHA1(nil), exclude...)
+	isEdge := make(map[SHA1]bool)
+	for _, id := range commits {
+		if uninteresting[id] {
+			continue
+		}
+
+		res = append(res, id)
+		for _, p := range parents[id] {
+			if uninteresting[p] && !isEdge[p] {
+				isEdge[p] = true
+				edges = append(edges, p)
+			}
+		}
+	}
+
+	return res, edges, nil
+}
+
+//generationQueue is a priority queue of commits that returns commits
+//with higher generation numbers first, then newer ones. Since no commit
+//can be reachable from one with a lower generation number, a commit
+//is processed only after all commits that can reach it.
+type generationQueue []queueItem
+
+func (q generationQueue) Len() int { return len(q) }
+
+func (q generationQueue) Less(i, j int) bool {
+	a, b := q[i].node, q[j].node
+	if a.Generation != b.Generation && a.Generation != 0 && b.Generation != 0 {
+		return a.Generation > b.Generation
+	}
+	return nodeQueue(q).Less(i, j)
+}
+
+func (q generationQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *generationQueue) Push(x interface{}) {
+	*q = append(*q, x.(queueItem))
+}
+
+func (q *generationQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
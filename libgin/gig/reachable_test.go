@@ -0,0 +1,98 @@
+package gig
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestReachableObjects(t *testing.T) {
+	repo, wt := mkTestRepo(t)
+	mkLogHistory(t, wt)
+	gitCmd(t, repo.Path, "tag", "-a", "-m", "annotated", "v1", "side")
+
+	rev := func(name string) SHA1 {
+		id, err := ParseSHA1(gitCmd(t, repo.Path, "rev-parse", name))
+		if err != nil {
+			t.Fatalf("could not parse id: %v", err)
+		}
+		return id
+	}
+
+	//git prints annotated tags with the name given on the command line
+	tag := rev("v1").String()
+
+	tests := []struct {
+		include []string
+		exclude []string
+	}{
+		{[]string{"master"}, nil},
+		{[]string{"master"}, []string{"side"}},
+		{[]string{"side"}, []string{"master~1"}},
+		{[]string{"v1", "master~1"}, []string{"master~3"}},
+		{[]string{"master~2"}, []string{"master"}},
+	}
+
+	check := func(r *Repository) {
+		for _, tt := range tests {
+			var include, exclude []SHA1
+			args := []string{"rev-list", "--objects"}
+			for _, name := range tt.include {
+				include = append(include, rev(name))
+				args = append(args, name)
+			}
+			for _, name := range tt.exclude {
+				exclude = append(exclude, rev(name))
+				args = append(args, "^"+name)
+			}
+
+			var expected []string
+			if out := gitCmd(t, repo.Path, args...); out != "" {
+				for _, l := range strings.Split(out, "\n") {
+					if strings.HasPrefix(l, tag) {
+						l = tag
+					}
+					expected = append(expected, strings.TrimSpace(l))
+				}
+			}
+
+			var actual []string
+			seen := make(map[SHA1]bool)
+			it := r.ReachableObjects(include, exclude)
+			for it.Next() {
+				obj := it.Object()
+				if seen[obj.ID] {
+					t.Fatalf("%v: object %s returned twice", args, obj.ID)
+				}
+				seen[obj.ID] = true
+
+				if obj.Type == ObjCommit && obj.Commit != obj.ID {
+					t.Fatalf("%v: unexpected commit for %s: %s", args, obj.ID, obj.Commit)
+				}
+
+				actual = append(actual, strings.TrimSpace(obj.ID.String()+" "+obj.Path))
+			}
+
+			if err := it.Err(); err != nil {
+				t.Fatalf("%v: iteration failed: %v", args, err)
+			}
+
+			sort.Strings(expected)
+			sort.Strings(actual)
+			if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+				t.Fatalf("%v: unexpected objects:\n%s\nexpected:\n%s", args,
+					strings.Join(actual, "\n"), strings.Join(expected, "\n"))
+			}
+		}
+	}
+
+	check(repo)
+
+	gitCmd(t, repo.Path, "commit-graph", "write", "--reachable")
+	check(&Repository{Path: repo.Path})
+
+	it := repo.ReachableObjects([]SHA1{rev("master^{tree}")}, nil)
+	if it.Next() || it.Err() == nil {
+		t.Fatal("expected error for non-commit object")
+	}
+}
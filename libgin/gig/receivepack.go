@@ -0,0 +1,410 @@
+package gig
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//ReceiveCommand is the update of a single ref requested by a push.
+//Old is the zero SHA1 if the ref is created, New is the zero SHA1
+//if the ref is deleted.
+type ReceiveCommand struct {
+	Name string
+	Old  SHA1
+	New  SHA1
+}
+
+//IsCreate checks if the command creates a new ref.
+func (c ReceiveCommand) IsCreate() bool {
+	return c.Old.IsZero()
+}
+
+//IsDelete checks if the command deletes the ref.
+func (c ReceiveCommand) IsDelete() bool {
+	return c.New.IsZero()
+}
+
+//ReceiveHooks are called while a push is processed, in the same way
+//as the corresponding git hooks (see githooks(5)). All hooks are
+//optional. When they are called, the pushed objects are already
+//stored in the repository, but the refs are not updated yet.
+type ReceiveHooks struct {
+	//PreReceive is called with all commands; if it returns an
+	//error, the push is rejected as a whole.
+	PreReceive func(repo *Repository, commands []ReceiveCommand) error
+
+	//Update is called for each command; if it returns an error,
+	//the update of that ref is rejected.
+	Update func(repo *Repository, command ReceiveCommand) error
+
+	//PostReceive is called with the commands that were carried
+	//out, after the refs have been updated.
+	PostReceive func(repo *Repository, commands []ReceiveCommand)
+}
+
+//ReceivePackHandler accepts pushes via the smart HTTP protocol, i.e.
+//it implements the "info/refs?service=git-receive-pack" and the
+//"git-receive-pack" endpoints of "git http-backend". The pushed pack
+//is stored via IndexPack and the accepted ref updates are carried out
+//in a RefTransaction each, or all in a single one for atomic pushes. It can be used as the ReceivePack of an
+//UploadPackHandler, to serve fetches and pushes with one handler.
+type ReceivePackHandler struct {
+	//Open returns the repository for the path of the request URL,
+	//see UploadPackHandler.Open.
+	Open func(path string) (*Repository, error)
+
+	//Hooks are called while processing a push.
+	Hooks ReceiveHooks
+
+	//Committer returns the identity that is recorded in the reflogs.
+	//If it is nil, "gig" is used.
+	Committer func(r *http.Request) Signature
+}
+
+//ServeHTTP implements http.Handler.
+func (h *ReceivePackHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var base string
+	var advertise bool
+
+	switch p := r.URL.Path; {
+	case strings.HasSuffix(p, "/info/refs") && (r.Method == "GET" || r.Method == "HEAD"):
+		base = strings.TrimSuffix(p, "/info/refs")
+		advertise = true
+
+		if svc := r.URL.Query().Get("service"); svc != "git-receive-pack" {
+			http.Error(w, fmt.Sprintf("service %q not supported", svc), http.StatusForbidden)
+			return
+		}
+	case strings.HasSuffix(p, "/git-receive-pack") && r.Method == "POST":
+		base = strings.TrimSuffix(p, "/git-receive-pack")
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	repo, err := h.Open(base)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer repo.Close()
+
+	w.Header().Set("Cache-Control", "no-cache, max-age=0, must-revalidate")
+
+	if advertise {
+		w.Header().Set("Content-Type", "application/x-git-receive-pack-advertisement")
+		pw := newPktWriter(w)
+		pw.writeLine("# service=git-receive-pack")
+		pw.flush()
+
+		err = advertiseReceivePack(repo, pw)
+		if err != nil && pw.err == nil {
+			pw.writeLine("ERR %v", err)
+		}
+		return
+	}
+
+	body, err := requestBody(r, "application/x-git-receive-pack-request")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer body.Close()
+
+	committer := NewSignature("gig", "", time.Now())
+	if h.Committer != nil {
+		committer = h.Committer(r)
+	}
+
+	rp := &receivePack{repo: repo, hooks: h.Hooks, committer: committer}
+
+	w.Header().Set("Content-Type", "application/x-git-receive-pack-result")
+	pw := newPktWriter(w)
+
+	err = rp.serve(newPktReader(body), body, pw)
+	if err != nil && pw.err == nil {
+		pw.writeLine("ERR %v", err)
+	}
+}
+
+//advertiseReceivePack writes the refs (without HEAD and peeled tags)
+//and the capabilities of receive-pack.
+func advertiseReceivePack(repo *Repository, pw *pktWriter) error {
+	format := repo.ObjectFormat()
+	caps := strings.Join([]string{"report-status", "delete-refs", "atomic", "ofs-delta",
+		"side-band-64k", "quiet", "object-format=" + format.String(), uploadPackAgent}, " ")
+
+	it := repo.Refs()
+	first := true
+	for it.Next() {
+		id, err := it.Ref().Resolve()
+		if err != nil {
+			continue
+		}
+
+		if first {
+			pw.writeLine("%s %s\x00%s", id, refPath(it.Ref()), caps)
+			first = false
+		} else {
+			pw.writeLine("%s %s", id, refPath(it.Ref()))
+		}
+	}
+
+	if err := it.Err(); err != nil {
+		return err
+	}
+
+	if first {
+		pw.writeLine("%s capabilities^{}\x00%s", format.ID(nil), caps)
+	}
+
+	return pw.flush()
+}
+
+//receivePack holds the state of a single receive-pack request.
+type receivePack struct {
+	repo      *Repository
+	hooks     ReceiveHooks
+	committer Signature
+}
+
+//commandStatus is the outcome of a command, an empty
+//reason means the command was carried out.
+type commandStatus struct {
+	ReceiveCommand
+	reason string
+}
+
+//serve reads the commands and the pack, carries out the commands and
+//sends the report. The pack follows the commands in the request as
+//raw data, it is read from body.
+func (rp *receivePack) serve(pr *pktReader, body io.Reader, pw *pktWriter) error {
+	var cmds []*commandStatus
+	caps := make(map[string]bool)
+
+	for first := true; ; first = false {
+		kind, line, err := pr.readLine()
+		if err != nil {
+			return err
+		} else if kind == pktFlush {
+			break
+		}
+
+		if first {
+			if pos := strings.IndexByte(line, 0); pos != -1 {
+				for _, c := range strings.Fields(line[pos+1:]) {
+					caps[c] = true
+				}
+				line = line[:pos]
+			}
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return fmt.Errorf("protocol error: unexpected %q", line)
+		}
+
+		cmd := &commandStatus{ReceiveCommand: ReceiveCommand{Name: fields[2]}}
+		cmd.Old, err = ParseSHA1(fields[0])
+		if err == nil {
+			cmd.New, err = ParseSHA1(fields[1])
+		}
+		if err != nil {
+			return fmt.Errorf("protocol error: unexpected %q", line)
+		} else if format := rp.repo.ObjectFormat(); cmd.Old.Format() != format || cmd.New.Format() != format {
+			return fmt.Errorf("protocol error: expected %s ids in %q", format, line)
+		}
+
+		cmds = append(cmds, cmd)
+	}
+
+	if len(cmds) == 0 {
+		return nil
+	}
+
+	//a pack is sent unless all commands are deletions
+	unpack := "ok"
+	for _, cmd := range cmds {
+		if !cmd.IsDelete() {
+			if _, err := rp.repo.IndexPack(body); err != nil {
+				unpack = err.Error()
+			}
+			break
+		}
+	}
+
+	if unpack != "ok" {
+		for _, cmd := range cmds {
+			cmd.reason = "unpacker error"
+		}
+	} else {
+		rp.execute(cmds, caps["atomic"])
+	}
+
+	if caps["report-status"] {
+		var w io.Writer = pw.w
+		if caps["side-band-64k"] {
+			w = &sidebandWriter{pw: pw, band: 1, max: pktMaxData - 1}
+		}
+
+		report := newPktWriter(w)
+		report.writeLine("unpack %s", unpack)
+		for _, cmd := range cmds {
+			if cmd.reason == "" {
+				report.writeLine("ok %s", cmd.Name)
+			} else {
+				report.writeLine("ng %s %s", cmd.Name, cmd.reason)
+			}
+		}
+
+		if err := report.flush(); err != nil {
+			return err
+		}
+
+		if caps["side-band-64k"] {
+			return pw.flush()
+		}
+	}
+
+	return pw.err
+}
+
+//execute checks the commands, calls the hooks and updates the refs.
+//If atomic is set, all commands fail if any of them fails, and
+//the refs are updated in a single transaction; otherwise each ref
+//is updated on its own and only the failed commands are rejected.
+func (rp *receivePack) execute(cmds []*commandStatus, atomic bool) {
+	var all []ReceiveCommand
+	for _, cmd := range cmds {
+		cmd.reason = rp.check(cmd.ReceiveCommand)
+		all = append(all, cmd.ReceiveCommand)
+	}
+
+	if failed(cmds) && atomic {
+		reject(cmds, "atomic push failed")
+		return
+	}
+
+	if rp.hooks.PreReceive != nil {
+		if err := rp.hooks.PreReceive(rp.repo, all); err != nil {
+			reject(cmds, fmt.Sprintf("pre-receive hook declined: %v", err))
+			return
+		}
+	}
+
+	if rp.hooks.Update != nil {
+		for _, cmd := range cmds {
+			if cmd.reason != "" {
+				continue
+			}
+
+			if err := rp.hooks.Update(rp.repo, cmd.ReceiveCommand); err != nil {
+				cmd.reason = fmt.Sprintf("hook declined: %v", err)
+			}
+		}
+	}
+
+	if failed(cmds) && atomic {
+		reject(cmds, "atomic push failed")
+		return
+	}
+
+	var done []ReceiveCommand
+	if atomic {
+		tx := rp.repo.NewRefTransaction(rp.committer, "push")
+		for _, cmd := range cmds {
+			tx.Add(cmd.update())
+		}
+
+		if err := tx.Commit(); err != nil {
+			reject(cmds, fmt.Sprintf("failed to update ref: %v", err))
+			return
+		}
+
+		done = all
+	} else {
+		//each ref on its own, so that one failure does not
+		//reject the others
+		for _, cmd := range cmds {
+			if cmd.reason != "" {
+				continue
+			}
+
+			tx := rp.repo.NewRefTransaction(rp.committer, "push")
+			tx.Add(cmd.update())
+			if err := tx.Commit(); err != nil {
+				cmd.reason = fmt.Sprintf("failed to update ref: %v", err)
+				continue
+			}
+
+			done = append(done, cmd.ReceiveCommand)
+		}
+	}
+
+	if len(done) != 0 && rp.hooks.PostReceive != nil {
+		rp.hooks.PostReceive(rp.repo, done)
+	}
+}
+
+//update returns the ref update that carries out the command.
+func (cmd *commandStatus) update() RefUpdate {
+	old := cmd.Old
+	return RefUpdate{Name: cmd.Name, Old: &old, New: cmd.New}
+}
+
+//check checks the ref name and that all objects reachable from
+//the new value are present; returns the reason of the failure.
+func (rp *receivePack) check(cmd ReceiveCommand) string {
+	if !strings.HasPrefix(cmd.Name, "refs/") || CheckRefName(cmd.Name) != nil {
+		return "funny refname"
+	} else if cmd.IsDelete() {
+		return ""
+	}
+
+	//objects reachable from the refs are complete
+	var tips []SHA1
+	it := rp.repo.Refs()
+	for it.Next() {
+		if id, err := it.Ref().Resolve(); err == nil {
+			if node, err := rp.repo.OpenCommitNode(id); err == nil {
+				tips = append(tips, node.ID)
+			}
+		}
+	}
+
+	objs := rp.repo.ReachableObjects([]SHA1{cmd.New}, tips)
+	for objs.Next() {
+		if obj := objs.Object(); obj.Type == ObjBlob {
+			if ok, err := rp.repo.HasObject(obj.ID); err != nil || !ok {
+				return "missing necessary objects"
+			}
+		}
+	}
+
+	if objs.Err() != nil {
+		return "missing necessary objects"
+	}
+
+	return ""
+}
+
+func failed(cmds []*commandStatus) bool {
+	for _, cmd := range cmds {
+		if cmd.reason != "" {
+			return true
+		}
+	}
+	return false
+}
+
+//reject fails all commands that have not failed already.
+func reject(cmds []*commandStatus, reason string) {
+	for _, cmd := range cmds {
+		if cmd.reason == "" {
+			cmd.reason = reason
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package gig
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestReceivePack(t *testing.T) {
+	repo, _ := mkTestRepo(t)
+
+	//the hooks run in the goroutines of the server
+	var mu sync.Mutex
+	var received [][]ReceiveCommand
+	var updated []ReceiveCommand
+
+	hooks := ReceiveHooks{
+		PreReceive: func(repo *Repository, cmds []ReceiveCommand) error {
+			mu.Lock()
+			received = append(received, cmds)
+			mu.Unlock()
+
+			for _, c := range cmds {
+				if strings.HasPrefix(c.Name, "refs/tags/") && c.IsDelete() {
+					return fmt.Errorf("tags must not be deleted")
+				}
+			}
+			return nil
+		},
+		Update: func(repo *Repository, cmd ReceiveCommand) error {
+			if cmd.IsCreate() || cmd.IsDelete() {
+				return nil
+			}
+
+			ff, err := repo.IsAncestor(cmd.Old, cmd.New)
+			if err != nil {
+				return err
+			} else if !ff {
+				return fmt.Errorf("no force-push to %s", cmd.Name)
+			}
+			return nil
+		},
+		PostReceive: func(repo *Repository, cmds []ReceiveCommand) {
+			mu.Lock()
+			updated = append(updated, cmds...)
+			mu.Unlock()
+		},
+	}
+
+	open := func(path string) (*Repository, error) {
+		if path != "/repo.git" {
+			return nil, fmt.Errorf("no such repository: %s", path)
+		}
+		return &Repository{Path: repo.Path}, nil
+	}
+
+	srv := httptest.NewServer(&UploadPackHandler{
+		Open:        open,
+		ReceivePack: &ReceivePackHandler{Open: open, Hooks: hooks},
+	})
+	defer srv.Close()
+	url := srv.URL + "/repo.git"
+
+	base := filepath.Dir(repo.Path)
+	wt := filepath.Join(base, "pusher")
+	gitCmd(t, base, "clone", "-q", url, wt)
+
+	commit := func(file, content string) SHA1 {
+		if err := ioutil.WriteFile(filepath.Join(wt, file), []byte(content), 0666); err != nil {
+			t.Fatalf("could not write file: %v", err)
+		}
+		gitCmd(t, wt, "add", "-A")
+		gitCmd(t, wt, "commit", "-q", "-m", "change "+file)
+
+		id, err := ParseSHA1(gitCmd(t, wt, "rev-parse", "HEAD"))
+		if err != nil {
+			t.Fatalf("could not parse id: %v", err)
+		}
+		return id
+	}
+
+	revParse := func(dir, name string) string {
+		out, _ := gitTry(dir, "rev-parse", "--verify", "-q", name)
+		return strings.TrimSpace(out)
+	}
+
+	old := revParse(repo.Path, "master")
+	head := commit("new.txt", "a new file\n")
+	gitCmd(t, wt, "tag", "-a", "-m", "release", "v1")
+	gitCmd(t, wt, "push", "-q", "origin", "master", "v1", "master:refs/heads/other")
+
+	for name, local := range map[string]string{"master": "master", "other": "master", "v1": "v1"} {
+		if revParse(repo.Path, name) != revParse(wt, local) {
+			t.Fatalf("ref %s was not updated", name)
+		}
+	}
+	gitCmd(t, repo.Path, "fsck", "--strict", "--no-dangling")
+
+	mu.Lock()
+	if len(received) != 1 || len(received[0]) != 3 || len(updated) != 3 {
+		t.Fatalf("unexpected hook calls: %v, %v", received, updated)
+	}
+
+	for _, c := range updated {
+		if c.Name == "refs/heads/master" && (c.Old.String() != old || c.New != head) {
+			t.Fatalf("unexpected command for master: %+v", c)
+		}
+	}
+	mu.Unlock()
+
+	//the update hook rejects non-fast-forward updates
+	gitCmd(t, wt, "reset", "-q", "--hard", "HEAD~1")
+	commit("other.txt", "rewritten history\n")
+
+	out, err := gitTry(wt, "push", "--force", "origin", "master")
+	if err == nil || !strings.Contains(out, "no force-push to refs/heads/master") {
+		t.Fatalf("expected force-push to be rejected, got %v:\n%s", err, out)
+	}
+
+	if revParse(repo.Path, "master") != head.String() {
+		t.Fatal("master must not have been changed")
+	}
+
+	//atomic pushes fail as a whole
+	gitCmd(t, wt, "branch", "atomic")
+	out, err = gitTry(wt, "push", "--force", "--atomic", "origin", "master", "atomic")
+	if err == nil || revParse(repo.Path, "refs/heads/atomic") != "" {
+		t.Fatalf("expected atomic push to fail, got %v:\n%s", err, out)
+	}
+
+	//non-atomic pushes are carried out partially
+	out, err = gitTry(wt, "push", "--force", "origin", "master", "atomic")
+	if err == nil || revParse(repo.Path, "refs/heads/atomic") != revParse(wt, "atomic") {
+		t.Fatalf("expected partial push, got %v:\n%s", err, out)
+	}
+
+	//the pre-receive hook rejects the whole push
+	out, err = gitTry(wt, "push", "origin", ":refs/tags/v1", ":other")
+	if err == nil || !strings.Contains(out, "tags must not be deleted") {
+		t.Fatalf("expected push to be rejected, got %v:\n%s", err, out)
+	}
+
+	if revParse(repo.Path, "refs/heads/other") == "" {
+		t.Fatal("branch must not have been deleted")
+	}
+
+	gitCmd(t, wt, "push", "-q", "origin", ":other")
+	if revParse(repo.Path, "refs/heads/other") != "" {
+		t.Fatal("expected branch to be deleted")
+	}
+
+	//a ref that cannot be updated does not fail the others
+	lock := filepath.Join(repo.Path, "refs", "heads", "locked.lock")
+	if err := ioutil.WriteFile(lock, nil, 0666); err != nil {
+		t.Fatalf("could not create lock file: %v", err)
+	}
+
+	mu.Lock()
+	n := len(updated)
+	mu.Unlock()
+	out, err = gitTry(wt, "push", "origin", "atomic:refs/heads/locked", "atomic:refs/heads/free")
+	if err == nil || revParse(repo.Path, "refs/heads/free") != revParse(wt, "atomic") || revParse(repo.Path, "refs/heads/locked") != "" {
+		t.Fatalf("expected partial push, got %v:\n%s", err, out)
+	}
+
+	mu.Lock()
+	if len(updated) != n+1 || updated[n].Name != "refs/heads/free" {
+		t.Fatalf("expected post-receive hook for the updated ref only, got %v", updated[n:])
+	}
+	mu.Unlock()
+	os.Remove(lock)
+
+	gitCmd(t, repo.Path, "fsck", "--strict", "--no-dangling")
+}
+
+//gitTry runs git like gitCmd, but returns the error
+//instead of failing the test.
+func gitTry(dir string, args ...string) (string, error) {
+	args = append([]string{"-c", "user.name=A U Thor", "-c", "user.email=author@example.com"}, args...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = os.Environ()
+
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
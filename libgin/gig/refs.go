@@ -2,14 +2,13 @@ package gig
 
 import (
 	"bufio"
-	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -47,6 +46,19 @@ func (r *ref) Namespace() string {
 	return r.ns
 }
 
+//refPath returns the path of the ref relative to the
+//repository, e.g. "refs/heads/master" or "HEAD".
+func refPath(r Ref) string {
+	switch ns := r.Namespace(); ns {
+	case "#special":
+		return r.Name()
+	case "#branch":
+		return path.Join("refs", "heads", r.Name())
+	default:
+		return path.Join("refs", ns, r.Name())
+	}
+}
+
 func IsBranchRef(r Ref) bool {
 	return r.Namespace() == "#branch"
 }
@@ -56,6 +68,9 @@ func IsBranchRef(r Ref) bool {
 type IDRef struct {
 	ref
 	id SHA1
+
+	peeled    SHA1
+	hasPeeled bool
 }
 
 //Resolve for IDRef returns the stored object
@@ -64,6 +79,14 @@ func (r *IDRef) Resolve() (SHA1, error) {
 	return r.id, nil
 }
 
+//Peeled returns the id of the (non-tag) object that is finally
+//referenced if the ref points to an annotated tag. This is only
+//known for refs stored in the packed-refs file; ok is false if
+//the peeled id is not known.
+func (r *IDRef) Peeled() (id SHA1, ok bool) {
+	return r.peeled, r.hasPeeled
+}
+
 //SymbolicRef is a reference that points
 //to another reference
 type SymbolicRef struct {
@@ -71,20 +94,41 @@ type SymbolicRef struct {
 	Symbol string
 }
 
+//symrefMaxDepth is the maximum number of symbolic refs
+//that are followed when resolving a symbolic ref. It is the
+//same limit that git uses.
+const symrefMaxDepth = 5
+
 //Resolve will resolve the symbolic reference into
-//an object id.
+//an object id, following chains of symbolic refs.
 func (r *SymbolicRef) Resolve() (SHA1, error) {
-	gdir := fmt.Sprintf("--git-dir=%s", r.repo.Path)
+	target, err := r.Target()
+	if err != nil {
+		return SHA1{}, err
+	}
 
-	cmd := exec.Command("git", gdir, "rev-parse", r.Fullname())
-	body, err := cmd.Output()
+	return target.Resolve()
+}
 
-	if err != nil {
-		var id SHA1
-		return id, err
+//Target returns the IDRef the symbolic ref finally points to,
+//following chains of symbolic refs.
+func (r *SymbolicRef) Target() (*IDRef, error) {
+	var cur Ref = r
+	for depth := 0; depth <= symrefMaxDepth; depth++ {
+		sym, ok := cur.(*SymbolicRef)
+		if !ok {
+			return cur.(*IDRef), nil
+		}
+
+		next, err := r.repo.parseRef(sym.Symbol)
+		if err != nil {
+			return nil, fmt.Errorf("git: could not resolve %q: %w", r.Fullname(), err)
+		}
+
+		cur = next
 	}
 
-	return ParseSHA1(string(body))
+	return nil, fmt.Errorf("git: symbolic ref %q nested too deeply", r.Fullname())
 }
 
 func parseRefName(filename string) (name, ns string, err error) {
@@ -130,10 +174,10 @@ func (repo *Repository) parseRef(filename string) (Ref, error) {
 	base := ref{repo, name, ns}
 
 	//now to the actual contents of the ref
-	data, err := ioutil.ReadFile(filepath.Join(repo.Path, filename))
+	data, err := ioutil.ReadFile(repo.refFile(filename))
 	if err != nil {
 		if os.IsNotExist(err) {
-			return repo.findPackedRef(base.Fullname())
+			return repo.findPackedRef(filename)
 		}
 		return nil, err
 	}
@@ -146,44 +190,160 @@ func (repo *Repository) parseRef(filename string) (Ref, error) {
 
 	id, err := ParseSHA1(b)
 	if err == nil {
-		return &IDRef{base, id}, nil
+		return &IDRef{ref: base, id: id}, nil
 	}
 
 	return nil, fmt.Errorf("git: unknown ref type: %q", b)
 }
 
-func (repo *Repository) listRefWithName(name string) (res []Ref) {
-	gdir := fmt.Sprintf("--git-dir=%s", repo.Path)
-	cmd := exec.Command("git", gdir, "show-ref", name)
-	body, err := cmd.Output()
+//listRefWithName returns all refs that match name in the same way
+//"git show-ref <name>" does, i.e. the name must match the full ref
+//name or a trailing part of it, consisting of whole components.
+func (repo *Repository) listRefWithName(name string) (res []Ref, err error) {
+	iter := repo.Refs()
+	for iter.Next() {
+		r := iter.Ref()
+		full := refPath(r)
+		if full == name || strings.HasSuffix(full, "/"+name) {
+			res = append(res, r)
+		}
+	}
+
+	return res, iter.Err()
+}
+
+//RefIter iterates over refs of a repository. The refs are
+//ordered by their full name.
+type RefIter struct {
+	refs []Ref
+	ref  Ref
+	err  error
+}
+
+//Next advances to the next ref. Returns false if there are
+//no refs left or if there was an error; use Err() to decide
+//between the two conditions.
+func (it *RefIter) Next() bool {
+	if it.err != nil || len(it.refs) == 0 {
+		it.ref = nil
+		return false
+	}
+
+	it.ref, it.refs = it.refs[0], it.refs[1:]
+	return true
+}
+
+//Ref returns the current ref.
+func (it *RefIter) Ref() Ref {
+	return it.ref
+}
 
+//Err returns the error encountered while listing the refs, if any.
+func (it *RefIter) Err() error {
+	return it.err
+}
+
+//Refs returns an iterator over all refs below "refs/", both loose
+//and packed ones, where loose refs take precedence over packed refs
+//with the same name. If namespaces are given, only refs in any of
+//these namespaces (see Ref.Namespace(), e.g. "#branch", "tags",
+//"remotes") are returned.
+func (repo *Repository) Refs(namespaces ...string) *RefIter {
+	refs, err := repo.loadRefs()
 	if err != nil {
-		return
+		return &RefIter{err: err}
 	}
 
-	r := bytes.NewBuffer(body)
+	var res []Ref
+	for _, r := range refs {
+		if len(namespaces) == 0 || containsString(namespaces, r.Namespace()) {
+			res = append(res, r)
+		}
+	}
 
-	for {
-		var l string
-		l, err = r.ReadString('\n')
+	return &RefIter{refs: res}
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+//loadRefs reads all loose and packed refs, sorted by name.
+func (repo *Repository) loadRefs() ([]Ref, error) {
+	packed, err := repo.loadPackedRefs()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	byName := make(map[string]Ref, len(packed))
+	for _, r := range packed {
+		byName[refPath(r)] = r
+	}
+
+	loose, err := repo.loadLooseRefs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range loose {
+		byName[refPath(r)] = r
+	}
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	refs := make([]Ref, len(names))
+	for i, name := range names {
+		refs[i] = byName[name]
+	}
+
+	return refs, nil
+}
+
+//loadLooseRefs reads all refs stored as files below "refs/".
+func (repo *Repository) loadLooseRefs() ([]Ref, error) {
+	var refs []Ref
+
+	base := filepath.Join(repo.Path, "refs")
+	err := filepath.Walk(base, func(fn string, fi os.FileInfo, err error) error {
 		if err != nil {
-			break
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		} else if fi.IsDir() || strings.HasSuffix(fn, ".lock") {
+			return nil
 		}
 
-		_, name := split2(l[:len(l)-1], " ")
-		r, err := repo.parseRef(name)
+		rel, err := filepath.Rel(repo.Path, fn)
+		if err != nil {
+			return err
+		}
 
+		r, err := repo.parseRef(filepath.ToSlash(rel))
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "git: could not parse ref with name %q: %v", name, err)
-			continue
+			//like git, we ignore broken refs
+			return nil
 		}
 
-		res = append(res, r)
-	}
+		refs = append(refs, r)
+		return nil
+	})
 
-	return
+	return refs, err
 }
 
+//loadPackedRefs reads all refs in the packed-refs file. The
+//peeled ids (i.e. lines starting with '^', following the ref
+//they belong to) are stored in the corresponding IDRef.
 func (repo *Repository) loadPackedRefs() ([]Ref, error) {
 
 	fd, err := os.Open(filepath.Join(repo.Path, "packed-refs"))
@@ -195,33 +355,48 @@ func (repo *Repository) loadPackedRefs() ([]Ref, error) {
 	r := bufio.NewReader(fd)
 
 	var refs []Ref
+	var last *IDRef
 	for {
 		var l string
 		l, err = r.ReadString('\n')
-		if err != nil {
+		if err != nil && (err != io.EOF || l == "") {
 			break
 		}
 
-		head, tail := split2(l, " ")
-		if tail == "" {
-			//probably a peeled id (i.e. "^SHA1")
-			//TODO: do something with it
+		l = strings.TrimRight(l, "\n")
+		switch {
+		case l == "" || l[0] == '#':
+			//header, i.e. "# pack-refs with: peeled fully-peeled sorted"
+			continue
+		case l[0] == '^':
+			if last == nil {
+				return nil, fmt.Errorf("git: peeled line without ref in packed-refs")
+			}
+
+			last.peeled, err = ParseSHA1(l[1:])
+			if err != nil {
+				return nil, fmt.Errorf("git: invalid peeled line in packed-refs: %v", err)
+			}
+			last.hasPeeled = true
 			continue
 		}
 
-		name, ns, err := parseRefName(tail[:len(tail)-1])
+		last = nil
+
+		head, tail := split2(l, " ")
+		name, ns, err := parseRefName(tail)
 		if err != nil {
-			//TODO: log error, panic?
+			//like git, we ignore broken refs
 			continue
 		}
 
 		id, err := ParseSHA1(head)
 		if err != nil {
-			//TODO: same as above
 			continue
 		}
 
-		refs = append(refs, &IDRef{ref{repo, name, ns}, id})
+		last = &IDRef{ref: ref{repo, name, ns}, id: id}
+		refs = append(refs, last)
 	}
 
 	if err != nil && err != io.EOF {
@@ -233,14 +408,14 @@ func (repo *Repository) loadPackedRefs() ([]Ref, error) {
 
 func (repo *Repository) findPackedRef(name string) (Ref, error) {
 	refs, err := repo.loadPackedRefs()
-	if err != nil {
+	if err != nil && !os.IsNotExist(err) {
 		return nil, err
 	}
 
 	for _, ref := range refs {
-		if ref.Fullname() == name {
+		if refPath(ref) == name {
 			return ref, nil
 		}
 	}
-	return nil, fmt.Errorf("ref with name %q not found", name)
+	return nil, fmt.Errorf("%w: %q", ErrRefNotFound, name)
 }
@@ -0,0 +1,147 @@
+package gig
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func mkRefs(t *testing.T, wt string, repo *Repository) {
+	t.Helper()
+
+	gitCmd(t, wt, "branch", "feature/one")
+	gitCmd(t, wt, "tag", "light")
+	gitCmd(t, wt, "tag", "-a", "-m", "annotated", "v1.0")
+	gitCmd(t, wt, "push", "-q", "bare", "--all")
+	gitCmd(t, wt, "push", "-q", "bare", "--tags")
+	gitCmd(t, repo.Path, "pack-refs", "--all")
+
+	//loose refs that override and extend the packed ones
+	gitCmd(t, wt, "commit", "-q", "--allow-empty", "-m", "loose")
+	gitCmd(t, wt, "branch", "loose")
+	gitCmd(t, wt, "push", "-q", "bare", "master", "loose")
+	gitCmd(t, repo.Path, "symbolic-ref", "refs/remotes/origin/HEAD", "refs/heads/master")
+}
+
+func TestRefs(t *testing.T) {
+	repo, wt := mkTestRepo(t)
+	mkRefs(t, wt, repo)
+
+	var got []string
+	iter := repo.Refs()
+	for iter.Next() {
+		r := iter.Ref()
+		id, err := r.Resolve()
+		if err != nil {
+			t.Fatalf("could not resolve %q: %v", r.Fullname(), err)
+		}
+
+		got = append(got, fmt.Sprintf("%s %s", id, refPath(r)))
+		if idr, ok := r.(*IDRef); ok {
+			if peeled, ok := idr.Peeled(); ok && peeled != id {
+				got = append(got, fmt.Sprintf("%s %s^{}", peeled, refPath(r)))
+			}
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		t.Fatalf("could not list refs: %v", err)
+	}
+
+	want := gitCmd(t, repo.Path, "show-ref", "-d")
+	if strings.Join(got, "\n") != want {
+		t.Fatalf("refs mismatch, got:\n%s\nexpected:\n%s", strings.Join(got, "\n"), want)
+	}
+
+	iter = repo.Refs("tags")
+	n := 0
+	for iter.Next() {
+		if ns := iter.Ref().Namespace(); ns != "tags" {
+			t.Fatalf("unexpected namespace %q", ns)
+		}
+		n++
+	}
+
+	if n != 2 {
+		t.Fatalf("expected 2 tags, got %d", n)
+	}
+}
+
+func TestSymbolicRefResolve(t *testing.T) {
+	repo, wt := mkTestRepo(t)
+	mkRefs(t, wt, repo)
+
+	for _, name := range []string{"HEAD", "refs/remotes/origin/HEAD"} {
+		r, err := repo.parseRef(name)
+		if err != nil {
+			t.Fatalf("could not parse %q: %v", name, err)
+		}
+
+		sym, ok := r.(*SymbolicRef)
+		if !ok {
+			t.Fatalf("expected %q to be a symbolic ref", name)
+		}
+
+		id, err := sym.Resolve()
+		if err != nil {
+			t.Fatalf("could not resolve %q: %v", name, err)
+		}
+
+		if want := gitCmd(t, repo.Path, "rev-parse", name); id.String() != want {
+			t.Fatalf("%q resolved to %s, expected %s", name, id, want)
+		}
+	}
+
+	gitCmd(t, repo.Path, "symbolic-ref", "refs/heads/loop1", "refs/heads/loop2")
+	gitCmd(t, repo.Path, "symbolic-ref", "refs/heads/loop2", "refs/heads/loop1")
+
+	r, err := repo.parseRef("refs/heads/loop1")
+	if err != nil {
+		t.Fatalf("could not parse loop1: %v", err)
+	}
+
+	if _, err = r.Resolve(); err == nil {
+		t.Fatalf("expected error for symbolic ref loop")
+	}
+}
+
+func TestOpenRefAndBranchExists(t *testing.T) {
+	repo, wt := mkTestRepo(t)
+	mkRefs(t, wt, repo)
+
+	r, err := repo.OpenRef("one")
+	if err != nil || r.Name() != "feature/one" || !IsBranchRef(r) {
+		t.Fatalf("OpenRef(one) => %v, %v", r, err)
+	}
+
+	r, err = repo.OpenRef("v1.0")
+	if err != nil || r.Namespace() != "tags" {
+		t.Fatalf("OpenRef(v1.0) => %v, %v", r, err)
+	}
+
+	if _, err = repo.OpenRef("nonexistent"); err == nil {
+		t.Fatalf("expected error for unknown ref")
+	}
+
+	tests := []struct {
+		pattern string
+		exists  bool
+	}{
+		{"master", true},
+		{"feature/one", true},
+		{"feature/*", true},
+		{"one", false},
+		{"v1.0", false},
+	}
+
+	for _, tt := range tests {
+		ok, err := repo.BranchExists(tt.pattern)
+		if err != nil {
+			t.Fatalf("BranchExists(%q) failed: %v", tt.pattern, err)
+		}
+
+		if ok != tt.exists {
+			t.Errorf("BranchExists(%q) => %v, expected %v", tt.pattern, ok, tt.exists)
+		}
+	}
+}
@@ -0,0 +1,483 @@
+package gig
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//RefUpdate describes the change of a single ref.
+type RefUpdate struct {
+	//Name is the full name of the ref, e.g. "refs/heads/master".
+	//If it is a symbolic ref (like "HEAD"), the ref it points to
+	//is updated instead.
+	Name string
+
+	//Old is the value the ref is expected to have. If it is nil,
+	//the current value is not checked, if it is the zero SHA1,
+	//the ref must not exist.
+	Old *SHA1
+
+	//New is the new value of the ref. If it is the zero SHA1,
+	//the ref will be deleted.
+	New SHA1
+}
+
+//RefTransaction updates a set of refs atomically: either all refs
+//are updated, or none. Every ref is locked via a ".lock" file, in
+//the same way git does it, and the expected old values are checked
+//while holding the locks. For every update a reflog entry is
+//written, with the Committer and the Message of the transaction.
+type RefTransaction struct {
+	Committer Signature
+	Message   string
+
+	repo    *Repository
+	updates []RefUpdate
+}
+
+//NewRefTransaction creates a new (empty) RefTransaction.
+func (repo *Repository) NewRefTransaction(committer Signature, message string) *RefTransaction {
+	return &RefTransaction{Committer: committer, Message: message, repo: repo}
+}
+
+//Add adds the update to the transaction.
+func (tx *RefTransaction) Add(update RefUpdate) {
+	tx.updates = append(tx.updates, update)
+}
+
+//UpdateRef sets the ref with the given name to newID, if its
+//current value matches oldID (see RefUpdate for details), and
+//writes a reflog entry.
+func (repo *Repository) UpdateRef(name string, newID SHA1, oldID *SHA1, committer Signature, message string) error {
+	tx := repo.NewRefTransaction(committer, message)
+	tx.Add(RefUpdate{Name: name, Old: oldID, New: newID})
+	return tx.Commit()
+}
+
+//DeleteRef deletes the ref with the given name, if its current
+//value matches oldID (see RefUpdate for details). The ref is also
+//removed from the packed-refs file, and its reflog is deleted.
+func (repo *Repository) DeleteRef(name string, oldID *SHA1) error {
+	tx := repo.NewRefTransaction(Signature{}, "")
+	tx.Add(RefUpdate{Name: name, Old: oldID})
+	return tx.Commit()
+}
+
+//CheckRefName checks if name is a valid full ref name, following
+//the rules of "git check-ref-format".
+func CheckRefName(name string) error {
+	invalid := func(reason string) error {
+		return fmt.Errorf("%w %q: %s", ErrInvalidRefName, name, reason)
+	}
+
+	if name == "HEAD" {
+		return nil
+	} else if !strings.HasPrefix(name, "refs/") {
+		return invalid("must start with refs/")
+	}
+
+	for _, comp := range strings.Split(name, "/") {
+		switch {
+		case comp == "":
+			return invalid("empty component")
+		case comp[0] == '.':
+			return invalid("component starts with '.'")
+		case strings.HasSuffix(comp, ".lock"):
+			return invalid("component ends with .lock")
+		}
+	}
+
+	switch {
+	case strings.HasSuffix(name, "."):
+		return invalid("ends with '.'")
+	case strings.Contains(name, ".."):
+		return invalid("contains '..'")
+	case strings.Contains(name, "@{"):
+		return invalid("contains '@{'")
+	case strings.ContainsAny(name, " ~^:?*[\\\x7f"):
+		return invalid("contains forbidden character")
+	}
+
+	for _, c := range name {
+		if c < 0x20 {
+			return invalid("contains control character")
+		}
+	}
+
+	return nil
+}
+
+//lockFile is a file that is created exclusively next to the
+//file it locks (with the ".lock" suffix) and then either renamed
+//into place (commit) or removed (rollback).
+type lockFile struct {
+	path string
+	fd   *os.File
+	done bool
+}
+
+func createLockFile(path string) (*lockFile, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0777)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if os.IsExist(err) {
+		return nil, fmt.Errorf("%w: unable to create %q: file exists, another git process seems to be running", ErrRefLocked, path+".lock")
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &lockFile{path: path, fd: fd}, nil
+}
+
+func (lf *lockFile) write(data []byte) error {
+	_, err := lf.fd.Write(data)
+	if err != nil {
+		return err
+	}
+
+	return lf.fd.Sync()
+}
+
+func (lf *lockFile) commit() error {
+	err := lf.fd.Close()
+	if err != nil {
+		return err
+	}
+
+	lf.done = true
+	return os.Rename(lf.path+".lock", lf.path)
+}
+
+func (lf *lockFile) rollback() {
+	if lf.done {
+		return
+	}
+
+	lf.done = true
+	lf.fd.Close()
+	os.Remove(lf.path + ".lock")
+}
+
+//refUpdateState is the state of one update during the commit.
+type refUpdateState struct {
+	RefUpdate
+
+	lock    *lockFile
+	old     SHA1
+	existed bool
+	packed  bool
+}
+
+//derefName returns the name of the ref that name finally points
+//to, if name is a symbolic ref, otherwise name itself.
+func (repo *Repository) derefName(name string) string {
+	for depth := 0; depth <= symrefMaxDepth; depth++ {
+		data, err := ioutil.ReadFile(repo.refFile(name))
+		if err != nil || !bytes.HasPrefix(data, []byte("ref:")) {
+			return name
+		}
+
+		name = strings.TrimSpace(string(data[4:]))
+	}
+
+	return name
+}
+
+//readRefValue returns the current value of the ref with the
+//given (full) name and if it is stored in the packed-refs file.
+func (repo *Repository) readRefValue(name string, packed map[string]SHA1) (id SHA1, ok bool, inPacked bool, err error) {
+	pid, inPacked := packed[name]
+
+	data, err := ioutil.ReadFile(repo.refFile(name))
+	if os.IsNotExist(err) {
+		return pid, inPacked, inPacked, nil
+	} else if err != nil {
+		return id, false, inPacked, err
+	}
+
+	id, err = ParseSHA1(string(data))
+	if err != nil {
+		return id, false, inPacked, fmt.Errorf("git: ref %q is not a valid object id", name)
+	}
+
+	return id, true, inPacked, nil
+}
+
+//Commit carries out all updates of the transaction.
+func (tx *RefTransaction) Commit() error {
+	repo := tx.repo
+
+	var states []*refUpdateState
+	seen := make(map[string]bool)
+
+	for _, u := range tx.updates {
+		u.Name = repo.derefName(u.Name)
+
+		if err := CheckRefName(u.Name); err != nil {
+			return err
+		} else if seen[u.Name] {
+			return fmt.Errorf("git: multiple updates for ref %q", u.Name)
+		}
+
+		seen[u.Name] = true
+		states = append(states, &refUpdateState{RefUpdate: u})
+	}
+
+	//locks are always taken in the same order
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Name < states[j].Name
+	})
+
+	defer func() {
+		for _, s := range states {
+			if s.lock != nil {
+				s.lock.rollback()
+			}
+		}
+	}()
+
+	for _, s := range states {
+		lock, err := createLockFile(repo.refFile(s.Name))
+		if err != nil {
+			return err
+		}
+
+		s.lock = lock
+	}
+
+	packed, err := repo.readPackedRefIDs()
+	if err != nil {
+		return err
+	}
+
+	var needsPacked bool
+	for _, s := range states {
+		s.old, s.existed, s.packed, err = repo.readRefValue(s.Name, packed)
+		if err != nil {
+			return err
+		}
+
+		if s.Old != nil {
+			switch {
+			case s.Old.IsZero() && s.existed:
+				return fmt.Errorf("%w: cannot create ref %q: it already exists", ErrStaleRef, s.Name)
+			case !s.Old.IsZero() && !s.existed:
+				return fmt.Errorf("%w: cannot update ref %q: it does not exist", ErrStaleRef, s.Name)
+			case !s.Old.IsZero() && *s.Old != s.old:
+				return fmt.Errorf("%w: cannot update ref %q: is at %s but expected %s", ErrStaleRef, s.Name, s.old, *s.Old)
+			}
+		}
+
+		if s.isDelete() {
+			if !s.existed {
+				return fmt.Errorf("%w: cannot delete ref %q", ErrRefNotFound, s.Name)
+			}
+			needsPacked = needsPacked || s.packed
+		}
+	}
+
+	//deletions of packed refs require rewriting the packed-refs file,
+	//which is prepared (and locked) before any ref is changed
+	var packedLock *lockFile
+	if needsPacked {
+		packedLock, err = tx.preparePackedRefs()
+		if err != nil {
+			return err
+		}
+		defer packedLock.rollback()
+	}
+
+	for _, s := range states {
+		if s.isDelete() {
+			continue
+		}
+
+		err = s.lock.write([]byte(s.New.String() + "\n"))
+		if err != nil {
+			return err
+		}
+	}
+
+	//point of no return
+	if packedLock != nil {
+		err = packedLock.commit()
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, s := range states {
+		if s.isDelete() {
+			err = os.Remove(repo.refFile(s.Name))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+
+			s.lock.rollback()
+			s.lock = nil
+			repo.deleteReflog(s.Name)
+			repo.pruneRefDirs(s.Name)
+			continue
+		}
+
+		err = s.lock.commit()
+		if err != nil {
+			return err
+		}
+		s.lock = nil
+
+		err = tx.writeReflogs(s)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//pruneRefDirs removes the now empty parent directories of
+//the deleted ref with the given name.
+func (repo *Repository) pruneRefDirs(name string) {
+	for _, base := range []string{repo.Path, filepath.Join(repo.Path, "logs")} {
+		dir := filepath.Dir(filepath.Join(base, filepath.FromSlash(name)))
+		stop := filepath.Join(base, "refs")
+
+		for dir != stop && strings.HasPrefix(dir, stop) {
+			if os.Remove(dir) != nil {
+				break
+			}
+			dir = filepath.Dir(dir)
+		}
+	}
+}
+
+func (s *refUpdateState) isDelete() bool {
+	return s.New.IsZero()
+}
+
+//readPackedRefIDs returns the ids of all packed refs by name.
+func (repo *Repository) readPackedRefIDs() (map[string]SHA1, error) {
+	refs, err := repo.loadPackedRefs()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	ids := make(map[string]SHA1, len(refs))
+	for _, r := range refs {
+		ids[refPath(r)], _ = r.Resolve()
+	}
+
+	return ids, nil
+}
+
+//preparePackedRefs locks the packed-refs file and writes the
+//new contents, without the refs that are deleted by the
+//transaction, into the lock file.
+func (tx *RefTransaction) preparePackedRefs() (*lockFile, error) {
+	path := filepath.Join(tx.repo.Path, "packed-refs")
+
+	lock, err := createLockFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	deleted := make(map[string]bool)
+	for _, u := range tx.updates {
+		if u.New.IsZero() {
+			deleted[tx.repo.derefName(u.Name)] = true
+		}
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		lock.rollback()
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	skip := false
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		l := s.Text()
+		switch {
+		case strings.HasPrefix(l, "^"):
+			//peeled line belonging to the previous ref
+		case strings.HasPrefix(l, "#"):
+			skip = false
+		default:
+			_, name := split2(l, " ")
+			skip = deleted[name]
+		}
+
+		if !skip {
+			buf.WriteString(l + "\n")
+		}
+	}
+
+	err = lock.write(buf.Bytes())
+	if err != nil {
+		lock.rollback()
+		return nil, err
+	}
+
+	return lock, nil
+}
+
+func (repo *Repository) reflogPath(name string) string {
+	return filepath.Join(repo.refDir(name), "logs", filepath.FromSlash(name))
+}
+
+func (repo *Repository) deleteReflog(name string) {
+	os.Remove(repo.reflogPath(name))
+}
+
+//appendReflog appends a single entry to the reflog of the
+//ref with the given name. Format of an entry:
+//[old sha1][space][new sha1][space][signature][tab][message][\n]
+func (repo *Repository) appendReflog(name string, old, new SHA1, who Signature, msg string) error {
+	path := repo.reflogPath(name)
+	err := os.MkdirAll(filepath.Dir(path), 0777)
+	if err != nil {
+		return err
+	}
+
+	fd, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+
+	//the message must be a single line
+	msg = strings.Join(strings.Fields(msg), " ")
+	_, err = fmt.Fprintf(fd, "%s %s %s\t%s\n", old, new, who, msg)
+
+	if cerr := fd.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+//writeReflogs writes the reflog entry for the update, and, if HEAD
+//points to the updated ref, also for HEAD (like git does).
+func (tx *RefTransaction) writeReflogs(s *refUpdateState) error {
+	repo := tx.repo
+	err := repo.appendReflog(s.Name, s.old, s.New, tx.Committer, tx.Message)
+	if err != nil {
+		return err
+	}
+
+	if s.Name != "HEAD" && repo.derefName("HEAD") == s.Name {
+		return repo.appendReflog("HEAD", s.old, s.New, tx.Committer, tx.Message)
+	}
+
+	return nil
+}
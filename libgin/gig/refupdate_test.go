@@ -0,0 +1,142 @@
+package gig
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRefUpdate(t *testing.T) {
+	repo, wt := mkTestRepo(t)
+	mkRefs(t, wt, repo)
+
+	who := NewSignature("A U Thor", "author@example.com", time.Unix(1462210432, 0).In(time.FixedZone("", 7200)))
+	master, _ := ParseSHA1(gitCmd(t, repo.Path, "rev-parse", "master"))
+	parent, _ := ParseSHA1(gitCmd(t, repo.Path, "rev-parse", "master^"))
+
+	var zero SHA1
+
+	//create
+	err := repo.UpdateRef("refs/heads/new", parent, &zero, who, "create new")
+	if err != nil {
+		t.Fatalf("could not create ref: %v", err)
+	}
+
+	if out := gitCmd(t, repo.Path, "rev-parse", "refs/heads/new"); out != parent.String() {
+		t.Fatalf("new ref points to %s, expected %s", out, parent)
+	}
+
+	//create again must fail
+	if err = repo.UpdateRef("refs/heads/new", master, &zero, who, "again"); err == nil {
+		t.Fatalf("expected error when creating existing ref")
+	}
+
+	//compare and swap with wrong old value
+	if err = repo.UpdateRef("refs/heads/new", master, &master, who, "wrong"); err == nil {
+		t.Fatalf("expected error for old value mismatch")
+	}
+
+	//correct old value
+	if err = repo.UpdateRef("refs/heads/new", master, &parent, who, "fast-forward"); err != nil {
+		t.Fatalf("could not update ref: %v", err)
+	}
+
+	reflog := gitCmd(t, repo.Path, "reflog", "show", "--format=%H %gs", "refs/heads/new")
+	want := master.String() + " fast-forward\n" + parent.String() + " create new"
+	if reflog != want {
+		t.Fatalf("unexpected reflog:\n%s\nexpected:\n%s", reflog, want)
+	}
+
+	logs, _ := ioutil.ReadFile(filepath.Join(repo.Path, "logs", "refs", "heads", "new"))
+	if !strings.Contains(string(logs), "<author@example.com> 1462210432 +0200\tcreate new") {
+		t.Fatalf("unexpected reflog entry: %s", logs)
+	}
+
+	//locked refs must not be updated
+	lock := filepath.Join(repo.Path, "refs", "heads", "new.lock")
+	if err = ioutil.WriteFile(lock, nil, 0666); err != nil {
+		t.Fatalf("could not create lock: %v", err)
+	}
+
+	if err = repo.UpdateRef("refs/heads/new", parent, nil, who, "locked"); err == nil {
+		t.Fatalf("expected error for locked ref")
+	}
+
+	if err = repo.DeleteRef("refs/heads/new", nil); err == nil {
+		t.Fatalf("expected error for locked ref")
+	}
+
+	//updates via HEAD go to master and are logged for both
+	if err = repo.UpdateRef("HEAD", parent, &master, who, "reset"); err != nil {
+		t.Fatalf("could not update HEAD: %v", err)
+	}
+
+	if out := gitCmd(t, repo.Path, "rev-parse", "refs/heads/master"); out != parent.String() {
+		t.Fatalf("master points to %s, expected %s", out, parent)
+	}
+
+	if out := gitCmd(t, repo.Path, "reflog", "show", "--format=%gs", "HEAD"); out != "reset" {
+		t.Fatalf("unexpected HEAD reflog: %q", out)
+	}
+
+	//delete packed refs
+	light, _ := ParseSHA1(gitCmd(t, repo.Path, "rev-parse", "refs/tags/light"))
+	if err = repo.DeleteRef("refs/tags/light", &light); err != nil {
+		t.Fatalf("could not delete packed ref: %v", err)
+	}
+
+	if err = repo.DeleteRef("refs/heads/feature/one", nil); err != nil {
+		t.Fatalf("could not delete packed ref: %v", err)
+	}
+
+	out := gitCmd(t, repo.Path, "show-ref")
+	if strings.Contains(out, "refs/tags/light") || strings.Contains(out, "feature/one") {
+		t.Fatalf("deleted refs still present:\n%s", out)
+	}
+
+	if !strings.Contains(out, "refs/tags/v1.0") {
+		t.Fatalf("unrelated packed ref was deleted:\n%s", out)
+	}
+
+	if err = repo.DeleteRef("refs/tags/light", nil); err == nil {
+		t.Fatalf("expected error when deleting non-existing ref")
+	}
+
+	if err = repo.UpdateRef("refs/heads/bad..name", master, nil, who, ""); err == nil {
+		t.Fatalf("expected error for invalid ref name")
+	}
+}
+
+func TestRefTransactionAtomic(t *testing.T) {
+	repo, _ := mkTestRepo(t)
+
+	who := NewSignature("A U Thor", "author@example.com", time.Unix(1462210432, 0))
+	master, _ := ParseSHA1(gitCmd(t, repo.Path, "rev-parse", "master"))
+	var zero SHA1
+
+	tx := repo.NewRefTransaction(who, "atomic")
+	tx.Add(RefUpdate{Name: "refs/heads/a", Old: &zero, New: master})
+	tx.Add(RefUpdate{Name: "refs/heads/master", Old: &zero, New: master})
+
+	if err := tx.Commit(); err == nil {
+		t.Fatalf("expected transaction to fail")
+	}
+
+	if out := gitCmd(t, repo.Path, "for-each-ref", "refs/heads/a"); out != "" {
+		t.Fatalf("ref of failed transaction was created: %s", out)
+	}
+
+	tx = repo.NewRefTransaction(who, "atomic")
+	tx.Add(RefUpdate{Name: "refs/heads/a", Old: &zero, New: master})
+	tx.Add(RefUpdate{Name: "refs/tags/b", Old: &zero, New: master})
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("could not commit transaction: %v", err)
+	}
+
+	if out := gitCmd(t, repo.Path, "for-each-ref", "--format=%(refname)", "refs/heads/a", "refs/tags/b"); out != "refs/heads/a\nrefs/tags/b" {
+		t.Fatalf("unexpected refs after transaction: %s", out)
+	}
+}
@@ -3,6 +3,7 @@ package gig
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -11,11 +12,41 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 )
 
 //Repository represents an on disk git repository.
 type Repository struct {
+	//Path is the git directory, which contains the objects
+	//and refs; for non-bare repositories the ".git" directory.
 	Path string
+
+	//WorkTree is the path of the work tree, empty for
+	//bare repositories.
+	WorkTree string
+
+	//GitDir is the git directory of a linked work tree (see
+	//git-worktree(1)), which contains its HEAD and index, while
+	//the objects and refs are shared in Path. Empty otherwise.
+	GitDir string
+
+	mu         sync.Mutex
+	format     *ObjectFormat
+	packs      *packSet
+	deltaBases *deltaBaseCache
+	objects    *objectCache
+	maxObject  int64
+
+	graph      *CommitGraph
+	graphPath  string
+	graphStamp time.Time
+
+	shallow      map[SHA1]bool
+	shallowStamp time.Time
+
+	promisor    map[SHA1]ObjectType
+	promisorKey string
 }
 
 //InitBareRepository creates a bare git repository at path.
@@ -38,46 +69,216 @@ func InitBareRepository(path string) (*Repository, error) {
 
 //IsBareRepository checks if path is a bare git repository.
 func IsBareRepository(path string) bool {
-
-	cmd := exec.Command("git", fmt.Sprintf("--git-dir=%s", path), "rev-parse", "--is-bare-repository")
-	body, err := cmd.Output()
-
-	if err != nil {
+	if !isGitDir(path) {
 		return false
 	}
 
-	status := strings.Trim(string(body), "\n ")
-	return status == "true"
+	bare, err := (&Repository{Path: path}).IsBare()
+	return err == nil && bare
 }
 
-//OpenRepository opens the repository at path. Currently
-//verifies that it is a (bare) repository and returns an
-//error if the check fails.
+//isGitDir checks if path looks like a git directory.
+func isGitDir(path string) bool {
+	for _, name := range []string{"HEAD", "objects", "refs"} {
+		if _, err := os.Stat(filepath.Join(path, name)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+//OpenRepository opens the repository at path, which is either a
+//git directory (bare or not) or a work tree, whose ".git" is the
+//git directory or a file pointing to it, like for linked work trees
+//and submodules. Returns ErrNotRepository if path is neither.
 func OpenRepository(path string) (*Repository, error) {
 	path, err := filepath.Abs(path)
 	if err != nil {
 		return nil, fmt.Errorf("git: could not determine absolute path")
 	}
 
-	if !IsBareRepository(path) {
-		return nil, fmt.Errorf("git: not a bare repository")
+	repo, err := openRepository(path)
+	if err != nil {
+		return nil, err
 	}
 
-	return &Repository{Path: path}, nil
+	_, err = repo.objectFormat()
+	if err != nil {
+		return nil, err
+	}
+
+	return repo, nil
+}
+
+//ObjectFormat returns the object format of the repository, i.e.
+//the hash function for object ids, which is SHA-1 unless the
+//"extensions.objectFormat" config option says otherwise.
+func (repo *Repository) ObjectFormat() ObjectFormat {
+	format, _ := repo.objectFormat()
+	return format
+}
+
+func (repo *Repository) objectFormat() (ObjectFormat, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if repo.format != nil {
+		return *repo.format, nil
+	}
+
+	config, err := repo.readConfig()
+	if err != nil {
+		return FormatSHA1, err
+	}
+
+	format := FormatSHA1
+	if value, ok := config.Get("extensions.objectFormat"); ok {
+		format, err = ParseObjectFormat(value)
+		if err != nil {
+			return FormatSHA1, err
+		}
+	}
+
+	repo.format = &format
+	return format, nil
+}
+
+func openRepository(path string) (*Repository, error) {
+	if isGitDir(path) {
+		repo := &Repository{Path: path}
+		bare, err := repo.IsBare()
+		if err != nil || bare {
+			return repo, err
+		}
+
+		repo.WorkTree, err = repo.configWorkTree()
+		return repo, err
+	}
+
+	gitDir := filepath.Join(path, ".git")
+	fi, err := os.Stat(gitDir)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrNotRepository, path)
+	} else if err != nil {
+		return nil, err
+	} else if !fi.IsDir() {
+		gitDir, err = readGitFile(gitDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	repo := &Repository{Path: gitDir, WorkTree: path}
+
+	//linked work trees share the objects and refs
+	common, err := ioutil.ReadFile(filepath.Join(gitDir, "commondir"))
+	if err == nil {
+		dir := strings.TrimSpace(string(common))
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(gitDir, dir)
+		}
+		repo.Path, repo.GitDir = filepath.Clean(dir), gitDir
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if !isGitDir(repo.Path) {
+		return nil, fmt.Errorf("%w: %s", ErrNotRepository, gitDir)
+	}
+
+	return repo, nil
+}
+
+//readGitFile reads a ".git" file, which contains the path of
+//the git directory as "gitdir: <path>".
+func readGitFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	line := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(line, "gitdir: ") {
+		return "", fmt.Errorf("%w: invalid gitfile format: %s", ErrNotRepository, path)
+	}
+
+	dir := strings.TrimPrefix(line, "gitdir: ")
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(filepath.Dir(path), dir)
+	}
+
+	return filepath.Clean(dir), nil
+}
+
+//configWorkTree returns the work tree of a non-bare git directory,
+//which is "core.worktree" if set, otherwise its parent directory.
+func (repo *Repository) configWorkTree() (string, error) {
+	config, err := repo.readConfig()
+	if err != nil {
+		return "", err
+	}
+
+	wt, ok := config.Get("core.worktree")
+	if !ok {
+		return filepath.Dir(repo.Path), nil
+	} else if !filepath.IsAbs(wt) {
+		wt = filepath.Join(repo.Path, wt)
+	}
+
+	return filepath.Clean(wt), nil
 }
 
 //DiscoverRepository returns the git repository that contains the
 //current working directory, or and error if the current working
-//dir does not lie inside one.
+//dir does not lie inside one. Like git, it checks the directory and
+//its parents for a ".git" or for being a git directory.
 func DiscoverRepository() (*Repository, error) {
-	cmd := exec.Command("git", "rev-parse", "--git-dir")
-	data, err := cmd.Output()
+	cwd, err := os.Getwd()
 	if err != nil {
 		return nil, err
 	}
 
-	path := strings.Trim(string(data), "\n ")
-	return &Repository{Path: path}, nil
+	for dir := cwd; ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil || isGitDir(dir) {
+			return OpenRepository(dir)
+		}
+
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	return nil, fmt.Errorf("%w (or any of the parent directories): %s", ErrNotRepository, cwd)
+}
+
+//gitDir returns the git directory of the work tree, i.e.
+//GitDir for linked work trees and Path otherwise.
+func (repo *Repository) gitDir() string {
+	if repo.GitDir != "" {
+		return repo.GitDir
+	}
+	return repo.Path
+}
+
+//refFile returns the path of the file of the ref with the given
+//name. Refs that are specific to a work tree, like HEAD, are in
+//its git directory, all others in Path.
+func (repo *Repository) refFile(name string) string {
+	return filepath.Join(repo.refDir(name), filepath.FromSlash(name))
+}
+
+func (repo *Repository) refDir(name string) string {
+	for _, prefix := range []string{"refs/bisect/", "refs/worktree/", "refs/rewritten/"} {
+		if strings.HasPrefix(name, prefix) {
+			return repo.gitDir()
+		}
+	}
+
+	if isPseudoRef(name) {
+		return repo.gitDir()
+	}
+
+	return repo.Path
 }
 
 //ReadDescription returns the contents of the description file.
@@ -108,7 +309,29 @@ func (repo *Repository) DeleteCollaborator(username string) error {
 }
 
 //OpenObject returns the git object for a give id (SHA1).
+//If the object is not present, the error wraps ErrObjectNotFound,
+//if it cannot be read, it is a *CorruptObjectError. Commits and
+//trees are cached, see SetObjectCacheLimit.
 func (repo *Repository) OpenObject(id SHA1) (Object, error) {
+	cache := repo.objectCache()
+	if obj, ok := cache.get(id); ok {
+		return obj, nil
+	}
+
+	obj, err := repo.openObject(id)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err = cache.add(id, obj)
+	if err != nil {
+		return nil, repo.corruptObject(id, err)
+	}
+
+	return obj, nil
+}
+
+func (repo *Repository) openObject(id SHA1) (Object, error) {
 	obj, err := repo.openRawObject(id)
 
 	if err != nil {
@@ -116,80 +339,150 @@ func (repo *Repository) OpenObject(id SHA1) (Object, error) {
 	}
 
 	if IsStandardObject(obj.otype) {
-		return parseObject(obj)
+		o, err := parseObject(obj)
+		if err != nil {
+			return nil, repo.corruptObject(id, err)
+		}
+		return o, nil
 	}
 
 	//not a standard object, *must* be a delta object,
 	// we know of no other types
 	if !IsDeltaObject(obj.otype) {
-		return nil, fmt.Errorf("git: unsupported object")
+		obj.Close()
+		return nil, repo.corruptObject(id, fmt.Errorf("unsupported object type %d", obj.otype))
 	}
 
 	delta, err := parseDelta(obj)
 	if err != nil {
-		return nil, err
+		return nil, repo.corruptObject(id, err)
 	}
 
-	chain, err := buildDeltaChain(delta, repo)
-
+	o, err := repo.resolveDelta(delta)
 	if err != nil {
-		return nil, err
+		return nil, repo.corruptObject(id, err)
 	}
 
-	//TODO: check depth, and especially expected memory usage
-	// beofre actually patching it
-
-	return chain.resolve()
+	return o, nil
 }
 
 func (repo *Repository) openRawObject(id SHA1) (gitObject, error) {
-	idstr := id.String()
-	opath := filepath.Join(repo.Path, "objects", idstr[:2], idstr[2:])
-
-	obj, err := openRawObject(opath)
+	obj, err := openRawObject(repo.looseObjectPath(id))
 
+	var perr *os.PathError
 	if err == nil {
+		obj.format = repo.ObjectFormat()
 		return obj, nil
-	} else if err != nil && !os.IsNotExist(err) {
+	} else if !errors.As(err, &perr) {
+		return obj, &CorruptObjectError{ID: id, Offset: -1, Err: err}
+	} else if !os.IsNotExist(err) {
 		return obj, err
 	}
 
-	indicies := repo.loadPackIndices()
-
-	for _, f := range indicies {
+	pf, off, found, err := repo.packSet().find(id)
+	if err != nil {
+		return gitObject{}, err
+	} else if found {
+		defer pf.release()
 
-		idx, err := PackIndexOpen(f)
+		obj, err = pf.readRawObject(off)
 		if err != nil {
-			continue
+			return obj, &CorruptObjectError{ID: id, Pack: pf.Name(), Offset: off, Err: err}
 		}
+		return obj, nil
+	}
 
-		//TODO: we should leave index files open,
-		defer idx.Close()
+	return gitObject{}, repo.objectNotFound(id)
+}
 
-		off, err := idx.FindOffset(id)
+//objectInfo returns the type and size of the object, which are
+//read from the object headers only: for deltas, the size is taken
+//from the delta and the type from the base at the end of the delta
+//chain, without resolving (and inflating) any of the deltas.
+func (repo *Repository) objectInfo(id SHA1) (ObjectType, int64, error) {
+	obj, err := repo.openRawObject(id)
+	if err != nil {
+		return 0, 0, err
+	}
 
+	size := int64(-1)
+	for IsDeltaObject(obj.otype) {
+		d, err := parseDelta(obj)
 		if err != nil {
-			continue
+			return 0, 0, repo.corruptObject(id, err)
 		}
 
-		pf, err := idx.OpenPackFile()
-		if err != nil {
-			return gitObject{}, err
+		if size < 0 {
+			size = d.SizeTarget
 		}
 
-		obj, err := pf.readRawObject(off)
+		pf := d.pf
+		pf.acquire()
+		d.Close()
+
+		if d.otype == ObjOFSDelta {
+			obj, err = pf.readRawObject(d.BaseOff)
+		} else {
+			obj, err = repo.openRawObject(d.BaseRef)
+		}
+		pf.release()
 
 		if err != nil {
-			return gitObject{}, err
+			return 0, 0, repo.corruptObject(id, err)
 		}
+	}
+	obj.Close()
 
-		return obj, nil
+	if !IsStandardObject(obj.otype) {
+		return 0, 0, repo.corruptObject(id, fmt.Errorf("unsupported object type %d", obj.otype))
+	} else if size < 0 {
+		size = obj.size
+	}
+
+	return obj.otype, size, nil
+}
+
+//Close closes the pack files and indices the repository keeps open
+//and clears its caches. It must not be called while the repository
+//or objects opened from it are in use; afterwards, the repository
+//can be used again, which re-opens the files as needed.
+func (repo *Repository) Close() error {
+	repo.mu.Lock()
+	packs, objects, deltaBases := repo.packs, repo.objects, repo.deltaBases
+	repo.packs = nil
+	repo.graph = nil
+	repo.shallow = nil
+	repo.promisor = nil
+	repo.mu.Unlock()
+
+	if packs != nil {
+		packs.close()
+	}
+
+	if objects != nil {
+		objects.clear()
+	}
+
+	if deltaBases != nil {
+		deltaBases.clear()
+	}
+
+	return nil
+}
+
+//packSet returns the set of (open) pack indices of
+//the repository, which is created on first use.
+func (repo *Repository) packSet() *packSet {
+	format := repo.ObjectFormat()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if repo.packs == nil {
+		repo.packs = newPackSet(filepath.Join(repo.Path, "objects", "pack"), format)
 	}
 
-	// from inspecting the os.isNotExist source it
-	// seems that if we have "not found" in the message
-	// os.IsNotExist() report true, which is what we want
-	return gitObject{}, fmt.Errorf("git: object not found")
+	return repo.packs
 }
 
 func (repo *Repository) loadPackIndices() []string {
@@ -204,15 +497,18 @@ func (repo *Repository) loadPackIndices() []string {
 }
 
 //OpenRef returns the Ref with the given name or an error
-//if either no maching could be found or in case the match
-//was not unique.
+//if either no maching could be found (ErrRefNotFound) or in
+//case the match was not unique (*AmbiguousRefError).
 func (repo *Repository) OpenRef(name string) (Ref, error) {
 
 	if name == "HEAD" {
 		return repo.parseRef("HEAD")
 	}
 
-	matches := repo.listRefWithName(name)
+	matches, err := repo.listRefWithName(name)
+	if err != nil {
+		return nil, err
+	}
 
 	//first search in local heads
 	var locals []Ref
@@ -233,11 +529,16 @@ func (repo *Repository) OpenRef(name string) (Ref, error) {
 
 	switch len(matches) {
 	case 0:
-		return nil, fmt.Errorf("git: ref matching %q not found", name)
+		return nil, fmt.Errorf("%w: no ref matching %q", ErrRefNotFound, name)
 	case 1:
 		return matches[0], nil
 	}
-	return nil, fmt.Errorf("git: ambiguous ref name, multiple matches")
+
+	candidates := make([]string, len(matches))
+	for i, m := range matches {
+		candidates[i] = refPath(m)
+	}
+	return nil, &AmbiguousRefError{Name: name, Candidates: candidates}
 }
 
 //Readlink returns the destination of a symbilc link blob object
@@ -249,7 +550,8 @@ func (repo *Repository) Readlink(id SHA1) (string, error) {
 	}
 
 	if b.Type() != ObjBlob {
-		return "", fmt.Errorf("id must point to a blob")
+		b.Close()
+		return "", &ObjectTypeError{ID: id, Type: b.Type(), Expected: ObjBlob}
 	}
 
 	blob := b.(*Blob)
@@ -267,24 +569,31 @@ func (repo *Repository) Readlink(id SHA1) (string, error) {
 //ObjectForPath will resolve the path to an object
 //for the file tree starting in the node root.
 //The root object can be either a Commit, Tree or Tag.
+//Errors for path components are *os.PathErrors, which wrap
+//os.ErrNotExist for missing entries, an *ObjectTypeError if
+//a component is not a tree, ErrSubmodule if it is a gitlink,
+//or the error of OpenObject.
 func (repo *Repository) ObjectForPath(root Object, pathstr string) (Object, error) {
 
 	var node Object
+	var nodeID SHA1
 	var err error
 
 	switch o := root.(type) {
 	case *Tree:
 		node = root
 	case *Commit:
+		nodeID = o.Tree
 		node, err = repo.OpenObject(o.Tree)
 	case *Tag:
+		nodeID = o.Object
 		node, err = repo.OpenObject(o.Object)
 	default:
-		return nil, fmt.Errorf("unsupported root object type")
+		return nil, fmt.Errorf("git: unsupported root object type %s", root.Type())
 	}
 
 	if err != nil {
-		return nil, fmt.Errorf("could not root tree object: %v", err)
+		return nil, fmt.Errorf("git: could not open root tree object: %w", err)
 	}
 
 	cleaned := path.Clean(strings.Trim(pathstr, " /"))
@@ -299,7 +608,7 @@ func (repo *Repository) ObjectForPath(root Object, pathstr string) (Object, erro
 			err := &os.PathError{
 				Op:   "convert git.Object to git.Tree",
 				Path: cwd,
-				Err:  fmt.Errorf("expected tree object, got %s", node.Type()),
+				Err:  &ObjectTypeError{ID: nodeID, Type: node.Type(), Expected: ObjTree},
 			}
 			return nil, err
 		}
@@ -312,10 +621,11 @@ func (repo *Repository) ObjectForPath(root Object, pathstr string) (Object, erro
 		}
 
 		var id *SHA1
+		var mode os.FileMode
 		for tree.Next() {
 			entry := tree.Entry()
 			if entry.Name == comps[i] {
-				id = &entry.ID
+				id, mode = &entry.ID, entry.Mode
 				break
 			}
 		}
@@ -332,8 +642,16 @@ func (repo *Repository) ObjectForPath(root Object, pathstr string) (Object, erro
 				Op:   "find object",
 				Path: cwd,
 				Err:  os.ErrNotExist}
+		} else if mode == ModeGitlink {
+			//the commit is in the repository of the submodule
+			cwd := strings.Join(comps[:i+1], "/")
+			return nil, &os.PathError{
+				Op:   "find object",
+				Path: cwd,
+				Err:  fmt.Errorf("%w at commit %s", ErrSubmodule, id)}
 		}
 
+		nodeID = *id
 		node, err = repo.OpenObject(*id)
 		if err != nil {
 			cwd := strings.Join(comps[:i+1], "/")
@@ -445,18 +763,19 @@ func commitsForRef(repoPath, ref, usefmt string) ([]byte, error) {
 	return body, nil
 }
 
-// BranchExists runs the "git branch <branchname> --list" command.
-// It will return an error, if the command fails, true, if the result is not empty and false otherwise.
+// BranchExists checks if a local branch matching the given name exists.
+// As with "git branch --list <pattern>", the name can be a shell pattern.
+// It will return an error, if the refs could not be read.
 func (repo *Repository) BranchExists(branch string) (bool, error) {
-	gdir := fmt.Sprintf("--git-dir=%s", repo.Path)
-
-	cmd := exec.Command("git", gdir, "branch", branch, "--list")
-	body, err := cmd.Output()
-	if err != nil {
-		return false, err
-	} else if len(body) == 0 {
-		return false, nil
+	iter := repo.Refs("#branch")
+	for iter.Next() {
+		ok, err := path.Match(branch, iter.Ref().Name())
+		if err != nil {
+			return false, err
+		} else if ok {
+			return true, nil
+		}
 	}
 
-	return true, nil
+	return false, iter.Err()
 }
@@ -0,0 +1,357 @@
+package gig
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//minPrefixLength is the minimal length of abbreviated
+//object ids, which is the same limit that git uses.
+const minPrefixLength = 4
+
+//ResolveRevision returns the id of the object the revision refers
+//to, see gitrevisions(7). Supported are full and abbreviated object
+//ids, ref names (which are looked up in the same order as git does,
+//e.g. "master" is found as "refs/heads/master"), "@" for HEAD, and
+//the suffixes "~<n>", "^<n>", "^{<type>}" and "^{}", as well as
+//"<rev>:<path>". Reflog ("@{...}") and index (":<path>") forms are
+//not supported.
+//Unknown names wrap ErrRefNotFound, ids that match no object wrap
+//ErrObjectNotFound and abbreviated ids that match multiple objects
+//return an *AmbiguousObjectError.
+func (repo *Repository) ResolveRevision(rev string) (SHA1, error) {
+	if strings.HasPrefix(rev, ":") {
+		return SHA1{}, fmt.Errorf("git: unsupported revision %q: index paths are not supported", rev)
+	} else if strings.Contains(rev, "@{") {
+		return SHA1{}, fmt.Errorf("git: unsupported revision %q: reflogs are not supported", rev)
+	}
+
+	expr, pathstr := split2(rev, ":")
+	hasPath := strings.Contains(rev, ":")
+
+	end := strings.IndexAny(expr, "~^")
+	if end == -1 {
+		end = len(expr)
+	}
+
+	id, err := repo.resolveName(expr[:end])
+	if err != nil {
+		return SHA1{}, err
+	}
+
+	for ops := expr[end:]; ops != ""; {
+		op := ops[0]
+		ops = ops[1:]
+
+		if op == '^' && strings.HasPrefix(ops, "{") {
+			brace := strings.IndexByte(ops, '}')
+			if brace == -1 {
+				return SHA1{}, fmt.Errorf("git: invalid revision %q", rev)
+			}
+
+			id, err = repo.peelRevision(id, ops[1:brace])
+			if err != nil {
+				return SHA1{}, err
+			}
+
+			ops = ops[brace+1:]
+			continue
+		}
+
+		digits := len(ops) - len(strings.TrimLeft(ops, "0123456789"))
+		n := 1
+		if digits > 0 {
+			n, err = strconv.Atoi(ops[:digits])
+			if err != nil {
+				return SHA1{}, fmt.Errorf("git: invalid revision %q", rev)
+			}
+			ops = ops[digits:]
+		}
+
+		if op == '~' {
+			id, err = repo.nthAncestor(id, n)
+		} else {
+			id, err = repo.nthParent(id, n)
+		}
+
+		if err != nil {
+			return SHA1{}, err
+		}
+	}
+
+	if hasPath {
+		return repo.resolvePath(id, pathstr)
+	}
+
+	return id, nil
+}
+
+//refRules are the patterns to expand short ref names with,
+//in order of precedence, see gitrevisions(7).
+var refRules = []string{
+	"%s",
+	"refs/%s",
+	"refs/tags/%s",
+	"refs/heads/%s",
+	"refs/remotes/%s",
+	"refs/remotes/%s/HEAD",
+}
+
+//resolveName resolves the name at the start of a revision, which
+//is either a ref name or a (possibly abbreviated) object id. Refs
+//take precedence over abbreviated ids, like they do in git.
+func (repo *Repository) resolveName(name string) (SHA1, error) {
+	if name == "" || name == "@" {
+		name = "HEAD"
+	}
+
+	//only full ids of the format of the repository, in a SHA-256
+	//repository 40 hex digits are an abbreviated id
+	if len(name) == 2*repo.ObjectFormat().Size() {
+		if id, err := ParseSHA1(name); err == nil {
+			return id, nil
+		}
+	}
+
+	for _, rule := range refRules {
+		full := fmt.Sprintf(rule, name)
+		if !isPseudoRef(full) && (CheckRefName(full) != nil || strings.Count(full, "/") < 2) {
+			continue
+		}
+
+		//a directory of refs, e.g. "refs/remotes/origin"
+		if fi, err := os.Stat(filepath.Join(repo.Path, full)); err == nil && fi.IsDir() {
+			continue
+		}
+
+		r, err := repo.parseRef(full)
+		if errors.Is(err, ErrRefNotFound) {
+			continue
+		} else if err != nil {
+			return SHA1{}, err
+		}
+
+		return r.Resolve()
+	}
+
+	if len(name) >= minPrefixLength && isHex(name) {
+		return repo.resolvePrefix(strings.ToLower(name))
+	}
+
+	return SHA1{}, fmt.Errorf("%w: unknown revision %q", ErrRefNotFound, name)
+}
+
+//isPseudoRef checks if name looks like a ref that is stored at the
+//top of the repository, like "HEAD" or "FETCH_HEAD".
+func isPseudoRef(name string) bool {
+	for _, c := range name {
+		if (c < 'A' || c > 'Z') && c != '_' {
+			return false
+		}
+	}
+	return name != ""
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+//resolvePrefix returns the id of the one object, loose or packed,
+//whose id starts with the lower-case hex prefix.
+func (repo *Repository) resolvePrefix(prefix string) (SHA1, error) {
+	ids, err := repo.packSet().findPrefix(prefix)
+	if err != nil {
+		return SHA1{}, err
+	}
+
+	dir := filepath.Join(repo.Path, "objects", prefix[:2])
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return SHA1{}, err
+	}
+
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), prefix[2:]) {
+			continue
+		}
+
+		if id, err := ParseSHA1(prefix[:2] + e.Name()); err == nil {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].compare(ids[j]) < 0
+	})
+
+	unique := ids[:0]
+	for i, id := range ids {
+		if i == 0 || id != ids[i-1] {
+			unique = append(unique, id)
+		}
+	}
+
+	switch len(unique) {
+	case 0:
+		return SHA1{}, fmt.Errorf("%w: no object with id prefix %s", ErrObjectNotFound, prefix)
+	case 1:
+		return unique[0], nil
+	}
+
+	return SHA1{}, &AmbiguousObjectError{Prefix: prefix, Candidates: unique}
+}
+
+//peelRevision implements the "^{<type>}" suffix: tags are peeled
+//until an object of the given type is found, commits are peeled to
+//their tree; an empty type peels tags to the first non-tag object.
+func (repo *Repository) peelRevision(id SHA1, typ string) (SHA1, error) {
+	var want ObjectType
+	switch typ {
+	case "":
+		peeled, _, err := repo.peelTag(id)
+		return peeled, err
+	case "object":
+		has, err := repo.HasObject(id)
+		if err != nil {
+			return SHA1{}, err
+		} else if !has {
+			return SHA1{}, repo.objectNotFound(id)
+		}
+		return id, nil
+	default:
+		var err error
+		if want, err = ParseObjectType(typ); err != nil {
+			return SHA1{}, fmt.Errorf("git: invalid revision suffix ^{%s}", typ)
+		}
+	}
+
+	return repo.peelTo(id, want)
+}
+
+//peelTo dereferences tags and commits (to their tree) starting
+//at id, until an object of the wanted type is found.
+func (repo *Repository) peelTo(id SHA1, want ObjectType) (SHA1, error) {
+	start := id
+	for {
+		obj, err := repo.OpenObject(id)
+		if err != nil {
+			return SHA1{}, err
+		}
+		obj.Close()
+
+		if obj.Type() == want {
+			return id, nil
+		}
+
+		switch o := obj.(type) {
+		case *Tag:
+			id = o.Object
+		case *Commit:
+			if want != ObjTree {
+				return SHA1{}, &ObjectTypeError{ID: start, Type: obj.Type(), Expected: want}
+			}
+			id = o.Tree
+		default:
+			return SHA1{}, &ObjectTypeError{ID: start, Type: obj.Type(), Expected: want}
+		}
+	}
+}
+
+//nthParent implements the "^<n>" suffix, where "^0" is the
+//commit itself.
+func (repo *Repository) nthParent(id SHA1, n int) (SHA1, error) {
+	id, err := repo.peelTo(id, ObjCommit)
+	if err != nil || n == 0 {
+		return id, err
+	}
+
+	commit, err := repo.openCommit(id)
+	if err != nil {
+		return SHA1{}, err
+	}
+
+	parents, err := repo.commitParents(id, commit.Parent)
+	if err != nil {
+		return SHA1{}, err
+	} else if n > len(parents) {
+		return SHA1{}, fmt.Errorf("%w: commit %s has no parent %d", ErrObjectNotFound, id, n)
+	}
+
+	return parents[n-1], nil
+}
+
+//nthAncestor implements the "~<n>" suffix, i.e. it follows
+//the first parent n times.
+func (repo *Repository) nthAncestor(id SHA1, n int) (SHA1, error) {
+	id, err := repo.peelTo(id, ObjCommit)
+	for i := 0; i < n && err == nil; i++ {
+		id, err = repo.nthParent(id, 1)
+	}
+
+	return id, err
+}
+
+//resolvePath implements "<rev>:<path>", it returns the id of
+//the entry at the path in the tree of rev. Errors are the same as
+//for ObjectForPath.
+func (repo *Repository) resolvePath(id SHA1, pathstr string) (SHA1, error) {
+	id, err := repo.peelTo(id, ObjTree)
+	if err != nil {
+		return SHA1{}, err
+	}
+
+	cleaned := path.Clean(pathstr)
+	if cleaned == "." {
+		return id, nil
+	}
+
+	comps := strings.Split(cleaned, "/")
+	for i, name := range comps {
+		cwd := strings.Join(comps[:i+1], "/")
+
+		obj, err := repo.OpenObject(id)
+		if err != nil {
+			return SHA1{}, &os.PathError{Op: "open object", Path: cwd, Err: err}
+		}
+
+		tree, ok := obj.(*Tree)
+		if !ok {
+			obj.Close()
+			err := &ObjectTypeError{ID: id, Type: obj.Type(), Expected: ObjTree}
+			return SHA1{}, &os.PathError{Op: "convert git.Object to git.Tree", Path: cwd, Err: err}
+		}
+
+		var entry *TreeEntry
+		for tree.Next() {
+			if e := tree.Entry(); e.Name == name {
+				entry = e
+				break
+			}
+		}
+
+		err = tree.Err()
+		tree.Close()
+
+		if err != nil {
+			return SHA1{}, &os.PathError{Op: "find object", Path: cwd, Err: err}
+		} else if entry == nil {
+			return SHA1{}, &os.PathError{Op: "find object", Path: cwd, Err: os.ErrNotExist}
+		}
+
+		id = entry.ID
+	}
+
+	return id, nil
+}
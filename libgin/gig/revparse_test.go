@@ -0,0 +1,169 @@
+package gig
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestResolveRevision(t *testing.T) {
+	repo, wt := mkTestRepo(t)
+	mkLogHistory(t, wt)
+	gitCmd(t, repo.Path, "tag", "-a", "-m", "annotated", "v1", "side")
+	gitCmd(t, repo.Path, "tag", "light", "master~1")
+	gitCmd(t, repo.Path, "update-ref", "refs/remotes/origin/master", "side")
+	gitCmd(t, repo.Path, "symbolic-ref", "refs/remotes/origin/HEAD", "refs/remotes/origin/master")
+
+	master := revParse(t, repo, "master").String()
+	tag := revParse(t, repo, "v1").String()
+
+	revs := []string{
+		"HEAD", "@", "master", "refs/heads/master", "heads/master", "side",
+		"v1", "light", "origin", "origin/master",
+		"v1^{}", "v1^{commit}", "v1^{tree}", "v1^{tag}", "v1^{object}", "v1^0",
+		"master~2", "master^^2", "master~1^2~1", "master^^", "master^0", "master~",
+		"master^{tree}", "HEAD~1^{tree}",
+		"master:", "master:data", "master:data/a.txt", "master:data/sub/c.txt",
+		"v1:side/one.txt", "HEAD~1:README.md", "master~1^2:side",
+		master, master[:7], master[:4] + "^{tree}", master + "~3",
+		tag[:10], tag[:10] + "^{}",
+	}
+
+	check := func() {
+		t.Helper()
+		for _, rev := range revs {
+			id, err := repo.ResolveRevision(rev)
+			if err != nil {
+				t.Fatalf("%s: could not resolve revision: %v", rev, err)
+			}
+
+			if expected := revParse(t, repo, rev); id != expected {
+				t.Fatalf("%s: expected %s, got %s", rev, expected, id)
+			}
+		}
+	}
+
+	check()
+
+	//all objects packed and in a multi-pack-index
+	gitCmd(t, repo.Path, "repack", "-q", "-a", "-d")
+	gitCmd(t, repo.Path, "multi-pack-index", "write")
+	repo, err := OpenRepository(repo.Path)
+	if err != nil {
+		t.Fatalf("could not open repository: %v", err)
+	}
+	check()
+
+	_, err = repo.ResolveRevision("nonexistent")
+	if !errors.Is(err, ErrRefNotFound) {
+		t.Fatalf("expected ErrRefNotFound, got %v", err)
+	}
+
+	_, err = repo.ResolveRevision("0000000")
+	if !errors.Is(err, ErrObjectNotFound) {
+		t.Fatalf("expected ErrObjectNotFound, got %v", err)
+	}
+
+	_, err = repo.ResolveRevision("master:missing.txt")
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected not exist error, got %v", err)
+	}
+
+	var terr *ObjectTypeError
+	for _, rev := range []string{"master:README.md/foo", "master^{blob}", "master^{tree}~1"} {
+		_, err = repo.ResolveRevision(rev)
+		if !errors.As(err, &terr) {
+			t.Fatalf("%s: expected ObjectTypeError, got %v", rev, err)
+		}
+	}
+
+	for _, rev := range []string{"master^2", "master~1^3", "master~20", "master^{", "master^{foo}", "master@{1}", ":README.md"} {
+		if _, err = repo.ResolveRevision(rev); err == nil {
+			t.Fatalf("%s: expected error", rev)
+		}
+	}
+}
+
+func TestResolveRevisionSHA256(t *testing.T) {
+	repo, _ := mkTestRepo(t, "--object-format=sha256")
+
+	master := revParse(t, repo, "master").String()
+	for _, rev := range []string{master, master[:40], master[:40] + "^{tree}", master[:7] + "~1"} {
+		id, err := repo.ResolveRevision(rev)
+		if err != nil {
+			t.Fatalf("%s: could not resolve revision: %v", rev, err)
+		}
+
+		if expected := revParse(t, repo, rev); id != expected || id.Format() != FormatSHA256 {
+			t.Fatalf("%s: expected %s, got %s", rev, expected, id)
+		}
+	}
+}
+
+func TestResolveRevisionAmbiguous(t *testing.T) {
+	repo, _ := mkTestRepo(t)
+
+	//write blobs until two of them share the first four hex digits
+	seen := make(map[string]SHA1)
+	var prefix string
+	var expected []SHA1
+	for i := 0; prefix == ""; i++ {
+		id := writeBlob(t, repo, fmt.Sprintf("blob %d\n", i))
+		p := id.String()[:4]
+		if other, ok := seen[p]; ok {
+			prefix, expected = p, []SHA1{other, id}
+		}
+		seen[p] = id
+	}
+
+	if expected[0].String() > expected[1].String() {
+		expected[0], expected[1] = expected[1], expected[0]
+	}
+
+	check := func() {
+		t.Helper()
+
+		var aerr *AmbiguousObjectError
+		_, err := repo.ResolveRevision(prefix)
+		if !errors.As(err, &aerr) {
+			t.Fatalf("expected AmbiguousObjectError, got %v", err)
+		}
+
+		if aerr.Prefix != prefix || len(aerr.Candidates) != 2 ||
+			aerr.Candidates[0] != expected[0] || aerr.Candidates[1] != expected[1] {
+			t.Fatalf("unexpected ambiguous object error: %v", aerr)
+		}
+
+		//one more digit than the common prefix is unique
+		n := len(prefix)
+		for expected[0].String()[:n] == expected[1].String()[:n] {
+			n++
+		}
+
+		for _, id := range expected {
+			for _, rev := range []string{id.String()[:n], id.String()} {
+				if resolved, err := repo.ResolveRevision(rev); err != nil || resolved != id {
+					t.Fatalf("%s: expected %s, got %s, %v", rev, id, resolved, err)
+				}
+			}
+		}
+	}
+
+	check()
+
+	//one of the objects in a pack, the other one loose
+	obj, err := repo.OpenObject(expected[0])
+	if err != nil {
+		t.Fatalf("could not open object: %v", err)
+	}
+
+	if _, err := repo.WritePack([]Object{obj}, 0); err != nil {
+		t.Fatalf("could not write pack: %v", err)
+	}
+
+	if err := os.Remove(repo.looseObjectPath(expected[0])); err != nil {
+		t.Fatalf("could not remove loose object: %v", err)
+	}
+	check()
+}
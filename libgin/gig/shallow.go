@@ -0,0 +1,122 @@
+package gig
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+//ShallowCommits returns the commits listed in the "shallow" file of
+//the repository, i.e. the commits of a shallow clone whose parents are
+//not present. The list is empty if the repository is not shallow.
+func (repo *Repository) ShallowCommits() ([]SHA1, error) {
+	shallow, err := repo.shallowCommits()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]SHA1, 0, len(shallow))
+	for id := range shallow {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].String() < ids[j].String()
+	})
+
+	return ids, nil
+}
+
+//IsShallow checks if the commit is one of the shallow commits,
+//whose parents are not present in the repository.
+func (repo *Repository) IsShallow(id SHA1) (bool, error) {
+	shallow, err := repo.shallowCommits()
+	if err != nil {
+		return false, err
+	}
+
+	return shallow[id], nil
+}
+
+//shallowCommits returns the set of shallow commits, which is
+//loaded on first use and reloaded when the file changes.
+func (repo *Repository) shallowCommits() (map[SHA1]bool, error) {
+	path := filepath.Join(repo.Path, "shallow")
+
+	var stamp time.Time
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	stamp = fi.ModTime()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if repo.shallow != nil && repo.shallowStamp.Equal(stamp) {
+		return repo.shallow, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	shallow := make(map[SHA1]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		id, err := ParseSHA1(line)
+		if err != nil {
+			return nil, fmt.Errorf("git: invalid line in shallow file: %q", line)
+		}
+		shallow[id] = true
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	repo.shallow, repo.shallowStamp = shallow, stamp
+	return shallow, nil
+}
+
+//commitParents returns the parents of the commit with the given id,
+//which are none if the commit is shallow. Walks of the history must
+//use this, instead of the parents of the commit object, so that the
+//shallow commits are the roots of the history.
+func (repo *Repository) commitParents(id SHA1, parents []SHA1) ([]SHA1, error) {
+	if len(parents) == 0 {
+		return parents, nil
+	}
+
+	shallow, err := repo.shallowCommits()
+	if err != nil || shallow[id] {
+		return nil, err
+	}
+
+	return parents, nil
+}
+
+//isCutOff checks if the commit is absent because the repository
+//is shallow, and the commit thus is a parent of a shallow commit.
+func (repo *Repository) isCutOff(id SHA1) (bool, error) {
+	shallow, err := repo.shallowCommits()
+	if err != nil || len(shallow) == 0 {
+		return false, err
+	}
+
+	has, err := repo.HasObject(id)
+	return !has, err
+}
@@ -0,0 +1,95 @@
+package gig
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestShallow(t *testing.T) {
+	src, wt := mkTestRepo(t)
+	mkLogHistory(t, wt)
+
+	path := filepath.Join(filepath.Dir(src.Path), "shallow.git")
+	gitCmd(t, filepath.Dir(path), "clone", "-q", "--bare", "--depth=2", "file://"+src.Path, path)
+	repo := &Repository{Path: path}
+
+	shallow, err := repo.ShallowCommits()
+	if err != nil {
+		t.Fatalf("could not read shallow commits: %v", err)
+	}
+
+	var ids []string
+	for _, id := range shallow {
+		ids = append(ids, id.String())
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(path, "shallow"))
+	if err != nil {
+		t.Fatalf("could not read shallow file: %v", err)
+	}
+
+	expected := strings.Fields(string(data))
+	sort.Strings(expected)
+	if len(ids) == 0 || strings.Join(ids, " ") != strings.Join(expected, " ") {
+		t.Fatalf("unexpected shallow commits: %v, expected: %v", ids, expected)
+	}
+
+	head, err := ParseSHA1(gitCmd(t, path, "rev-parse", "master"))
+	if err != nil {
+		t.Fatalf("could not parse id: %v", err)
+	}
+
+	//the history ends at the shallow commits
+	if log, expected := logIDs(t, repo.Log(nil, head)), gitCmd(t, path, "rev-list", "master"); log != expected {
+		t.Fatalf("unexpected log:\n%s\nexpected:\n%s", log, expected)
+	}
+
+	var objects []string
+	it := repo.ReachableObjects([]SHA1{head}, nil)
+	for it.Next() {
+		objects = append(objects, strings.TrimSpace(it.Object().ID.String()+" "+it.Object().Path))
+	}
+
+	if err := it.Err(); err != nil {
+		t.Fatalf("could not enumerate objects: %v", err)
+	}
+
+	revList := strings.Split(gitCmd(t, path, "rev-list", "--objects", "master"), "\n")
+	for i := range revList {
+		revList[i] = strings.TrimSpace(revList[i])
+	}
+	sort.Strings(objects)
+	sort.Strings(revList)
+	if strings.Join(objects, "\n") != strings.Join(revList, "\n") {
+		t.Fatalf("unexpected objects:\n%s\nexpected:\n%s", strings.Join(objects, "\n"), strings.Join(revList, "\n"))
+	}
+
+	//shallow commits are diffed against the empty tree
+	for _, id := range shallow {
+		obj, err := repo.OpenObject(id)
+		if err != nil {
+			t.Fatalf("could not open commit: %v", err)
+		}
+		obj.Close()
+
+		changes, err := repo.DiffCommit(obj.(*Commit), nil)
+		if err != nil {
+			t.Fatalf("could not diff shallow commit: %v", err)
+		}
+
+		files := strings.Split(gitCmd(t, path, "ls-tree", "-r", "--name-only", id.String()), "\n")
+		if len(changes) != len(files) || changes[0].Type != ChangeAdded {
+			t.Fatalf("expected %d added files, got %v", len(files), changes)
+		}
+	}
+
+	report, err := repo.Verify()
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	} else if !report.OK() {
+		t.Fatalf("expected healthy shallow clone, got:\n%s", report)
+	}
+}
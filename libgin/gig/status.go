@@ -0,0 +1,641 @@
+package gig
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+//FileKind classifies the files of a work tree.
+type FileKind int
+
+//FileKind values. Annexed files are either symlinks into the
+//annex (locked files) or pointer files (unlocked files), see
+//git-annex(1).
+const (
+	FileRegular FileKind = iota + 1
+	FileSymlink
+	FileAnnexSymlink
+	FileAnnexPointer
+	FileGitlink
+	FileDirectory
+)
+
+func (k FileKind) String() string {
+	switch k {
+	case FileRegular:
+		return "regular"
+	case FileSymlink:
+		return "symlink"
+	case FileAnnexSymlink:
+		return "annex-symlink"
+	case FileAnnexPointer:
+		return "annex-pointer"
+	case FileGitlink:
+		return "gitlink"
+	case FileDirectory:
+		return "directory"
+	}
+	return "unknown"
+}
+
+//annexPointerMax is the maximal size of git-annex pointer files.
+const annexPointerMax = 32 * 1024
+
+//StatusEntry is the status of a path of the work tree.
+type StatusEntry struct {
+	Path string
+
+	//Staged is the change of the file between the tree of HEAD and
+	//the index, Unstaged the one between the index and the work
+	//tree; zero if the file did not change.
+	Staged   ChangeType
+	Unstaged ChangeType
+
+	//Conflict is the bit mask of the merge stages in the index,
+	//1<<(stage-1), for unmerged paths; zero otherwise.
+	Conflict int
+
+	//Untracked and ignored paths are not in the index. Directories
+	//without tracked files are reported as a whole, with a trailing
+	//slash, just like "git status" does.
+	Untracked bool
+	Ignored   bool
+
+	Kind FileKind
+
+	//AnnexKey is the git-annex key of annexed files; AnnexPresent
+	//reports whether their content is present in the work tree.
+	AnnexKey     string
+	AnnexPresent bool
+}
+
+//conflictCodes are the status codes of unmerged paths, indexed by
+//the bit mask of the merge stages.
+var conflictCodes = [8]string{1: "DD", 2: "AU", 3: "UD", 4: "UA", 5: "DU", 6: "AA", 7: "UU"}
+
+//String returns the entry in the format of "git status --porcelain",
+//without quoting of the path.
+func (e StatusEntry) String() string {
+	code := "  "
+	switch {
+	case e.Untracked:
+		code = "??"
+	case e.Ignored:
+		code = "!!"
+	case e.Conflict != 0:
+		code = conflictCodes[e.Conflict&7]
+	default:
+		letter := func(ct ChangeType) string {
+			if ct == 0 {
+				return " "
+			}
+			return ct.Letter()
+		}
+		code = letter(e.Staged) + letter(e.Unstaged)
+	}
+
+	return code + " " + e.Path
+}
+
+//statusWalker holds the state of a Status call.
+type statusWalker struct {
+	repo *Repository
+	idx  *Index
+
+	//indexTime is the modification time of the index; files modified
+	//at the same time or later are compared by their content, since
+	//they might have changed after the index was written.
+	indexTime time.Time
+	fileMode  bool
+	annex     bool
+
+	entries map[string]*StatusEntry
+}
+
+//Status compares the tree of HEAD, the index and the work tree and
+//returns the changed, untracked and ignored paths, ordered by path.
+//Files whose stat data match the index are assumed to be unchanged,
+//all others are compared by their content; filters and the
+//conversion of line endings are not applied. Changes of submodules
+//are detected by their HEAD only. Ignored paths are determined by
+//the .gitignore files, info/exclude and core.excludesFile.
+func (repo *Repository) Status() ([]StatusEntry, error) {
+	if repo.WorkTree == "" {
+		return nil, fmt.Errorf("git: %s has no work tree", repo.Path)
+	}
+
+	st := &statusWalker{repo: repo, entries: make(map[string]*StatusEntry)}
+
+	idx, err := repo.ReadIndex()
+	if os.IsNotExist(err) {
+		idx = &Index{}
+	} else if err != nil {
+		return nil, err
+	}
+	st.idx = idx
+
+	if fi, err := os.Stat(filepath.Join(repo.gitDir(), "index")); err == nil {
+		st.indexTime = fi.ModTime()
+	}
+
+	config, err := repo.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	st.fileMode, err = config.Bool("core.fileMode", true)
+	if err != nil {
+		return nil, err
+	}
+
+	_, hasUUID := config.Get("annex.uuid")
+	_, hasVersion := config.Get("annex.version")
+	st.annex = hasUUID || hasVersion
+
+	head, err := repo.ResolveRevision("HEAD^{tree}")
+	if err != nil && !errors.Is(err, ErrRefNotFound) {
+		return nil, err
+	}
+
+	err = st.diffHead(head)
+	if err != nil {
+		return nil, err
+	}
+
+	err = st.diffWorkTree()
+	if err != nil {
+		return nil, err
+	}
+
+	patterns, err := repo.excludePatterns(config)
+	if err != nil {
+		return nil, err
+	}
+
+	untracked, ignored, err := st.walkUntracked("", patterns, false)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]StatusEntry, 0, len(st.entries)+len(untracked)+len(ignored))
+	for _, e := range st.entries {
+		status = append(status, *e)
+	}
+	status = append(status, untracked...)
+	status = append(status, ignored...)
+
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Path < status[j].Path
+	})
+
+	return status, nil
+}
+
+func (st *statusWalker) entry(name string) *StatusEntry {
+	e, ok := st.entries[name]
+	if !ok {
+		e = &StatusEntry{Path: name}
+		st.entries[name] = e
+	}
+	return e
+}
+
+//flattenTree adds all entries below the tree, except for the
+//trees themselves, to files, keyed by their path.
+func (repo *Repository) flattenTree(id SHA1, prefix string, files map[string]TreeEntry) error {
+	entries, err := repo.readTreeEntries(id)
+	if err != nil {
+		return err
+	}
+
+	for name, e := range entries {
+		if isTreeMode(e.Mode) {
+			err = repo.flattenTree(e.ID, prefix+name+"/", files)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		files[prefix+name] = e
+	}
+
+	return nil
+}
+
+//diffHead compares the tree of HEAD (the zero id if there is no
+//commit yet) with the index and records conflicts.
+func (st *statusWalker) diffHead(head SHA1) error {
+	conflicts := false
+	for i := range st.idx.Entries {
+		e := &st.idx.Entries[i]
+		if e.Stage != 0 {
+			st.entry(e.Name).Conflict |= 1 << uint(e.Stage-1)
+			conflicts = true
+		}
+	}
+
+	//the cached tree of the index matches HEAD, nothing is staged
+	if t := st.idx.Tree; t != nil && t.Entries >= 0 && t.ID == head && !conflicts {
+		return nil
+	}
+
+	files := make(map[string]TreeEntry)
+	err := st.repo.flattenTree(head, "", files)
+	if err != nil {
+		return err
+	}
+
+	for i := range st.idx.Entries {
+		e := &st.idx.Entries[i]
+		if e.Stage != 0 || e.IntentToAdd {
+			continue
+		}
+
+		var change ChangeType
+		h, ok := files[e.Name]
+		switch {
+		case !ok:
+			change = ChangeAdded
+		case modeKind(h.Mode) != modeKind(e.Mode):
+			change = ChangeTypeChanged
+		case h.Mode != e.Mode || h.ID != e.ID:
+			change = ChangeModified
+		default:
+			continue
+		}
+
+		se := st.entry(e.Name)
+		se.Staged = change
+		err = st.classify(se, e.Mode, e.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	for name, h := range files {
+		if st.idx.Entry(name) != nil {
+			continue
+		}
+
+		se := st.entry(name)
+		se.Staged = ChangeDeleted
+		err = st.classify(se, h.Mode, h.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//diffWorkTree compares the index with the work tree.
+func (st *statusWalker) diffWorkTree() error {
+	for i := range st.idx.Entries {
+		e := &st.idx.Entries[i]
+		if e.Stage != 0 || e.SkipWorktree {
+			continue
+		}
+
+		change, err := st.workTreeChange(e)
+		if err != nil {
+			return fmt.Errorf("git: %s: %v", e.Name, err)
+		} else if change == 0 {
+			continue
+		}
+
+		se := st.entry(e.Name)
+		se.Unstaged = change
+		err = st.classify(se, e.Mode, e.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//workTreeChange returns how the file in the work tree differs
+//from the index entry, zero if it is unchanged.
+func (st *statusWalker) workTreeChange(e *IndexEntry) (ChangeType, error) {
+	p := filepath.Join(st.repo.WorkTree, filepath.FromSlash(e.Name))
+
+	fi, err := os.Lstat(p)
+	if os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR) {
+		return ChangeDeleted, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	isLink := fi.Mode()&os.ModeSymlink != 0
+
+	switch {
+	case e.Mode == ModeGitlink:
+		if !fi.IsDir() {
+			return ChangeTypeChanged, nil
+		}
+
+		//submodules that are not checked out are unchanged
+		sub, err := OpenRepository(p)
+		if err != nil {
+			return 0, nil
+		}
+
+		id, err := sub.ResolveRevision("HEAD")
+		if err != nil || id == e.ID {
+			return 0, nil
+		}
+		return ChangeModified, nil
+	case fi.IsDir():
+		return ChangeDeleted, nil
+	case isLink != (e.Mode == ModeSymlink):
+		return ChangeTypeChanged, nil
+	case e.IntentToAdd:
+		return ChangeAdded, nil
+	case e.AssumeValid:
+		return 0, nil
+	case st.fileMode && !isLink && (fi.Mode()&0100 != 0) != (e.Mode == ModeExec):
+		return ChangeModified, nil
+	case e.Size != uint32(fi.Size()):
+		return ChangeModified, nil
+	case fi.ModTime().Equal(e.MTime) && fi.ModTime().Before(st.indexTime):
+		return 0, nil
+	}
+
+	var id SHA1
+	format := st.repo.ObjectFormat()
+	if isLink {
+		target, err := os.Readlink(p)
+		if err != nil {
+			return 0, err
+		}
+		id, err = hashObjectData(format, ObjBlob, int64(len(target)), strings.NewReader(target))
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		f, err := os.Open(p)
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+
+		id, err = hashObjectData(format, ObjBlob, fi.Size(), f)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if id != e.ID {
+		return ChangeModified, nil
+	}
+
+	return 0, nil
+}
+
+//walkUntracked returns the untracked and the ignored paths below
+//the directory dir, which is relative to the work tree and either
+//empty or has a trailing slash. The .gitignore file of each
+//directory adds to the patterns; everything below an ignored
+//directory is ignored.
+func (st *statusWalker) walkUntracked(dir string, patterns []ignorePattern, ignored bool) ([]StatusEntry, []StatusEntry, error) {
+	abs := filepath.Join(st.repo.WorkTree, filepath.FromSlash(dir))
+
+	more, err := readIgnoreFile(filepath.Join(abs, ".gitignore"), dir)
+	if err != nil {
+		return nil, nil, err
+	} else if len(more) > 0 {
+		patterns = append(patterns[:len(patterns):len(patterns)], more...)
+	}
+
+	infos, err := ioutil.ReadDir(abs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var untracked, ignoredPaths []StatusEntry
+	add := func(e StatusEntry, ign bool) {
+		if ign {
+			e.Ignored = true
+			ignoredPaths = append(ignoredPaths, e)
+		} else {
+			e.Untracked = true
+			untracked = append(untracked, e)
+		}
+	}
+
+	for _, fi := range infos {
+		name := dir + fi.Name()
+		if name == ".git" || st.idx.Entry(name) != nil {
+			continue
+		}
+
+		ign := ignored || isIgnored(patterns, name, fi.IsDir())
+
+		if !fi.IsDir() {
+			e := StatusEntry{Path: name, Kind: FileRegular}
+			if fi.Mode()&os.ModeSymlink != 0 {
+				st.classifyLink(&e, filepath.Join(abs, fi.Name()))
+			}
+			add(e, ign)
+			continue
+		}
+
+		if st.hasTracked(name + "/") {
+			u, i, err := st.walkUntracked(name+"/", patterns, ign)
+			if err != nil {
+				return nil, nil, err
+			}
+			untracked = append(untracked, u...)
+			ignoredPaths = append(ignoredPaths, i...)
+			continue
+		}
+
+		//directories without tracked files are reported as a whole;
+		//nested repositories are not looked into
+		e := StatusEntry{Path: name + "/", Kind: FileDirectory}
+		sub := filepath.Join(abs, fi.Name())
+
+		if ign {
+			if containsFiles(sub) {
+				add(e, true)
+			}
+			continue
+		} else if _, err := os.Lstat(filepath.Join(sub, ".git")); err == nil {
+			add(e, false)
+			continue
+		}
+
+		u, i, err := st.walkUntracked(name+"/", patterns, false)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(u) > 0 {
+			add(e, false)
+			ignoredPaths = append(ignoredPaths, i...)
+		} else if len(i) > 0 {
+			add(e, true)
+		}
+	}
+
+	return untracked, ignoredPaths, nil
+}
+
+//hasTracked reports whether there are index entries below the
+//directory, given with a trailing slash.
+func (st *statusWalker) hasTracked(dir string) bool {
+	entries := st.idx.Entries
+	i := sort.Search(len(entries), func(i int) bool {
+		return entries[i].Name >= dir
+	})
+
+	return i < len(entries) && strings.HasPrefix(entries[i].Name, dir)
+}
+
+//errFound stops the walk of containsFiles.
+var errFound = errors.New("found")
+
+//containsFiles reports whether there are any files below the
+//directory.
+func containsFiles(dir string) bool {
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			return errFound
+		}
+		return nil
+	})
+
+	return err == errFound
+}
+
+//classify sets the kind of the entry from the mode and the content
+//of the blob with the given id (i.e. the version of the file in the
+//index, or in HEAD if it was deleted). Annexed files are only
+//detected in git-annex repositories.
+func (st *statusWalker) classify(se *StatusEntry, mode os.FileMode, id SHA1) error {
+	p := filepath.Join(st.repo.WorkTree, filepath.FromSlash(se.Path))
+
+	switch modeKind(mode) {
+	case ModeGitlink:
+		se.Kind = FileGitlink
+	case ModeSymlink:
+		se.Kind = FileSymlink
+		if !st.annex {
+			return nil
+		}
+
+		target, err := os.Readlink(p)
+		if err != nil {
+			data, err := st.repo.readBlob(id)
+			if err != nil {
+				return err
+			}
+			target = string(data)
+		}
+
+		if key := annexKey(target, true); key != "" {
+			se.Kind, se.AnnexKey = FileAnnexSymlink, key
+			_, err := os.Stat(p)
+			se.AnnexPresent = err == nil
+		}
+	default:
+		se.Kind = FileRegular
+		if !st.annex {
+			return nil
+		}
+
+		pointer, err := st.repo.readSmallBlob(id, annexPointerMax)
+		if err != nil {
+			return err
+		}
+
+		key := annexKey(string(pointer), false)
+		if key == "" {
+			return nil
+		}
+
+		se.Kind, se.AnnexKey = FileAnnexPointer, key
+
+		//the content is present, unless the file is the pointer file
+		fi, err := os.Lstat(p)
+		if err != nil || !fi.Mode().IsRegular() {
+			return nil
+		} else if fi.Size() != int64(len(pointer)) {
+			se.AnnexPresent = true
+			return nil
+		}
+
+		data, err := ioutil.ReadFile(p)
+		se.AnnexPresent = err == nil && string(data) != string(pointer)
+	}
+
+	return nil
+}
+
+//classifyLink sets the kind of an untracked symlink.
+func (st *statusWalker) classifyLink(se *StatusEntry, p string) {
+	se.Kind = FileSymlink
+	if !st.annex {
+		return
+	}
+
+	target, err := os.Readlink(p)
+	if err != nil {
+		return
+	}
+
+	if key := annexKey(target, true); key != "" {
+		se.Kind, se.AnnexKey = FileAnnexSymlink, key
+		_, err := os.Stat(p)
+		se.AnnexPresent = err == nil
+	}
+}
+
+//readSmallBlob returns the content of the blob, or nil if it is
+//larger than max bytes.
+func (repo *Repository) readSmallBlob(id SHA1, max int64) ([]byte, error) {
+	obj, err := repo.OpenObject(id)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	blob, ok := obj.(*Blob)
+	if !ok {
+		return nil, &ObjectTypeError{ID: id, Type: obj.Type(), Expected: ObjBlob}
+	} else if blob.Size() > max {
+		return nil, nil
+	}
+
+	return ioutil.ReadAll(blob)
+}
+
+//annexKey returns the git-annex key of an annexed file, given the
+//target of a symlink or the content of a pointer file; empty if the
+//file is not annexed.
+func annexKey(data string, symlink bool) string {
+	if symlink {
+		if !strings.Contains(data, "annex/objects/") {
+			return ""
+		}
+		return path.Base(data)
+	}
+
+	if !strings.HasPrefix(data, "/annex/objects/") {
+		return ""
+	}
+
+	if nl := strings.IndexByte(data, '\n'); nl != -1 {
+		data = data[:nl]
+	}
+
+	return path.Base(strings.TrimSpace(data))
+}
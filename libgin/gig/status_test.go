@@ -0,0 +1,256 @@
+package gig
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//writeFiles writes the files, given as path and content, below dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+			t.Fatalf("could not create dir: %v", err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0666); err != nil {
+			t.Fatalf("could not write file: %v", err)
+		}
+	}
+}
+
+//checkStatus compares the status of the work tree with the one
+//"git status" reports.
+func checkStatus(t *testing.T, wt string) []StatusEntry {
+	t.Helper()
+
+	repo, err := OpenRepository(wt)
+	if err != nil {
+		t.Fatalf("could not open repository: %v", err)
+	}
+
+	status, err := repo.Status()
+	if err != nil {
+		t.Fatalf("could not get status: %v", err)
+	}
+
+	var lines []string
+	for _, e := range status {
+		lines = append(lines, e.String())
+	}
+
+	out, err := gitTry(wt, "status", "--porcelain", "--ignored")
+	if err != nil {
+		t.Fatalf("git status failed: %v\n%s", err, out)
+	}
+
+	if actual, expected := sortLines(strings.Join(lines, "\n")), sortLines(strings.TrimSuffix(out, "\n")); actual != expected {
+		t.Fatalf("unexpected status:\n%s\nexpected:\n%s", actual, expected)
+	}
+
+	return status
+}
+
+//isolateConfig keeps the global config and ignore files of the
+//user out of the tests.
+func isolateConfig(t *testing.T) {
+	setenv(t, "GIT_CONFIG_NOSYSTEM", "1")
+	setenv(t, "GIT_CONFIG_GLOBAL", writeConfig(t, t.TempDir(), "global", ""))
+	setenv(t, "XDG_CONFIG_HOME", t.TempDir())
+}
+
+func TestStatus(t *testing.T) {
+	isolateConfig(t)
+	_, wt := mkTestRepo(t)
+
+	writeFiles(t, wt, map[string]string{"exec.sh": "#!/bin/sh\n", "same.txt": "same\n"})
+	if err := os.Symlink("README.md", filepath.Join(wt, "link")); err != nil {
+		t.Fatalf("could not create symlink: %v", err)
+	}
+	gitCmd(t, wt, "add", "exec.sh", "same.txt", "link")
+	gitCmd(t, wt, "commit", "-q", "-m", "more files")
+
+	//changes in the index and the work tree
+	writeFiles(t, wt, map[string]string{"README.md": "changed\n", "data/a.txt": "staged\n", "new.txt": "new\n", "ita.txt": "ita\n"})
+	gitCmd(t, wt, "add", "data/a.txt", "new.txt")
+	gitCmd(t, wt, "add", "-N", "ita.txt")
+	writeFiles(t, wt, map[string]string{"data/a.txt": "staged and changed\n", "same.txt": "same\n"})
+	gitCmd(t, wt, "rm", "-q", "--cached", "data/sub/c.txt")
+
+	if err := os.Remove(filepath.Join(wt, "data", "b.txt")); err != nil {
+		t.Fatalf("could not remove file: %v", err)
+	}
+	if err := os.Chmod(filepath.Join(wt, "exec.sh"), 0755); err != nil {
+		t.Fatalf("could not change mode: %v", err)
+	}
+	if err := os.Remove(filepath.Join(wt, "link")); err != nil {
+		t.Fatalf("could not remove file: %v", err)
+	}
+
+	//untracked and ignored files
+	excludes := writeConfig(t, t.TempDir(), "excludes", "*.glob\n")
+	gitCmd(t, wt, "config", "core.excludesFile", excludes)
+
+	writeFiles(t, wt, map[string]string{
+		"link":              "no longer a link\n",
+		".gitignore":        "# comment\n*.log\n!keep.log\nbuild/\n/top.tmp\n\\#hash\n",
+		".git/info/exclude": "*.excl\n",
+		"x.log":             "",
+		"keep.log":          "",
+		"top.tmp":           "",
+		"#hash":             "",
+		"a.excl":            "",
+		"a.glob":            "",
+		"build/out/file":    "",
+		"data/top.tmp":      "",
+		"data/.gitignore":   "*.txt\n",
+		"data/new.txt":      "",
+		"untracked/a":       "",
+		"untracked/b.log":   "",
+		"untracked/c/d":     "",
+		"onlyignored/x.log": "",
+		"nested/file":       "",
+	})
+
+	if err := os.MkdirAll(filepath.Join(wt, "empty", "dir"), 0777); err != nil {
+		t.Fatalf("could not create dir: %v", err)
+	}
+	gitCmd(t, wt, "init", "-q", "nested")
+
+	status := checkStatus(t, wt)
+
+	kinds := map[string]FileKind{"link": FileSymlink, "untracked/": FileDirectory, "README.md": FileRegular}
+	for _, e := range status {
+		if k, ok := kinds[e.Path]; ok && e.Kind != k {
+			t.Fatalf("%s: expected kind %s, got %s", e.Path, k, e.Kind)
+		}
+	}
+
+	//"git status" refreshed the stat data of the index
+	checkStatus(t, wt)
+
+	//a repository without commits
+	fresh := filepath.Join(t.TempDir(), "fresh")
+	gitCmd(t, t.TempDir(), "init", "-q", fresh)
+	writeFiles(t, fresh, map[string]string{"a": "a\n", "b": "b\n"})
+	checkStatus(t, fresh)
+	gitCmd(t, fresh, "add", "a")
+	checkStatus(t, fresh)
+
+	repo, err := OpenRepository(filepath.Join(fresh, ".git"))
+	if err != nil {
+		t.Fatalf("could not open repository: %v", err)
+	}
+
+	if _, err := repo.Status(); err != nil {
+		t.Fatalf("could not get status via the git directory: %v", err)
+	}
+
+	bare, err := OpenRepository(filepath.Join(filepath.Dir(wt), "repo.git"))
+	if err != nil {
+		t.Fatalf("could not open repository: %v", err)
+	} else if _, err := bare.Status(); err == nil {
+		t.Fatalf("expected an error for a bare repository")
+	}
+}
+
+func TestStatusConflict(t *testing.T) {
+	isolateConfig(t)
+	_, wt := mkTestRepo(t)
+
+	gitCmd(t, wt, "checkout", "-q", "-b", "other", "HEAD~1")
+	writeFiles(t, wt, map[string]string{"README.md": "other\n", "data/a.txt": "other\n", "added.txt": "other\n"})
+	gitCmd(t, wt, "rm", "-q", "data/b.txt")
+	gitCmd(t, wt, "add", "-A")
+	gitCmd(t, wt, "commit", "-q", "-m", "other")
+
+	gitCmd(t, wt, "checkout", "-q", "master")
+	writeFiles(t, wt, map[string]string{"data/b.txt": "master\n", "added.txt": "master\n"})
+	gitCmd(t, wt, "rm", "-q", "data/a.txt")
+	gitCmd(t, wt, "add", "-A")
+	gitCmd(t, wt, "commit", "-q", "-m", "master")
+
+	if _, err := gitTry(wt, "merge", "-q", "other"); err == nil {
+		t.Fatalf("expected merge conflicts")
+	}
+
+	status := checkStatus(t, wt)
+
+	conflicts := map[string]int{"README.md": 7, "data/a.txt": 5, "data/b.txt": 3, "added.txt": 6}
+	for _, e := range status {
+		if e.Conflict != conflicts[e.Path] {
+			t.Fatalf("%s: expected conflict %d, got %d", e.Path, conflicts[e.Path], e.Conflict)
+		}
+	}
+}
+
+func TestStatusAnnex(t *testing.T) {
+	isolateConfig(t)
+	_, wt := mkTestRepo(t)
+
+	gitCmd(t, wt, "config", "annex.uuid", "5d4d0a2f-0a71-4c4b-9b76-1c6a3b1d7f54")
+	gitCmd(t, wt, "config", "annex.version", "8")
+
+	const present = "SHA256E-s8--aaaa.dat"
+	const missing = "SHA256E-s8--bbbb.dat"
+
+	writeFiles(t, wt, map[string]string{
+		".git/annex/objects/Xx/Yy/" + present + "/" + present: "content\n",
+		"unlocked.dat": "/annex/objects/" + present + "\n",
+		"pointer.dat":  "/annex/objects/" + missing + "\n",
+		"plain.dat":    "/not/annex/objects\n",
+	})
+
+	for name, key := range map[string]string{"locked.dat": present, "broken.dat": missing, "untracked.dat": missing, "link.dat": ""} {
+		target := ".git/annex/objects/Xx/Yy/" + key + "/" + key
+		if key == "" {
+			target = "README.md"
+		}
+		if err := os.Symlink(target, filepath.Join(wt, name)); err != nil {
+			t.Fatalf("could not create symlink: %v", err)
+		}
+	}
+
+	gitCmd(t, wt, "add", "unlocked.dat", "pointer.dat", "plain.dat", "locked.dat", "broken.dat", "link.dat")
+
+	//the content of the unlocked file is put in place
+	writeFiles(t, wt, map[string]string{"unlocked.dat": "content\n"})
+
+	status := checkStatus(t, wt)
+
+	type annexState struct {
+		kind    FileKind
+		key     string
+		present bool
+	}
+
+	expected := map[string]annexState{
+		"unlocked.dat":  {FileAnnexPointer, present, true},
+		"pointer.dat":   {FileAnnexPointer, missing, false},
+		"plain.dat":     {FileRegular, "", false},
+		"locked.dat":    {FileAnnexSymlink, present, true},
+		"broken.dat":    {FileAnnexSymlink, missing, false},
+		"untracked.dat": {FileAnnexSymlink, missing, false},
+		"link.dat":      {FileSymlink, "", false},
+	}
+
+	for _, e := range status {
+		state, ok := expected[e.Path]
+		if !ok {
+			continue
+		}
+		delete(expected, e.Path)
+
+		if actual := (annexState{e.Kind, e.AnnexKey, e.AnnexPresent}); actual != state {
+			t.Fatalf("%s: expected %+v, got %+v", e.Path, state, actual)
+		}
+	}
+
+	if len(expected) != 0 {
+		t.Fatalf("missing status entries: %v", expected)
+	}
+}
@@ -0,0 +1,224 @@
+package gig
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+//objectHeader returns the header of the git object representation,
+//i.e. [type][space][length {ASCII}][\0], see writeHeader.
+func objectHeader(otype ObjectType, size int64) []byte {
+	return []byte(fmt.Sprintf("%s %d\x00", otype, size))
+}
+
+//headerSkipper is a writer that discards everything up to
+//and including the first \0 byte, i.e. the object header,
+//and then passes all data to the wrapped writer.
+type headerSkipper struct {
+	w    io.Writer
+	done bool
+}
+
+func (hs *headerSkipper) Write(data []byte) (int, error) {
+	if hs.done {
+		return hs.w.Write(data)
+	}
+
+	n := len(data)
+	pos := bytes.IndexByte(data, 0)
+	if pos == -1 {
+		return n, nil
+	}
+
+	hs.done = true
+	_, err := hs.w.Write(data[pos+1:])
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+//looseObjectPath returns the path of the loose object with the given id.
+func (repo *Repository) looseObjectPath(id SHA1) string {
+	idstr := id.String()
+	return filepath.Join(repo.Path, "objects", idstr[:2], idstr[2:])
+}
+
+//HasObject checks if the object with the given id is stored
+//in the repository, either as loose object or in a pack.
+func (repo *Repository) HasObject(id SHA1) (bool, error) {
+	_, err := os.Stat(repo.looseObjectPath(id))
+	if err == nil {
+		return true, nil
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	pf, _, found, err := repo.packSet().find(id)
+	if found {
+		pf.release()
+	}
+	return found, err
+}
+
+//WriteObject stores the object in the repository as a loose
+//object and returns its id. The object is hashed and compressed
+//into a temporary file, which is then atomically moved into
+//place. If the object already exists, nothing is written.
+//NB: The object's data is consumed in the process.
+func (repo *Repository) WriteObject(obj Object) (SHA1, error) {
+	var id SHA1
+
+	if !IsStandardObject(obj.Type()) {
+		return id, fmt.Errorf("git: cannot write %s objects", obj.Type())
+	}
+
+	dir := filepath.Join(repo.Path, "objects")
+	tmp, err := ioutil.TempFile(dir, "tmp_obj_")
+	if err != nil {
+		return id, fmt.Errorf("git: could not create temporary object: %v", err)
+	}
+
+	//cleanup in case of errors, after the rename it is a noop
+	defer os.Remove(tmp.Name())
+
+	format := repo.ObjectFormat()
+	h := format.New()
+	zw := zlib.NewWriter(tmp)
+
+	n, err := obj.WriteTo(io.MultiWriter(h, zw))
+	if err == nil {
+		err = zw.Close()
+	}
+
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return id, fmt.Errorf("git: could not write object: %v", err)
+	}
+
+	header := objectHeader(obj.Type(), obj.Size())
+	if n != int64(len(header))+obj.Size() {
+		return id, fmt.Errorf("git: object size mismatch (%d != %d)", n-int64(len(header)), obj.Size())
+	}
+
+	id = format.ID(h.Sum(nil))
+
+	if ok, err := repo.HasObject(id); err != nil {
+		return id, err
+	} else if ok {
+		return id, nil
+	}
+
+	target := repo.looseObjectPath(id)
+	err = os.MkdirAll(filepath.Dir(target), 0777)
+	if err != nil {
+		return id, fmt.Errorf("git: could not create object dir: %v", err)
+	}
+
+	err = os.Chmod(tmp.Name(), 0444)
+	if err != nil {
+		return id, err
+	}
+
+	err = os.Rename(tmp.Name(), target)
+	if err != nil {
+		return id, fmt.Errorf("git: could not store object: %v", err)
+	}
+
+	return id, nil
+}
+
+//WritePack stores the objects in a new pack file (and the
+//corresponding index) in the repository. If deltaWindow is
+//larger than zero, objects are delta compressed against up
+//to deltaWindow preceding objects of the same type. Returns
+//the checksum of the pack, which is also part of its name.
+//NB: The objects' data is consumed in the process.
+func (repo *Repository) WritePack(objects []Object, deltaWindow int) (SHA1, error) {
+	var sum SHA1
+
+	dir := filepath.Join(repo.Path, "objects", "pack")
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return sum, err
+	}
+
+	packTmp, err := ioutil.TempFile(dir, "tmp_pack_")
+	if err != nil {
+		return sum, err
+	}
+	defer os.Remove(packTmp.Name())
+	defer packTmp.Close()
+
+	pw, err := newPackWriter(packTmp, uint32(len(objects)), repo.ObjectFormat())
+	if err != nil {
+		return sum, err
+	}
+	pw.DeltaWindow = deltaWindow
+
+	for _, obj := range objects {
+		_, err = pw.WriteObject(obj)
+		if err != nil {
+			return sum, err
+		}
+	}
+
+	err = pw.Close()
+	if err != nil {
+		return sum, err
+	}
+
+	idxTmp, err := ioutil.TempFile(dir, "tmp_idx_")
+	if err != nil {
+		return sum, err
+	}
+	defer os.Remove(idxTmp.Name())
+	defer idxTmp.Close()
+
+	err = pw.WriteIndex(idxTmp)
+	if err != nil {
+		return sum, err
+	}
+
+	sum = pw.Checksum()
+	return sum, repo.installPack(sum, packTmp, idxTmp)
+}
+
+//installPack moves the (temporary) pack and index file into
+//place. The index is moved last, since that is what makes the
+//pack visible to readers.
+func (repo *Repository) installPack(sum SHA1, pack, idx *os.File) error {
+	for _, f := range []*os.File{pack, idx} {
+		err := f.Sync()
+		if err == nil {
+			err = f.Chmod(0444)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	base := filepath.Join(repo.Path, "objects", "pack", fmt.Sprintf("pack-%s", sum))
+
+	err := os.Rename(pack.Name(), base+".pack")
+	if err != nil {
+		return fmt.Errorf("git: could not store pack: %v", err)
+	}
+
+	err = os.Rename(idx.Name(), base+".idx")
+	if err != nil {
+		return fmt.Errorf("git: could not store pack index: %v", err)
+	}
+
+	return nil
+}
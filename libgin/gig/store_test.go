@@ -0,0 +1,53 @@
+package gig
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteObject(t *testing.T) {
+	repo, _ := mkTestRepo(t)
+
+	data := []byte("some data for a new blob\n")
+	id, err := repo.WriteObject(NewBlob(bytes.NewReader(data), int64(len(data))))
+	if err != nil {
+		t.Fatalf("could not write blob: %v", err)
+	}
+
+	if out := gitCmd(t, repo.Path, "cat-file", "-p", id.String()); out+"\n" != string(data) {
+		t.Fatalf("blob content mismatch: %q", out)
+	}
+
+	//writing it again must be a noop
+	id2, err := repo.WriteObject(NewBlob(bytes.NewReader(data), int64(len(data))))
+	if err != nil || id2 != id {
+		t.Fatalf("rewriting blob => %s, %v", id2, err)
+	}
+
+	if ok, err := repo.HasObject(id); !ok || err != nil {
+		t.Fatalf("HasObject(%s) => %v, %v", id, ok, err)
+	}
+
+	//re-write an existing (packed) commit loosely
+	head, _ := ParseSHA1(gitCmd(t, repo.Path, "rev-parse", "master"))
+	obj, err := repo.OpenObject(head)
+	if err != nil {
+		t.Fatalf("could not open commit: %v", err)
+	}
+
+	cid, err := repo.WriteObject(obj)
+	if err != nil || cid != head {
+		t.Fatalf("rewriting commit => %s, %v", cid, err)
+	}
+
+	//wrong size must be detected
+	_, err = repo.WriteObject(NewBlob(bytes.NewReader(data), int64(len(data))+1))
+	if err == nil {
+		t.Fatalf("expected error for blob with wrong size")
+	}
+
+	if out := gitCmd(t, repo.Path, "fsck", "--full", "--no-dangling"); strings.Contains(out, "error") {
+		t.Fatalf("fsck failed: %s", out)
+	}
+}
@@ -0,0 +1,276 @@
+package gig
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//Submodule is a gitlink entry of a tree, i.e. a commit of another
+//repository, together with its settings in the .gitmodules file of
+//the tree, see gitmodules(5).
+type Submodule struct {
+	//Name is the name of the submodule in .gitmodules, which is
+	//also the name of its local clone in the "modules" directory;
+	//the path if the submodule is not in .gitmodules.
+	Name string
+	Path string
+
+	//URL is the URL as given in .gitmodules, i.e. relative URLs
+	//are relative to the remote of the superproject; empty if the
+	//submodule is not in .gitmodules.
+	URL    string
+	Branch string
+
+	ID SHA1
+}
+
+//SubmodulePolicy controls how tree traversals treat gitlinks.
+type SubmodulePolicy int
+
+//SubmodulePolicy values.
+const (
+	//SubmoduleSkip ignores gitlinks.
+	SubmoduleSkip SubmodulePolicy = iota
+
+	//SubmoduleReport passes gitlinks on, but does not look into
+	//the submodules.
+	SubmoduleReport
+
+	//SubmoduleRecurse passes gitlinks on and then traverses the
+	//tree of the commit in the local clone of the submodule.
+	//Submodules without a local clone that has the commit are
+	//treated like with SubmoduleReport.
+	SubmoduleRecurse
+)
+
+//SkipTree can be returned by a TreeWalkFunc to skip the
+//entries of a tree or submodule.
+var SkipTree = errors.New("skip this tree")
+
+//TreeWalkFunc is called by WalkTree for each entry. The path is
+//relative to the root tree, repo is the repository the entry is
+//in, which is a different one for the entries of submodules; those
+//are closed (see Repository.Close) when their walk is done.
+type TreeWalkFunc func(repo *Repository, path string, entry *TreeEntry) error
+
+//readGitmodules reads the .gitmodules file of the tree; includes
+//are not followed. Trees without the file yield an empty config.
+func (repo *Repository) readGitmodules(tree SHA1) (*Config, error) {
+	c := &Config{noIncludes: true}
+
+	entries, err := repo.readTreeEntries(tree)
+	if err != nil {
+		return nil, err
+	}
+
+	e, ok := entries[".gitmodules"]
+	if !ok || modeKind(e.Mode) != ModeBlob {
+		return c, nil
+	}
+
+	data, err := repo.readBlob(e.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.parse(string(data), tree.String()+":.gitmodules", "", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+//submoduleAt returns the submodule with the given path and commit,
+//with the settings from the .gitmodules config.
+func submoduleAt(config *Config, path string, id SHA1) Submodule {
+	sub := Submodule{Name: path, Path: path, ID: id}
+
+	for _, name := range config.Subsections("submodule") {
+		if p, _ := config.Get("submodule." + name + ".path"); p != path {
+			continue
+		}
+
+		sub.Name = name
+		sub.URL, _ = config.Get("submodule." + name + ".url")
+		sub.Branch, _ = config.Get("submodule." + name + ".branch")
+	}
+
+	return sub
+}
+
+//Submodules returns the submodules of the tree with the given id
+//(at any depth, but not those of the submodules), ordered by path.
+func (repo *Repository) Submodules(tree SHA1) ([]Submodule, error) {
+	config, err := repo.readGitmodules(tree)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]TreeEntry)
+	err = repo.flattenTree(tree, "", files)
+	if err != nil {
+		return nil, err
+	}
+
+	var subs []Submodule
+	for path, e := range files {
+		if e.Mode == ModeGitlink {
+			subs = append(subs, submoduleAt(config, path, e.ID))
+		}
+	}
+
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].Path < subs[j].Path
+	})
+
+	return subs, nil
+}
+
+//validSubmoduleName reports whether the name can be used as the
+//name of the local clone. Like git, names which are absolute or
+//contain ".." components are rejected, as they come from the tree
+//and could otherwise point to any repository; so are backslashes.
+func validSubmoduleName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "/") || filepath.IsAbs(name) || strings.Contains(name, "\\") {
+		return false
+	}
+
+	for _, c := range strings.Split(name, "/") {
+		if c == ".." {
+			return false
+		}
+	}
+
+	return true
+}
+
+//within reports whether path is below dir.
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+//OpenSubmodule opens the local clone of the submodule, i.e. the
+//one in the "modules" directory of the repository or, for older
+//clones, the one in the work tree. Returns an error that wraps
+//ErrNotRepository if there is none or if the name or path of the
+//submodule is invalid.
+func (repo *Repository) OpenSubmodule(sub *Submodule) (*Repository, error) {
+	if !validSubmoduleName(sub.Name) {
+		return nil, fmt.Errorf("%w: invalid submodule name %q", ErrNotRepository, sub.Name)
+	}
+
+	modules := filepath.Join(repo.Path, "modules")
+	path := filepath.Join(modules, filepath.FromSlash(sub.Name))
+	if !within(modules, path) {
+		return nil, fmt.Errorf("%w: invalid submodule name %q", ErrNotRepository, sub.Name)
+	}
+
+	if isGitDir(path) {
+		return OpenRepository(path)
+	}
+
+	if repo.WorkTree != "" {
+		path = filepath.Join(repo.WorkTree, filepath.FromSlash(sub.Path))
+		if !within(repo.WorkTree, path) {
+			return nil, fmt.Errorf("%w: invalid submodule path %q", ErrNotRepository, sub.Path)
+		}
+
+		if _, err := os.Stat(filepath.Join(path, ".git")); err == nil {
+			return OpenRepository(path)
+		}
+	}
+
+	return nil, fmt.Errorf("%w: no local clone of submodule %q", ErrNotRepository, sub.Name)
+}
+
+//WalkTree calls fn for all entries below the tree with the given id,
+//depth-first and in the order of the trees, i.e. trees before their
+//entries. Gitlinks are handled according to the policy. Errors of
+//fn, except for SkipTree, stop the walk and are returned.
+func (repo *Repository) WalkTree(tree SHA1, policy SubmodulePolicy, fn TreeWalkFunc) error {
+	var config *Config
+	if policy == SubmoduleRecurse {
+		var err error
+		config, err = repo.readGitmodules(tree)
+		if err != nil {
+			return err
+		}
+	}
+
+	return repo.walkTree(tree, "", "", policy, config, fn)
+}
+
+//walkTree walks the tree at dir (relative to the root of repo), whose
+//paths are prefixed with prefix, the path of the submodule.
+func (repo *Repository) walkTree(tree SHA1, prefix, dir string, policy SubmodulePolicy, config *Config, fn TreeWalkFunc) error {
+	obj, err := repo.OpenObject(tree)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	t, ok := obj.(*Tree)
+	if !ok {
+		return &ObjectTypeError{ID: tree, Type: obj.Type(), Expected: ObjTree}
+	}
+
+	for t.Next() {
+		e := *t.Entry()
+		if e.Mode == ModeGitlink && policy == SubmoduleSkip {
+			continue
+		}
+
+		name := dir + e.Name
+		err := fn(repo, prefix+name, &e)
+		if err == SkipTree {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		switch {
+		case e.Mode == ModeTree:
+			err = repo.walkTree(e.ID, prefix, name+"/", policy, config, fn)
+		case e.Mode == ModeGitlink && policy == SubmoduleRecurse:
+			err = repo.walkSubmodule(submoduleAt(config, name, e.ID), prefix, fn)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return t.Err()
+}
+
+//walkSubmodule walks the tree of the submodule in its local clone,
+//if there is one.
+func (repo *Repository) walkSubmodule(sub Submodule, prefix string, fn TreeWalkFunc) error {
+	subrepo, err := repo.OpenSubmodule(&sub)
+	if errors.Is(err, ErrNotRepository) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer subrepo.Close()
+
+	tree, err := subrepo.ResolveRevision(sub.ID.String() + "^{tree}")
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	config, err := subrepo.readGitmodules(tree)
+	if err != nil {
+		return err
+	}
+
+	return subrepo.walkTree(tree, prefix+sub.Path+"/", "", SubmoduleRecurse, config, fn)
+}
@@ -0,0 +1,173 @@
+package gig
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSubmodules(t *testing.T) {
+	_, sub := mkTestRepo(t)
+	subHead := gitCmd(t, sub, "rev-parse", "HEAD")
+
+	super := filepath.Join(filepath.Dir(sub), "super")
+	gitCmd(t, filepath.Dir(sub), "init", "-q", super)
+	writeFiles(t, super, map[string]string{"file.txt": "file\n", "deps/other.txt": "other\n"})
+	gitCmd(t, super, "-c", "protocol.file.allow=always", "submodule", "add", "-q", sub, "mod")
+	gitCmd(t, super, "-c", "protocol.file.allow=always", "submodule", "add", "-q", "--name", "lib", "-b", "master", sub, "deps/lib")
+	gitCmd(t, super, "add", "-A")
+	gitCmd(t, super, "commit", "-q", "-m", "submodules")
+
+	repo, err := OpenRepository(super)
+	if err != nil {
+		t.Fatalf("could not open repository: %v", err)
+	}
+
+	tree := revParse(t, repo, "HEAD^{tree}")
+	id, err := ParseSHA1(subHead)
+	if err != nil {
+		t.Fatalf("could not parse id: %v", err)
+	}
+
+	subs, err := repo.Submodules(tree)
+	if err != nil {
+		t.Fatalf("could not list submodules: %v", err)
+	}
+
+	expected := []Submodule{
+		{Name: "lib", Path: "deps/lib", URL: sub, Branch: "master", ID: id},
+		{Name: "mod", Path: "mod", URL: sub, ID: id},
+	}
+	if !reflect.DeepEqual(subs, expected) {
+		t.Fatalf("unexpected submodules:\n%+v\nexpected:\n%+v", subs, expected)
+	}
+
+	//paths into submodules
+	obj, err := repo.OpenObject(revParse(t, repo, "HEAD"))
+	if err != nil {
+		t.Fatalf("could not open commit: %v", err)
+	}
+	defer obj.Close()
+
+	for p, link := range map[string]string{"mod": "mod", "deps/lib/data/a.txt": "deps/lib"} {
+		_, err := repo.ObjectForPath(obj, p)
+		var perr *os.PathError
+		if !errors.Is(err, ErrSubmodule) || !errors.As(err, &perr) || perr.Path != link {
+			t.Fatalf("%s: expected ErrSubmodule for %s, got %v", p, link, err)
+		}
+	}
+
+	//traversals with the different policies
+	walk := func(repo *Repository, policy SubmodulePolicy, skip string) string {
+		t.Helper()
+
+		var paths []string
+		err := repo.WalkTree(tree, policy, func(r *Repository, p string, e *TreeEntry) error {
+			inSub := strings.HasPrefix(p, "mod/") || strings.HasPrefix(p, "deps/lib/")
+			if inSub == (r.Path == repo.Path) {
+				t.Fatalf("%s: unexpected repository %s", p, r.Path)
+			}
+
+			paths = append(paths, p)
+			if p == skip {
+				return SkipTree
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("could not walk tree: %v", err)
+		}
+
+		return strings.Join(paths, "\n")
+	}
+
+	all := gitCmd(t, super, "ls-tree", "-r", "-t", "--name-only", "HEAD")
+	subFiles := gitCmd(t, sub, "ls-tree", "-r", "-t", "--name-only", "HEAD")
+
+	var withoutLinks []string
+	for _, p := range strings.Split(all, "\n") {
+		if p != "mod" && p != "deps/lib" {
+			withoutLinks = append(withoutLinks, p)
+		}
+	}
+
+	recursed := all
+	for _, prefix := range []string{"mod/", "deps/lib/"} {
+		recursed += "\n" + prefix + strings.Replace(subFiles, "\n", "\n"+prefix, -1)
+	}
+
+	if paths := walk(repo, SubmoduleSkip, ""); paths != strings.Join(withoutLinks, "\n") {
+		t.Fatalf("unexpected paths when skipping submodules:\n%s", paths)
+	} else if paths := walk(repo, SubmoduleReport, ""); paths != all {
+		t.Fatalf("unexpected paths when reporting submodules:\n%s", paths)
+	} else if paths := walk(repo, SubmoduleRecurse, ""); sortLines(paths) != sortLines(recursed) {
+		t.Fatalf("unexpected paths when recursing into submodules:\n%s\nexpected:\n%s", paths, recursed)
+	} else if paths := walk(repo, SubmoduleRecurse, "deps"); strings.Contains(paths, "deps/") {
+		t.Fatalf("unexpected paths when skipping a tree:\n%s", paths)
+	}
+
+	//a clone without the submodules
+	bare := filepath.Join(filepath.Dir(sub), "super.git")
+	gitCmd(t, filepath.Dir(sub), "clone", "-q", "--bare", super, bare)
+
+	clone, err := OpenRepository(bare)
+	if err != nil {
+		t.Fatalf("could not open repository: %v", err)
+	}
+
+	if paths := walk(clone, SubmoduleRecurse, ""); paths != all {
+		t.Fatalf("unexpected paths when recursing into missing submodules:\n%s", paths)
+	}
+
+	if _, err := clone.OpenSubmodule(&subs[0]); !errors.Is(err, ErrNotRepository) {
+		t.Fatalf("expected ErrNotRepository, got %v", err)
+	}
+
+	//names from the tree must not lead out of the modules directory
+	victim := filepath.Join(filepath.Dir(sub), "victim.git")
+	gitCmd(t, filepath.Dir(sub), "clone", "-q", "--bare", sub, victim)
+
+	evil := filepath.Join(filepath.Dir(sub), "evil")
+	gitCmd(t, filepath.Dir(sub), "init", "-q", evil)
+	writeFiles(t, evil, map[string]string{".gitmodules": "[submodule \"../../victim.git\"]\n\tpath = mod\n\turl = " + sub + "\n"})
+	gitCmd(t, evil, "update-index", "--add", "--cacheinfo", "160000,"+subHead+",mod")
+	gitCmd(t, evil, "add", ".gitmodules")
+	gitCmd(t, evil, "commit", "-q", "-m", "evil")
+
+	evilBare := filepath.Join(filepath.Dir(sub), "evil.git")
+	gitCmd(t, filepath.Dir(sub), "clone", "-q", "--bare", evil, evilBare)
+
+	clone, err = OpenRepository(evilBare)
+	if err != nil {
+		t.Fatalf("could not open repository: %v", err)
+	}
+
+	tree = revParse(t, clone, "HEAD^{tree}")
+	var paths []string
+	err = clone.WalkTree(tree, SubmoduleRecurse, func(r *Repository, p string, e *TreeEntry) error {
+		paths = append(paths, p)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("could not walk tree: %v", err)
+	} else if strings.Join(paths, "\n") != ".gitmodules\nmod" {
+		t.Fatalf("unexpected paths with an invalid submodule name:\n%s", strings.Join(paths, "\n"))
+	}
+
+	for _, name := range []string{"../../victim.git", "a/../../b", "/abs", "a\\..\\b", "..", ""} {
+		if validSubmoduleName(name) {
+			t.Fatalf("expected %q to be invalid", name)
+		}
+		if _, err := clone.OpenSubmodule(&Submodule{Name: name, Path: "mod"}); !errors.Is(err, ErrNotRepository) {
+			t.Fatalf("%q: expected ErrNotRepository, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"mod", "deps/lib", "a..b", ".hidden"} {
+		if !validSubmoduleName(name) {
+			t.Fatalf("expected %q to be valid", name)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package gig
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+//TagRef is a tag, i.e. a ref in "refs/tags", which is either an
+//annotated tag (pointing to a tag object) or a lightweight tag.
+type TagRef struct {
+	//Name is the name of the tag, without "refs/tags/".
+	Name string
+
+	//ID is the value of the ref, which is the id of the
+	//tag object for annotated tags.
+	ID SHA1
+
+	//Target is the object the tag finally points to, after
+	//peeling all tag objects, and TargetType its type.
+	Target     SHA1
+	TargetType ObjectType
+
+	//Tag is the tag object of annotated tags, nil for
+	//lightweight tags.
+	Tag *Tag
+}
+
+//Tags returns all tags of the repository, ordered by name.
+func (repo *Repository) Tags() ([]*TagRef, error) {
+	var tags []*TagRef
+
+	iter := repo.Refs()
+	for iter.Next() {
+		ref := iter.Ref()
+		if ref.Namespace() != "tags" {
+			continue
+		}
+
+		id, err := ref.Resolve()
+		if err != nil {
+			return nil, err
+		}
+
+		obj, err := repo.OpenObject(id)
+		if err != nil {
+			return nil, err
+		}
+		obj.Close()
+
+		tr := &TagRef{Name: ref.Name(), ID: id, Target: id, TargetType: obj.Type()}
+		if tag, ok := obj.(*Tag); ok {
+			tr.Tag = tag
+			tr.Target, tr.TargetType, err = repo.PeelTag(id)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		tags = append(tags, tr)
+	}
+
+	return tags, iter.Err()
+}
+
+//PeelTag follows a chain of tag objects, starting at id, and returns
+//the id and type of the first non-tag object. If id is not a tag,
+//it is returned together with its type.
+func (repo *Repository) PeelTag(id SHA1) (SHA1, ObjectType, error) {
+	for {
+		obj, err := repo.OpenObject(id)
+		if err != nil {
+			return SHA1{}, 0, err
+		}
+		obj.Close()
+
+		tag, isTag := obj.(*Tag)
+		if !isTag {
+			return id, obj.Type(), nil
+		}
+
+		id = tag.Object
+	}
+}
+
+//peelTag returns the non-tag object an annotated tag finally points
+//to; ok is false if id is not an annotated tag.
+func (repo *Repository) peelTag(id SHA1) (peeled SHA1, ok bool, err error) {
+	peeled, _, err = repo.PeelTag(id)
+	return peeled, err == nil && peeled != id, err
+}
+
+//VerifyCommit checks the OpenPGP signature ("gpgsig", or "gpgsig-sha256"
+//in SHA-256 repositories) of the commit with the given id against the key ring, see KeyRing.Verify. Returns
+//ErrNotSigned if the commit is not signed.
+func (repo *Repository) VerifyCommit(id SHA1, kr *KeyRing) (*PGPSignature, error) {
+	return repo.verifyObject(id, ObjCommit, kr)
+}
+
+//VerifyTag checks the OpenPGP signature of the tag object with the
+//given id against the key ring, see KeyRing.Verify. Returns
+//ErrNotSigned if the tag is not signed.
+func (repo *Repository) VerifyTag(id SHA1, kr *KeyRing) (*PGPSignature, error) {
+	return repo.verifyObject(id, ObjTag, kr)
+}
+
+func (repo *Repository) verifyObject(id SHA1, otype ObjectType, kr *KeyRing) (*PGPSignature, error) {
+	t, data, err := repo.readRawData(id)
+	if err != nil {
+		return nil, err
+	} else if t != otype {
+		return nil, &ObjectTypeError{ID: id, Type: t, Expected: otype}
+	}
+
+	var payload []byte
+	var signature string
+	if otype == ObjCommit {
+		payload, signature = splitCommitSignature(data, id.Format())
+	} else {
+		payload, signature = splitTagSignature(data)
+	}
+
+	if signature == "" {
+		return nil, fmt.Errorf("%w: %s %s", ErrNotSigned, otype, id)
+	}
+
+	return kr.Verify(payload, signature)
+}
+
+//splitCommitSignature splits the raw data of a commit into the
+//signed payload, which is the commit without the signature headers,
+//and the signature for the object format.
+func splitCommitSignature(data []byte, format ObjectFormat) ([]byte, string) {
+	header, other := []byte("gpgsig "), []byte("gpgsig-sha256 ")
+	if format == FormatSHA256 {
+		header, other = other, header
+	}
+
+	var payload bytes.Buffer
+	var sig strings.Builder
+	inSig, inHeader := false, true
+
+	for len(data) > 0 {
+		line := data
+		if n := bytes.IndexByte(data, '\n'); n != -1 {
+			line = data[:n+1]
+		}
+		data = data[len(line):]
+
+		switch {
+		case !inHeader:
+			payload.Write(line)
+			continue
+		case inSig && line[0] == ' ':
+			sig.Write(line[1:])
+			continue
+		}
+
+		inSig = false
+		switch {
+		case bytes.HasPrefix(line, header):
+			inSig = true
+			sig.Write(line[len(header):])
+		case bytes.HasPrefix(line, other):
+			//the signature of the commit in the other object
+			//format, which is not part of the payload either
+			for len(data) > 0 && data[0] == ' ' {
+				n := bytes.IndexByte(data, '\n')
+				if n == -1 {
+					n = len(data) - 1
+				}
+				data = data[n+1:]
+			}
+		default:
+			inHeader = line[0] != '\n'
+			payload.Write(line)
+		}
+	}
+
+	return payload.Bytes(), sig.String()
+}
+
+//splitTagSignature splits the raw data of a tag into the signed
+//payload and the signature, which is appended to the message.
+func splitTagSignature(data []byte) ([]byte, string) {
+	n := bytes.LastIndex(data, []byte("\n-----BEGIN PGP SIGNATURE-----"))
+	if n == -1 || bytes.Index(data, []byte("\n\n")) > n {
+		return data, ""
+	}
+
+	return data[:n+1], string(data[n+1:])
+}
@@ -0,0 +1,306 @@
+package gig
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTags(t *testing.T) {
+	repo, _ := mkTestRepo(t)
+	gitCmd(t, repo.Path, "tag", "light", "master~1")
+	gitCmd(t, repo.Path, "tag", "-a", "-m", "release", "v1", "master")
+	gitCmd(t, repo.Path, "tag", "-a", "-m", "nested", "v2", "v1")
+	gitCmd(t, repo.Path, "tag", "tree", "master^{tree}")
+
+	tags, err := repo.Tags()
+	if err != nil {
+		t.Fatalf("could not list tags: %v", err)
+	}
+
+	expected := []struct {
+		name   string
+		target string
+		otype  ObjectType
+		tag    string
+	}{
+		{"light", "master~1", ObjCommit, ""},
+		{"tree", "master^{tree}", ObjTree, ""},
+		{"v1", "master", ObjCommit, "v1"},
+		{"v2", "master", ObjCommit, "v2"},
+	}
+
+	if len(tags) != len(expected) {
+		t.Fatalf("expected %d tags, got %d", len(expected), len(tags))
+	}
+
+	for i, e := range expected {
+		tr := tags[i]
+		if tr.Name != e.name || tr.ID != revParse(t, repo, e.name) {
+			t.Fatalf("unexpected tag %q (%s), expected %q", tr.Name, tr.ID, e.name)
+		}
+
+		if tr.Target != revParse(t, repo, e.target) || tr.TargetType != e.otype {
+			t.Fatalf("%s: unexpected target %s (%s)", tr.Name, tr.Target, tr.TargetType)
+		}
+
+		if (tr.Tag == nil) != (e.tag == "") || (tr.Tag != nil && tr.Tag.Tag != e.tag) {
+			t.Fatalf("%s: unexpected tag object %v", tr.Name, tr.Tag)
+		}
+	}
+
+	//v2 points to v1, which is peeled as well
+	if tags[3].Tag.Object != tags[2].ID {
+		t.Fatalf("expected v2 to point to v1")
+	}
+}
+
+//mkGPGHome creates a temporary gpg home directory, which is used
+//for the rest of the test, and returns a function that generates
+//a signing key with the given algorithm and returns its fingerprint.
+func mkGPGHome(t *testing.T) func(algo string) string {
+	t.Helper()
+
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("[W] gpg not found. Skipping test")
+	}
+
+	home, err := ioutil.TempDir("", "gig-gpg")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v", err)
+	}
+
+	old, had := os.LookupEnv("GNUPGHOME")
+	os.Setenv("GNUPGHOME", home)
+	t.Cleanup(func() {
+		exec.Command("gpgconf", "--kill", "gpg-agent").Run()
+		if had {
+			os.Setenv("GNUPGHOME", old)
+		} else {
+			os.Unsetenv("GNUPGHOME")
+		}
+		os.RemoveAll(home)
+	})
+
+	return func(algo string) string {
+		t.Helper()
+
+		uid := "Signer " + algo + " <" + algo + "@example.com>"
+		gpg(t, "--quick-gen-key", uid, algo, "sign", "never")
+
+		out := gpg(t, "--with-colons", "--list-keys", uid)
+		for _, l := range strings.Split(out, "\n") {
+			if strings.HasPrefix(l, "fpr:") {
+				return strings.Split(l, ":")[9]
+			}
+		}
+
+		t.Fatalf("no fingerprint for key %q", uid)
+		return ""
+	}
+}
+
+func gpg(t *testing.T, args ...string) string {
+	t.Helper()
+
+	args = append([]string{"--batch", "--quiet", "--passphrase", "", "--pinentry-mode", "loopback"}, args...)
+	out, err := exec.Command("gpg", args...).Output()
+	if err != nil {
+		t.Fatalf("gpg %s failed: %v", strings.Join(args, " "), err)
+	}
+
+	return string(out)
+}
+
+func TestVerifySignatures(t *testing.T) {
+	genKey := mkGPGHome(t)
+	repo, wt := mkTestRepo(t)
+
+	rsaKey := genKey("rsa2048")
+	ecdsaKey := genKey("nistp256")
+	edKey := genKey("ed25519")
+
+	//a signing subkey, which is used instead of the primary key
+	gpg(t, "--quick-add-key", edKey, "ed25519", "sign", "never")
+
+	kr, err := ReadKeyRing(strings.NewReader(gpg(t, "--armor", "--export")))
+	if err != nil {
+		t.Fatalf("could not read key ring: %v", err)
+	}
+
+	if len(kr.Keys) != 4 {
+		t.Fatalf("expected 4 keys, got %d", len(kr.Keys))
+	}
+
+	binary, err := ReadKeyRing(strings.NewReader(gpg(t, "--export", rsaKey)))
+	if err != nil || len(binary.Keys) != 1 || binary.Keys[0].Fingerprint != rsaKey {
+		t.Fatalf("could not read binary key ring: %v", err)
+	}
+
+	if key := kr.KeyByID(rsaKey[24:]); key == nil || key.UserIDs[0] != "Signer rsa2048 <rsa2048@example.com>" {
+		t.Fatalf("unexpected key for id %s: %v", rsaKey[24:], key)
+	}
+
+	gitCmd(t, wt, "commit", "-q", "--allow-empty", "-m", "unsigned")
+	gitCmd(t, wt, "tag", "-a", "-m", "unsigned", "unsigned")
+	for _, key := range []string{rsaKey, ecdsaKey, edKey} {
+		gitCmd(t, wt, "-c", "user.signingkey="+key, "commit", "-q", "--allow-empty", "-S", "-m", "signed\n\nmessage")
+		gitCmd(t, wt, "-c", "user.signingkey="+key, "tag", "-s", "-m", "signed", "tag-"+key)
+		gitCmd(t, wt, "branch", "-f", "signed-"+key)
+	}
+	gitCmd(t, wt, "push", "-q", "--tags", "bare", "master", "signed-"+rsaKey, "signed-"+ecdsaKey, "signed-"+edKey)
+
+	for _, key := range []string{rsaKey, ecdsaKey, edKey} {
+		sig, err := repo.VerifyCommit(revParse(t, repo, "signed-"+key), kr)
+		if err != nil {
+			t.Fatalf("%s: could not verify commit: %v", key, err)
+		}
+
+		primary := sig.Key
+		if primary.Primary != nil {
+			primary = primary.Primary
+		}
+
+		if primary.Fingerprint != key || sig.Created.IsZero() {
+			t.Fatalf("%s: unexpected signature %+v", key, sig)
+		}
+
+		if key == edKey && sig.Key.Primary == nil {
+			t.Fatalf("%s: expected signature by subkey", key)
+		}
+
+		sig, err = repo.VerifyTag(revParse(t, repo, "tag-"+key), kr)
+		if err != nil {
+			t.Fatalf("%s: could not verify tag: %v", key, err)
+		}
+
+		if sig.Key.Fingerprint != key && sig.Key.Primary.Fingerprint != key {
+			t.Fatalf("%s: unexpected signature %+v", key, sig)
+		}
+	}
+
+	if _, err := repo.VerifyCommit(revParse(t, repo, "signed-"+edKey), binary); !errors.Is(err, ErrUnknownSigningKey) {
+		t.Fatalf("expected ErrUnknownSigningKey, got %v", err)
+	}
+
+	if _, err := repo.VerifyCommit(revParse(t, repo, "master~3"), kr); !errors.Is(err, ErrNotSigned) {
+		t.Fatalf("expected ErrNotSigned, got %v", err)
+	}
+
+	if _, err := repo.VerifyTag(revParse(t, repo, "unsigned"), kr); !errors.Is(err, ErrNotSigned) {
+		t.Fatalf("expected ErrNotSigned, got %v", err)
+	}
+
+	var terr *ObjectTypeError
+	if _, err := repo.VerifyTag(revParse(t, repo, "master"), kr); !errors.As(err, &terr) {
+		t.Fatalf("expected ObjectTypeError, got %v", err)
+	}
+
+	//the same signatures for modified objects
+	for _, rev := range []string{"signed-" + rsaKey, "tag-" + edKey} {
+		otype := "commit"
+		if strings.HasPrefix(rev, "tag-") {
+			otype = "tag"
+		}
+
+		raw := gitCmd(t, repo.Path, "cat-file", otype, rev)
+		forged := strings.Replace(raw, "signed", "forged", 1)
+
+		cmd := exec.Command("git", "hash-object", "-t", otype, "-w", "--stdin")
+		cmd.Dir = repo.Path
+		cmd.Stdin = bytes.NewBufferString(forged + "\n")
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("could not write forged object: %v", err)
+		}
+
+		id, err := ParseSHA1(string(out))
+		if err != nil {
+			t.Fatalf("could not parse id: %v", err)
+		}
+
+		if otype == "commit" {
+			_, err = repo.VerifyCommit(id, kr)
+		} else {
+			_, err = repo.VerifyTag(id, kr)
+		}
+
+		if !errors.Is(err, ErrBadSignature) {
+			t.Fatalf("%s: expected ErrBadSignature, got %v", rev, err)
+		}
+	}
+}
+
+func TestVerifyKeyState(t *testing.T) {
+	genKey := mkGPGHome(t)
+	key := genKey("ed25519")
+
+	data := []byte("signed data\n")
+	path := filepath.Join(t.TempDir(), "data")
+	if err := ioutil.WriteFile(path, data, 0666); err != nil {
+		t.Fatalf("could not write data: %v", err)
+	}
+
+	sign := func(args ...string) string {
+		t.Helper()
+		args = append(args, "--armor", "--detach-sign", "-o", "-", path)
+		return gpg(t, args...)
+	}
+
+	verify := func(signature string) error {
+		t.Helper()
+		kr, err := ReadKeyRing(strings.NewReader(gpg(t, "--armor", "--export")))
+		if err != nil {
+			t.Fatalf("could not read key ring: %v", err)
+		}
+
+		_, err = kr.Verify(data, signature)
+		return err
+	}
+
+	if err := verify(sign("-u", key)); err != nil {
+		t.Fatalf("could not verify signature: %v", err)
+	}
+
+	//unknown subpackets that are marked as critical
+	if err := verify(sign("-u", key, "--sig-notation", "!note@example.com=value")); err == nil || !strings.Contains(err.Error(), "critical") {
+		t.Fatalf("expected error for critical notation, got %v", err)
+	}
+
+	//a signature that expired a day after it was made, two days ago
+	past := time.Now().Add(-48 * time.Hour).UTC().Format("20060102T150405")
+	expired := sign("-u", key, "--faked-system-time", past, "--ignore-time-conflict", "--default-sig-expire", "1d")
+	if err := verify(expired); !errors.Is(err, ErrExpiredSignature) {
+		t.Fatalf("expected ErrExpiredSignature, got %v", err)
+	}
+
+	//a key that expired a day after it was created
+	uid := "Expired <expired@example.com>"
+	gpg(t, "--faked-system-time", past, "--quick-gen-key", uid, "ed25519", "sign", "1d")
+	if err := verify(sign("-u", uid, "--faked-system-time", past, "--ignore-time-conflict")); !errors.Is(err, ErrExpiredKey) {
+		t.Fatalf("expected ErrExpiredKey, got %v", err)
+	}
+
+	//a revoked key, with the revocation certificate made by gpg
+	signature := sign("-u", key)
+	rev, err := ioutil.ReadFile(filepath.Join(os.Getenv("GNUPGHOME"), "openpgp-revocs.d", key+".rev"))
+	if err != nil {
+		t.Fatalf("could not read revocation certificate: %v", err)
+	}
+
+	cmd := exec.Command("gpg", "--batch", "--quiet", "--import")
+	cmd.Stdin = strings.NewReader(strings.Replace(string(rev), ":-----BEGIN", "-----BEGIN", 1))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("could not import revocation: %v\n%s", err, out)
+	}
+
+	if err := verify(signature); !errors.Is(err, ErrRevokedKey) {
+		t.Fatalf("expected ErrRevokedKey, got %v", err)
+	}
+}
@@ -0,0 +1,328 @@
+package gig
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+//treeEntryLess compares tree entries in the order git expects them
+//in tree objects: by name, where the names of trees are compared as
+//if they had a trailing slash.
+func treeEntryLess(a, b *TreeEntry) bool {
+	an, bn := a.Name, b.Name
+	if a.Mode == ModeTree {
+		an += "/"
+	}
+	if b.Mode == ModeTree {
+		bn += "/"
+	}
+	return an < bn
+}
+
+//NewTree returns a new Tree object with the given entries, which
+//are sorted in the order git requires. All ids must be of the
+//same object format.
+func NewTree(entries []TreeEntry) (*Tree, error) {
+	sorted := make([]*TreeEntry, len(entries))
+	for i := range entries {
+		sorted[i] = &entries[i]
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return treeEntryLess(sorted[i], sorted[j])
+	})
+
+	var buf bytes.Buffer
+	var format ObjectFormat
+	for i, e := range sorted {
+		if e.Name == "" || strings.ContainsAny(e.Name, "/\x00") {
+			return nil, fmt.Errorf("git: invalid tree entry name %q", e.Name)
+		} else if i > 0 && e.Name == sorted[i-1].Name {
+			return nil, fmt.Errorf("git: duplicate tree entry %q", e.Name)
+		} else if i > 0 && e.ID.Format() != format {
+			return nil, fmt.Errorf("git: tree entry %q has a %s id, expected %s", e.Name, e.ID.Format(), format)
+		}
+		format = e.ID.Format()
+
+		//format is: [mode{ASCII, octal}][space][name][\0][SHA1]
+		fmt.Fprintf(&buf, "%o %s\x00", uint32(e.Mode), e.Name)
+		buf.Write(e.ID.Bytes())
+	}
+
+	obj := gitObject{otype: ObjTree, size: int64(buf.Len()), source: ioutil.NopCloser(&buf), format: format}
+	return &Tree{gitObject: obj}, nil
+}
+
+//objectSize determines the size of an object, which does not have
+//its size set yet, by serializing it. Only used for small objects
+//like commits and tags.
+func objectSize(obj Object, otype ObjectType) int64 {
+	var cw countingWriter
+	cw.w = ioutil.Discard
+
+	n, _ := obj.WriteTo(&cw)
+	return n - int64(len(objectHeader(otype, 0)))
+}
+
+//NewCommit returns a new Commit object.
+func NewCommit(tree SHA1, parents []SHA1, author, committer Signature, message string) *Commit {
+	c := &Commit{
+		gitObject: gitObject{otype: ObjCommit},
+		Tree:      tree,
+		Parent:    parents,
+		Author:    author,
+		Committer: committer,
+		Message:   message,
+	}
+
+	c.size = objectSize(c, ObjCommit)
+	return c
+}
+
+//NewTag returns a new (annotated) Tag object.
+func NewTag(object SHA1, otype ObjectType, name string, tagger Signature, message string) *Tag {
+	t := &Tag{
+		gitObject: gitObject{otype: ObjTag},
+		Object:    object,
+		ObjType:   otype,
+		Tag:       name,
+		Tagger:    tagger,
+		Message:   message,
+	}
+
+	t.size = objectSize(t, ObjTag)
+	return t
+}
+
+//CreateCommit creates a new commit object for the given tree and
+//parents and stores it in the repository. Returns the commit's id.
+func (repo *Repository) CreateCommit(tree SHA1, parents []SHA1, author, committer Signature, message string) (SHA1, error) {
+	return repo.WriteObject(NewCommit(tree, parents, author, committer, message))
+}
+
+//TreeBuilder builds (nested) trees from a set of path to object
+//mappings. It can start with an existing tree, which then gets
+//modified; unchanged subtrees are not rewritten.
+type TreeBuilder struct {
+	repo *Repository
+	root *treeNode
+}
+
+//treeNode is a tree that is being build. If id is set and
+//entries is nil, it refers to an existing, unmodified, tree
+//that is loaded lazily.
+type treeNode struct {
+	id      *SHA1
+	entries map[string]*treeNodeEntry
+}
+
+type treeNodeEntry struct {
+	mode os.FileMode
+	id   SHA1
+	tree *treeNode //only set for trees
+}
+
+//NewTreeBuilder creates a new TreeBuilder, which starts with an
+//empty tree.
+func NewTreeBuilder(repo *Repository) *TreeBuilder {
+	return &TreeBuilder{repo: repo, root: &treeNode{entries: make(map[string]*treeNodeEntry)}}
+}
+
+//Load replaces the contents of the builder with the tree with
+//the given id.
+func (tb *TreeBuilder) Load(tree SHA1) error {
+	node := &treeNode{id: &tree}
+	err := tb.load(node)
+	if err != nil {
+		return err
+	}
+
+	tb.root = node
+	return nil
+}
+
+//load reads the entries of an existing tree into node.
+func (tb *TreeBuilder) load(node *treeNode) error {
+	if node.entries != nil {
+		return nil
+	}
+
+	obj, err := tb.repo.OpenObject(*node.id)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	tree, ok := obj.(*Tree)
+	if !ok {
+		return &ObjectTypeError{ID: *node.id, Type: obj.Type(), Expected: ObjTree}
+	}
+
+	entries := make(map[string]*treeNodeEntry)
+	for tree.Next() {
+		e := tree.Entry()
+		entry := &treeNodeEntry{mode: e.Mode, id: e.ID}
+		if e.Mode == ModeTree {
+			id := e.ID
+			entry.tree = &treeNode{id: &id}
+		}
+		entries[e.Name] = entry
+	}
+
+	if err = tree.Err(); err != nil {
+		return err
+	}
+
+	node.entries = entries
+	return nil
+}
+
+func splitTreePath(path string) ([]string, error) {
+	comps := strings.Split(strings.Trim(path, "/"), "/")
+	for _, c := range comps {
+		if c == "" || c == "." || c == ".." || c == ".git" || strings.IndexByte(c, 0) != -1 {
+			return nil, fmt.Errorf("git: invalid path %q", path)
+		}
+	}
+
+	return comps, nil
+}
+
+//walk returns the node of the directory that contains the entry
+//with the given path components, creating missing directories
+//if create is true. Every node on the way is marked as modified.
+func (tb *TreeBuilder) walk(comps []string, create bool) (*treeNode, error) {
+	node := tb.root
+	for _, c := range comps[:len(comps)-1] {
+		err := tb.load(node)
+		if err != nil {
+			return nil, err
+		}
+		node.id = nil
+
+		entry, ok := node.entries[c]
+		if !ok || entry.tree == nil {
+			if !create {
+				return nil, nil
+			}
+
+			//missing, or replacing a non-tree entry
+			entry = &treeNodeEntry{mode: ModeTree, tree: &treeNode{entries: make(map[string]*treeNodeEntry)}}
+			node.entries[c] = entry
+		}
+
+		node = entry.tree
+	}
+
+	err := tb.load(node)
+	if err != nil {
+		return nil, err
+	}
+	node.id = nil
+
+	return node, nil
+}
+
+//Add adds an entry with the given mode and id at path. Missing
+//parent directories are created. An existing entry at path is
+//replaced. Trees can be added by using ModeTree.
+func (tb *TreeBuilder) Add(path string, mode os.FileMode, id SHA1) error {
+	comps, err := splitTreePath(path)
+	if err != nil {
+		return err
+	}
+
+	switch mode {
+	case ModeBlob, ModeExec, ModeSymlink, ModeGitlink, ModeTree:
+	default:
+		return fmt.Errorf("git: invalid mode %o for %q", uint32(mode), path)
+	}
+
+	node, err := tb.walk(comps, true)
+	if err != nil {
+		return err
+	}
+
+	entry := &treeNodeEntry{mode: mode, id: id}
+	if mode == ModeTree {
+		entry.tree = &treeNode{id: &id}
+	}
+
+	node.entries[comps[len(comps)-1]] = entry
+	return nil
+}
+
+//Remove removes the entry (file or whole directory) at path.
+//Returns an error if the path does not exist.
+func (tb *TreeBuilder) Remove(path string) error {
+	comps, err := splitTreePath(path)
+	if err != nil {
+		return err
+	}
+
+	node, err := tb.walk(comps, false)
+	if err != nil {
+		return err
+	}
+
+	name := comps[len(comps)-1]
+	if node == nil || node.entries[name] == nil {
+		return fmt.Errorf("git: path %q not in tree", path)
+	}
+
+	delete(node.entries, name)
+	return nil
+}
+
+//Write stores all new or modified trees in the repository and
+//returns the id of the root tree. Empty directories are omitted,
+//like git does it.
+func (tb *TreeBuilder) Write() (SHA1, error) {
+	id, _, err := tb.write(tb.root)
+	return id, err
+}
+
+//write stores the tree of node and returns its id. If the
+//tree is empty, false is returned and nothing is stored,
+//unless it is the root tree.
+func (tb *TreeBuilder) write(node *treeNode) (SHA1, bool, error) {
+	if node.id != nil {
+		return *node.id, true, nil
+	}
+
+	var entries []TreeEntry
+	for name, e := range node.entries {
+		if e.tree != nil {
+			id, ok, err := tb.write(e.tree)
+			if err != nil {
+				return SHA1{}, false, err
+			} else if !ok {
+				continue
+			}
+			e.id = id
+		}
+
+		entries = append(entries, TreeEntry{Mode: e.mode, ID: e.id, Name: name})
+	}
+
+	if len(entries) == 0 && node != tb.root {
+		return SHA1{}, false, nil
+	}
+
+	tree, err := NewTree(entries)
+	if err != nil {
+		return SHA1{}, false, err
+	}
+
+	id, err := tb.repo.WriteObject(tree)
+	if err != nil {
+		return SHA1{}, false, err
+	}
+
+	node.id = &id
+	return id, true, nil
+}
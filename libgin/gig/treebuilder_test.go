@@ -0,0 +1,147 @@
+package gig
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeBlob(t *testing.T, repo *Repository, content string) SHA1 {
+	t.Helper()
+
+	id, err := repo.WriteObject(NewBlob(strings.NewReader(content), int64(len(content))))
+	if err != nil {
+		t.Fatalf("could not write blob: %v", err)
+	}
+
+	return id
+}
+
+func TestTreeBuilder(t *testing.T) {
+	repo, _ := mkTestRepo(t)
+
+	blob := writeBlob(t, repo, "content\n")
+	script := writeBlob(t, repo, "#!/bin/sh\n")
+
+	tb := NewTreeBuilder(repo)
+	for _, p := range []string{"a.txt", "a/b.txt", "a-b", "a/c/d.txt", "z"} {
+		if err := tb.Add(p, ModeBlob, blob); err != nil {
+			t.Fatalf("could not add %q: %v", p, err)
+		}
+	}
+
+	if err := tb.Add("bin/run", ModeExec, script); err != nil {
+		t.Fatalf("could not add script: %v", err)
+	}
+
+	if err := tb.Add("../evil", ModeBlob, blob); err == nil {
+		t.Fatalf("expected error for invalid path")
+	}
+
+	id, err := tb.Write()
+	if err != nil {
+		t.Fatalf("could not write tree: %v", err)
+	}
+
+	out := gitCmd(t, repo.Path, "ls-tree", "-r", "--name-only", id.String())
+	want := "a-b\na.txt\na/b.txt\na/c/d.txt\nbin/run\nz"
+	if out != want {
+		t.Fatalf("unexpected tree:\n%s\nexpected:\n%s", out, want)
+	}
+
+	out = gitCmd(t, repo.Path, "ls-tree", id.String(), "bin/run")
+	if !strings.HasPrefix(out, "100755 blob "+script.String()) {
+		t.Fatalf("unexpected entry for script: %s", out)
+	}
+
+	gitCmd(t, repo.Path, "fsck", "--strict", "--no-dangling")
+
+	//modify an existing commit's tree
+	head, _ := ParseSHA1(gitCmd(t, repo.Path, "rev-parse", "master"))
+	tree, _ := ParseSHA1(gitCmd(t, repo.Path, "rev-parse", "master^{tree}"))
+
+	tb = NewTreeBuilder(repo)
+	if err = tb.Load(tree); err != nil {
+		t.Fatalf("could not load tree: %v", err)
+	}
+
+	if err = tb.Add("datacite.yml", ModeBlob, blob); err != nil {
+		t.Fatalf("could not add file: %v", err)
+	}
+
+	if err = tb.Remove("data/sub/c.txt"); err != nil {
+		t.Fatalf("could not remove file: %v", err)
+	}
+
+	if err = tb.Remove("data/nonexistent"); err == nil {
+		t.Fatalf("expected error when removing non-existing file")
+	}
+
+	id, err = tb.Write()
+	if err != nil {
+		t.Fatalf("could not write tree: %v", err)
+	}
+
+	out = gitCmd(t, repo.Path, "ls-tree", "-r", "--name-only", id.String())
+	want = "README.md\ndata/a.txt\ndata/b.txt\ndatacite.yml"
+	if out != want {
+		t.Fatalf("unexpected tree:\n%s\nexpected:\n%s", out, want)
+	}
+
+	who := NewSignature("GIN", "gin@example.com", time.Unix(1600000000, 0).In(time.FixedZone("", -3*3600)))
+	cid, err := repo.CreateCommit(id, []SHA1{head}, who, who, "Add datacite.yml\n")
+	if err != nil {
+		t.Fatalf("could not create commit: %v", err)
+	}
+
+	if err = repo.UpdateRef("refs/heads/master", cid, &head, who, "commit: Add datacite.yml"); err != nil {
+		t.Fatalf("could not update master: %v", err)
+	}
+
+	out = gitCmd(t, repo.Path, "log", "-1", "--format=%an <%ae> %ad%n%s", "--date=raw", "master")
+	if out != "GIN <gin@example.com> 1600000000 -0300\nAdd datacite.yml" {
+		t.Fatalf("unexpected commit: %s", out)
+	}
+
+	gitCmd(t, repo.Path, "fsck", "--strict", "--no-dangling")
+
+	obj, err := repo.OpenObject(cid)
+	if err != nil {
+		t.Fatalf("could not open commit: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err = obj.WriteTo(&buf); err != nil {
+		t.Fatalf("could not serialize commit: %v", err)
+	}
+
+	commit := obj.(*Commit)
+	if commit.Tree != id || len(commit.Parent) != 1 || commit.Parent[0] != head {
+		t.Fatalf("unexpected commit contents: %+v", commit)
+	}
+}
+
+func TestNewTag(t *testing.T) {
+	repo, _ := mkTestRepo(t)
+
+	head, _ := ParseSHA1(gitCmd(t, repo.Path, "rev-parse", "master"))
+	who := NewSignature("GIN", "gin@example.com", time.Unix(1600000000, 0).UTC())
+
+	id, err := repo.WriteObject(NewTag(head, ObjCommit, "10.12751/g-node.abcdef", who, "DOI release\n"))
+	if err != nil {
+		t.Fatalf("could not write tag: %v", err)
+	}
+
+	gitCmd(t, repo.Path, "update-ref", "refs/tags/doi", id.String())
+	if out := gitCmd(t, repo.Path, "rev-parse", "doi^{commit}"); out != head.String() {
+		t.Fatalf("tag peels to %s, expected %s", out, head)
+	}
+
+	out := gitCmd(t, repo.Path, "cat-file", "-p", id.String())
+	if !strings.Contains(out, "tagger GIN <gin@example.com> 1600000000 +0000") {
+		t.Fatalf("unexpected tag:\n%s", out)
+	}
+
+	gitCmd(t, repo.Path, "fsck", "--strict", "--no-dangling")
+}
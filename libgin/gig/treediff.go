@@ -0,0 +1,520 @@
+package gig
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+)
+
+//ChangeType is the type of a change between two trees.
+type ChangeType int
+
+//ChangeType values.
+const (
+	ChangeAdded ChangeType = iota + 1
+	ChangeDeleted
+	ChangeModified
+	ChangeRenamed
+	ChangeTypeChanged
+)
+
+func (ct ChangeType) String() string {
+	switch ct {
+	case ChangeAdded:
+		return "added"
+	case ChangeDeleted:
+		return "deleted"
+	case ChangeModified:
+		return "modified"
+	case ChangeRenamed:
+		return "renamed"
+	case ChangeTypeChanged:
+		return "type-changed"
+	}
+	return "unknown"
+}
+
+//Letter returns the status letter git uses for the change type
+//(e.g. in "git diff --name-status"), i.e. A, D, M, R or T.
+func (ct ChangeType) Letter() string {
+	switch ct {
+	case ChangeAdded:
+		return "A"
+	case ChangeDeleted:
+		return "D"
+	case ChangeModified:
+		return "M"
+	case ChangeRenamed:
+		return "R"
+	case ChangeTypeChanged:
+		return "T"
+	}
+	return "X"
+}
+
+//Change is a single change of a file between two trees. For added
+//files, the Old fields are empty, for deleted ones the New fields.
+//Similarity is the estimated similarity of the content in percent,
+//which is only meaningful for renames.
+type Change struct {
+	Type       ChangeType
+	OldPath    string
+	NewPath    string
+	OldMode    os.FileMode
+	NewMode    os.FileMode
+	OldID      SHA1
+	NewID      SHA1
+	Similarity int
+}
+
+//Path returns the path of the file the change refers to,
+//i.e. the new path, unless the file was deleted.
+func (c *Change) Path() string {
+	if c.Type == ChangeDeleted {
+		return c.OldPath
+	}
+	return c.NewPath
+}
+
+func (c Change) String() string {
+	if c.Type == ChangeRenamed {
+		return fmt.Sprintf("%s%03d\t%s\t%s", c.Type.Letter(), c.Similarity, c.OldPath, c.NewPath)
+	}
+	return fmt.Sprintf("%s\t%s", c.Type.Letter(), c.Path())
+}
+
+//DiffOptions control how trees are compared.
+type DiffOptions struct {
+	//DetectRenames enables the detection of renamed files.
+	DetectRenames bool
+
+	//RenameThreshold is the minimal similarity (in percent)
+	//of two files to be considered a rename; defaults to 50.
+	RenameThreshold int
+
+	//RenameLimit is the maximal number of added or deleted
+	//files for which inexact rename detection, which requires
+	//comparing the contents, is done; defaults to 1000.
+	RenameLimit int
+}
+
+//DiffTree compares the trees oldTree and newTree recursively and
+//returns the changes, ordered by path. The zero SHA1 can be used
+//for the empty tree. If opts is nil, renames are not detected.
+func (repo *Repository) DiffTree(oldTree, newTree SHA1, opts *DiffOptions) ([]Change, error) {
+	var changes []Change
+
+	err := repo.diffTree(oldTree, newTree, "", &changes)
+	if err != nil {
+		return nil, err
+	}
+
+	sortChanges(changes)
+
+	if opts != nil && opts.DetectRenames {
+		changes, err = repo.detectRenames(changes, opts)
+		if err != nil {
+			return nil, err
+		}
+		sortChanges(changes)
+	}
+
+	return changes, nil
+}
+
+//sortChanges sorts the changes by path; the order of
+//changes with the same path is kept.
+func sortChanges(changes []Change) {
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Path() < changes[j].Path()
+	})
+}
+
+//DiffCommit returns the changes that the commit introduced with
+//respect to its first parent (or the empty tree for root commits and
+//shallow commits, whose parents are not present).
+func (repo *Repository) DiffCommit(commit *Commit, opts *DiffOptions) ([]Change, error) {
+	var parentTree SHA1
+
+	if len(commit.Parent) > 0 {
+		node, err := repo.OpenCommitNode(commit.Parent[0])
+		if err == nil {
+			parentTree = node.Tree
+		} else if cut, cerr := repo.isCutOff(commit.Parent[0]); cerr != nil || !cut {
+			return nil, err
+		}
+	}
+
+	return repo.DiffTree(parentTree, commit.Tree, opts)
+}
+
+//readTreeEntries returns all entries of the tree with the given
+//id, keyed by name. The zero SHA1 yields an empty tree.
+func (repo *Repository) readTreeEntries(id SHA1) (map[string]TreeEntry, error) {
+	entries := make(map[string]TreeEntry)
+
+	if id.IsZero() {
+		return entries, nil
+	}
+
+	obj, err := repo.OpenObject(id)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	tree, ok := obj.(*Tree)
+	if !ok {
+		return nil, &ObjectTypeError{ID: id, Type: obj.Type(), Expected: ObjTree}
+	}
+
+	for tree.Next() {
+		e := tree.Entry()
+		entries[e.Name] = *e
+	}
+
+	return entries, tree.Err()
+}
+
+func isTreeMode(mode os.FileMode) bool {
+	return mode == ModeTree
+}
+
+//modeKind returns the kind of entry a mode represents,
+//i.e. tree, regular file, symlink or gitlink, ignoring
+//the executable bit.
+func modeKind(mode os.FileMode) os.FileMode {
+	if mode == ModeExec {
+		return ModeBlob
+	}
+	return mode
+}
+
+func (repo *Repository) diffTree(oldTree, newTree SHA1, prefix string, changes *[]Change) error {
+	if oldTree == newTree {
+		return nil
+	}
+
+	oldEntries, err := repo.readTreeEntries(oldTree)
+	if err != nil {
+		return err
+	}
+
+	newEntries, err := repo.readTreeEntries(newTree)
+	if err != nil {
+		return err
+	}
+
+	//walk the entries in order, so that the changes (and thus the
+	//detected renames) do not depend on the map iteration order
+	names := make([]string, 0, len(oldEntries)+len(newEntries))
+	for name := range oldEntries {
+		names = append(names, name)
+	}
+	for name := range newEntries {
+		if _, ok := oldEntries[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		p := path.Join(prefix, name)
+		o, inOld := oldEntries[name]
+		n, inNew := newEntries[name]
+
+		switch {
+		case !inNew:
+			err = repo.diffRemoved(o, p, changes)
+		case !inOld:
+			err = repo.diffAdded(n, p, changes)
+		case o.ID == n.ID && o.Mode == n.Mode:
+		case isTreeMode(o.Mode) && isTreeMode(n.Mode):
+			err = repo.diffTree(o.ID, n.ID, p, changes)
+		case isTreeMode(o.Mode) || isTreeMode(n.Mode):
+			//file replaced by a directory or vice versa
+			err = repo.diffRemoved(o, p, changes)
+			if err == nil {
+				err = repo.diffAdded(n, p, changes)
+			}
+		case modeKind(o.Mode) != modeKind(n.Mode):
+			*changes = append(*changes, Change{Type: ChangeTypeChanged,
+				OldPath: p, NewPath: p, OldMode: o.Mode, NewMode: n.Mode, OldID: o.ID, NewID: n.ID})
+		default:
+			*changes = append(*changes, Change{Type: ChangeModified,
+				OldPath: p, NewPath: p, OldMode: o.Mode, NewMode: n.Mode, OldID: o.ID, NewID: n.ID})
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (repo *Repository) diffAdded(e TreeEntry, p string, changes *[]Change) error {
+	if isTreeMode(e.Mode) {
+		return repo.diffTree(SHA1{}, e.ID, p, changes)
+	}
+
+	*changes = append(*changes, Change{Type: ChangeAdded, NewPath: p, NewMode: e.Mode, NewID: e.ID})
+	return nil
+}
+
+func (repo *Repository) diffRemoved(e TreeEntry, p string, changes *[]Change) error {
+	if isTreeMode(e.Mode) {
+		return repo.diffTree(e.ID, SHA1{}, p, changes)
+	}
+
+	*changes = append(*changes, Change{Type: ChangeDeleted, OldPath: p, OldMode: e.Mode, OldID: e.ID})
+	return nil
+}
+
+//detectRenames pairs deleted and added files to renames. First exact
+//renames (identical content) are detected, then, if the number of
+//candidates is within the limit, renames based on content similarity.
+func (repo *Repository) detectRenames(changes []Change, opts *DiffOptions) ([]Change, error) {
+	threshold := opts.RenameThreshold
+	if threshold <= 0 {
+		threshold = 50
+	}
+
+	limit := opts.RenameLimit
+	if limit <= 0 {
+		limit = 1000
+	}
+
+	var added, deleted []int
+	for i, c := range changes {
+		switch {
+		case c.Type == ChangeAdded && modeKind(c.NewMode) == ModeBlob:
+			added = append(added, i)
+		case c.Type == ChangeDeleted && modeKind(c.OldMode) == ModeBlob:
+			deleted = append(deleted, i)
+		}
+	}
+
+	if len(added) == 0 || len(deleted) == 0 {
+		return changes, nil
+	}
+
+	//pairs maps the index of an added change to the
+	//index of the deleted change it was renamed from
+	pairs := make(map[int]int)
+	similarity := make(map[int]int)
+	used := make(map[int]bool)
+
+	byID := make(map[SHA1][]int)
+	for _, d := range deleted {
+		byID[changes[d].OldID] = append(byID[changes[d].OldID], d)
+	}
+
+	var remaining []int
+	for _, a := range added {
+		cands := byID[changes[a].NewID]
+		if len(cands) == 0 {
+			remaining = append(remaining, a)
+			continue
+		}
+
+		//prefer the candidate with the same base name
+		best := cands[0]
+		for _, d := range cands {
+			if path.Base(changes[d].OldPath) == path.Base(changes[a].NewPath) {
+				best = d
+				break
+			}
+		}
+
+		pairs[a], similarity[a], used[best] = best, 100, true
+		byID[changes[a].NewID] = removeInt(cands, best)
+	}
+
+	var left []int
+	for _, d := range deleted {
+		if !used[d] {
+			left = append(left, d)
+		}
+	}
+
+	if len(remaining) > 0 && len(left) > 0 && len(remaining) <= limit && len(left) <= limit {
+		err := repo.detectInexactRenames(changes, remaining, left, threshold, pairs, similarity)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	renamedFrom := make(map[int]bool)
+	for _, d := range pairs {
+		renamedFrom[d] = true
+	}
+
+	var res []Change
+	for i, c := range changes {
+		if renamedFrom[i] {
+			continue
+		}
+
+		if d, ok := pairs[i]; ok {
+			old := changes[d]
+			c.Type = ChangeRenamed
+			c.OldPath, c.OldMode, c.OldID = old.OldPath, old.OldMode, old.OldID
+			c.Similarity = similarity[i]
+		}
+
+		res = append(res, c)
+	}
+
+	return res, nil
+}
+
+func removeInt(list []int, x int) []int {
+	for i, v := range list {
+		if v == x {
+			return append(list[:i:i], list[i+1:]...)
+		}
+	}
+	return list
+}
+
+func (repo *Repository) detectInexactRenames(changes []Change, added, deleted []int, threshold int,
+	pairs, similarity map[int]int) error {
+
+	type candidate struct {
+		a, d  int
+		score int
+	}
+
+	sigs := make(map[SHA1]*contentSignature)
+	signature := func(id SHA1) (*contentSignature, error) {
+		if sig, ok := sigs[id]; ok {
+			return sig, nil
+		}
+
+		//blobs that are too large are not scored, like
+		//git does it for files above core.bigFileThreshold
+		data, err := repo.readBlob(id)
+		if errors.Is(err, ErrObjectTooLarge) {
+			sigs[id] = nil
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		sig := newContentSignature(data)
+		sigs[id] = sig
+		return sig, nil
+	}
+
+	var cands []candidate
+	for _, a := range added {
+		as, err := signature(changes[a].NewID)
+		if err != nil {
+			return err
+		} else if as == nil {
+			continue
+		}
+
+		for _, d := range deleted {
+			ds, err := signature(changes[d].OldID)
+			if err != nil {
+				return err
+			} else if ds == nil {
+				continue
+			}
+
+			if score := as.similarity(ds); score >= threshold {
+				cands = append(cands, candidate{a, d, score})
+			}
+		}
+	}
+
+	//best matches first, ties are broken by the order of the paths
+	sort.SliceStable(cands, func(i, j int) bool {
+		return cands[i].score > cands[j].score
+	})
+
+	usedA, usedD := make(map[int]bool), make(map[int]bool)
+	for _, c := range cands {
+		if usedA[c.a] || usedD[c.d] {
+			continue
+		}
+
+		usedA[c.a], usedD[c.d] = true, true
+		pairs[c.a], similarity[c.a] = c.d, c.score
+	}
+
+	return nil
+}
+
+//readBlob reads the whole content of the blob with the given id.
+//Blobs larger than the maximum object size (see SetMaxObjectSize)
+//yield an error that wraps ErrObjectTooLarge.
+func (repo *Repository) readBlob(id SHA1) ([]byte, error) {
+	obj, err := repo.OpenObject(id)
+	if err != nil {
+		return nil, err
+	}
+
+	blob, ok := obj.(*Blob)
+	if !ok {
+		obj.Close()
+		return nil, &ObjectTypeError{ID: id, Type: obj.Type(), Expected: ObjBlob}
+	}
+
+	return readObjectData(blob.gitObject, repo.maxObjectSize())
+}
+
+//contentSignature is used to estimate the similarity of file
+//contents, in the same spirit as git does it: the content is
+//split into chunks (lines, at most 64 bytes long) and the number
+//of bytes of each distinct chunk is counted.
+type contentSignature struct {
+	size   int
+	chunks map[uint32]int
+}
+
+func newContentSignature(data []byte) *contentSignature {
+	sig := &contentSignature{size: len(data), chunks: make(map[uint32]int)}
+
+	start := 0
+	h := uint32(2166136261)
+	for i, b := range data {
+		h = (h ^ uint32(b)) * 16777619
+		if b == '\n' || i-start+1 == 64 || i == len(data)-1 {
+			sig.chunks[h] += i - start + 1
+			start = i + 1
+			h = 2166136261
+		}
+	}
+
+	return sig
+}
+
+//similarity returns the estimated similarity of two contents in
+//percent, i.e. the amount of common data relative to the larger
+//of the two.
+func (sig *contentSignature) similarity(other *contentSignature) int {
+	max := sig.size
+	if other.size > max {
+		max = other.size
+	}
+
+	if max == 0 {
+		return 100
+	}
+
+	common := 0
+	for h, n := range sig.chunks {
+		m := other.chunks[h]
+		if m < n {
+			n = m
+		}
+		common += n
+	}
+
+	return common * 100 / max
+}
@@ -0,0 +1,267 @@
+package gig
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+//mkDiffCommit makes changes to the work tree of the test repository
+//and commits them; returns the tree ids of the parent and the commit.
+func mkDiffCommit(t *testing.T, repo *Repository, wt string) (SHA1, SHA1) {
+	t.Helper()
+
+	write := func(name, content string, mode os.FileMode) {
+		p := filepath.Join(wt, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+			t.Fatalf("could not create dir: %v", err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), mode); err != nil {
+			t.Fatalf("could not write file: %v", err)
+		}
+	}
+
+	//exact rename, inexact rename, modification, addition
+	if err := os.Mkdir(filepath.Join(wt, "moved"), 0777); err != nil {
+		t.Fatalf("could not create dir: %v", err)
+	}
+	gitCmd(t, wt, "mv", "data/a.txt", "moved/a.txt")
+	gitCmd(t, wt, "mv", "data/b.txt", "data/b2.txt")
+	write("data/b2.txt", strings.Repeat("line of file 2\n", 20)+"\nchanged\n", 0666)
+	write("README.md", "new readme\n", 0666)
+	write("new.txt", "brand new\n", 0755)
+	gitCmd(t, wt, "add", "-A")
+	gitCmd(t, wt, "commit", "-q", "-m", "rename")
+
+	//type change (file -> symlink), directory -> file
+	gitCmd(t, wt, "rm", "-q", "README.md", "data/sub/c.txt")
+	if err := os.Symlink("new.txt", filepath.Join(wt, "README.md")); err != nil {
+		t.Skipf("could not create symlink: %v", err)
+	}
+	write("data/sub", "now a file\n", 0666)
+	gitCmd(t, wt, "add", "-A")
+	gitCmd(t, wt, "commit", "-q", "-m", "type change")
+
+	gitCmd(t, wt, "push", "-q", "bare", "master")
+
+	parse := func(rev string) SHA1 {
+		id, err := ParseSHA1(gitCmd(t, repo.Path, "rev-parse", rev))
+		if err != nil {
+			t.Fatalf("could not parse id: %v", err)
+		}
+		return id
+	}
+
+	return parse("master~2^{tree}"), parse("master^{tree}")
+}
+
+func formatChanges(changes []Change) string {
+	var lines []string
+	for _, c := range changes {
+		lines = append(lines, c.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestDiffTree(t *testing.T) {
+	repo, wt := mkTestRepo(t)
+	a, b := mkDiffCommit(t, repo, wt)
+
+	for _, renames := range []bool{false, true} {
+		args := []string{"diff-tree", "-r", "--name-status", "--no-renames"}
+		opts := &DiffOptions{DetectRenames: renames}
+		if renames {
+			args = []string{"diff-tree", "-r", "--name-status", "-M"}
+		}
+
+		args = append(args, a.String(), b.String())
+		expected := gitCmd(t, repo.Path, args...)
+
+		changes, err := repo.DiffTree(a, b, opts)
+		if err != nil {
+			t.Fatalf("DiffTree failed: %v", err)
+		}
+
+		actual := formatChanges(changes)
+		if renames {
+			//the similarity estimate is not the same as git's
+			//and the order of renames can differ
+			expected = sortLines(scoreRe.ReplaceAllString(expected, "R"))
+			actual = sortLines(scoreRe.ReplaceAllString(actual, "R"))
+		}
+
+		if actual != expected {
+			t.Fatalf("renames: %v, expected:\n%s\ngot:\n%s", renames, expected, actual)
+		}
+	}
+
+	//details of a single change
+	changes, err := repo.DiffTree(a, b, &DiffOptions{DetectRenames: true})
+	if err != nil {
+		t.Fatalf("DiffTree failed: %v", err)
+	}
+
+	found := 0
+	for _, c := range changes {
+		switch c.NewPath {
+		case "README.md":
+			if c.Type != ChangeTypeChanged || c.OldMode != ModeBlob || c.NewMode != ModeSymlink {
+				t.Fatalf("unexpected change for README.md: %+v", c)
+			}
+			found++
+		case "moved/a.txt":
+			if c.Type != ChangeRenamed || c.OldPath != "data/a.txt" || c.Similarity != 100 || c.OldID != c.NewID {
+				t.Fatalf("unexpected change for moved/a.txt: %+v", c)
+			}
+			found++
+		case "data/b2.txt":
+			if c.Type != ChangeRenamed || c.OldPath != "data/b.txt" || c.Similarity == 100 || c.Similarity < 50 {
+				t.Fatalf("unexpected change for data/b2.txt: %+v", c)
+			}
+			found++
+		}
+	}
+
+	if found != 3 {
+		t.Fatalf("missing changes: %s", formatChanges(changes))
+	}
+
+	//blobs above the maximum object size are not scored,
+	//exact renames are still found
+	r := &Repository{Path: repo.Path}
+	r.SetMaxObjectSize(1)
+	changes, err = r.DiffTree(a, b, &DiffOptions{DetectRenames: true})
+	if err != nil {
+		t.Fatalf("DiffTree failed: %v", err)
+	}
+
+	for _, c := range changes {
+		if c.NewPath == "moved/a.txt" && c.Type != ChangeRenamed || c.NewPath == "data/b2.txt" && c.Type != ChangeAdded {
+			t.Fatalf("unexpected change with large blobs: %+v", c)
+		}
+	}
+
+	//empty tree
+	changes, err = repo.DiffTree(SHA1{}, a, nil)
+	if err != nil {
+		t.Fatalf("DiffTree failed: %v", err)
+	}
+
+	if len(changes) != 4 {
+		t.Fatalf("expected 4 added files, got: %s", formatChanges(changes))
+	}
+
+	for _, c := range changes {
+		if c.Type != ChangeAdded {
+			t.Fatalf("expected added file, got %s", c)
+		}
+	}
+
+	changes, err = repo.DiffTree(a, a, nil)
+	if err != nil || len(changes) != 0 {
+		t.Fatalf("expected no changes for identical trees, got: %v, %v", changes, err)
+	}
+}
+
+func TestDiffTreeIdenticalRenames(t *testing.T) {
+	repo, wt := mkTestRepo(t)
+
+	//identical files, moved exactly and with (identical) changes
+	content := strings.Repeat("the same line\n", 20)
+	files := make(map[string]string)
+	for i := 1; i <= 5; i++ {
+		files[fmt.Sprintf("old/x%d", i)] = content
+	}
+	files["old/m1"] = content + "m\n"
+	files["old/m2"] = content + "m\n"
+	writeFiles(t, wt, files)
+	gitCmd(t, wt, "add", "-A")
+	gitCmd(t, wt, "commit", "-q", "-m", "identical files")
+
+	gitCmd(t, wt, "rm", "-q", "-r", "old")
+	files = make(map[string]string)
+	for i := 1; i <= 5; i++ {
+		files[fmt.Sprintf("new/y%d", i)] = content
+	}
+	files["new/n1"] = content + "n\n"
+	files["new/n2"] = content + "n\n"
+	writeFiles(t, wt, files)
+	gitCmd(t, wt, "add", "-A")
+	gitCmd(t, wt, "commit", "-q", "-m", "move them")
+	gitCmd(t, wt, "push", "-q", "bare", "master")
+
+	a, b := revParse(t, repo, "master~1^{tree}"), revParse(t, repo, "master^{tree}")
+
+	var expected []string
+	for _, c := range []string{"m1\tnew/n1", "m2\tnew/n2"} {
+		expected = append(expected, "R\told/"+c)
+	}
+	for i := 1; i <= 5; i++ {
+		expected = append(expected, fmt.Sprintf("R100\told/x%d\tnew/y%d", i, i))
+	}
+
+	for i := 0; i < 20; i++ {
+		changes, err := repo.DiffTree(a, b, &DiffOptions{DetectRenames: true})
+		if err != nil {
+			t.Fatalf("DiffTree failed: %v", err)
+		}
+
+		var actual []string
+		for _, c := range changes {
+			if c.Similarity != 100 {
+				c.Similarity = 0
+			}
+			actual = append(actual, strings.Replace(c.String(), "R000", "R", 1))
+		}
+
+		if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+			t.Fatalf("unexpected renames:\n%s\nexpected:\n%s", strings.Join(actual, "\n"), strings.Join(expected, "\n"))
+		}
+	}
+}
+
+var scoreRe = regexp.MustCompile(`R\d{3}`)
+
+func sortLines(s string) string {
+	lines := strings.Split(s, "\n")
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+func TestDiffCommit(t *testing.T) {
+	repo, _ := mkTestRepo(t)
+
+	head, err := ParseSHA1(gitCmd(t, repo.Path, "rev-parse", "master"))
+	if err != nil {
+		t.Fatalf("could not parse id: %v", err)
+	}
+
+	for id := head; ; {
+		commit, err := repo.OpenObject(id)
+		if err != nil {
+			t.Fatalf("could not open commit: %v", err)
+		}
+		c := commit.(*Commit)
+		commit.Close()
+
+		changes, err := repo.DiffCommit(c, nil)
+		if err != nil {
+			t.Fatalf("DiffCommit failed: %v", err)
+		}
+
+		expected := gitCmd(t, repo.Path, "diff-tree", "-r", "--root", "--no-commit-id", "--name-status", id.String())
+		if actual := formatChanges(changes); actual != expected {
+			t.Fatalf("commit %s: expected:\n%s\ngot:\n%s", id, expected, actual)
+		}
+
+		if len(c.Parent) == 0 {
+			break
+		}
+		id = c.Parent[0]
+	}
+}
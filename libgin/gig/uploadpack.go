@@ -0,0 +1,634 @@
+package gig
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+//uploadPackAgent is sent as the agent capability.
+const uploadPackAgent = "agent=gig/libgin"
+
+//UploadPackHandler serves read-only clones and fetches of git
+//repositories via the smart HTTP protocol, i.e. it implements the
+//"info/refs?service=git-upload-pack" and "git-upload-pack" endpoints
+//of "git http-backend", for protocol versions 0 (and 1) and 2.
+//Shallow and partial clones are not supported.
+type UploadPackHandler struct {
+	//Open returns the repository for the path of the request
+	//URL with the "/info/refs" or "/git-upload-pack" suffix removed,
+	//e.g. "/alice/data.git". If it returns an error, the request
+	//fails with "404 Not Found". The repository is closed (see
+	//Repository.Close) once the request has been served, so Open
+	//must return a new Repository for every request.
+	Open func(path string) (*Repository, error)
+
+	//DeltaWindow is used for the PackWriter of generated packs.
+	//Zero, the default, disables delta compression.
+	DeltaWindow int
+
+	//ReceivePack handles the requests for the git-receive-pack
+	//service, i.e. pushes (see ReceivePackHandler). If it is nil,
+	//the repositories are read-only.
+	ReceivePack http.Handler
+}
+
+//ServeHTTP implements http.Handler.
+func (h *UploadPackHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var base string
+	var advertise bool
+
+	p := r.URL.Path
+	svc := r.URL.Query().Get("service")
+	if strings.HasSuffix(p, "/git-receive-pack") || (strings.HasSuffix(p, "/info/refs") && svc == "git-receive-pack") {
+		if h.ReceivePack == nil {
+			http.Error(w, "repository is read-only", http.StatusForbidden)
+			return
+		}
+
+		h.ReceivePack.ServeHTTP(w, r)
+		return
+	}
+
+	switch {
+	case strings.HasSuffix(p, "/info/refs") && (r.Method == "GET" || r.Method == "HEAD"):
+		base = strings.TrimSuffix(p, "/info/refs")
+		advertise = true
+
+		if svc != "git-upload-pack" {
+			http.Error(w, fmt.Sprintf("service %q not supported", svc), http.StatusForbidden)
+			return
+		}
+	case strings.HasSuffix(p, "/git-upload-pack") && r.Method == "POST":
+		base = strings.TrimSuffix(p, "/git-upload-pack")
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	repo, err := h.Open(base)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer repo.Close()
+
+	up := &uploadPack{repo: repo, deltaWindow: h.DeltaWindow}
+	up.refs, err = up.listRefs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	v2 := isProtocolV2(r.Header.Get("Git-Protocol"))
+	w.Header().Set("Cache-Control", "no-cache, max-age=0, must-revalidate")
+
+	if advertise {
+		w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+		pw := newPktWriter(w)
+		pw.writeLine("# service=git-upload-pack")
+		pw.flush()
+
+		if v2 {
+			up.advertiseV2(pw)
+		} else {
+			up.advertise(pw)
+		}
+		return
+	}
+
+	body, err := requestBody(r, "application/x-git-upload-pack-request")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", "application/x-git-upload-pack-result")
+	pr, pw := newPktReader(body), newPktWriter(w)
+
+	if v2 {
+		err = up.serveV2(pr, pw)
+	} else {
+		err = up.serve(pr, pw)
+	}
+
+	//the status has been sent already, the client has to deal
+	//with a truncated response, but we can try to tell it why
+	if err != nil && pw.err == nil {
+		pw.writeLine("ERR upload-pack: %v", err)
+	}
+}
+
+//requestBody checks the content type of the request and returns
+//its body, which is decompressed if necessary.
+func requestBody(r *http.Request, contentType string) (io.ReadCloser, error) {
+	if ct := r.Header.Get("Content-Type"); ct != contentType {
+		return nil, fmt.Errorf("unexpected content type %q", ct)
+	}
+
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		return gzip.NewReader(r.Body)
+	}
+
+	return r.Body, nil
+}
+
+//isProtocolV2 checks if the Git-Protocol header requests version 2.
+func isProtocolV2(header string) bool {
+	for _, param := range strings.Split(header, ":") {
+		if param == "version=2" {
+			return true
+		}
+	}
+	return false
+}
+
+//advertisedRef is a ref as announced to the client.
+type advertisedRef struct {
+	name   string
+	id     SHA1
+	symref string
+
+	peeled    SHA1
+	hasPeeled bool
+}
+
+//uploadPack holds the state of a single upload-pack request.
+type uploadPack struct {
+	repo        *Repository
+	deltaWindow int
+	refs        []advertisedRef
+}
+
+//listRefs returns HEAD, if it can be resolved, and all refs.
+//Refs that cannot be resolved are skipped, like git does.
+func (up *uploadPack) listRefs() ([]advertisedRef, error) {
+	var all []Ref
+	if head, err := up.repo.parseRef("HEAD"); err == nil {
+		all = append(all, head)
+	}
+
+	it := up.repo.Refs()
+	for it.Next() {
+		all = append(all, it.Ref())
+	}
+
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	var refs []advertisedRef
+	for _, r := range all {
+		id, err := r.Resolve()
+		if err != nil {
+			continue
+		}
+
+		ar := advertisedRef{name: refPath(r), id: id}
+		if sym, ok := r.(*SymbolicRef); ok {
+			target, err := sym.Target()
+			if err != nil {
+				continue
+			}
+			ar.symref = refPath(target)
+		}
+
+		ar.peeled, ar.hasPeeled, err = up.repo.peelTag(id)
+		if err != nil {
+			continue
+		}
+
+		refs = append(refs, ar)
+	}
+
+	return refs, nil
+}
+
+//isTip checks if id is the value of one of the advertised refs,
+//which are the only objects clients may ask for.
+func (up *uploadPack) isTip(id SHA1) bool {
+	for _, r := range up.refs {
+		if r.id == id {
+			return true
+		}
+	}
+	return false
+}
+
+//advertise writes the protocol v0 ref advertisement. The
+//capabilities are appended to the first ref.
+func (up *uploadPack) advertise(pw *pktWriter) error {
+	caps := []string{"multi_ack_detailed", "side-band-64k", "side-band", "ofs-delta",
+		"include-tag", "no-progress", "object-format=" + up.repo.ObjectFormat().String()}
+	for _, r := range up.refs {
+		if r.symref != "" && r.name == "HEAD" {
+			caps = append(caps, "symref=HEAD:"+r.symref)
+		}
+	}
+	caps = append(caps, uploadPackAgent)
+
+	if len(up.refs) == 0 {
+		pw.writeLine("%s capabilities^{}\x00%s", up.repo.ObjectFormat().ID(nil), strings.Join(caps, " "))
+	}
+
+	for i, r := range up.refs {
+		if i == 0 {
+			pw.writeLine("%s %s\x00%s", r.id, r.name, strings.Join(caps, " "))
+		} else {
+			pw.writeLine("%s %s", r.id, r.name)
+		}
+
+		if r.hasPeeled {
+			pw.writeLine("%s %s^{}", r.peeled, r.name)
+		}
+	}
+
+	return pw.flush()
+}
+
+//advertiseV2 writes the protocol v2 capability advertisement.
+func (up *uploadPack) advertiseV2(pw *pktWriter) error {
+	pw.writeLine("version 2")
+	pw.writeLine(uploadPackAgent)
+	pw.writeLine("ls-refs")
+	pw.writeLine("fetch")
+	pw.writeLine("server-option")
+	pw.writeLine("object-format=" + up.repo.ObjectFormat().String())
+	return pw.flush()
+}
+
+//negotiation holds the state of the want/have negotiation.
+type negotiation struct {
+	wants   []SHA1
+	common  []SHA1
+	exclude []SHA1
+	seen    map[SHA1]bool
+}
+
+//want adds an object the client asked for.
+func (up *uploadPack) want(n *negotiation, arg string) error {
+	id, err := ParseSHA1(arg)
+	if err != nil {
+		return fmt.Errorf("protocol error: invalid want %q", arg)
+	}
+
+	if !up.isTip(id) {
+		return fmt.Errorf("not our ref %s", id)
+	}
+
+	n.wants = append(n.wants, id)
+	return nil
+}
+
+//have processes an object the client has, and reports if we
+//have it too. Common commits (and tags pointing to commits) are
+//excluded from the pack.
+func (up *uploadPack) have(n *negotiation, arg string) (bool, error) {
+	id, err := ParseSHA1(arg)
+	if err != nil {
+		return false, fmt.Errorf("protocol error: invalid have %q", arg)
+	}
+
+	if n.seen == nil {
+		n.seen = make(map[SHA1]bool)
+	}
+
+	if n.seen[id] {
+		return true, nil
+	}
+
+	ok, err := up.repo.HasObject(id)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	n.seen[id] = true
+	n.common = append(n.common, id)
+
+	peeled, _, err := up.repo.peelTag(id)
+	if err != nil {
+		return false, err
+	}
+
+	if node, err := up.repo.OpenCommitNode(peeled); err == nil {
+		n.exclude = append(n.exclude, node.ID)
+	}
+
+	return true, nil
+}
+
+//serve handles a protocol v0 upload-pack request. Over HTTP each
+//request contains all wants and the haves found to be common so far,
+//as the server keeps no state between requests.
+func (up *uploadPack) serve(pr *pktReader, pw *pktWriter) error {
+	var n negotiation
+	caps := make(map[string]bool)
+
+	for first := true; ; first = false {
+		kind, line, err := pr.readLine()
+		if err != nil {
+			return err
+		} else if kind == pktFlush {
+			break
+		}
+
+		if first {
+			fields := strings.Fields(line)
+			if len(fields) > 2 {
+				for _, c := range fields[2:] {
+					caps[c] = true
+				}
+				line = strings.Join(fields[:2], " ")
+			}
+		}
+
+		if !strings.HasPrefix(line, "want ") {
+			return fmt.Errorf("protocol error: unexpected %q", line)
+		}
+
+		if err := up.want(&n, line[5:]); err != nil {
+			return err
+		}
+	}
+
+	if len(n.wants) == 0 {
+		return nil
+	}
+
+	multiAck := caps["multi_ack_detailed"]
+	var last SHA1
+	for {
+		kind, line, err := pr.readLine()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case kind == pktFlush:
+			if len(n.common) == 0 || multiAck {
+				pw.writeLine("NAK")
+			}
+			return pw.err
+
+		case line == "done":
+			if len(n.common) > 0 && multiAck {
+				pw.writeLine("ACK %s", last)
+			} else if len(n.common) == 0 {
+				pw.writeLine("NAK")
+			}
+
+			return up.sendPack(pw, &n, caps)
+
+		case strings.HasPrefix(line, "have "):
+			ok, err := up.have(&n, line[5:])
+			if err != nil {
+				return err
+			} else if !ok {
+				continue
+			}
+
+			last = n.common[len(n.common)-1]
+			if multiAck {
+				pw.writeLine("ACK %s common", last)
+			} else if len(n.common) == 1 {
+				pw.writeLine("ACK %s", last)
+			}
+
+		default:
+			return fmt.Errorf("protocol error: unexpected %q", line)
+		}
+	}
+}
+
+//sendPack writes the pack for a v0 request, multiplexed onto the
+//side-band channel, if the client asked for it.
+func (up *uploadPack) sendPack(pw *pktWriter, n *negotiation, caps map[string]bool) error {
+	var w io.Writer = pw.w
+	switch {
+	case caps["side-band-64k"]:
+		w = &sidebandWriter{pw: pw, band: 1, max: pktMaxData - 1}
+	case caps["side-band"]:
+		w = &sidebandWriter{pw: pw, band: 1, max: 999}
+	}
+
+	err := up.writePack(w, n, caps["include-tag"])
+	if err != nil {
+		return err
+	}
+
+	if w != pw.w {
+		return pw.flush()
+	}
+
+	return nil
+}
+
+//serveV2 handles a protocol v2 request, which consists of a
+//command, capabilities and, after a delimiter, the arguments.
+func (up *uploadPack) serveV2(pr *pktReader, pw *pktWriter) error {
+	var command string
+	var args []string
+
+	for inArgs := false; ; {
+		kind, line, err := pr.readLine()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case kind == pktFlush:
+			switch command {
+			case "ls-refs":
+				return up.lsRefs(pw, args)
+			case "fetch":
+				return up.fetch(pw, args)
+			case "":
+				return nil
+			}
+			return fmt.Errorf("unknown command %q", command)
+
+		case kind == pktDelim:
+			inArgs = true
+		case inArgs:
+			args = append(args, line)
+		case strings.HasPrefix(line, "command="):
+			command = line[8:]
+		case strings.HasPrefix(line, "object-format=") && line[14:] != up.repo.ObjectFormat().String():
+			return fmt.Errorf("unsupported object format %q", line[14:])
+		}
+	}
+}
+
+//lsRefs implements the v2 ls-refs command.
+func (up *uploadPack) lsRefs(pw *pktWriter, args []string) error {
+	var symrefs, peel bool
+	var prefixes []string
+
+	for _, arg := range args {
+		switch {
+		case arg == "symrefs":
+			symrefs = true
+		case arg == "peel":
+			peel = true
+		case strings.HasPrefix(arg, "ref-prefix "):
+			prefixes = append(prefixes, arg[11:])
+		}
+	}
+
+	for _, r := range up.refs {
+		match := len(prefixes) == 0
+		for _, p := range prefixes {
+			match = match || strings.HasPrefix(r.name, p)
+		}
+
+		if !match {
+			continue
+		}
+
+		line := r.id.String() + " " + r.name
+		if symrefs && r.symref != "" {
+			line += " symref-target:" + r.symref
+		}
+		if peel && r.hasPeeled {
+			line += " peeled:" + r.peeled.String()
+		}
+
+		pw.writeLine("%s", line)
+	}
+
+	return pw.flush()
+}
+
+//fetch implements the v2 fetch command. Unless the client is done,
+//only the acknowledgments are sent; the pack is always sent on the
+//side-band channel.
+func (up *uploadPack) fetch(pw *pktWriter, args []string) error {
+	var n negotiation
+	var haves []string
+	done, includeTag := false, false
+
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "want "):
+			if err := up.want(&n, arg[5:]); err != nil {
+				return err
+			}
+		case strings.HasPrefix(arg, "have "):
+			haves = append(haves, arg[5:])
+		case arg == "done":
+			done = true
+		case arg == "include-tag":
+			includeTag = true
+		case arg == "thin-pack", arg == "no-progress", arg == "ofs-delta":
+		default:
+			return fmt.Errorf("unsupported fetch argument %q", arg)
+		}
+	}
+
+	for _, h := range haves {
+		if _, err := up.have(&n, h); err != nil {
+			return err
+		}
+	}
+
+	if !done {
+		pw.writeLine("acknowledgments")
+		if len(n.common) == 0 {
+			pw.writeLine("NAK")
+		}
+		for _, id := range n.common {
+			pw.writeLine("ACK %s", id)
+		}
+		return pw.flush()
+	}
+
+	pw.writeLine("packfile")
+	err := up.writePack(&sidebandWriter{pw: pw, band: 1, max: pktMaxData - 1}, &n, includeTag)
+	if err != nil {
+		return err
+	}
+
+	return pw.flush()
+}
+
+//writePack writes a pack with all objects reachable from the wants
+//but not from the common commits. If includeTag is set, annotated
+//tags are added whose targets are part of the pack.
+func (up *uploadPack) writePack(w io.Writer, n *negotiation, includeTag bool) error {
+	var ids []SHA1
+	inPack := make(map[SHA1]bool)
+
+	it := up.repo.ReachableObjects(n.wants, n.exclude)
+	for it.Next() {
+		id := it.Object().ID
+		ids = append(ids, id)
+		inPack[id] = true
+	}
+
+	if err := it.Err(); err != nil {
+		return err
+	}
+
+	if includeTag {
+		for _, r := range up.refs {
+			if r.hasPeeled && !inPack[r.id] && inPack[r.peeled] {
+				tags, err := up.tagChain(r.id)
+				if err != nil {
+					return err
+				}
+
+				for _, id := range tags {
+					if !inPack[id] {
+						ids = append(ids, id)
+						inPack[id] = true
+					}
+				}
+			}
+		}
+	}
+
+	packer, err := newPackWriter(w, uint32(len(ids)), up.repo.ObjectFormat())
+	if err != nil {
+		return err
+	}
+	packer.DeltaWindow = up.deltaWindow
+
+	for _, id := range ids {
+		obj, err := up.repo.OpenObject(id)
+		if err != nil {
+			return err
+		}
+
+		_, err = packer.WriteObject(obj)
+		obj.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return packer.Close()
+}
+
+//tagChain returns the ids of the tag and of all tags it points
+//to, in case of tags of tags.
+func (up *uploadPack) tagChain(id SHA1) ([]SHA1, error) {
+	var tags []SHA1
+	for {
+		obj, err := up.repo.OpenObject(id)
+		if err != nil {
+			return nil, err
+		}
+		obj.Close()
+
+		tag, ok := obj.(*Tag)
+		if !ok {
+			return tags, nil
+		}
+
+		tags = append(tags, id)
+		id = tag.Object
+	}
+}
@@ -0,0 +1,133 @@
+package gig
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestUploadPackHandler(t *testing.T) {
+	repo, wt := mkTestRepo(t)
+	mkLogHistory(t, wt)
+	gitCmd(t, repo.Path, "tag", "-a", "-m", "annotated", "v1", "side~1")
+	gitCmd(t, repo.Path, "pack-refs", "--all")
+
+	var mu sync.Mutex
+	var opened []*Repository
+
+	handler := &UploadPackHandler{
+		Open: func(path string) (*Repository, error) {
+			if path != "/repo.git" {
+				return nil, fmt.Errorf("no such repository: %s", path)
+			}
+
+			r := &Repository{Path: repo.Path}
+			mu.Lock()
+			opened = append(opened, r)
+			mu.Unlock()
+			return r, nil
+		},
+		DeltaWindow: 10,
+	}
+
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	url := srv.URL + "/repo.git"
+
+	expected := gitCmd(t, repo.Path, "show-ref", "--head", "--dereference")
+	base := filepath.Dir(repo.Path)
+
+	for _, version := range []string{"0", "2"} {
+		proto := "protocol.version=" + version
+
+		if refs := lsRemote(t, base, proto, url); refs != expected {
+			t.Fatalf("v%s: unexpected refs:\n%s\nexpected:\n%s", version, refs, expected)
+		}
+
+		clone := filepath.Join(base, "clone-v"+version)
+		gitCmd(t, base, "-c", proto, "clone", "-q", "--bare", url, clone)
+		gitCmd(t, clone, "fsck", "--strict", "--no-dangling")
+
+		if refs := gitCmd(t, clone, "show-ref", "--head", "--dereference"); refs != expected {
+			t.Fatalf("v%s: unexpected refs in clone:\n%s\nexpected:\n%s", version, refs, expected)
+		}
+
+		//a partial clone that then fetches the rest
+		partial := filepath.Join(base, "partial-v"+version)
+		gitCmd(t, base, "init", "-q", "--bare", partial)
+		gitCmd(t, partial, "config", "fetch.unpackLimit", "1")
+		gitCmd(t, partial, "-c", proto, "fetch", "-q", "--no-tags", url, "refs/heads/side:refs/heads/side")
+		gitCmd(t, partial, "-c", proto, "fetch", "-q", "--tags", url, "+refs/heads/*:refs/heads/*")
+		gitCmd(t, partial, "fsck", "--strict", "--no-dangling")
+
+		//objects the client already had must not be sent again
+		all := gitCmd(t, repo.Path, "rev-list", "--objects", "--all")
+		stats := gitCmd(t, partial, "count-objects", "-v")
+		if n := len(strings.Split(all, "\n")); !strings.Contains(stats, fmt.Sprintf("in-pack: %d\n", n)) {
+			t.Fatalf("v%s: expected %d packed objects, got:\n%s", version, n, stats)
+		}
+
+		if refs := gitCmd(t, partial, "show-ref", "--dereference"); !strings.Contains(expected, refs) {
+			t.Fatalf("v%s: unexpected refs after fetch:\n%s\nexpected:\n%s", version, refs, expected)
+		}
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{"GET", "/other.git/info/refs?service=git-upload-pack", http.StatusNotFound},
+		{"GET", "/repo.git/info/refs?service=git-receive-pack", http.StatusForbidden},
+		{"POST", "/repo.git/git-receive-pack", http.StatusForbidden},
+		{"GET", "/repo.git/HEAD", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, srv.URL+tt.path, nil)
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != tt.status {
+			t.Fatalf("%s %s: expected status %d, got %d", tt.method, tt.path, tt.status, res.StatusCode)
+		}
+	}
+
+	//the repositories are closed after each request
+	mu.Lock()
+	defer mu.Unlock()
+	for _, r := range opened {
+		r.mu.Lock()
+		packs := r.packs
+		r.mu.Unlock()
+
+		if packs != nil {
+			t.Fatal("expected repository to be closed")
+		}
+	}
+}
+
+//lsRemote lists the refs of the remote like "git show-ref --head
+//--dereference" does for a local repository.
+func lsRemote(t *testing.T, dir, proto, url string) string {
+	t.Helper()
+
+	var lines []string
+	for _, l := range strings.Split(gitCmd(t, dir, "-c", proto, "ls-remote", url), "\n") {
+		fields := strings.Fields(l)
+		lines = append(lines, fields[0]+" "+fields[1])
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -3,6 +3,8 @@ package gig
 import (
 	"bytes"
 	"compress/zlib"
+	"encoding/binary"
+	"fmt"
 	"io"
 	"strings"
 )
@@ -69,3 +71,38 @@ func (o *gitObject) wrapSourceWithDeflate() error {
 func (o *gitObject) wrapSource(rc io.ReadCloser) {
 	o.source = &zlibReadCloser{io.LimitedReader{R: rc, N: o.size}, o.source}
 }
+
+//chunk is an entry of the chunk lookup table that is used
+//by the multi-pack-index and the commit-graph file formats.
+type chunk struct {
+	start int64
+	end   int64
+}
+
+//readChunkTable reads the table of contents of a chunk based
+//file (multi-pack-index, commit-graph), which consists of n+1
+//entries of [id{4 byte}][offset{8 byte}], starting at off. The
+//chunks are returned in a map keyed by their id.
+func readChunkTable(r io.ReaderAt, off int64, n int) (map[string]chunk, error) {
+	data := make([]byte, (n+1)*12)
+	_, err := r.ReadAt(data, off)
+	if err != nil {
+		return nil, fmt.Errorf("git: could not read chunk table: %v", err)
+	}
+
+	chunks := make(map[string]chunk, n)
+	for i := 0; i < n; i++ {
+		entry := data[i*12:]
+		id := string(entry[:4])
+		start := int64(binary.BigEndian.Uint64(entry[4:12]))
+		end := int64(binary.BigEndian.Uint64(entry[16:24]))
+
+		if start > end {
+			return nil, fmt.Errorf("git: invalid chunk table entry %q", id)
+		}
+
+		chunks[id] = chunk{start, end}
+	}
+
+	return chunks, nil
+}
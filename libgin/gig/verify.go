@@ -0,0 +1,540 @@
+package gig
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//ObjectProblem describes a problem with a single object,
+//e.g. that its content does not match its id.
+type ObjectProblem struct {
+	ID      SHA1
+	Problem string
+}
+
+func (p ObjectProblem) String() string {
+	return fmt.Sprintf("%s: %s", p.ID, p.Problem)
+}
+
+//MissingObject is an object that is referenced by another
+//object (or ref), but is not in the repository.
+type MissingObject struct {
+	ID       SHA1
+	Type     ObjectType
+	Referrer string
+}
+
+func (m MissingObject) String() string {
+	return fmt.Sprintf("missing %s %s (referenced by %s)", m.Type, m.ID, m.Referrer)
+}
+
+//PackProblem describes a problem with a pack or its index.
+type PackProblem struct {
+	Pack    string
+	Problem string
+}
+
+func (p PackProblem) String() string {
+	return fmt.Sprintf("%s: %s", p.Pack, p.Problem)
+}
+
+//VerifyReport is the result of the verification of a repository.
+type VerifyReport struct {
+	LooseObjects  int
+	PackedObjects int
+	Reachable     int
+
+	//Promised is the number of reachable objects that are absent
+	//from a partial clone, but promised by the promisor remote,
+	//which are not considered missing.
+	Promised int
+
+	Corrupt      []ObjectProblem
+	Missing      []MissingObject
+	DanglingRefs []string
+	PackProblems []PackProblem
+}
+
+//OK reports whether no problems have been found.
+func (r *VerifyReport) OK() bool {
+	return len(r.Corrupt) == 0 && len(r.Missing) == 0 &&
+		len(r.DanglingRefs) == 0 && len(r.PackProblems) == 0
+}
+
+func (r *VerifyReport) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%d loose, %d packed, %d reachable objects\n",
+		r.LooseObjects, r.PackedObjects, r.Reachable)
+
+	if r.Promised > 0 {
+		fmt.Fprintf(&b, "%d promised objects\n", r.Promised)
+	}
+
+	for _, p := range r.PackProblems {
+		fmt.Fprintf(&b, "pack %s\n", p)
+	}
+	for _, p := range r.Corrupt {
+		fmt.Fprintf(&b, "corrupt %s\n", p)
+	}
+	for _, m := range r.Missing {
+		fmt.Fprintf(&b, "%s\n", m)
+	}
+	for _, name := range r.DanglingRefs {
+		fmt.Fprintf(&b, "dangling ref %s\n", name)
+	}
+
+	return b.String()
+}
+
+//Verify checks the integrity of the repository, like "git fsck":
+//The ids of all loose and packed objects are recomputed, the
+//checksums of packs and pack indices as well as the CRC32 of
+//packed objects (for version 2 indices) are checked, and all
+//objects reachable from HEAD and the refs are checked to exist.
+//The history of shallow clones ends at the shallow commits, objects
+//absent from partial clones are only counted (see PartialClone).
+//Problems are collected in the report; an error is only returned
+//if the verification itself fails.
+func (repo *Repository) Verify() (*VerifyReport, error) {
+	report := &VerifyReport{}
+
+	err := repo.verifyLooseObjects(report)
+	if err != nil {
+		return nil, err
+	}
+
+	err = repo.verifyPacks(report)
+	if err != nil {
+		return nil, err
+	}
+
+	err = repo.verifyConnectivity(report)
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+//hashObjectData computes the id of an object from its type, size
+//and data; it fails if the data does not match the size.
+func hashObjectData(format ObjectFormat, otype ObjectType, size int64, r io.Reader) (SHA1, error) {
+	var id SHA1
+
+	h := format.New()
+	h.Write(objectHeader(otype, size))
+
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return id, err
+	} else if n != size {
+		return id, fmt.Errorf("size mismatch (%d != %d)", n, size)
+	}
+
+	return format.ID(h.Sum(nil)), nil
+}
+
+func (repo *Repository) verifyLooseObjects(report *VerifyReport) error {
+	dir := filepath.Join(repo.Path, "objects")
+
+	subdirs, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range subdirs {
+		if !sub.IsDir() || len(sub.Name()) != 2 {
+			continue
+		}
+
+		files, err := ioutil.ReadDir(filepath.Join(dir, sub.Name()))
+		if err != nil {
+			return err
+		}
+
+		for _, f := range files {
+			id, err := ParseSHA1(sub.Name() + f.Name())
+			if err != nil {
+				//temporary or foreign files
+				continue
+			}
+
+			report.LooseObjects++
+
+			obj, err := openRawObject(filepath.Join(dir, sub.Name(), f.Name()))
+			if err != nil {
+				report.Corrupt = append(report.Corrupt, ObjectProblem{id, err.Error()})
+				continue
+			}
+
+			actual, err := hashObjectData(repo.ObjectFormat(), obj.otype, obj.size, obj.source)
+			obj.source.Close()
+
+			if err != nil {
+				report.Corrupt = append(report.Corrupt, ObjectProblem{id, err.Error()})
+			} else if actual != id {
+				report.Corrupt = append(report.Corrupt, ObjectProblem{id, fmt.Sprintf("hash mismatch (%s)", actual)})
+			}
+		}
+	}
+
+	return nil
+}
+
+func (repo *Repository) verifyPacks(report *VerifyReport) error {
+	indices, err := filepath.Glob(filepath.Join(repo.Path, "objects", "pack", "*.idx"))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range indices {
+		name := filepath.Base(path)
+
+		problems, err := repo.verifyPack(path, report)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+
+		for _, p := range problems {
+			report.PackProblems = append(report.PackProblems, PackProblem{name, p})
+		}
+	}
+
+	return nil
+}
+
+//fileChecksum checks the trailing checksum of the file and returns
+//it, together with the checksum-sized data preceding it.
+func fileChecksum(path string, format ObjectFormat) (SHA1, SHA1, bool, error) {
+	var sum, prev SHA1
+	hs := int64(format.Size())
+
+	f, err := os.Open(path)
+	if err != nil {
+		return sum, prev, false, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return sum, prev, false, err
+	} else if fi.Size() < 2*hs {
+		return sum, prev, false, fmt.Errorf("file too small")
+	}
+
+	h := format.New()
+	_, err = io.CopyN(h, f, fi.Size()-hs)
+	if err != nil {
+		return sum, prev, false, err
+	}
+
+	buf := make([]byte, 2*hs)
+	_, err = f.ReadAt(buf, fi.Size()-2*hs)
+	if err != nil {
+		return sum, prev, false, err
+	}
+
+	prev, sum = format.ID(buf[:hs]), format.ID(buf[hs:])
+	return sum, prev, bytes.Equal(h.Sum(nil), sum.Bytes()), nil
+}
+
+//verifyPack checks the pack and its index at path and all the
+//objects in it; returns the problems found.
+func (repo *Repository) verifyPack(path string, report *VerifyReport) ([]string, error) {
+	var problems []string
+
+	packPath := strings.TrimSuffix(path, ".idx") + ".pack"
+	format := repo.ObjectFormat()
+
+	packSum, _, ok, err := fileChecksum(packPath, format)
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		problems = append(problems, "pack checksum mismatch")
+	}
+
+	_, idxPackSum, ok, err := fileChecksum(path, format)
+	if err != nil {
+		return problems, err
+	} else if !ok {
+		problems = append(problems, "index checksum mismatch")
+	}
+
+	if idxPackSum != packSum {
+		problems = append(problems, "index belongs to a different pack")
+	}
+
+	idx, err := openPackIndex(path, format)
+	if err != nil {
+		return problems, err
+	}
+	defer idx.Close()
+
+	pf, err := OpenPackFile(packPath)
+	if err != nil {
+		return problems, err
+	}
+	defer pf.Close()
+	pf.Format = format
+
+	fi, err := pf.Stat()
+	if err != nil {
+		return problems, err
+	}
+
+	n := idx.Count()
+	if uint32(n) != pf.ObjCount {
+		problems = append(problems, fmt.Sprintf("index has %d objects, pack %d", n, pf.ObjCount))
+	}
+
+	type entry struct {
+		id  SHA1
+		pos int
+		off int64
+	}
+
+	entries := make([]entry, n)
+	for i := 0; i < n; i++ {
+		e := &entries[i]
+		e.pos = i
+
+		err = idx.ReadSHA1(&e.id, i)
+		if err == nil {
+			e.off, err = idx.ReadOffset(i)
+		}
+		if err != nil {
+			return problems, err
+		}
+	}
+
+	//the packed data of an object ends where the next one starts
+	sort.Slice(entries, func(i, j int) bool { return entries[i].off < entries[j].off })
+
+	for i, e := range entries {
+		report.PackedObjects++
+
+		end := fi.Size() - int64(format.Size())
+		if i+1 < len(entries) {
+			end = entries[i+1].off
+		}
+
+		if e.off < 12 || e.off >= end {
+			report.Corrupt = append(report.Corrupt, ObjectProblem{e.id, "invalid pack offset"})
+			continue
+		}
+
+		if idx.Version == 2 {
+			expected, err := idx.ReadCRC32(e.pos)
+			if err != nil {
+				return problems, err
+			}
+
+			crc := crc32.NewIEEE()
+			_, err = io.Copy(crc, io.NewSectionReader(pf, e.off, end-e.off))
+			if err != nil {
+				return problems, err
+			}
+
+			if crc.Sum32() != expected {
+				report.Corrupt = append(report.Corrupt, ObjectProblem{e.id, "crc32 mismatch"})
+				continue
+			}
+		}
+
+		actual, err := repo.hashPackedObject(pf, e.off)
+		if err != nil {
+			report.Corrupt = append(report.Corrupt, ObjectProblem{e.id, err.Error()})
+		} else if actual != e.id {
+			report.Corrupt = append(report.Corrupt, ObjectProblem{e.id, fmt.Sprintf("hash mismatch (%s)", actual)})
+		}
+	}
+
+	return problems, nil
+}
+
+//hashPackedObject computes the id of the object at offset in the
+//pack, resolving deltas if necessary.
+func (repo *Repository) hashPackedObject(pf *PackFile, offset int64) (SHA1, error) {
+	obj, err := pf.readRawObject(offset)
+	if err != nil {
+		return SHA1{}, err
+	}
+
+	if IsStandardObject(obj.otype) {
+		defer obj.source.Close()
+		return hashObjectData(pf.Format, obj.otype, obj.size, obj.source)
+	} else if !IsDeltaObject(obj.otype) {
+		return SHA1{}, fmt.Errorf("unknown object type %d", obj.otype)
+	}
+
+	delta, err := parseDelta(obj)
+	if err != nil {
+		return SHA1{}, err
+	}
+
+	defer delta.Close()
+
+	otype, base, err := repo.deltaBase(delta)
+	if err != nil {
+		return SHA1{}, err
+	}
+
+	r, err := newDeltaReader(delta, base)
+	if err != nil {
+		return SHA1{}, err
+	}
+
+	return hashObjectData(pf.Format, otype, delta.SizeTarget, r)
+}
+
+//verifyConnectivity checks that all objects reachable from HEAD
+//and the refs exist and have the expected type.
+func (repo *Repository) verifyConnectivity(report *VerifyReport) error {
+	type item struct {
+		id       SHA1
+		otype    ObjectType
+		referrer string
+	}
+
+	var roots []Ref
+
+	//an unborn HEAD (no commits yet) is fine
+	if head, err := repo.parseRef("HEAD"); err == nil {
+		if _, err := head.Resolve(); err == nil {
+			roots = append(roots, head)
+		}
+	}
+
+	refs := repo.Refs()
+	for refs.Next() {
+		roots = append(roots, refs.Ref())
+	}
+
+	if err := refs.Err(); err != nil {
+		return err
+	}
+
+	var stack []item
+	for _, ref := range roots {
+		id, err := ref.Resolve()
+		if err != nil {
+			report.DanglingRefs = append(report.DanglingRefs, refPath(ref))
+			continue
+		}
+
+		ok, err := repo.HasObject(id)
+		if err != nil {
+			return err
+		} else if !ok {
+			report.DanglingRefs = append(report.DanglingRefs, refPath(ref))
+			continue
+		}
+
+		stack = append(stack, item{id, 0, refPath(ref)})
+	}
+
+	partial, err := repo.PartialClone()
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[SHA1]bool)
+	corrupt := make(map[SHA1]bool)
+	for _, c := range report.Corrupt {
+		corrupt[c.ID] = true
+	}
+
+	for len(stack) > 0 {
+		it := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if seen[it.id] {
+			continue
+		}
+		seen[it.id] = true
+
+		ok, err := repo.HasObject(it.id)
+		if err != nil {
+			return err
+		} else if !ok && partial != nil {
+			promised, err := repo.promised(partial, it.id)
+			if err != nil {
+				return err
+			} else if promised {
+				report.Promised++
+				continue
+			}
+		}
+
+		if !ok {
+			report.Missing = append(report.Missing, MissingObject{it.id, it.otype, it.referrer})
+			continue
+		}
+
+		report.Reachable++
+
+		//blobs do not reference other objects
+		if it.otype == ObjBlob || corrupt[it.id] {
+			continue
+		}
+
+		obj, err := repo.OpenObject(it.id)
+		if err != nil {
+			report.Corrupt = append(report.Corrupt, ObjectProblem{it.id, err.Error()})
+			continue
+		}
+
+		if it.otype != 0 && obj.Type() != it.otype {
+			obj.Close()
+			report.Corrupt = append(report.Corrupt, ObjectProblem{it.id,
+				fmt.Sprintf("expected %s, but is %s (referenced by %s)", it.otype, obj.Type(), it.referrer)})
+			continue
+		}
+
+		referrer := it.id.String()
+		switch o := obj.(type) {
+		case *Commit:
+			stack = append(stack, item{o.Tree, ObjTree, referrer})
+
+			parents, err := repo.commitParents(it.id, o.Parent)
+			if err != nil {
+				obj.Close()
+				return err
+			}
+
+			for _, p := range parents {
+				stack = append(stack, item{p, ObjCommit, referrer})
+			}
+		case *Tree:
+			for o.Next() {
+				e := o.Entry()
+				switch {
+				case e.Mode == ModeGitlink:
+					//submodule commits live in other repositories
+				case e.Mode == ModeTree:
+					stack = append(stack, item{e.ID, ObjTree, referrer})
+				default:
+					stack = append(stack, item{e.ID, ObjBlob, referrer})
+				}
+			}
+
+			if err := o.Err(); err != nil {
+				report.Corrupt = append(report.Corrupt, ObjectProblem{it.id, err.Error()})
+			}
+		case *Tag:
+			stack = append(stack, item{o.Object, o.ObjType, referrer})
+		}
+
+		obj.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package gig
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	repo, wt := mkTestRepo(t)
+
+	//a loose commit on top of the packed history
+	err := ioutil.WriteFile(filepath.Join(wt, "loose.txt"), []byte("loose\n"), 0666)
+	if err != nil {
+		t.Fatalf("could not write file: %v", err)
+	}
+	gitCmd(t, wt, "add", "-A")
+	gitCmd(t, wt, "commit", "-q", "-m", "loose")
+	gitCmd(t, wt, "push", "-q", "bare", "master")
+
+	report, err := repo.Verify()
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+
+	if !report.OK() {
+		t.Fatalf("expected healthy repository, got:\n%s", report)
+	}
+
+	counts := make(map[string]int)
+	for _, l := range strings.Split(gitCmd(t, repo.Path, "count-objects", "-v"), "\n") {
+		fields := strings.SplitN(l, ": ", 2)
+		counts[fields[0]], _ = strconv.Atoi(fields[1])
+	}
+
+	if report.LooseObjects != counts["count"] || report.PackedObjects != counts["in-pack"] {
+		t.Fatalf("unexpected object counts: %s", report)
+	}
+
+	if all := len(listObjects(t, repo)); report.Reachable != all {
+		t.Fatalf("expected %d reachable objects, got %d", all, report.Reachable)
+	}
+
+	//dangling ref
+	bad := strings.Repeat("ab", 20)
+	err = ioutil.WriteFile(filepath.Join(repo.Path, "refs", "heads", "broken"), []byte(bad+"\n"), 0666)
+	if err != nil {
+		t.Fatalf("could not write ref: %v", err)
+	}
+
+	//missing object: delete the loose blob
+	blob, err := ParseSHA1(gitCmd(t, repo.Path, "rev-parse", "master:loose.txt"))
+	if err != nil {
+		t.Fatalf("could not parse id: %v", err)
+	}
+	os.Remove(repo.looseObjectPath(blob))
+
+	//corrupt loose object: replace it with another one
+	tree, err := ParseSHA1(gitCmd(t, repo.Path, "rev-parse", "master^{tree}"))
+	if err != nil {
+		t.Fatalf("could not parse id: %v", err)
+	}
+	data, err := ioutil.ReadFile(repo.looseObjectPath(tree))
+	if err != nil {
+		t.Fatalf("could not read object: %v", err)
+	}
+	other := writeBlob(t, repo, "unreachable\n")
+	os.Chmod(repo.looseObjectPath(other), 0666)
+	if err := ioutil.WriteFile(repo.looseObjectPath(other), data, 0666); err != nil {
+		t.Fatalf("could not write object: %v", err)
+	}
+
+	//corrupt pack: flip a byte in the middle
+	packs, err := filepath.Glob(filepath.Join(repo.Path, "objects", "pack", "*.pack"))
+	if err != nil || len(packs) != 1 {
+		t.Fatalf("expected exactly one pack, got %v (%v)", packs, err)
+	}
+	pack, err := ioutil.ReadFile(packs[0])
+	if err != nil {
+		t.Fatalf("could not read pack: %v", err)
+	}
+	pack[len(pack)/2] ^= 0xFF
+	os.Chmod(packs[0], 0666)
+	if err := ioutil.WriteFile(packs[0], pack, 0666); err != nil {
+		t.Fatalf("could not write pack: %v", err)
+	}
+
+	report, err = (&Repository{Path: repo.Path}).Verify()
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+
+	if report.OK() {
+		t.Fatal("expected problems to be found")
+	}
+
+	if len(report.DanglingRefs) != 1 || report.DanglingRefs[0] != "refs/heads/broken" {
+		t.Fatalf("expected dangling ref, got %v", report.DanglingRefs)
+	}
+
+	hasProblem := func(id SHA1, problem string) bool {
+		for _, c := range report.Corrupt {
+			if c.ID == id && strings.Contains(c.Problem, problem) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !hasProblem(other, "hash mismatch") {
+		t.Fatalf("expected hash mismatch for %s, got:\n%s", other, report)
+	}
+
+	if len(report.PackProblems) != 1 || report.PackProblems[0].Problem != "pack checksum mismatch" {
+		t.Fatalf("expected pack checksum mismatch, got %v", report.PackProblems)
+	}
+
+	crc := false
+	for _, c := range report.Corrupt {
+		crc = crc || c.Problem == "crc32 mismatch"
+	}
+	if !crc {
+		t.Fatalf("expected crc32 mismatch, got:\n%s", report)
+	}
+
+	//the blob is referenced by the tree, which is still intact
+	found := false
+	for _, m := range report.Missing {
+		found = found || (m.ID == blob && m.Type == ObjBlob && m.Referrer == tree.String())
+	}
+	if !found {
+		t.Fatalf("expected missing blob %s, got:\n%s", blob, report)
+	}
+}
@@ -1,7 +1,14 @@
 package gig
 
-import "fmt"
+import (
+	"io"
+)
 
+//WalkRef walks the history of the ref with the given name and
+//returns all commits for which goOn returned true. The parents
+//of a commit are only visited if goOn returned true for it. The
+//commit-graph is used for the traversal, if present, so only the
+//commits that are returned need to be inflated.
 func (repo *Repository) WalkRef(refname string, goOn func(SHA1) bool) (map[SHA1]*Commit, error) {
 	head, err := repo.OpenRef(refname)
 	if err != nil {
@@ -14,27 +21,45 @@ func (repo *Repository) WalkRef(refname string, goOn func(SHA1) bool) (map[SHA1]
 	}
 
 	commits := make(map[SHA1]*Commit)
-	repo.walkCommitTree(commits, HId, goOn)
+	err = repo.walkCommitTree(commits, HId, goOn)
+	if err != nil {
+		return nil, err
+	}
+
 	return commits, nil
 }
 
 func (repo *Repository) walkCommitTree(commits map[SHA1]*Commit, commitId SHA1,
 	goOn func(SHA1) bool) error {
-	commit, err := repo.OpenObject(commitId)
-	commit.Close()
+
+	var err error
+	walkErr := repo.WalkAncestors(commitId, func(node *CommitNode) bool {
+		if err != nil || !goOn(node.ID) {
+			return false
+		}
+
+		var obj Object
+		obj, err = repo.OpenObject(node.ID)
+		if err != nil {
+			return false
+		}
+		obj.Close()
+
+		commit, ok := obj.(*Commit)
+		if !ok {
+			err = &ObjectTypeError{ID: node.ID, Type: obj.Type(), Expected: ObjCommit}
+			return false
+		}
+
+		commits[node.ID] = commit
+		return true
+	})
+
 	if err != nil {
 		return err
 	}
 
-	if _, ok := commits[commitId]; !ok && goOn(commitId) {
-		commits[commitId] = commit.(*Commit)
-		for _, parent := range commit.(*Commit).Parent {
-			repo.walkCommitTree(commits, parent, goOn)
-		}
-		return nil
-	} else {
-		return nil
-	}
+	return walkErr
 }
 
 func (repo *Repository) GetBlobsForCommit(commit *Commit, blobs map[SHA1]*Blob) error {
@@ -46,35 +71,110 @@ func (repo *Repository) GetBlobsForCommit(commit *Commit, blobs map[SHA1]*Blob)
 
 	tree, ok := treeOb.(*Tree)
 	if !ok {
-		return fmt.Errorf("Could not assert a tree")
+		return &ObjectTypeError{ID: commit.Tree, Type: treeOb.Type(), Expected: ObjTree}
 	}
 
 	err = repo.GetBlobsForTree(tree, blobs)
 	return err
 }
 
+//GetBlobsForTree adds the blobs below the tree to blobs. Gitlinks
+//are skipped, see WalkTree for traversals that include submodules.
+//The blobs do not hold open files until they are read; they are
+//closed when they were read completely or via Close.
 func (repo *Repository) GetBlobsForTree(tree *Tree, blobs map[SHA1]*Blob) error {
 	for tree.Next() {
 		trEntry := tree.Entry()
 		switch trEntry.Type {
 		case ObjBlob:
-			if blobOb, err := repo.OpenObject(trEntry.ID); err != nil {
+			if _, ok := blobs[trEntry.ID]; ok {
+				continue
+			}
+
+			blob, err := repo.lazyBlob(trEntry.ID)
+			if err != nil {
 				return err
-			} else {
-				blobs[trEntry.ID] = blobOb.(*Blob)
-				blobOb.Close()
 			}
+			blobs[trEntry.ID] = blob
 		case ObjTree:
-			if treeOb, err := repo.OpenObject(trEntry.ID); err != nil {
+			treeOb, err := repo.OpenObject(trEntry.ID)
+			if err != nil {
 				return err
-			} else {
-				if err = repo.GetBlobsForTree(treeOb.(*Tree), blobs); err != nil {
-					treeOb.Close()
-					return err
-				}
+			}
+
+			subtree, ok := treeOb.(*Tree)
+			if !ok {
+				treeOb.Close()
+				return &ObjectTypeError{ID: trEntry.ID, Type: treeOb.Type(), Expected: ObjTree}
+			}
 
+			err = repo.GetBlobsForTree(subtree, blobs)
+			treeOb.Close()
+			if err != nil {
+				return err
 			}
 		}
 	}
 	return tree.Err()
 }
+
+//lazyBlob returns the blob with the given id, which is only
+//opened when it is read; until then, only its header is read.
+func (repo *Repository) lazyBlob(id SHA1) (*Blob, error) {
+	otype, size, err := repo.objectInfo(id)
+	if err != nil {
+		return nil, err
+	} else if otype != ObjBlob {
+		return nil, &ObjectTypeError{ID: id, Type: otype, Expected: ObjBlob}
+	}
+
+	source := &reopener{open: func() (io.ReadCloser, error) {
+		obj, err := repo.OpenObject(id)
+		if err != nil {
+			return nil, err
+		}
+		return obj.(*Blob), nil
+	}}
+
+	return &Blob{gitObject{otype: ObjBlob, size: size, source: source, format: id.Format()}}, nil
+}
+
+//reopener opens its source on the first read and closes
+//it again at its end.
+type reopener struct {
+	open func() (io.ReadCloser, error)
+	rc   io.ReadCloser
+	err  error
+}
+
+func (r *reopener) Read(data []byte) (int, error) {
+	if r.rc == nil {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		r.rc, r.err = r.open()
+		if r.err != nil {
+			r.rc = nil
+			return 0, r.err
+		}
+	}
+
+	n, err := r.rc.Read(data)
+	if err == io.EOF {
+		r.rc.Close()
+		r.rc, r.err = nil, io.EOF
+	}
+
+	return n, err
+}
+
+func (r *reopener) Close() error {
+	if r.rc == nil {
+		return nil
+	}
+
+	err := r.rc.Close()
+	r.rc, r.err = nil, io.EOF
+	return err
+}
@@ -1,6 +1,9 @@
 package gig
 
 import (
+	"fmt"
+	"io/ioutil"
+	"strings"
 	"testing"
 )
 
@@ -40,4 +43,98 @@ func TestGetBlobs(t *testing.T) {
 	if blobs[id_blob] == nil {
 		t.Fatalf("Expected non-nil value")
 	}
+
+	//the blobs can still be read
+	data, err := ioutil.ReadAll(blobs[id_blob])
+	if err != nil {
+		t.Fatalf("Could not read blob: %v", err)
+	} else if int64(len(data)) != blobs[id_blob].Size() {
+		t.Fatalf("Expected %d bytes, got %d", blobs[id_blob].Size(), len(data))
+	}
+
+	expected := gitCmd(t, rep.Path, "cat-file", "blob", id_blob.String())
+	if strings.TrimSpace(string(data)) != expected {
+		t.Fatalf("Unexpected blob content %q", data)
+	}
+
+	for _, blob := range blobs {
+		if err := blob.Close(); err != nil {
+			t.Fatalf("Could not close blob: %v", err)
+		}
+	}
+}
+
+func TestObjectInfo(t *testing.T) {
+	repo, wt := mkTestRepo(t)
+
+	//a chain of deltas
+	content := strings.Repeat("a line of a file that changes a bit\n", 100)
+	for i := 0; i < 3; i++ {
+		writeFiles(t, wt, map[string]string{"delta.txt": content + strings.Repeat("x\n", i)})
+		gitCmd(t, wt, "add", "-A")
+		gitCmd(t, wt, "commit", "-q", "-m", "delta")
+	}
+	gitCmd(t, wt, "push", "-q", "bare", "master")
+	gitCmd(t, repo.Path, "repack", "-q", "-a", "-d", "-f")
+
+	out := gitCmd(t, repo.Path, "cat-file", "--batch-all-objects", "--batch-check=%(objectname) %(objecttype) %(objectsize) %(deltabase)")
+
+	deltas := 0
+	for _, line := range strings.Split(out, "\n") {
+		f := strings.Fields(line)
+		id, err := ParseSHA1(f[0])
+		if err != nil {
+			t.Fatalf("could not parse id: %v", err)
+		}
+
+		otype, size, err := repo.objectInfo(id)
+		if err != nil {
+			t.Fatalf("could not read info of %s: %v", id, err)
+		} else if info := fmt.Sprintf("%s %s %d", id, otype, size); info != strings.Join(f[:3], " ") {
+			t.Fatalf("unexpected info %q, expected %q", info, strings.Join(f[:3], " "))
+		}
+
+		if !strings.HasPrefix(f[3], "0000000") {
+			deltas++
+		}
+	}
+
+	if deltas == 0 {
+		t.Fatalf("expected deltified objects")
+	}
+}
+
+func TestGetBlobsGitlink(t *testing.T) {
+	repo, wt := mkTestRepo(t)
+
+	//a submodule commit that is not in the repository
+	link := strings.Repeat("12", 20)
+	gitCmd(t, wt, "update-index", "--add", "--cacheinfo", "160000,"+link+",data/mod")
+	gitCmd(t, wt, "commit", "-q", "-m", "gitlink")
+	gitCmd(t, wt, "push", "-q", "bare", "master")
+
+	obj, err := repo.OpenObject(revParse(t, repo, "master"))
+	if err != nil {
+		t.Fatalf("could not open commit: %v", err)
+	}
+	defer obj.Close()
+
+	blobs := make(map[SHA1]*Blob)
+	if err := repo.GetBlobsForCommit(obj.(*Commit), blobs); err != nil {
+		t.Fatalf("could not get blobs: %v", err)
+	}
+
+	expected := gitCmd(t, repo.Path, "ls-tree", "-r", "--format=%(objecttype)", "master")
+	if n := strings.Count(expected, "blob"); len(blobs) != n {
+		t.Fatalf("expected %d blobs, got %d", n, len(blobs))
+	}
+
+	id, _ := ParseSHA1(link)
+	if _, ok := blobs[id]; ok {
+		t.Fatalf("gitlink was taken for a blob")
+	}
+
+	for _, blob := range blobs {
+		blob.Close()
+	}
 }
@@ -118,7 +118,7 @@ func (t *Tree) WriteTo(writer io.Writer) (int64, error) {
 		}
 		n++
 
-		x, err = w.Write(entry.ID[:])
+		x, err = w.Write(entry.ID.Bytes())
 		n += int64(x)
 		if err != nil {
 			return n, err